@@ -34,6 +34,8 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"time"
 
 	"google.golang.org/grpc/encoding/gzip"
@@ -42,6 +44,9 @@ import (
 const (
 	// DefStoreLivenessTimeout is the default value for store liveness timeout.
 	DefStoreLivenessTimeout = "1s"
+	// rpcTimeoutMultiplierEnv is the environment variable overriding the
+	// default RPC timeout multiplier, e.g. to run tests against slow clusters.
+	rpcTimeoutMultiplierEnv = "TIKV_CLIENT_RPC_TIMEOUT_MULTIPLIER"
 )
 
 // TiKVClient is the config for tikv client.
@@ -81,6 +86,27 @@ type TiKVClient struct {
 	CoprCache            CoprocessorCache `toml:"copr-cache" json:"copr-cache"`
 	// TTLRefreshedTxnSize controls whether a transaction should update its TTL or not.
 	TTLRefreshedTxnSize int64 `toml:"ttl-refreshed-txn-size" json:"ttl-refreshed-txn-size"`
+	// TxnStatusCacheCapacity is the max number of the statuses of uncommitted
+	// transactions cached by the lock resolver. 0 disables the cache.
+	TxnStatusCacheCapacity uint `toml:"txn-status-cache-capacity" json:"txn-status-cache-capacity"`
+	// HotRegionCacheSize is the number of the recently used regions kept in
+	// front of the region cache to speed up looking up regions by key. The
+	// lookups take a mutex to keep the LRU order, so it only helps if the
+	// region cache is much larger than the hot set. 0 disables it.
+	HotRegionCacheSize uint `toml:"hot-region-cache-size" json:"hot-region-cache-size"`
+	// MaxRegionCacheSize is the max number of the regions in the region cache.
+	// The least recently used regions, approximated by sampling, are evicted
+	// when it's exceeded and are loaded from PD again on next access. 0 means
+	// no limit.
+	MaxRegionCacheSize uint `toml:"max-region-cache-size" json:"max-region-cache-size"`
+	// StoreInfoCacheSize is the number of the store metas loaded from PD that
+	// are cached for a minute, so resolving the stores again doesn't query PD.
+	// 0 disables it.
+	StoreInfoCacheSize uint `toml:"store-info-cache-size" json:"store-info-cache-size"`
+	// RPCTimeoutMultiplier multiplies the timeouts of the RPCs sent to TiKV.
+	// The default value can be overridden by the environment variable
+	// TIKV_CLIENT_RPC_TIMEOUT_MULTIPLIER.
+	RPCTimeoutMultiplier float64 `toml:"rpc-timeout-multiplier" json:"rpc-timeout-multiplier"`
 }
 
 // AsyncCommit is the config for the async commit feature. The switch to enable it is a system variable.
@@ -141,6 +167,11 @@ func DefaultTiKVClient() TiKVClient {
 
 		TTLRefreshedTxnSize: 32 * 1024 * 1024,
 
+		TxnStatusCacheCapacity: 4096,
+		HotRegionCacheSize:     0,
+
+		RPCTimeoutMultiplier: defaultRPCTimeoutMultiplier(),
+
 		CoprCache: CoprocessorCache{
 			CapacityMB:            1000,
 			AdmissionMaxRanges:    500,
@@ -158,5 +189,15 @@ func (config *TiKVClient) Valid() error {
 	if config.GrpcCompressionType != "none" && config.GrpcCompressionType != gzip.Name {
 		return fmt.Errorf("grpc-compression-type should be none or %s, but got %s", gzip.Name, config.GrpcCompressionType)
 	}
+	if config.RPCTimeoutMultiplier <= 0 {
+		return fmt.Errorf("rpc-timeout-multiplier should be greater than 0, but got %v", config.RPCTimeoutMultiplier)
+	}
 	return nil
 }
+
+func defaultRPCTimeoutMultiplier() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(rpcTimeoutMultiplierEnv), 64); err == nil && v > 0 {
+		return v
+	}
+	return 1
+}
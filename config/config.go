@@ -64,18 +64,29 @@ func init() {
 // Config contains configuration options.
 type Config struct {
 	CommitterConcurrency int
-	MaxTxnTTL            uint64
-	TiKVClient           TiKVClient
-	Security             Security
-	PDClient             PDClient
-	PessimisticTxn       PessimisticTxn
-	TxnLocalLatches      TxnLocalLatches
+	// CommitterMaxBatchKeys limits the number of keys in a batch of a
+	// transaction commit RPC. 0 means no limit.
+	CommitterMaxBatchKeys int
+	// CommitterMaxBatchBytes limits the size of a batch of a transaction commit
+	// RPC, the size of prewrite batches contains both keys and values, and the
+	// size of other batches contains only keys. A batch always contains at
+	// least one key. 0 means no limit.
+	CommitterMaxBatchBytes int
+	MaxTxnTTL              uint64
+	TiKVClient             TiKVClient
+	Security               Security
+	PDClient               PDClient
+	PessimisticTxn         PessimisticTxn
+	TxnLocalLatches        TxnLocalLatches
 	// StoresRefreshInterval indicates the interval of refreshing stores info, the unit is second.
 	StoresRefreshInterval uint64
 	OpenTracingEnable     bool
 	Path                  string
 	EnableForwarding      bool
 	TxnScope              string
+	// EnableRegionEpochCheck makes the region cache reject the read responses
+	// of regions whose epoch is older than one seen before.
+	EnableRegionEpochCheck bool
 }
 
 // DefaultConfig returns the default configuration.
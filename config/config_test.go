@@ -33,6 +33,7 @@
 package config
 
 import (
+	"os"
 	"testing"
 
 	"github.com/pingcap/failpoint"
@@ -72,3 +73,21 @@ func TestTxnScopeValue(t *testing.T) {
 	err = failpoint.Disable("tikvclient/injectTxnScope")
 	assert.Nil(t, err)
 }
+
+func TestRPCTimeoutMultiplier(t *testing.T) {
+	if v, ok := os.LookupEnv(rpcTimeoutMultiplierEnv); ok {
+		defer os.Setenv(rpcTimeoutMultiplierEnv, v)
+	}
+	os.Unsetenv(rpcTimeoutMultiplierEnv)
+	cfg := DefaultTiKVClient()
+	assert.Equal(t, 1.0, cfg.RPCTimeoutMultiplier)
+	assert.Nil(t, cfg.Valid())
+	cfg.RPCTimeoutMultiplier = 0
+	assert.NotNil(t, cfg.Valid())
+
+	os.Setenv(rpcTimeoutMultiplierEnv, "5")
+	defer os.Unsetenv(rpcTimeoutMultiplierEnv)
+	assert.Equal(t, 5.0, DefaultTiKVClient().RPCTimeoutMultiplier)
+	os.Setenv(rpcTimeoutMultiplierEnv, "-1")
+	assert.Equal(t, 1.0, DefaultTiKVClient().RPCTimeoutMultiplier)
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"go.uber.org/zap"
+)
+
+// ConfigWatcher delivers the updates of the TiKVClient config. The channel is
+// closed when the watcher stops.
+type ConfigWatcher interface {
+	Subscribe() <-chan TiKVClient
+}
+
+// WatchConfig stores the configs received from w to the global config until
+// ctx is done or the channel is closed. The fields read on use, such as
+// TTLRefreshedTxnSize, StoreLimit and AsyncCommit, take effect immediately,
+// while the fields read once at start, such as GrpcConnectionCount, only
+// affect the connections created later. An invalid config is logged and
+// dropped, the global config keeps the last valid one.
+func WatchConfig(ctx context.Context, w ConfigWatcher) {
+	ch := w.Subscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cfg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := cfg.Valid(); err != nil {
+				logutil.BgLogger().Warn("invalid tikv client config ignored", zap.Error(err))
+				continue
+			}
+			UpdateGlobal(func(conf *Config) {
+				conf.TiKVClient = cfg
+			})
+			logutil.BgLogger().Info("tikv client config updated")
+		}
+	}
+}
+
+// PDConfigWatcher is a ConfigWatcher that polls the TiKVClient config in JSON
+// from an HTTP endpoint, such as the config item kept in PD by the deployment
+// tools. The fields absent in the document keep their values in the global
+// config. An update is delivered only if the config is changed.
+type PDConfigWatcher struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	once   sync.Once
+	ch     chan TiKVClient
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPDConfigWatcher creates a PDConfigWatcher that polls url every interval.
+func NewPDConfigWatcher(url string, interval time.Duration) *PDConfigWatcher {
+	return &PDConfigWatcher{
+		url:      url,
+		interval: interval,
+		client:   InternalHTTPClient(),
+		ch:       make(chan TiKVClient),
+		done:     make(chan struct{}),
+	}
+}
+
+// Subscribe implements ConfigWatcher. The polling starts on the first call.
+func (w *PDConfigWatcher) Subscribe() <-chan TiKVClient {
+	w.once.Do(func() {
+		var ctx context.Context
+		ctx, w.cancel = context.WithCancel(context.Background())
+		go w.run(ctx)
+	})
+	return w.ch
+}
+
+// Close stops the polling and closes the channel.
+func (w *PDConfigWatcher) Close() {
+	w.once.Do(func() {
+		close(w.ch)
+		close(w.done)
+	})
+	if w.cancel != nil {
+		w.cancel()
+		<-w.done
+	}
+}
+
+func (w *PDConfigWatcher) run(ctx context.Context) {
+	defer close(w.done)
+	defer close(w.ch)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	last := GetGlobalConfig().TiKVClient
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cfg, err := w.fetch(ctx)
+		if err != nil {
+			logutil.BgLogger().Warn("fetch tikv client config failed", zap.String("url", w.url), zap.Error(err))
+			continue
+		}
+		if reflect.DeepEqual(cfg, last) {
+			continue
+		}
+		select {
+		case w.ch <- cfg:
+			last = cfg
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *PDConfigWatcher) fetch(ctx context.Context) (TiKVClient, error) {
+	cfg := GetGlobalConfig().TiKVClient
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.url, nil)
+	if err != nil {
+		return cfg, errors.Trace(err)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return cfg, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return cfg, errors.Errorf("unexpected status %s", resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return cfg, errors.Trace(err)
+	}
+	return cfg, nil
+}
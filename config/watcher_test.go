@@ -0,0 +1,87 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPDConfigWatcher(t *testing.T) {
+	defer UpdateGlobal(func(conf *Config) {})()
+	var size int64 = 1024
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"ttl-refreshed-txn-size": %d}`, atomic.LoadInt64(&size))
+	}))
+	defer server.Close()
+
+	w := NewPDConfigWatcher(server.URL, 10*time.Millisecond)
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		WatchConfig(ctx, w)
+	}()
+
+	storeLimit := GetGlobalConfig().TiKVClient.StoreLimit
+	require.Eventually(t, func() bool {
+		return GetGlobalConfig().TiKVClient.TTLRefreshedTxnSize == 1024
+	}, 5*time.Second, 10*time.Millisecond)
+	// The fields absent in the document are not changed.
+	require.Equal(t, storeLimit, GetGlobalConfig().TiKVClient.StoreLimit)
+
+	atomic.StoreInt64(&size, 2048)
+	require.Eventually(t, func() bool {
+		return GetGlobalConfig().TiKVClient.TTLRefreshedTxnSize == 2048
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+type chanConfigWatcher chan TiKVClient
+
+func (w chanConfigWatcher) Subscribe() <-chan TiKVClient {
+	return w
+}
+
+func TestWatchConfigInvalid(t *testing.T) {
+	defer UpdateGlobal(func(conf *Config) {})()
+	w := make(chanConfigWatcher)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		WatchConfig(context.Background(), w)
+	}()
+
+	valid := GetGlobalConfig().TiKVClient
+	valid.TTLRefreshedTxnSize = 1024
+	w <- valid
+	invalid := valid
+	invalid.TTLRefreshedTxnSize = 2048
+	invalid.GrpcConnectionCount = 0
+	w <- invalid
+	close(w)
+	<-done
+	// The invalid config is dropped and the last valid one is kept.
+	require.Equal(t, valid, GetGlobalConfig().TiKVClient)
+}
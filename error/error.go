@@ -34,6 +34,7 @@ package error
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/pingcap/errors"
@@ -80,6 +81,10 @@ var (
 	ErrRegionNotInitialized = errors.New("region not Initialized")
 	// ErrUnknown is the unknow error.
 	ErrUnknown = errors.New("unknow")
+	// ErrNonAtomicBatch is returned when a raw write batch is written successfully but not atomically.
+	ErrNonAtomicBatch = errors.New("raw write batch is not written atomically")
+	// ErrTxnBranchCommitted is returned when a transaction is committed after another branch of it.
+	ErrTxnBranchCommitted = errors.New("another branch of the transaction is committed")
 )
 
 // MismatchClusterID represents the message that the cluster ID of the PD client does not match the PD.
@@ -87,7 +92,40 @@ const MismatchClusterID = "mismatch cluster id"
 
 // IsErrNotFound checks if err is a kind of NotFound error.
 func IsErrNotFound(err error) bool {
-	return errors.ErrorEqual(err, ErrNotExist)
+	return errors.ErrorEqual(err, ErrNotExist) || Is(err, ErrNotExist)
+}
+
+// Is reports whether any error in err's chain matches target. Unlike errors.Is
+// of the standard library, it follows both the Unwrap chain and the Cause chain
+// of github.com/pingcap/errors, so it works for errors wrapped by errors.Trace,
+// errors.Annotate and fmt.Errorf("%w").
+//
+// The error types of this package implement Is by comparing the type only, so
+// Is(err, &ErrKeyExist{}) returns true for any ErrKeyExist in the chain.
+func Is(err, target error) bool {
+	if target == nil {
+		return err == target
+	}
+	for err != nil {
+		if err == target {
+			return true
+		}
+		if x, ok := err.(interface{ Is(error) bool }); ok && x.Is(target) {
+			return true
+		}
+		err = unwrapOnce(err)
+	}
+	return false
+}
+
+func unwrapOnce(err error) error {
+	switch x := err.(type) {
+	case interface{ Unwrap() error }:
+		return x.Unwrap()
+	case interface{ Cause() error }:
+		return x.Cause()
+	}
+	return nil
 }
 
 // ErrDeadlock wraps *kvrpcpb.Deadlock to implement the error interface.
@@ -101,6 +139,12 @@ func (d *ErrDeadlock) Error() string {
 	return d.Deadlock.String()
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any ErrDeadlock.
+func (d *ErrDeadlock) Is(target error) bool {
+	_, ok := target.(*ErrDeadlock)
+	return ok
+}
+
 // PDError wraps *pdpb.Error to implement the error interface.
 type PDError struct {
 	Err *pdpb.Error
@@ -110,6 +154,12 @@ func (d *PDError) Error() string {
 	return d.Err.String()
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any PDError.
+func (d *PDError) Is(target error) bool {
+	_, ok := target.(*PDError)
+	return ok
+}
+
 // ErrKeyExist wraps *pdpb.AlreadyExist to implement the error interface.
 type ErrKeyExist struct {
 	*kvrpcpb.AlreadyExist
@@ -119,10 +169,15 @@ func (k *ErrKeyExist) Error() string {
 	return k.AlreadyExist.String()
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any ErrKeyExist.
+func (k *ErrKeyExist) Is(target error) bool {
+	_, ok := target.(*ErrKeyExist)
+	return ok
+}
+
 // IsErrKeyExist returns true if it is ErrKeyExist.
 func IsErrKeyExist(err error) bool {
-	_, ok := errors.Cause(err).(*ErrKeyExist)
-	return ok
+	return Is(err, &ErrKeyExist{})
 }
 
 // ErrWriteConflict wraps *kvrpcpb.ErrWriteConflict to implement the error interface.
@@ -134,10 +189,15 @@ func (k *ErrWriteConflict) Error() string {
 	return k.WriteConflict.String()
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any ErrWriteConflict.
+func (k *ErrWriteConflict) Is(target error) bool {
+	_, ok := target.(*ErrWriteConflict)
+	return ok
+}
+
 // IsErrWriteConflict returns true if it is ErrWriteConflict.
 func IsErrWriteConflict(err error) bool {
-	_, ok := errors.Cause(err).(*ErrWriteConflict)
-	return ok
+	return Is(err, &ErrWriteConflict{})
 }
 
 //NewErrWriteConfictWithArgs generates an ErrWriteConflict with args.
@@ -151,6 +211,76 @@ func NewErrWriteConfictWithArgs(startTs, conflictTs, conflictCommitTs uint64, ke
 	return &ErrWriteConflict{WriteConflict: &conflict}
 }
 
+// ErrDuplicateKey is the error when a key appears in both mutation sets to be merged.
+type ErrDuplicateKey struct {
+	Key []byte
+}
+
+func (e *ErrDuplicateKey) Error() string {
+	return fmt.Sprintf("duplicate key in mutations, key: %q", e.Key)
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrDuplicateKey.
+func (e *ErrDuplicateKey) Is(target error) bool {
+	_, ok := target.(*ErrDuplicateKey)
+	return ok
+}
+
+// BatchError is the error of an action on a batch of keys in a region.
+type BatchError struct {
+	RegionID uint64
+	Err      error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("region %d: %v", e.RegionID, e.Err)
+}
+
+// Unwrap returns the error of the batch.
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError collects the errors of all failed batches of an action which keeps
+// going after a batch fails, e.g. committing secondary keys. The regions that
+// are not listed succeeded.
+type MultiError struct {
+	Errors []BatchError
+}
+
+func (e *MultiError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d batches failed", len(e.Errors))
+	for i := range e.Errors {
+		fmt.Fprintf(&b, ", %v", &e.Errors[i])
+	}
+	return b.String()
+}
+
+// FailedRegions returns the IDs of the regions of the failed batches.
+func (e *MultiError) FailedRegions() []uint64 {
+	ids := make([]uint64, 0, len(e.Errors))
+	for _, be := range e.Errors {
+		ids = append(ids, be.RegionID)
+	}
+	return ids
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any
+// MultiError, and the targets matched by the error of any batch. A MultiError
+// has several causes, so it implements Is instead of Unwrap.
+func (e *MultiError) Is(target error) bool {
+	if _, ok := target.(*MultiError); ok {
+		return true
+	}
+	for i := range e.Errors {
+		if Is(&e.Errors[i], target) {
+			return true
+		}
+	}
+	return false
+}
+
 // ErrWriteConflictInLatch is the error when the commit meets an write conflict error when local latch is enabled.
 type ErrWriteConflictInLatch struct {
 	StartTS uint64
@@ -160,6 +290,50 @@ func (e *ErrWriteConflictInLatch) Error() string {
 	return fmt.Sprintf("write conflict in latch,startTS: %v", e.StartTS)
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any ErrWriteConflictInLatch.
+func (e *ErrWriteConflictInLatch) Is(target error) bool {
+	_, ok := target.(*ErrWriteConflictInLatch)
+	return ok
+}
+
+// ErrReadWriteConflict is the error when a key read locked by an optimistic
+// transaction is written by another transaction after the start ts. It wraps the
+// write conflict reported by the prewrite of the read lock.
+type ErrReadWriteConflict struct {
+	Conflict *ErrWriteConflict
+}
+
+func (e *ErrReadWriteConflict) Error() string {
+	return fmt.Sprintf("read-write conflict, the read key is written after the txn starts: %v", e.Conflict)
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrReadWriteConflict.
+func (e *ErrReadWriteConflict) Is(target error) bool {
+	_, ok := target.(*ErrReadWriteConflict)
+	return ok
+}
+
+// Unwrap returns the write conflict, so IsErrWriteConflict is true for it.
+func (e *ErrReadWriteConflict) Unwrap() error {
+	return e.Conflict
+}
+
+// ErrPluginNotFound is the error when the coprocessor plugin is not loaded by
+// TiKV, the caller may fall back to process the data on the client side.
+type ErrPluginNotFound struct {
+	Name string
+}
+
+func (e *ErrPluginNotFound) Error() string {
+	return fmt.Sprintf("coprocessor plugin %s is not found", e.Name)
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrPluginNotFound.
+func (e *ErrPluginNotFound) Is(target error) bool {
+	_, ok := target.(*ErrPluginNotFound)
+	return ok
+}
+
 // ErrRetryable wraps *kvrpcpb.Retryable to implement the error interface.
 type ErrRetryable struct {
 	Retryable string
@@ -169,6 +343,12 @@ func (k *ErrRetryable) Error() string {
 	return k.Retryable
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any ErrRetryable.
+func (k *ErrRetryable) Is(target error) bool {
+	_, ok := target.(*ErrRetryable)
+	return ok
+}
+
 // ErrTxnTooLarge is the error when transaction is too large, lock time reached the maximum value.
 type ErrTxnTooLarge struct {
 	Size int
@@ -178,6 +358,47 @@ func (e *ErrTxnTooLarge) Error() string {
 	return fmt.Sprintf("txn too large, size: %v.", e.Size)
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any ErrTxnTooLarge.
+func (e *ErrTxnTooLarge) Is(target error) bool {
+	_, ok := target.(*ErrTxnTooLarge)
+	return ok
+}
+
+// ErrAsyncCommitFailed is the error when an async commit transaction cannot
+// proceed and is not allowed to fall back to 2PC.
+type ErrAsyncCommitFailed struct {
+	StartTS uint64
+	Reason  string
+}
+
+func (e *ErrAsyncCommitFailed) Error() string {
+	return fmt.Sprintf("async commit failed, startTS: %v, reason: %v", e.StartTS, e.Reason)
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrAsyncCommitFailed.
+func (e *ErrAsyncCommitFailed) Is(target error) bool {
+	_, ok := target.(*ErrAsyncCommitFailed)
+	return ok
+}
+
+// ErrVersionMismatch is the error when the version of a key doesn't match the
+// expected one.
+type ErrVersionMismatch struct {
+	Key      []byte
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *ErrVersionMismatch) Error() string {
+	return fmt.Sprintf("version mismatch, key: %q, expected: %v, actual: %v", e.Key, e.Expected, e.Actual)
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrVersionMismatch.
+func (e *ErrVersionMismatch) Is(target error) bool {
+	_, ok := target.(*ErrVersionMismatch)
+	return ok
+}
+
 // ErrEntryTooLarge is the error when a key value entry is too large.
 type ErrEntryTooLarge struct {
 	Limit uint64
@@ -188,6 +409,12 @@ func (e *ErrEntryTooLarge) Error() string {
 	return fmt.Sprintf("entry size too large, size: %v,limit: %v.", e.Size, e.Limit)
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any ErrEntryTooLarge.
+func (e *ErrEntryTooLarge) Is(target error) bool {
+	_, ok := target.(*ErrEntryTooLarge)
+	return ok
+}
+
 // ErrPDServerTimeout is the error when pd server is timeout.
 type ErrPDServerTimeout struct {
 	msg string
@@ -202,6 +429,12 @@ func (e *ErrPDServerTimeout) Error() string {
 	return e.msg
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any ErrPDServerTimeout.
+func (e *ErrPDServerTimeout) Is(target error) bool {
+	_, ok := target.(*ErrPDServerTimeout)
+	return ok
+}
+
 // ErrGCTooEarly is the error that GC life time is shorter than transaction duration
 type ErrGCTooEarly struct {
 	TxnStartTS  time.Time
@@ -212,6 +445,12 @@ func (e *ErrGCTooEarly) Error() string {
 	return fmt.Sprintf("GC life time is shorter than transaction duration, transaction starts at %v, GC safe point is %v", e.TxnStartTS, e.GCSafePoint)
 }
 
+// Is implements the interface used by Is and errors.Is. It matches any ErrGCTooEarly.
+func (e *ErrGCTooEarly) Is(target error) bool {
+	_, ok := target.(*ErrGCTooEarly)
+	return ok
+}
+
 // ErrTokenLimit is the error that token is up to the limit.
 type ErrTokenLimit struct {
 	StoreID uint64
@@ -220,3 +459,107 @@ type ErrTokenLimit struct {
 func (e *ErrTokenLimit) Error() string {
 	return fmt.Sprintf("Store token is up to the limit, store id = %d.", e.StoreID)
 }
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrTokenLimit.
+func (e *ErrTokenLimit) Is(target error) bool {
+	_, ok := target.(*ErrTokenLimit)
+	return ok
+}
+
+// ErrQuotaExceeded is the error that a request exceeds the read or write quota
+// of its keyspace.
+type ErrQuotaExceeded struct {
+	Keyspace uint32
+	Reason   string
+}
+
+func (e *ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("quota of keyspace %d exceeded: %s", e.Keyspace, e.Reason)
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrQuotaExceeded.
+func (e *ErrQuotaExceeded) Is(target error) bool {
+	_, ok := target.(*ErrQuotaExceeded)
+	return ok
+}
+
+// ErrStaleRegionEpoch is the error that a response is from a region whose epoch
+// is older than the latest epoch seen by the client, so the result may be stale.
+type ErrStaleRegionEpoch struct {
+	RegionID      uint64
+	Version       uint64
+	LatestVersion uint64
+}
+
+func (e *ErrStaleRegionEpoch) Error() string {
+	return fmt.Sprintf("stale region epoch, region id: %d, version: %d, latest version: %d", e.RegionID, e.Version, e.LatestVersion)
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrStaleRegionEpoch.
+func (e *ErrStaleRegionEpoch) Is(target error) bool {
+	_, ok := target.(*ErrStaleRegionEpoch)
+	return ok
+}
+
+// ErrSerializableConflict is the error that keys read by a serializable
+// transaction were written by other transactions after it started. It wraps
+// the read-write conflict reported by the prewrite of the read keys.
+type ErrSerializableConflict struct {
+	StartTS  uint64
+	Keys     [][]byte
+	Conflict *ErrReadWriteConflict
+}
+
+func (e *ErrSerializableConflict) Error() string {
+	keys := make([]string, 0, len(e.Keys))
+	for _, k := range e.Keys {
+		keys = append(keys, fmt.Sprintf("%q", k))
+	}
+	return fmt.Sprintf("serializable conflict, txnStartTS: %d, keys read by the transaction are written after it starts: [%s]", e.StartTS, strings.Join(keys, ", "))
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrSerializableConflict.
+func (e *ErrSerializableConflict) Is(target error) bool {
+	_, ok := target.(*ErrSerializableConflict)
+	return ok
+}
+
+// Unwrap returns the read-write conflict, so IsErrWriteConflict is true for it.
+func (e *ErrSerializableConflict) Unwrap() error {
+	return e.Conflict
+}
+
+// ErrDeadlockDetected is the error that the pessimistic transactions of the
+// client wait for the locks of each other. Cycle lists the start ts of the
+// transactions, starting and ending with the transaction getting the error.
+type ErrDeadlockDetected struct {
+	Cycle []uint64
+}
+
+func (e *ErrDeadlockDetected) Error() string {
+	return fmt.Sprintf("deadlock detected, wait-for cycle: %v", e.Cycle)
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrDeadlockDetected.
+func (e *ErrDeadlockDetected) Is(target error) bool {
+	_, ok := target.(*ErrDeadlockDetected)
+	return ok
+}
+
+// ErrStalenessTooLarge is the error that the timestamp of a stale read falls
+// behind the GC safe point, so the data at the timestamp may be collected.
+type ErrStalenessTooLarge struct {
+	Staleness   time.Duration
+	ReadTS      uint64
+	GCSafePoint uint64
+}
+
+func (e *ErrStalenessTooLarge) Error() string {
+	return fmt.Sprintf("staleness %v is too large, read ts %d is not after GC safe point %d", e.Staleness, e.ReadTS, e.GCSafePoint)
+}
+
+// Is implements the interface used by Is and errors.Is. It matches any ErrStalenessTooLarge.
+func (e *ErrStalenessTooLarge) Is(target error) bool {
+	_, ok := target.(*ErrStalenessTooLarge)
+	return ok
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIs(t *testing.T) {
+	conflict := &ErrWriteConflict{WriteConflict: &kvrpcpb.WriteConflict{Key: []byte("k")}}
+	tooLarge := &ErrTxnTooLarge{Size: 1}
+
+	// The error types match any error of the same type.
+	require.True(t, Is(conflict, &ErrWriteConflict{}))
+	require.True(t, stderrors.Is(conflict, &ErrWriteConflict{}))
+	require.False(t, Is(conflict, &ErrTxnTooLarge{}))
+
+	// Is follows the Cause chain of errors.Trace and errors.Annotate, which
+	// errors.Is of the standard library doesn't, and the Unwrap chain.
+	traced := errors.Trace(errors.Annotate(errors.Trace(conflict), "commit"))
+	require.True(t, Is(traced, &ErrWriteConflict{}))
+	require.True(t, IsErrWriteConflict(traced))
+	require.False(t, Is(traced, &ErrTxnTooLarge{}))
+	require.False(t, stderrors.Is(traced, &ErrWriteConflict{}))
+	require.True(t, stderrors.Is(errors.Cause(traced), &ErrWriteConflict{}))
+	wrapped := fmt.Errorf("commit: %w", errors.Trace(tooLarge))
+	require.True(t, Is(wrapped, &ErrTxnTooLarge{}))
+	require.True(t, stderrors.Is(fmt.Errorf("commit: %w", tooLarge), &ErrTxnTooLarge{}))
+
+	// The errors with a cause unwrap to it.
+	rw := &ErrReadWriteConflict{Conflict: conflict}
+	require.True(t, Is(errors.Trace(rw), &ErrReadWriteConflict{}))
+	require.True(t, Is(errors.Trace(rw), &ErrWriteConflict{}))
+	require.True(t, stderrors.Is(rw, &ErrWriteConflict{}))
+	require.Equal(t, conflict, stderrors.Unwrap(rw))
+	batch := &BatchError{RegionID: 1, Err: errors.Trace(tooLarge)}
+	require.True(t, Is(batch, &ErrTxnTooLarge{}))
+	var target *ErrTxnTooLarge
+	require.True(t, stderrors.As(fmt.Errorf("%w", &BatchError{RegionID: 1, Err: tooLarge}), &target))
+	require.Equal(t, tooLarge, target)
+
+	// A MultiError matches the errors of all its batches.
+	multi := errors.Trace(&MultiError{Errors: []BatchError{
+		{RegionID: 1, Err: errors.Trace(conflict)},
+		{RegionID: 2, Err: tooLarge},
+	}})
+	require.True(t, Is(multi, &MultiError{}))
+	require.True(t, Is(multi, &ErrWriteConflict{}))
+	require.True(t, Is(multi, &ErrTxnTooLarge{}))
+	require.False(t, Is(multi, &ErrTokenLimit{}))
+	require.True(t, stderrors.Is(errors.Cause(multi), &ErrTxnTooLarge{}))
+
+	// The sentinel errors are compared by identity.
+	require.True(t, IsErrNotFound(errors.Trace(ErrNotExist)))
+	require.False(t, Is(errors.Trace(ErrNotExist), ErrCannotSetNilValue))
+}
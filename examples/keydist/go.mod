@@ -0,0 +1,7 @@
+module keydist
+
+go 1.16
+
+require github.com/tikv/client-go/v2 v2.0.0
+
+replace github.com/tikv/client-go/v2 => ../../
@@ -0,0 +1,60 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+
+	"github.com/tikv/client-go/v2/tikv"
+)
+
+var (
+	pdAddr     = flag.String("pd", "127.0.0.1:2379", "pd address")
+	startKey   = flag.String("start", "", "hex encoded start key of the range")
+	endKey     = flag.String("end", "", "hex encoded end key of the range, empty means the end of the key space")
+	sampleRate = flag.Float64("rate", 0.01, "sample rate of the keys")
+	splits     = flag.Int("splits", 0, "number of the suggested split points")
+)
+
+func main() {
+	flag.Parse()
+	start, err := hex.DecodeString(*startKey)
+	if err != nil {
+		panic(err)
+	}
+	end, err := hex.DecodeString(*endKey)
+	if err != nil {
+		panic(err)
+	}
+	client, err := tikv.NewTxnClient([]string{*pdAddr})
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	dist, err := tikv.NewKeyDistributionAnalyzer(client).Analyze(context.Background(), start, end, *sampleRate)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("estimated keys: %d, sampled keys: %d\n", dist.EstimatedKeys(), len(dist.Samples))
+	for _, r := range dist.Regions {
+		fmt.Printf("region %d [%x, %x): %d sampled keys\n", r.RegionID, r.StartKey, r.EndKey, r.SampledKeys)
+	}
+	for _, key := range dist.SuggestedSplitPoints(*splits) {
+		fmt.Printf("split point: %x\n", key)
+	}
+}
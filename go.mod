@@ -3,6 +3,8 @@ module github.com/tikv/client-go/v2
 go 1.16
 
 require (
+	github.com/beorn7/perks v1.0.1
+	github.com/coreos/go-semver v0.3.0
 	github.com/cznic/mathutil v0.0.0-20181122101859-297441e03548
 	github.com/dgryski/go-farm v0.0.0-20190423205320-6a90982ecee2
 	github.com/dustin/go-humanize v1.0.0 // indirect
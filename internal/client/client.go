@@ -44,6 +44,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
@@ -58,6 +59,7 @@ import (
 	"github.com/tikv/client-go/v2/metrics"
 	"github.com/tikv/client-go/v2/tikvrpc"
 	"github.com/tikv/client-go/v2/util"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/connectivity"
@@ -78,6 +80,37 @@ const (
 	ReadTimeoutMedium = 60 * time.Second // For requests that may need scan region.
 )
 
+// Timeouts are the timeout durations multiplied by the RPC timeout multiplier.
+type Timeouts struct {
+	Multiplier float64
+	Dial       time.Duration
+	ReadShort  time.Duration
+}
+
+// ResolveTimeouts multiplies the timeout durations. A non-positive multiplier
+// is treated as 1.
+func ResolveTimeouts(multiplier float64) Timeouts {
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+	t := Timeouts{Multiplier: multiplier}
+	t.Dial = t.Scale(dialTimeout)
+	t.ReadShort = t.Scale(ReadTimeoutShort)
+	return t
+}
+
+// GlobalTimeouts resolves the timeouts by the multiplier of the global config.
+// The requests sent by a RegionRequestSender are scaled by the sender, the
+// others should take their timeouts from here.
+func GlobalTimeouts() Timeouts {
+	return ResolveTimeouts(config.GetGlobalConfig().TiKVClient.RPCTimeoutMultiplier)
+}
+
+// Scale multiplies a timeout duration by the multiplier.
+func (t Timeouts) Scale(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * t.Multiplier)
+}
+
 // Grpc window size
 const (
 	GrpcInitialWindowSize     = 1 << 30
@@ -105,18 +138,34 @@ type connArray struct {
 	// streamTimeout binds with a background goroutine to process coprocessor streaming timeout.
 	streamTimeout chan *tikvrpc.Lease
 	dialTimeout   time.Duration
+	// auditLogger is not nil when the RPC audit log is enabled.
+	auditLogger *zap.Logger
 	// batchConn is not null when batch is enabled.
 	*batchConn
 	done chan struct{}
+
+	// connState decides the gauge that the connections are counted in.
+	connState   int32
+	activeConns prometheus.Gauge
+	idleConns   prometheus.Gauge
+	reused      prometheus.Counter
 }
 
-func newConnArray(maxSize uint, addr string, security config.Security, idleNotify *uint32, enableBatch bool, dialTimeout time.Duration) (*connArray, error) {
+// The states of the connections of a connArray.
+const (
+	connActive int32 = iota
+	connIdle
+	connClosed
+)
+
+func newConnArray(maxSize uint, addr string, security config.Security, idleNotify *uint32, enableBatch bool, dialTimeout time.Duration, auditLogger *zap.Logger) (*connArray, error) {
 	a := &connArray{
 		index:         0,
 		v:             make([]*grpc.ClientConn, maxSize),
 		streamTimeout: make(chan *tikvrpc.Lease, 1024),
 		done:          make(chan struct{}),
 		dialTimeout:   dialTimeout,
+		auditLogger:   auditLogger,
 	}
 	if err := a.Init(addr, security, idleNotify, enableBatch); err != nil {
 		return nil, err
@@ -126,6 +175,9 @@ func newConnArray(maxSize uint, addr string, security config.Security, idleNotif
 
 func (a *connArray) Init(addr string, security config.Security, idleNotify *uint32, enableBatch bool) error {
 	a.target = addr
+	a.activeConns = metrics.TiKVGRPCConnectionsActive.WithLabelValues(addr, "active")
+	a.idleConns = metrics.TiKVGRPCConnectionsActive.WithLabelValues(addr, "idle")
+	a.reused = metrics.TiKVGRPCConnectionReuseTotal.WithLabelValues(addr)
 
 	opt := grpc.WithInsecure()
 	if len(security.ClusterSSLCA) != 0 {
@@ -145,12 +197,21 @@ func (a *connArray) Init(addr string, security config.Security, idleNotify *uint
 		unaryInterceptor = grpc_opentracing.UnaryClientInterceptor()
 		streamInterceptor = grpc_opentracing.StreamClientInterceptor()
 	}
+	if a.auditLogger != nil {
+		auditInterceptor := newAuditUnaryInterceptor(a.auditLogger)
+		if unaryInterceptor != nil {
+			unaryInterceptor = grpc_middleware.ChainUnaryClient(unaryInterceptor, auditInterceptor)
+		} else {
+			unaryInterceptor = auditInterceptor
+		}
+	}
 
 	allowBatch := (cfg.TiKVClient.MaxBatchSize > 0) && enableBatch
 	if allowBatch {
 		a.batchConn = newBatchConn(uint(len(a.v)), cfg.TiKVClient.MaxBatchSize, idleNotify)
 		a.pendingRequests = metrics.TiKVBatchPendingRequests.WithLabelValues(a.target)
 		a.batchSize = metrics.TiKVBatchRequests.WithLabelValues(a.target)
+		a.batchConn.onIdle = a.markIdle
 	}
 	keepAlive := cfg.TiKVClient.GrpcKeepAliveTime
 	keepAliveTimeout := cfg.TiKVClient.GrpcKeepAliveTimeout
@@ -192,6 +253,8 @@ func (a *connArray) Init(addr string, security config.Security, idleNotify *uint
 			return errors.Trace(err)
 		}
 		a.v[i] = conn
+		a.activeConns.Inc()
+		metrics.TiKVGRPCConnectionCreateTotal.WithLabelValues(addr).Inc()
 
 		if allowBatch {
 			batchClient := &batchCommandsClient{
@@ -222,18 +285,36 @@ func (a *connArray) Get() *grpc.ClientConn {
 	return a.v[next]
 }
 
+// markIdle moves the connections from the active gauge to the idle gauge when
+// the batchConn becomes idle.
+func (a *connArray) markIdle() {
+	if atomic.CompareAndSwapInt32(&a.connState, connActive, connIdle) {
+		n := float64(len(a.v))
+		a.activeConns.Sub(n)
+		a.idleConns.Add(n)
+	}
+}
+
 func (a *connArray) Close() {
 	if a.batchConn != nil {
 		a.batchConn.Close()
 	}
 
+	closed := 0
 	for i, c := range a.v {
 		if c != nil {
 			err := c.Close()
 			terror.Log(errors.Trace(err))
 			a.v[i] = nil
+			closed++
 		}
 	}
+	switch atomic.SwapInt32(&a.connState, connClosed) {
+	case connActive:
+		a.activeConns.Sub(float64(closed))
+	case connIdle:
+		a.idleConns.Sub(float64(closed))
+	}
 
 	close(a.done)
 }
@@ -256,18 +337,29 @@ type RPCClient struct {
 	// Implement background cleanup.
 	isClosed    bool
 	dialTimeout time.Duration
+	auditLogger *zap.Logger
+
+	quotaEnforcer QuotaEnforcer
+	// keyspaceID is the keyspace the requests are charged to by quotaEnforcer.
+	keyspaceID uint32
+	// tracer is not nil when the RPCs of the sampled transactions are traced.
+	tracer *txnTracer
+
+	configWatcher     config.ConfigWatcher
+	stopConfigWatcher func()
 }
 
 // NewRPCClient creates a client that manages connections and rpc calls with tikv-servers.
-func NewRPCClient(security config.Security, opts ...func(c *RPCClient)) *RPCClient {
+func NewRPCClient(security config.Security, opts ...ClientOption) *RPCClient {
 	cli := &RPCClient{
 		conns:       make(map[string]*connArray),
 		security:    security,
-		dialTimeout: dialTimeout,
+		dialTimeout: GlobalTimeouts().Dial,
 	}
 	for _, opt := range opts {
 		opt(cli)
 	}
+	cli.startConfigWatcher()
 	return cli
 }
 
@@ -285,6 +377,8 @@ func (c *RPCClient) getConnArray(addr string, enableBatch bool, opt ...func(cfg
 		if err != nil {
 			return nil, err
 		}
+	} else {
+		array.reused.Inc()
 	}
 	return array, nil
 }
@@ -299,7 +393,7 @@ func (c *RPCClient) createConnArray(addr string, enableBatch bool, opts ...func(
 		for _, opt := range opts {
 			opt(&client)
 		}
-		array, err = newConnArray(client.GrpcConnectionCount, addr, c.security, &c.idleNotify, enableBatch, c.dialTimeout)
+		array, err = newConnArray(client.GrpcConnectionCount, addr, c.security, &c.idleNotify, enableBatch, c.dialTimeout, c.auditLogger)
 		if err != nil {
 			return nil, err
 		}
@@ -345,7 +439,22 @@ func (c *RPCClient) updateTiKVSendReqHistogram(req *tikvrpc.Request, start time.
 }
 
 // SendRequest sends a Request to server and receives Response.
-func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (resp *tikvrpc.Response, err error) {
+	if c.tracer != nil {
+		if startTS, ok := c.tracer.sampled(req); ok {
+			start := time.Now()
+			defer func() {
+				c.tracer.record(startTS, addr, req, resp, err, time.Since(start))
+			}()
+		}
+	}
+	if c.quotaEnforcer != nil {
+		return c.sendRequestWithQuota(ctx, addr, req, timeout)
+	}
+	return c.sendRequest(ctx, addr, req, timeout)
+}
+
+func (c *RPCClient) sendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
 	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
 		span1 := span.Tracer().StartSpan(fmt.Sprintf("rpcClient.SendRequest, region ID: %d, type: %s", req.RegionId, req.Type), opentracing.ChildOf(span.Context()))
 		defer span1.Finish()
@@ -382,10 +491,16 @@ func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.R
 	if config.GetGlobalConfig().TiKVClient.MaxBatchSize > 0 && enableBatch {
 		if batchReq := req.ToBatchCommandsRequest(); batchReq != nil {
 			defer trace.StartRegion(ctx, req.Type.String()).End()
-			return sendBatchRequest(ctx, addr, req.ForwardedHost, connArray.batchConn, batchReq, timeout)
+			if c.auditLogger != nil {
+				logRPCAudit(c.auditLogger, req.Type.String(), addr, &req.Context)
+			}
+			return sendBatchRequest(ctx, addr, req.ForwardedHost, req.Metadata, connArray.batchConn, batchReq, timeout)
 		}
 	}
 
+	if c.auditLogger != nil {
+		ctx = context.WithValue(ctx, auditCmdTypeKey{}, req.Type)
+	}
 	clientConn := connArray.Get()
 	if state := clientConn.GetState(); state == connectivity.TransientFailure {
 		storeID := strconv.FormatUint(req.Context.GetPeer().GetStoreId(), 10)
@@ -405,6 +520,9 @@ func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.R
 	if req.ForwardedHost != "" {
 		ctx = metadata.AppendToOutgoingContext(ctx, forwardMetadataKey, req.ForwardedHost)
 	}
+	for k, v := range req.Metadata {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
 	switch req.Type {
 	case tikvrpc.CmdBatchCop:
 		return c.getBatchCopStreamResponse(ctx, client, req, timeout, connArray)
@@ -524,6 +642,9 @@ func (c *RPCClient) getMPPStreamResponse(ctx context.Context, client tikvpb.Tikv
 // Close closes all connections.
 func (c *RPCClient) Close() error {
 	// TODO: add a unit test for SendRequest After Closed
+	if c.stopConfigWatcher != nil {
+		c.stopConfigWatcher()
+	}
 	c.closeConns()
 	return nil
 }
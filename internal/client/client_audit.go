@@ -0,0 +1,99 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+)
+
+// ClientOption configures the RPCClient.
+type ClientOption = func(c *RPCClient)
+
+// WithRPCAuditLog makes the RPCClient log every outgoing RPC with its type,
+// destination and all fields of its kvrpcpb.Context at DEBUG level. It's used
+// to audit that requests carry the expected resource group tag, priority, etc.
+//
+// Unary RPCs are logged by a gRPC unary interceptor. Requests sent by the batch
+// commands stream are logged before they are put into the batch. Other
+// streaming RPCs, such as coprocessor streams, are not logged.
+func WithRPCAuditLog(logger *zap.Logger) ClientOption {
+	return func(c *RPCClient) {
+		c.auditLogger = logger
+	}
+}
+
+// auditCmdTypeKey is the context key of the tikvrpc.CmdType of the RPC, the
+// unary interceptor logs it as the type of the RPC.
+type auditCmdTypeKey struct{}
+
+type contextGetter interface {
+	GetContext() *kvrpcpb.Context
+}
+
+// newAuditUnaryInterceptor returns a gRPC unary interceptor that logs the
+// kvrpcpb.Context of every request.
+func newAuditUnaryInterceptor(logger *zap.Logger) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var rpcCtx *kvrpcpb.Context
+		if r, ok := req.(contextGetter); ok {
+			rpcCtx = r.GetContext()
+		}
+		rpcType := method
+		if tp, ok := ctx.Value(auditCmdTypeKey{}).(tikvrpc.CmdType); ok {
+			rpcType = tp.String()
+		}
+		logRPCAudit(logger, rpcType, cc.Target(), rpcCtx)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func logRPCAudit(logger *zap.Logger, rpcType string, target string, rpcCtx *kvrpcpb.Context) {
+	ce := logger.Check(zapcore.DebugLevel, "rpc audit")
+	if ce == nil {
+		return
+	}
+	if rpcCtx == nil {
+		ce.Write(zap.String("type", rpcType), zap.String("target", target), zap.Bool("hasContext", false))
+		return
+	}
+	ce.Write(
+		zap.String("type", rpcType),
+		zap.String("target", target),
+		zap.Uint64("storeID", rpcCtx.GetPeer().GetStoreId()),
+		zap.Uint64("regionID", rpcCtx.GetRegionId()),
+		zap.Uint64("confVer", rpcCtx.GetRegionEpoch().GetConfVer()),
+		zap.Uint64("ver", rpcCtx.GetRegionEpoch().GetVersion()),
+		zap.Uint64("peerID", rpcCtx.GetPeer().GetId()),
+		zap.Uint64("term", rpcCtx.GetTerm()),
+		zap.Stringer("priority", rpcCtx.GetPriority()),
+		zap.Stringer("isolationLevel", rpcCtx.GetIsolationLevel()),
+		zap.Bool("notFillCache", rpcCtx.GetNotFillCache()),
+		zap.Bool("syncLog", rpcCtx.GetSyncLog()),
+		zap.Bool("recordTimeStat", rpcCtx.GetRecordTimeStat()),
+		zap.Bool("recordScanStat", rpcCtx.GetRecordScanStat()),
+		zap.Bool("replicaRead", rpcCtx.GetReplicaRead()),
+		zap.Uint64s("resolvedLocks", rpcCtx.GetResolvedLocks()),
+		zap.Uint64("maxExecutionDurationMs", rpcCtx.GetMaxExecutionDurationMs()),
+		zap.Uint64("appliedIndex", rpcCtx.GetAppliedIndex()),
+		zap.Uint64("taskID", rpcCtx.GetTaskId()),
+		zap.Bool("staleRead", rpcCtx.GetStaleRead()),
+		zap.Binary("resourceGroupTag", rpcCtx.GetResourceGroupTag()),
+	)
+}
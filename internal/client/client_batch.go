@@ -37,6 +37,8 @@ import (
 	"context"
 	"math"
 	"runtime/trace"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -64,11 +66,42 @@ type batchCommandsEntry struct {
 	// forwardedHost is the address of a store which will handle the request.
 	// It's different from the address the request sent to.
 	forwardedHost string
+	// metadata is the gRPC metadata of the request, see tikvrpc.Request.
+	metadata map[string]string
 	// canceled indicated the request is canceled or not.
 	canceled int32
 	err      error
 }
 
+// streamKey identifies the stream to send the entry by. gRPC doesn't support
+// setting metadata for each request in a stream, so the entries with different
+// forwarded hosts or metadata are sent by different streams.
+func (b *batchCommandsEntry) streamKey() string {
+	return batchStreamKey(b.forwardedHost, b.metadata)
+}
+
+// batchStreamKey is the forwarded host followed by the sorted metadata. The
+// keys and values of gRPC metadata are printable, so they're separated by 0.
+func batchStreamKey(forwardedHost string, md map[string]string) string {
+	if len(md) == 0 {
+		return forwardedHost
+	}
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(forwardedHost)
+	for _, k := range keys {
+		sb.WriteByte(0)
+		sb.WriteString(k)
+		sb.WriteByte(0)
+		sb.WriteString(md[k])
+	}
+	return sb.String()
+}
+
 func (b *batchCommandsEntry) isCanceled() bool {
 	return atomic.LoadInt32(&b.canceled) == 1
 }
@@ -87,8 +120,12 @@ type batchCommandsBuilder struct {
 	entries    []*batchCommandsEntry
 	requests   []*tikvpb.BatchCommandsRequest_Request
 	requestIDs []uint64
-	// In most cases, there isn't any forwardingReq.
+	// In most cases, there isn't any forwardingReq. The requests that need
+	// forwarding or have metadata are keyed by batchCommandsEntry.streamKey.
 	forwardingReqs map[string]*tikvpb.BatchCommandsRequest
+	// forwardingEntries are the first entries of forwardingReqs, whose
+	// forwarded host and metadata are set to the streams.
+	forwardingEntries map[string]*batchCommandsEntry
 }
 
 func (b *batchCommandsBuilder) len() int {
@@ -101,7 +138,7 @@ func (b *batchCommandsBuilder) push(entry *batchCommandsEntry) {
 
 // build builds BatchCommandsRequests and calls collect() for each valid entry.
 // The first return value is the request that doesn't need forwarding.
-// The second is a map that maps forwarded hosts to requests.
+// The second is a map that maps stream keys to requests.
 func (b *batchCommandsBuilder) build(
 	collect func(id uint64, e *batchCommandsEntry),
 ) (*tikvpb.BatchCommandsRequest, map[string]*tikvpb.BatchCommandsRequest) {
@@ -112,14 +149,15 @@ func (b *batchCommandsBuilder) build(
 		if collect != nil {
 			collect(b.idAlloc, e)
 		}
-		if e.forwardedHost == "" {
+		if key := e.streamKey(); key == "" {
 			b.requestIDs = append(b.requestIDs, b.idAlloc)
 			b.requests = append(b.requests, e.req)
 		} else {
-			batchReq, ok := b.forwardingReqs[e.forwardedHost]
+			batchReq, ok := b.forwardingReqs[key]
 			if !ok {
 				batchReq = &tikvpb.BatchCommandsRequest{}
-				b.forwardingReqs[e.forwardedHost] = batchReq
+				b.forwardingReqs[key] = batchReq
+				b.forwardingEntries[key] = e
 			}
 			batchReq.RequestIds = append(batchReq.RequestIds, b.idAlloc)
 			batchReq.Requests = append(batchReq.Requests, e.req)
@@ -161,16 +199,18 @@ func (b *batchCommandsBuilder) reset() {
 
 	for k := range b.forwardingReqs {
 		delete(b.forwardingReqs, k)
+		delete(b.forwardingEntries, k)
 	}
 }
 
 func newBatchCommandsBuilder(maxBatchSize uint) *batchCommandsBuilder {
 	return &batchCommandsBuilder{
-		idAlloc:        0,
-		entries:        make([]*batchCommandsEntry, 0, maxBatchSize),
-		requests:       make([]*tikvpb.BatchCommandsRequest_Request, 0, maxBatchSize),
-		requestIDs:     make([]uint64, 0, maxBatchSize),
-		forwardingReqs: make(map[string]*tikvpb.BatchCommandsRequest),
+		idAlloc:           0,
+		entries:           make([]*batchCommandsEntry, 0, maxBatchSize),
+		requests:          make([]*tikvpb.BatchCommandsRequest_Request, 0, maxBatchSize),
+		requestIDs:        make([]uint64, 0, maxBatchSize),
+		forwardingReqs:    make(map[string]*tikvpb.BatchCommandsRequest),
+		forwardingEntries: make(map[string]*batchCommandsEntry),
 	}
 }
 
@@ -190,6 +230,8 @@ type batchConn struct {
 	// Notify rpcClient to check the idle flag
 	idleNotify *uint32
 	idleDetect *time.Timer
+	// onIdle is called once when the batchConn becomes idle if it's not nil.
+	onIdle func()
 
 	pendingRequests prometheus.Observer
 	batchSize       prometheus.Observer
@@ -227,7 +269,9 @@ func (a *batchConn) fetchAllPendingRequests(
 		a.idleDetect.Reset(idleTimeout)
 	case <-a.idleDetect.C:
 		a.idleDetect.Reset(idleTimeout)
-		atomic.AddUint32(&a.idle, 1)
+		if atomic.AddUint32(&a.idle, 1) == 1 && a.onIdle != nil {
+			a.onIdle()
+		}
 		atomic.CompareAndSwapUint32(a.idleNotify, 0, 1)
 		// This batchConn to be recycled
 		return time.Now()
@@ -376,10 +420,11 @@ func (a *batchConn) getClientAndSend() {
 		}
 	})
 	if req != nil {
-		cli.send("", req)
+		cli.send("", nil, req)
 	}
-	for forwardedHost, req := range forwardingReqs {
-		cli.send(forwardedHost, req)
+	for key, req := range forwardingReqs {
+		e := a.reqBuilder.forwardingEntries[key]
+		cli.send(e.forwardedHost, e.metadata, req)
 	}
 }
 
@@ -420,6 +465,7 @@ func (l *tryLock) unlockForRecreate() {
 type batchCommandsStream struct {
 	tikvpb.Tikv_BatchCommandsClient
 	forwardedHost string
+	metadata      map[string]string
 }
 
 func (s *batchCommandsStream) recv() (resp *tikvpb.BatchCommandsResponse, err error) {
@@ -448,6 +494,9 @@ func (s *batchCommandsStream) recreate(conn *grpc.ClientConn) error {
 	if s.forwardedHost != "" {
 		ctx = metadata.AppendToOutgoingContext(ctx, forwardMetadataKey, s.forwardedHost)
 	}
+	for k, v := range s.metadata {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
 	streamClient, err := tikvClient.BatchCommands(ctx)
 	if err != nil {
 		return errors.Trace(err)
@@ -467,9 +516,11 @@ type batchCommandsClient struct {
 	client *batchCommandsStream
 	// TiDB uses [gRPC-metadata](https://github.com/grpc/grpc-go/blob/master/Documentation/grpc-metadata.md) to
 	// indicate a request needs forwarding. gRPC doesn't support setting a metadata for each request in a stream,
-	// so we need to create a stream for each forwarded host.
+	// so we need to create a stream for each forwarded host. The requests with
+	// metadata are sent the same way.
 	//
-	// forwardedClients are clients that need forwarding. It's a map that maps forwarded hosts to streams
+	// forwardedClients are clients that need forwarding or have metadata. It's a map that maps
+	// stream keys to streams, see batchCommandsEntry.streamKey.
 	forwardedClients map[string]*batchCommandsStream
 	batched          sync.Map
 
@@ -491,8 +542,9 @@ func (c *batchCommandsClient) isStopped() bool {
 	return atomic.LoadInt32(&c.closed) != 0
 }
 
-func (c *batchCommandsClient) send(forwardedHost string, req *tikvpb.BatchCommandsRequest) {
-	err := c.initBatchClient(forwardedHost)
+func (c *batchCommandsClient) send(forwardedHost string, md map[string]string, req *tikvpb.BatchCommandsRequest) {
+	key := batchStreamKey(forwardedHost, md)
+	err := c.initBatchClient(key, forwardedHost, md)
 	if err != nil {
 		logutil.BgLogger().Warn(
 			"init create streaming fail",
@@ -505,8 +557,8 @@ func (c *batchCommandsClient) send(forwardedHost string, req *tikvpb.BatchComman
 	}
 
 	client := c.client
-	if forwardedHost != "" {
-		client = c.forwardedClients[forwardedHost]
+	if key != "" {
+		client = c.forwardedClients[key]
 	}
 	if err := client.Send(req); err != nil {
 		logutil.BgLogger().Info(
@@ -699,19 +751,19 @@ func (c *batchCommandsClient) recreateStreamingClient(err error, streamClient *b
 	return false
 }
 
-func (c *batchCommandsClient) newBatchStream(forwardedHost string) (*batchCommandsStream, error) {
-	batchStream := &batchCommandsStream{forwardedHost: forwardedHost}
+func (c *batchCommandsClient) newBatchStream(forwardedHost string, md map[string]string) (*batchCommandsStream, error) {
+	batchStream := &batchCommandsStream{forwardedHost: forwardedHost, metadata: md}
 	if err := batchStream.recreate(c.conn); err != nil {
 		return nil, errors.Trace(err)
 	}
 	return batchStream, nil
 }
 
-func (c *batchCommandsClient) initBatchClient(forwardedHost string) error {
-	if forwardedHost == "" && c.client != nil {
+func (c *batchCommandsClient) initBatchClient(key, forwardedHost string, md map[string]string) error {
+	if key == "" && c.client != nil {
 		return nil
 	}
-	if _, ok := c.forwardedClients[forwardedHost]; ok {
+	if _, ok := c.forwardedClients[key]; ok {
 		return nil
 	}
 
@@ -719,14 +771,14 @@ func (c *batchCommandsClient) initBatchClient(forwardedHost string) error {
 		return err
 	}
 
-	streamClient, err := c.newBatchStream(forwardedHost)
+	streamClient, err := c.newBatchStream(forwardedHost, md)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	if forwardedHost == "" {
+	if key == "" {
 		c.client = streamClient
 	} else {
-		c.forwardedClients[forwardedHost] = streamClient
+		c.forwardedClients[key] = streamClient
 	}
 	go c.batchRecvLoop(c.tikvClientCfg, c.tikvLoad, streamClient)
 	return nil
@@ -748,6 +800,7 @@ func sendBatchRequest(
 	ctx context.Context,
 	addr string,
 	forwardedHost string,
+	md map[string]string,
 	batchConn *batchConn,
 	req *tikvpb.BatchCommandsRequest_Request,
 	timeout time.Duration,
@@ -757,6 +810,7 @@ func sendBatchRequest(
 		req:           req,
 		res:           make(chan *tikvpb.BatchCommandsResponse_Response, 1),
 		forwardedHost: forwardedHost,
+		metadata:      md,
 		canceled:      0,
 		err:           nil,
 	}
@@ -45,10 +45,15 @@ import (
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/tikvpb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/metrics"
 	"github.com/tikv/client-go/v2/tikvrpc"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -73,16 +78,46 @@ func TestConn(t *testing.T) {
 	assert.Nil(t, conn3)
 }
 
+func TestConnMetrics(t *testing.T) {
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.MaxBatchSize = 0
+		conf.TiKVClient.GrpcConnectionCount = 2
+	})()
+
+	client := NewRPCClient(config.Security{})
+	addr := "127.0.0.1:6380"
+	active := metrics.TiKVGRPCConnectionsActive.WithLabelValues(addr, "active")
+	idle := metrics.TiKVGRPCConnectionsActive.WithLabelValues(addr, "idle")
+	for i := 0; i < 3; i++ {
+		_, err := client.getConnArray(addr, true)
+		require.Nil(t, err)
+	}
+	require.Equal(t, 2.0, testutil.ToFloat64(metrics.TiKVGRPCConnectionCreateTotal.WithLabelValues(addr)))
+	require.Equal(t, 2.0, testutil.ToFloat64(metrics.TiKVGRPCConnectionReuseTotal.WithLabelValues(addr)))
+	require.Equal(t, 2.0, testutil.ToFloat64(active))
+
+	conns, err := client.getConnArray(addr, true)
+	require.Nil(t, err)
+	conns.markIdle()
+	conns.markIdle()
+	require.Equal(t, 0.0, testutil.ToFloat64(active))
+	require.Equal(t, 2.0, testutil.ToFloat64(idle))
+
+	client.Close()
+	require.Equal(t, 0.0, testutil.ToFloat64(active))
+	require.Equal(t, 0.0, testutil.ToFloat64(idle))
+}
+
 func TestCancelTimeoutRetErr(t *testing.T) {
 	req := new(tikvpb.BatchCommandsRequest_Request)
 	a := newBatchConn(1, 1, nil)
 
 	ctx, cancel := context.WithCancel(context.TODO())
 	cancel()
-	_, err := sendBatchRequest(ctx, "", "", a, req, 2*time.Second)
+	_, err := sendBatchRequest(ctx, "", "", nil, a, req, 2*time.Second)
 	assert.Equal(t, errors.Cause(err), context.Canceled)
 
-	_, err = sendBatchRequest(context.Background(), "", "", a, req, 0)
+	_, err = sendBatchRequest(context.Background(), "", "", nil, a, req, 0)
 	assert.Equal(t, errors.Cause(err), context.DeadlineExceeded)
 }
 
@@ -274,6 +309,109 @@ func TestForwardMetadataByUnaryCall(t *testing.T) {
 	assert.Equal(t, atomic.LoadUint64(&checkCnt), uint64(4))
 }
 
+func TestRPCAuditLog(t *testing.T) {
+	server, port := startMockTikvService()
+	require.True(t, port > 0)
+	defer server.Stop()
+	addr := fmt.Sprintf("%s:%d", "127.0.0.1", port)
+
+	// Disable batch.
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.MaxBatchSize = 0
+		conf.TiKVClient.GrpcConnectionCount = 1
+	})()
+	core, logs := observer.New(zapcore.DebugLevel)
+	rpcClient := NewRPCClient(config.Security{}, WithRPCAuditLog(zap.New(core)))
+	defer rpcClient.closeConns()
+
+	prewriteReq := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{}, kvrpcpb.Context{
+		Priority:         kvrpcpb.CommandPri_High,
+		ResourceGroupTag: []byte("tag"),
+	})
+	require.Nil(t, tikvrpc.SetContext(prewriteReq, &metapb.Region{Id: 2}, &metapb.Peer{Id: 3, StoreId: 1}))
+	_, err := rpcClient.SendRequest(context.Background(), addr, prewriteReq, 10*time.Second)
+	require.Nil(t, err)
+
+	entries := logs.FilterMessage("rpc audit").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, tikvrpc.CmdPrewrite.String(), fields["type"])
+	assert.Equal(t, addr, fields["target"])
+	assert.Equal(t, "High", fields["priority"])
+	assert.Equal(t, []byte("tag"), fields["resourceGroupTag"])
+	assert.Equal(t, uint64(2), fields["regionID"])
+	assert.Equal(t, uint64(1), fields["storeID"])
+}
+
+type chanConfigWatcher chan config.TiKVClient
+
+func (w chanConfigWatcher) Subscribe() <-chan config.TiKVClient { return w }
+
+func TestConfigWatcher(t *testing.T) {
+	defer config.UpdateGlobal(func(conf *config.Config) {})()
+	w := make(chanConfigWatcher)
+	rpcClient := NewRPCClient(config.Security{}, WithConfigWatcher(w))
+
+	cfg := config.GetGlobalConfig().TiKVClient
+	cfg.StoreLimit = 42
+	w <- cfg
+	require.Eventually(t, func() bool {
+		return config.GetGlobalConfig().TiKVClient.StoreLimit == 42
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The watcher is stopped with the client.
+	require.Nil(t, rpcClient.Close())
+	select {
+	case w <- cfg:
+		require.Fail(t, "config is received after the client is closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRequestMetadata(t *testing.T) {
+	server, port := startMockTikvService()
+	require.True(t, port > 0)
+	defer server.Stop()
+	addr := fmt.Sprintf("%s:%d", "127.0.0.1", port)
+
+	for _, maxBatchSize := range []uint{0, 128} {
+		restore := config.UpdateGlobal(func(conf *config.Config) {
+			conf.TiKVClient.MaxBatchSize = maxBatchSize
+			conf.TiKVClient.GrpcConnectionCount = 1
+		})
+		rpcClient := NewRPCClient(config.Security{})
+
+		var checkCnt uint64
+		var caller atomic.Value
+		server.setMetaChecker(func(ctx context.Context) error {
+			atomic.AddUint64(&checkCnt, 1)
+			md, ok := metadata.FromIncomingContext(ctx)
+			assert.True(t, ok)
+			assert.Equal(t, []string{caller.Load().(string)}, md.Get("x-caller"))
+			return nil
+		})
+
+		for i, name := range []string{"alice", "alice", "bob"} {
+			caller.Store(name)
+			prewriteReq := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{})
+			prewriteReq.Metadata = map[string]string{"x-caller": name}
+			_, err := rpcClient.SendRequest(context.Background(), addr, prewriteReq, 10*time.Second)
+			assert.Nil(t, err)
+			if maxBatchSize == 0 {
+				assert.Equal(t, uint64(i+1), atomic.LoadUint64(&checkCnt))
+			}
+		}
+		if maxBatchSize > 0 {
+			// The batched requests with the same metadata share a stream.
+			assert.Equal(t, uint64(2), atomic.LoadUint64(&checkCnt))
+		}
+
+		server.setMetaChecker(nil)
+		rpcClient.closeConns()
+		restore()
+	}
+}
+
 func TestForwardMetadataByBatchCommands(t *testing.T) {
 	server, port := startMockTikvService()
 	require.True(t, port > 0)
@@ -445,3 +583,13 @@ func TestBatchCommandsBuilder(t *testing.T) {
 	assert.Equal(t, len(builder.forwardingReqs), 0)
 	assert.NotEqual(t, builder.idAlloc, 0)
 }
+
+func TestGlobalTimeouts(t *testing.T) {
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.RPCTimeoutMultiplier = 2
+	})()
+	timeouts := GlobalTimeouts()
+	assert.Equal(t, 2*dialTimeout, timeouts.Dial)
+	assert.Equal(t, 2*ReadTimeoutShort, timeouts.ReadShort)
+	assert.Equal(t, 2*dialTimeout, NewRPCClient(config.Security{}).dialTimeout)
+}
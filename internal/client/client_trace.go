@@ -0,0 +1,263 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"go.uber.org/zap"
+)
+
+const (
+	// txnTraceCapacity is the max number of the RPC records kept for a
+	// transaction, the oldest ones are dropped first.
+	txnTraceCapacity = 256
+	// maxTracedTxns is the max number of the transactions traced at the same
+	// time. When it's reached, the traces idle for txnTraceIdleTimeout are
+	// emitted to make room for a new one, or the least recently active one if
+	// none is idle.
+	maxTracedTxns = 1024
+	// txnTraceIdleTimeout is the time after which a trace without new records
+	// may be emitted before its transaction emits it. A transaction may never
+	// emit its trace, e.g. a bare snapshot, or the secondaries committed after
+	// the transaction is closed.
+	txnTraceIdleTimeout = time.Minute
+)
+
+// TraceSampler decides whether the RPCs of a transaction are traced.
+type TraceSampler interface {
+	ShouldSample(startTS uint64) bool
+}
+
+// WithTraceSampler makes the RPCClient record the requests and responses of
+// the transactions sampled by s in the text proto format. The records of a
+// transaction are emitted when it finishes, to the writer set by
+// WithTraceWriter or to the log.
+//
+// The RPCs are attributed to a transaction by the start ts in the requests,
+// so the reads of a snapshot at the start ts are recorded too. Streaming RPCs
+// and the requests to resolve the locks of other transactions are not
+// recorded.
+func WithTraceSampler(s TraceSampler) ClientOption {
+	return func(c *RPCClient) {
+		if c.tracer == nil {
+			c.tracer = newTxnTracer()
+		}
+		c.tracer.sampler = s
+	}
+}
+
+// WithTraceWriter sets the writer the traces of the sampled transactions are
+// written to.
+func WithTraceWriter(w io.Writer) ClientOption {
+	return func(c *RPCClient) {
+		if c.tracer == nil {
+			c.tracer = newTxnTracer()
+		}
+		c.tracer.writer = w
+	}
+}
+
+// EmitTxnTrace emits the recorded RPCs of a transaction if it's traced by the
+// RPCClient under c.
+func EmitTxnTrace(c Client, startTS uint64) {
+	switch c := c.(type) {
+	case *RPCClient:
+		if c.tracer != nil {
+			c.tracer.emit(startTS)
+		}
+	case *reqCollapse:
+		EmitTxnTrace(c.Client, startTS)
+	case *writeStallClient:
+		EmitTxnTrace(c.Client, startTS)
+	}
+}
+
+type txnTrace struct {
+	records []string
+	// next is the position of the next record once the ring is full.
+	next       int
+	dropped    int
+	lastActive time.Time
+}
+
+func (t *txnTrace) add(record string) {
+	t.lastActive = time.Now()
+	if len(t.records) < txnTraceCapacity {
+		t.records = append(t.records, record)
+		return
+	}
+	t.records[t.next] = record
+	t.next = (t.next + 1) % txnTraceCapacity
+	t.dropped++
+}
+
+// ordered returns the records from the oldest to the latest.
+func (t *txnTrace) ordered() []string {
+	return append(append([]string(nil), t.records[t.next:]...), t.records[:t.next]...)
+}
+
+type txnTracer struct {
+	sampler TraceSampler
+	writer  io.Writer
+
+	mu     sync.Mutex
+	traces map[uint64]*txnTrace
+	// skipped are the transactions not sampled, so the sampler is asked once
+	// for a transaction.
+	skipped map[uint64]struct{}
+}
+
+func newTxnTracer() *txnTracer {
+	return &txnTracer{
+		traces:  make(map[uint64]*txnTrace),
+		skipped: make(map[uint64]struct{}),
+	}
+}
+
+// requestStartTS returns the start ts of the transaction sending the request.
+func requestStartTS(req *tikvrpc.Request) (uint64, bool) {
+	switch req.Type {
+	case tikvrpc.CmdResolveLock, tikvrpc.CmdCleanup, tikvrpc.CmdCheckSecondaryLocks:
+		// The start ts is of the transaction owning the lock.
+		return 0, false
+	}
+	switch r := req.Req.(type) {
+	case interface{ GetStartVersion() uint64 }:
+		return r.GetStartVersion(), true
+	case interface{ GetVersion() uint64 }:
+		return r.GetVersion(), true
+	}
+	return 0, false
+}
+
+// sampled returns the start ts of the request if its transaction is traced.
+func (t *txnTracer) sampled(req *tikvrpc.Request) (uint64, bool) {
+	if t.sampler == nil {
+		return 0, false
+	}
+	startTS, ok := requestStartTS(req)
+	if !ok || startTS == 0 {
+		return 0, false
+	}
+	t.mu.Lock()
+	if _, ok := t.traces[startTS]; ok {
+		t.mu.Unlock()
+		return startTS, true
+	}
+	if _, ok := t.skipped[startTS]; ok {
+		t.mu.Unlock()
+		return 0, false
+	}
+	if !t.sampler.ShouldSample(startTS) {
+		if len(t.skipped) >= maxTracedTxns {
+			t.skipped = make(map[uint64]struct{})
+		}
+		t.skipped[startTS] = struct{}{}
+		t.mu.Unlock()
+		return 0, false
+	}
+	var evicted map[uint64]*txnTrace
+	if len(t.traces) >= maxTracedTxns {
+		evicted = t.evictLocked(time.Now())
+	}
+	t.traces[startTS] = &txnTrace{lastActive: time.Now()}
+	t.mu.Unlock()
+	for ts, trace := range evicted {
+		t.write(ts, trace)
+	}
+	return startTS, true
+}
+
+// evictLocked removes the traces idle for txnTraceIdleTimeout, or the least
+// recently active one if none is idle, and returns them to be emitted. It
+// should be called with t.mu held.
+func (t *txnTracer) evictLocked(now time.Time) map[uint64]*txnTrace {
+	evicted := make(map[uint64]*txnTrace)
+	var (
+		oldestTS uint64
+		oldest   *txnTrace
+	)
+	for ts, trace := range t.traces {
+		if now.Sub(trace.lastActive) >= txnTraceIdleTimeout {
+			evicted[ts] = trace
+			delete(t.traces, ts)
+		} else if oldest == nil || trace.lastActive.Before(oldest.lastActive) {
+			oldestTS, oldest = ts, trace
+		}
+	}
+	if len(evicted) == 0 && oldest != nil {
+		evicted[oldestTS] = oldest
+		delete(t.traces, oldestTS)
+	}
+	return evicted
+}
+
+func (t *txnTracer) record(startTS uint64, addr string, req *tikvrpc.Request, resp *tikvrpc.Response, err error, elapsed time.Duration) {
+	record := fmt.Sprintf("%s %s to %s in %v request: %s", time.Now().Format(time.RFC3339Nano), req.Type, addr, elapsed, textProto(req.Req))
+	if err != nil {
+		record += fmt.Sprintf(" error: %v", err)
+	} else if resp != nil {
+		record += " response: " + textProto(resp.Resp)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if trace, ok := t.traces[startTS]; ok {
+		trace.add(record)
+	}
+}
+
+func (t *txnTracer) emit(startTS uint64) {
+	t.mu.Lock()
+	trace, ok := t.traces[startTS]
+	delete(t.traces, startTS)
+	delete(t.skipped, startTS)
+	t.mu.Unlock()
+	if ok {
+		t.write(startTS, trace)
+	}
+}
+
+func (t *txnTracer) write(startTS uint64, trace *txnTrace) {
+	records := trace.ordered()
+	if t.writer == nil {
+		logutil.BgLogger().Info("txn trace", zap.Uint64("txnStartTS", startTS),
+			zap.Int("dropped", trace.dropped), zap.Strings("rpcs", records))
+		return
+	}
+	// The writer may be shared by the clients, write the trace in one call.
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "txn %d trace, %d records dropped\n", startTS, trace.dropped)
+	for _, record := range records {
+		buf.WriteString(record)
+		buf.WriteByte('\n')
+	}
+	if _, err := io.WriteString(t.writer, buf.String()); err != nil {
+		logutil.BgLogger().Warn("write txn trace failed", zap.Uint64("txnStartTS", startTS), zap.Error(err))
+	}
+}
+
+func textProto(v interface{}) string {
+	if msg, ok := v.(proto.Message); ok {
+		return proto.CompactTextString(msg)
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+type evenTraceSampler struct{}
+
+func (evenTraceSampler) ShouldSample(startTS uint64) bool {
+	return startTS%2 == 0
+}
+
+func TestTxnTracer(t *testing.T) {
+	var buf bytes.Buffer
+	rpcClient := NewRPCClient(config.Security{}, WithTraceSampler(evenTraceSampler{}), WithTraceWriter(&buf))
+	defer rpcClient.Close()
+	tracer := rpcClient.tracer
+
+	prewrite := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{StartVersion: 10, PrimaryLock: []byte("k")})
+	startTS, ok := tracer.sampled(prewrite)
+	require.True(t, ok)
+	require.Equal(t, uint64(10), startTS)
+	tracer.record(startTS, "store1", prewrite, &tikvrpc.Response{Resp: &kvrpcpb.PrewriteResponse{}}, nil, 0)
+
+	get := tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k"), Version: 10})
+	startTS, ok = tracer.sampled(get)
+	require.True(t, ok)
+	tracer.record(startTS, "store1", get, &tikvrpc.Response{Resp: &kvrpcpb.GetResponse{Value: []byte("v")}}, nil, 0)
+
+	_, ok = tracer.sampled(tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Version: 11}))
+	require.False(t, ok)
+	_, ok = tracer.sampled(tikvrpc.NewRequest(tikvrpc.CmdResolveLock, &kvrpcpb.ResolveLockRequest{StartVersion: 12}))
+	require.False(t, ok)
+
+	// The trace is emitted through the clients wrapping the RPCClient.
+	EmitTxnTrace(NewReqCollapse(NewWriteStallClient(rpcClient, nil)), 10)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t, "txn 10 trace, 0 records dropped", lines[0])
+	require.Contains(t, lines[1], `Prewrite to store1`)
+	require.Contains(t, lines[1], `primary_lock:"k" start_version:10`)
+	require.Contains(t, lines[2], `response: value:"v"`)
+	require.Empty(t, tracer.traces)
+
+	// Tracing goes on once the never emitted traces fill up the tracer, by
+	// evicting the idle ones first and then the least recently active one.
+	buf.Reset()
+	for i := 0; i < maxTracedTxns; i++ {
+		_, ok = tracer.sampled(tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Version: uint64(100 + 2*i)}))
+		require.True(t, ok)
+	}
+	tracer.traces[100].lastActive = time.Now().Add(-txnTraceIdleTimeout)
+	tracer.traces[102].lastActive = time.Now().Add(-txnTraceIdleTimeout)
+	_, ok = tracer.sampled(tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Version: 10000}))
+	require.True(t, ok)
+	require.Len(t, tracer.traces, maxTracedTxns-1)
+	require.NotContains(t, tracer.traces, uint64(100))
+	require.NotContains(t, tracer.traces, uint64(102))
+	require.Contains(t, buf.String(), "txn 100 trace")
+	require.Contains(t, buf.String(), "txn 102 trace")
+	tracer.traces[104].lastActive = time.Now().Add(-time.Second)
+	_, ok = tracer.sampled(tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Version: 10002}))
+	require.True(t, ok)
+	_, ok = tracer.sampled(tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Version: 10004}))
+	require.True(t, ok)
+	require.Len(t, tracer.traces, maxTracedTxns)
+	require.NotContains(t, tracer.traces, uint64(104))
+
+	// The oldest records are dropped.
+	trace := &txnTrace{}
+	for i := 0; i < txnTraceCapacity+2; i++ {
+		trace.add(string(rune('a' + i%26)))
+	}
+	records := trace.ordered()
+	require.Len(t, records, txnTraceCapacity)
+	require.Equal(t, 2, trace.dropped)
+	require.Equal(t, "c", records[0])
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+
+	"github.com/tikv/client-go/v2/config"
+)
+
+// WithConfigWatcher makes the RPCClient apply the config updates from w to the
+// global config until it's closed.
+func WithConfigWatcher(w config.ConfigWatcher) ClientOption {
+	return func(c *RPCClient) {
+		c.configWatcher = w
+	}
+}
+
+func (c *RPCClient) startConfigWatcher() {
+	if c.configWatcher == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	c.stopConfigWatcher = func() {
+		cancel()
+		<-done
+	}
+	go func() {
+		defer close(done)
+		config.WatchConfig(ctx, c.configWatcher)
+	}()
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/util"
+)
+
+// QuotaEnforcer limits the reads and writes of the keyspaces sharing a
+// cluster. CheckWrite is called before a prewrite request is sent, with the
+// size of its mutations. The size of a read is unknown until it's done, so
+// CheckRead is called after the response of a get, batch get or scan request
+// is received, with the size of the returned pairs. A non-nil error of the
+// checks fails the request with a tikverr.ErrQuotaExceeded, which is not
+// retried.
+type QuotaEnforcer interface {
+	CheckRead(keyspace uint32, bytes int64) error
+	CheckWrite(keyspace uint32, bytes int64) error
+}
+
+// WithQuotaEnforcer makes the RPCClient check the requests against the
+// enforcer. The requests are charged to the keyspace of the client set by
+// WithKeyspaceID.
+func WithQuotaEnforcer(e QuotaEnforcer) ClientOption {
+	return func(c *RPCClient) {
+		c.quotaEnforcer = e
+	}
+}
+
+// WithKeyspaceID sets the keyspace the RPCClient serves, 0 by default. The
+// clients of different keyspaces sharing a cluster are created with their own
+// keyspace IDs.
+func WithKeyspaceID(id uint32) ClientOption {
+	return func(c *RPCClient) {
+		c.keyspaceID = id
+	}
+}
+
+func checkWriteQuota(e QuotaEnforcer, keyspace uint32, req *tikvrpc.Request) error {
+	if req.Type != tikvrpc.CmdPrewrite {
+		return nil
+	}
+	mutations := req.Prewrite().GetMutations()
+	if len(mutations) == 0 {
+		return nil
+	}
+	var size int64
+	for _, m := range mutations {
+		size += int64(len(m.Key) + len(m.Value))
+	}
+	err := util.SafeCall(func() error {
+		return e.CheckWrite(keyspace, size)
+	}, errors.New("QuotaEnforcer panicked on CheckWrite"))
+	return wrapQuotaError(keyspace, err)
+}
+
+// checkReadQuota checks the size of the pairs returned by a read.
+func checkReadQuota(e QuotaEnforcer, keyspace uint32, req *tikvrpc.Request, resp *tikvrpc.Response) error {
+	var (
+		pairs []*kvrpcpb.KvPair
+		size  int64
+	)
+	switch r := resp.Resp.(type) {
+	case *kvrpcpb.GetResponse:
+		if req.Type != tikvrpc.CmdGet || r.GetRegionError() != nil {
+			return nil
+		}
+		size = int64(len(req.Get().GetKey()) + len(r.GetValue()))
+	case *kvrpcpb.BatchGetResponse:
+		if r.GetRegionError() != nil {
+			return nil
+		}
+		pairs = r.GetPairs()
+	case *kvrpcpb.ScanResponse:
+		if r.GetRegionError() != nil {
+			return nil
+		}
+		pairs = r.GetPairs()
+	default:
+		return nil
+	}
+	for _, p := range pairs {
+		size += int64(len(p.Key) + len(p.Value))
+	}
+	err := util.SafeCall(func() error {
+		return e.CheckRead(keyspace, size)
+	}, errors.New("QuotaEnforcer panicked on CheckRead"))
+	return wrapQuotaError(keyspace, err)
+}
+
+func wrapQuotaError(keyspace uint32, err error) error {
+	if err == nil || tikverr.Is(err, &tikverr.ErrQuotaExceeded{}) {
+		return err
+	}
+	return &tikverr.ErrQuotaExceeded{Keyspace: keyspace, Reason: err.Error()}
+}
+
+func (c *RPCClient) sendRequestWithQuota(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if err := checkWriteQuota(c.quotaEnforcer, c.keyspaceID, req); err != nil {
+		return nil, err
+	}
+	resp, err := c.sendRequest(ctx, addr, req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReadQuota(c.quotaEnforcer, c.keyspaceID, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// QuotaLimit is the limit of the reads or writes of a keyspace. Zero rates
+// mean unlimited.
+type QuotaLimit struct {
+	// RequestsPerSecond is the rate of requests.
+	RequestsPerSecond float64
+	// RequestBurst is the number of requests allowed at once, it defaults to
+	// RequestsPerSecond.
+	RequestBurst float64
+	// BytesPerSecond is the rate of the bytes read or written.
+	BytesPerSecond float64
+	// ByteBurst is the number of bytes allowed at once, it defaults to
+	// BytesPerSecond.
+	ByteBurst float64
+}
+
+// tokenBucket allows going into debt, so a request larger than the burst is
+// allowed when the bucket is not empty, and the following requests are
+// rejected until the debt is paid. A zero rate means unlimited.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) tokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+type quotaBuckets struct {
+	requests tokenBucket
+	bytes    tokenBucket
+}
+
+func newQuotaBuckets(limit QuotaLimit, now time.Time) quotaBuckets {
+	return quotaBuckets{
+		requests: newTokenBucket(limit.RequestsPerSecond, limit.RequestBurst, now),
+		bytes:    newTokenBucket(limit.BytesPerSecond, limit.ByteBurst, now),
+	}
+}
+
+func (q *quotaBuckets) check(keyspace uint32, op string, bytes int64, now time.Time) error {
+	q.requests.refill(now)
+	q.bytes.refill(now)
+	if q.requests.rate > 0 && q.requests.tokens < 1 {
+		return &tikverr.ErrQuotaExceeded{Keyspace: keyspace, Reason: op + " request rate limit"}
+	}
+	if q.bytes.rate > 0 && q.bytes.tokens <= 0 {
+		return &tikverr.ErrQuotaExceeded{Keyspace: keyspace, Reason: op + " throughput limit"}
+	}
+	q.requests.take(1)
+	q.bytes.take(float64(bytes))
+	return nil
+}
+
+type keyspaceQuota struct {
+	read  quotaBuckets
+	write quotaBuckets
+}
+
+// TokenBucketEnforcer is a QuotaEnforcer limiting the request rate and the
+// throughput of the reads and writes of every keyspace by token buckets. A
+// large request is allowed as long as the bucket is not empty, and the
+// following requests are rejected until the debt is paid.
+type TokenBucketEnforcer struct {
+	mu           sync.Mutex
+	defaultRead  QuotaLimit
+	defaultWrite QuotaLimit
+	limits       map[uint32][2]QuotaLimit
+	quotas       map[uint32]*keyspaceQuota
+}
+
+// NewTokenBucketEnforcer creates a TokenBucketEnforcer which applies the read
+// and write limits to every keyspace without its own limits.
+func NewTokenBucketEnforcer(read, write QuotaLimit) *TokenBucketEnforcer {
+	return &TokenBucketEnforcer{
+		defaultRead:  read,
+		defaultWrite: write,
+		limits:       make(map[uint32][2]QuotaLimit),
+		quotas:       make(map[uint32]*keyspaceQuota),
+	}
+}
+
+// SetKeyspaceLimit sets the read and write limits of a keyspace. The buckets
+// of the keyspace are reset to full.
+func (e *TokenBucketEnforcer) SetKeyspaceLimit(keyspace uint32, read, write QuotaLimit) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.limits[keyspace] = [2]QuotaLimit{read, write}
+	delete(e.quotas, keyspace)
+}
+
+func (e *TokenBucketEnforcer) getQuota(keyspace uint32, now time.Time) *keyspaceQuota {
+	q, ok := e.quotas[keyspace]
+	if !ok {
+		read, write := e.defaultRead, e.defaultWrite
+		if limits, ok := e.limits[keyspace]; ok {
+			read, write = limits[0], limits[1]
+		}
+		q = &keyspaceQuota{read: newQuotaBuckets(read, now), write: newQuotaBuckets(write, now)}
+		e.quotas[keyspace] = q
+	}
+	return q
+}
+
+// CheckRead implements QuotaEnforcer.
+func (e *TokenBucketEnforcer) CheckRead(keyspace uint32, bytes int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	return e.getQuota(keyspace, now).read.check(keyspace, "read", bytes, now)
+}
+
+// CheckWrite implements QuotaEnforcer.
+func (e *TokenBucketEnforcer) CheckWrite(keyspace uint32, bytes int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	return e.getQuota(keyspace, now).write.check(keyspace, "write", bytes, now)
+}
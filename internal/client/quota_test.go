@@ -0,0 +1,176 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/config"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+func TestQuotaBucketsRate(t *testing.T) {
+	now := time.Now()
+	q := newQuotaBuckets(QuotaLimit{RequestsPerSecond: 10, RequestBurst: 2}, now)
+	require.Nil(t, q.check(1, "read", 0, now))
+	require.Nil(t, q.check(1, "read", 0, now))
+	err := q.check(1, "read", 0, now)
+	require.True(t, tikverr.Is(err, &tikverr.ErrQuotaExceeded{}))
+
+	// One token is refilled every 100ms.
+	require.Nil(t, q.check(1, "read", 0, now.Add(100*time.Millisecond)))
+	require.NotNil(t, q.check(1, "read", 0, now.Add(100*time.Millisecond)))
+
+	// The tokens don't exceed the burst however long the bucket is idle.
+	now = now.Add(time.Hour)
+	require.Nil(t, q.check(1, "read", 0, now))
+	require.Nil(t, q.check(1, "read", 0, now))
+	require.NotNil(t, q.check(1, "read", 0, now))
+}
+
+func TestQuotaBucketsDebt(t *testing.T) {
+	now := time.Now()
+	// The burst defaults to the rate.
+	q := newQuotaBuckets(QuotaLimit{BytesPerSecond: 100}, now)
+	require.Equal(t, float64(100), q.bytes.burst)
+
+	// A request larger than the burst is allowed and leaves a debt of 900.
+	require.Nil(t, q.check(1, "write", 1000, now))
+	require.NotNil(t, q.check(1, "write", 1, now))
+	require.NotNil(t, q.check(1, "write", 1, now.Add(9*time.Second)))
+	require.Nil(t, q.check(1, "write", 1, now.Add(10*time.Second)))
+
+	// Zero rates are unlimited.
+	q = newQuotaBuckets(QuotaLimit{}, now)
+	for i := 0; i < 100; i++ {
+		require.Nil(t, q.check(1, "write", 1<<30, now))
+	}
+}
+
+func TestTokenBucketEnforcerKeyspaces(t *testing.T) {
+	e := NewTokenBucketEnforcer(QuotaLimit{}, QuotaLimit{BytesPerSecond: 1})
+	e.SetKeyspaceLimit(2, QuotaLimit{BytesPerSecond: 1}, QuotaLimit{})
+
+	// Keyspace 1 uses the default limits.
+	require.Nil(t, e.CheckWrite(1, 100))
+	err := e.CheckWrite(1, 100)
+	require.True(t, tikverr.Is(err, &tikverr.ErrQuotaExceeded{}))
+	require.Nil(t, e.CheckRead(1, 1<<30))
+	require.Nil(t, e.CheckRead(1, 1<<30))
+
+	// Keyspace 2 has its own limits.
+	require.Nil(t, e.CheckWrite(2, 100))
+	require.Nil(t, e.CheckWrite(2, 100))
+	require.Nil(t, e.CheckRead(2, 100))
+	require.NotNil(t, e.CheckRead(2, 100))
+}
+
+// recordingEnforcer records the checks and rejects the writes.
+type recordingEnforcer struct {
+	mu        sync.Mutex
+	reads     []uint32
+	readBytes int64
+}
+
+func (e *recordingEnforcer) CheckRead(keyspace uint32, bytes int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reads = append(e.reads, keyspace)
+	e.readBytes += bytes
+	return nil
+}
+
+func (e *recordingEnforcer) CheckWrite(keyspace uint32, bytes int64) error {
+	return fmt.Errorf("write %d bytes to keyspace %d", bytes, keyspace)
+}
+
+func TestQuotaEnforcerWiring(t *testing.T) {
+	server, port := startMockTikvService()
+	require.True(t, port > 0)
+	defer server.Stop()
+	addr := fmt.Sprintf("%s:%d", "127.0.0.1", port)
+
+	// Disable batch.
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.MaxBatchSize = 0
+		conf.TiKVClient.GrpcConnectionCount = 1
+	})()
+	var sent int32
+	server.setMetaChecker(func(context.Context) error {
+		atomic.AddInt32(&sent, 1)
+		return nil
+	})
+	e := &recordingEnforcer{}
+	rpcClient := NewRPCClient(config.Security{}, WithQuotaEnforcer(e), WithKeyspaceID(7))
+	defer rpcClient.closeConns()
+
+	// The write over quota is rejected before it's sent.
+	key := []byte("k")
+	prewriteReq := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{
+		Mutations: []*kvrpcpb.Mutation{{Key: key, Value: []byte("v")}},
+	})
+	_, err := rpcClient.SendRequest(context.Background(), addr, prewriteReq, 10*time.Second)
+	var quotaErr *tikverr.ErrQuotaExceeded
+	require.True(t, errors.As(err, &quotaErr))
+	require.Equal(t, uint32(7), quotaErr.Keyspace)
+	require.Equal(t, int32(0), atomic.LoadInt32(&sent))
+
+	// The read is checked by its size after it's received.
+	getReq := tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: key})
+	resp, err := rpcClient.SendRequest(context.Background(), addr, getReq, 10*time.Second)
+	require.Nil(t, err)
+	require.Equal(t, []byte("value"), resp.Resp.(*kvrpcpb.GetResponse).GetValue())
+	require.Equal(t, int32(1), atomic.LoadInt32(&sent))
+	require.Equal(t, []uint32{7}, e.reads)
+	require.Equal(t, int64(len(key)+len("value")), e.readBytes)
+}
+
+func TestReadQuotaDebt(t *testing.T) {
+	server, port := startMockTikvService()
+	require.True(t, port > 0)
+	defer server.Stop()
+	addr := fmt.Sprintf("%s:%d", "127.0.0.1", port)
+
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.MaxBatchSize = 0
+		conf.TiKVClient.GrpcConnectionCount = 1
+	})()
+	var sent int32
+	server.setMetaChecker(func(context.Context) error {
+		atomic.AddInt32(&sent, 1)
+		return nil
+	})
+	e := NewTokenBucketEnforcer(QuotaLimit{BytesPerSecond: 1}, QuotaLimit{})
+	rpcClient := NewRPCClient(config.Security{}, WithQuotaEnforcer(e))
+	defer rpcClient.closeConns()
+
+	// The first read is served although it's over the quota, and the debt
+	// fails the next one.
+	getReq := tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k")})
+	resp, err := rpcClient.SendRequest(context.Background(), addr, getReq, 10*time.Second)
+	require.Nil(t, err)
+	require.Equal(t, []byte("value"), resp.Resp.(*kvrpcpb.GetResponse).GetValue())
+	_, err = rpcClient.SendRequest(context.Background(), addr, getReq, 10*time.Second)
+	require.True(t, tikverr.Is(err, &tikverr.ErrQuotaExceeded{}))
+	require.Equal(t, int32(2), atomic.LoadInt32(&sent))
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+const (
+	writeStallMinInterval     = 10 * time.Millisecond
+	writeStallMaxInterval     = time.Second
+	writeStallRecoverInterval = time.Second
+)
+
+// WriteStallDetector paces the dispatch of transaction writes when TiKV is
+// stalled. TiKV doesn't report write stalls explicitly, it rejects the writes
+// with ServerIsBusy when its scheduler or RocksDB can't keep up, so every
+// ServerIsBusy reply to a write doubles the dispatch interval, up to
+// writeStallMaxInterval. The interval is halved for every
+// writeStallRecoverInterval without stalls until the throttle is lifted.
+type WriteStallDetector struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	next       time.Time
+	lastStall  time.Time
+	throttleAt time.Time
+}
+
+// NewWriteStallDetector creates a WriteStallDetector.
+func NewWriteStallDetector() *WriteStallDetector {
+	return &WriteStallDetector{}
+}
+
+var clusterWriteStallDetectors struct {
+	sync.Mutex
+	m map[uint64]*WriteStallDetector
+}
+
+// ClusterWriteStallDetector returns the WriteStallDetector of the cluster. It's
+// shared by all the stores of the cluster in the process, so a stall seen by
+// one of them paces the writes of the others too.
+func ClusterWriteStallDetector(clusterID uint64) *WriteStallDetector {
+	clusterWriteStallDetectors.Lock()
+	defer clusterWriteStallDetectors.Unlock()
+	if clusterWriteStallDetectors.m == nil {
+		clusterWriteStallDetectors.m = make(map[uint64]*WriteStallDetector)
+	}
+	d, ok := clusterWriteStallDetectors.m[clusterID]
+	if !ok {
+		d = NewWriteStallDetector()
+		clusterWriteStallDetectors.m[clusterID] = d
+	}
+	return d
+}
+
+// OnStall throttles the dispatch rate.
+func (d *WriteStallDetector) OnStall() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if d.interval == 0 {
+		d.interval = writeStallMinInterval
+		d.throttleAt = now
+	} else if d.interval < writeStallMaxInterval {
+		d.interval *= 2
+		if d.interval > writeStallMaxInterval {
+			d.interval = writeStallMaxInterval
+		}
+	}
+	d.lastStall = now
+	metrics.TiKVWriteStallThrottleTotal.Inc()
+}
+
+// Interval returns the current dispatch interval, 0 means not throttled.
+func (d *WriteStallDetector) Interval() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recover(time.Now())
+	return d.interval
+}
+
+func (d *WriteStallDetector) recover(now time.Time) {
+	for d.interval > 0 && now.Sub(d.lastStall) >= writeStallRecoverInterval {
+		d.interval /= 2
+		d.lastStall = d.lastStall.Add(writeStallRecoverInterval)
+		if d.interval < writeStallMinInterval {
+			d.interval = 0
+			metrics.TiKVWriteStallDuration.Observe(now.Sub(d.throttleAt).Seconds())
+		}
+	}
+}
+
+// Wait blocks until the next write can be dispatched. It returns immediately
+// if not throttled.
+func (d *WriteStallDetector) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	now := time.Now()
+	d.recover(now)
+	if d.interval == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	if d.next.Before(now) {
+		d.next = now
+	}
+	wait := d.next.Sub(now)
+	d.next = d.next.Add(d.interval)
+	d.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	}
+}
+
+var _ Client = &writeStallClient{}
+
+type writeStallClient struct {
+	Client
+	detector *WriteStallDetector
+}
+
+// NewWriteStallClient creates a Client which reports the ServerIsBusy replies
+// to transaction writes to detector.
+func NewWriteStallClient(client Client, detector *WriteStallDetector) Client {
+	return &writeStallClient{Client: client, detector: detector}
+}
+
+func (c *writeStallClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	resp, err := c.Client.SendRequest(ctx, addr, req, timeout)
+	if err != nil || !req.IsTxnWriteRequest() {
+		return resp, err
+	}
+	if regionErr, _ := resp.GetRegionError(); regionErr.GetServerIsBusy() != nil {
+		c.detector.OnStall()
+	}
+	return resp, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+type busyClient struct {
+	Client
+}
+
+func (c busyClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	regionErr := &errorpb.Error{ServerIsBusy: &errorpb.ServerIsBusy{Reason: "write stall"}}
+	switch req.Type {
+	case tikvrpc.CmdPrewrite:
+		return &tikvrpc.Response{Resp: &kvrpcpb.PrewriteResponse{RegionError: regionErr}}, nil
+	default:
+		return &tikvrpc.Response{Resp: &kvrpcpb.GetResponse{RegionError: regionErr}}, nil
+	}
+}
+
+func TestWriteStallDetector(t *testing.T) {
+	d := NewWriteStallDetector()
+	client := NewWriteStallClient(busyClient{}, d)
+	ctx := context.Background()
+
+	// Reads don't throttle writes.
+	_, err := client.SendRequest(ctx, "", tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{}), time.Second)
+	require.Nil(t, err)
+	require.Equal(t, time.Duration(0), d.Interval())
+	require.Nil(t, d.Wait(ctx))
+
+	for i := 0; i < 3; i++ {
+		_, err = client.SendRequest(ctx, "", tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{}), time.Second)
+		require.Nil(t, err)
+	}
+	require.Equal(t, 4*writeStallMinInterval, d.Interval())
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.Nil(t, d.Wait(ctx))
+	}
+	require.GreaterOrEqual(t, time.Since(start), 2*d.Interval())
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	d.next = time.Now().Add(time.Hour)
+	require.NotNil(t, d.Wait(ctx))
+
+	// The throttle is lifted after no stall for a while.
+	d.lastStall = time.Now().Add(-3 * writeStallRecoverInterval)
+	require.Equal(t, time.Duration(0), d.Interval())
+}
+
+func TestClusterWriteStallDetector(t *testing.T) {
+	d := ClusterWriteStallDetector(1)
+	require.Same(t, d, ClusterWriteStallDetector(1))
+	require.NotSame(t, d, ClusterWriteStallDetector(2))
+}
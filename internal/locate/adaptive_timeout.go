@@ -0,0 +1,121 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/beorn7/perks/quantile"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+const (
+	// adaptiveTimeoutWindow is the number of latencies in a window. The P99 is
+	// computed from the current window, or the previous one if the current
+	// window is too small, so it reflects the recent latencies.
+	adaptiveTimeoutWindow = 1000
+	// adaptiveTimeoutMinSamples is the number of latencies needed before the
+	// P99 is used.
+	adaptiveTimeoutMinSamples = 100
+	adaptiveTimeoutFactor     = 1.5
+)
+
+// AdaptiveTimeoutPolicy sets the timeout of the retried requests to 1.5 times
+// the P99 latency of the recent successful requests of the same type, bounded
+// by [MinTimeout, MaxTimeout]. It keeps the retries from timing out on slow
+// but healthy clusters and from waiting too long on fast clusters. The timeout
+// of the caller is used until enough latencies are observed. The zero value is
+// a policy without bounds.
+type AdaptiveTimeoutPolicy struct {
+	MinTimeout time.Duration
+	MaxTimeout time.Duration
+
+	mu      sync.Mutex
+	windows map[tikvrpc.CmdType]*latencyWindows
+}
+
+type latencyWindows struct {
+	cur  *quantile.Stream
+	prev *quantile.Stream
+}
+
+// NewAdaptiveTimeoutPolicy creates an AdaptiveTimeoutPolicy that bounds the
+// timeouts by [minTimeout, maxTimeout].
+func NewAdaptiveTimeoutPolicy(minTimeout, maxTimeout time.Duration) *AdaptiveTimeoutPolicy {
+	return &AdaptiveTimeoutPolicy{
+		MinTimeout: minTimeout,
+		MaxTimeout: maxTimeout,
+	}
+}
+
+// WithAdaptiveTimeout makes the sender set the timeout of the retried requests
+// by policy and feed the latencies of the successful requests to it. The
+// policy can be shared by senders.
+func WithAdaptiveTimeout(policy *AdaptiveTimeoutPolicy) SenderOption {
+	return func(s *RegionRequestSender) {
+		s.adaptiveTimeout = policy
+	}
+}
+
+func newLatencyStream() *quantile.Stream {
+	return quantile.NewTargeted(map[float64]float64{0.99: 0.001})
+}
+
+// Observe records the latency of a successful request.
+func (p *AdaptiveTimeoutPolicy) Observe(cmd tikvrpc.CmdType, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.windows == nil {
+		p.windows = make(map[tikvrpc.CmdType]*latencyWindows)
+	}
+	w, ok := p.windows[cmd]
+	if !ok {
+		w = &latencyWindows{cur: newLatencyStream()}
+		p.windows[cmd] = w
+	}
+	if w.cur.Count() >= adaptiveTimeoutWindow {
+		w.prev, w.cur = w.cur, newLatencyStream()
+	}
+	w.cur.Insert(float64(latency))
+}
+
+// Timeout returns the timeout of a retried request of cmd, or fallback if not
+// enough latencies of cmd are observed.
+func (p *AdaptiveTimeoutPolicy) Timeout(cmd tikvrpc.CmdType, fallback time.Duration) time.Duration {
+	p.mu.Lock()
+	w, ok := p.windows[cmd]
+	var p99 float64
+	if ok {
+		if w.cur.Count() >= adaptiveTimeoutMinSamples {
+			p99 = w.cur.Query(0.99)
+		} else if w.prev != nil {
+			p99 = w.prev.Query(0.99)
+		} else {
+			ok = false
+		}
+	}
+	p.mu.Unlock()
+	if !ok {
+		return fallback
+	}
+	timeout := time.Duration(p99 * adaptiveTimeoutFactor)
+	if timeout < p.MinTimeout {
+		timeout = p.MinTimeout
+	}
+	if p.MaxTimeout > 0 && timeout > p.MaxTimeout {
+		timeout = p.MaxTimeout
+	}
+	return timeout
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locate
+
+import (
+	"sync"
+	"sync/atomic"
+
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+// EpochVerifier remembers the latest region version seen for each region and
+// detects the responses of requests sent with an older version.
+//
+// TiKV responses don't carry the region epoch, so the versions are learned from
+// the requests that succeeded, whose epoch was accepted by TiKV, and from the
+// current regions in EpochNotMatch errors. Only the version is tracked, a conf
+// change doesn't move any key between regions.
+type EpochVerifier struct {
+	// versions maps region ID to *uint64.
+	versions sync.Map
+}
+
+// NewEpochVerifier creates an EpochVerifier.
+func NewEpochVerifier() *EpochVerifier {
+	return &EpochVerifier{}
+}
+
+// Observe records the version of a region if it's newer than the known one.
+func (v *EpochVerifier) Observe(regionID, version uint64) {
+	val, loaded := v.versions.LoadOrStore(regionID, &version)
+	if !loaded {
+		return
+	}
+	latest := val.(*uint64)
+	for {
+		old := atomic.LoadUint64(latest)
+		if version <= old || atomic.CompareAndSwapUint64(latest, old, version) {
+			return
+		}
+	}
+}
+
+// Verify returns an ErrStaleRegionEpoch if the version of the region is older
+// than the known one.
+func (v *EpochVerifier) Verify(regionID, version uint64) error {
+	val, ok := v.versions.Load(regionID)
+	if !ok {
+		return nil
+	}
+	if latest := atomic.LoadUint64(val.(*uint64)); version < latest {
+		return &tikverr.ErrStaleRegionEpoch{RegionID: regionID, Version: version, LatestVersion: latest}
+	}
+	return nil
+}
+
+// isDataReadCmd returns whether the command reads the data of a region.
+func isDataReadCmd(tp tikvrpc.CmdType) bool {
+	switch tp {
+	case tikvrpc.CmdGet, tikvrpc.CmdScan, tikvrpc.CmdBatchGet,
+		tikvrpc.CmdRawGet, tikvrpc.CmdRawBatchGet, tikvrpc.CmdRawScan,
+		tikvrpc.CmdCop, tikvrpc.CmdCopStream, tikvrpc.CmdBatchCop:
+		return true
+	}
+	return false
+}
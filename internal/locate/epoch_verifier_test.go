@@ -0,0 +1,38 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+func TestEpochVerifier(t *testing.T) {
+	v := NewEpochVerifier()
+	require.Nil(t, v.Verify(1, 5))
+
+	v.Observe(1, 5)
+	v.Observe(1, 3)
+	require.Nil(t, v.Verify(1, 5))
+	require.Nil(t, v.Verify(1, 6))
+	err := v.Verify(1, 4)
+	require.True(t, tikverr.Is(err, &tikverr.ErrStaleRegionEpoch{}))
+	require.Equal(t, uint64(5), err.(*tikverr.ErrStaleRegionEpoch).LatestVersion)
+
+	v.Observe(1, 7)
+	require.NotNil(t, v.Verify(1, 6))
+	require.Nil(t, v.Verify(2, 1))
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locate
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/btree"
+)
+
+// hotRegionMetricsInterval is the interval of updating the hit ratio metric of
+// the hot region cache.
+const hotRegionMetricsInterval = 10
+
+// HotRegionCache is a fixed-size LRU cache of the recently used regions, kept
+// in front of the full region cache. Most lookups of a workload hit a small set
+// of regions, looking them up in a small tree is faster than in the tree of all
+// the regions, which can have millions of entries.
+//
+// The cache holds the same Region objects as the full cache, a region
+// invalidated or replaced in the full cache fails the TTL check and is dropped
+// on the next lookup.
+type HotRegionCache struct {
+	mu       sync.Mutex
+	capacity int
+	sorted   *btree.BTree
+	// entries maps the start key of a region to its element in lru.
+	entries map[string]*list.Element
+	lru     *list.List
+
+	hits   uint64
+	misses uint64
+}
+
+// NewHotRegionCache creates a HotRegionCache holding at most capacity regions.
+func NewHotRegionCache(capacity int) *HotRegionCache {
+	return &HotRegionCache{
+		capacity: capacity,
+		sorted:   btree.New(btreeDegree),
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// search finds the region containing the key, see RegionCache.searchCachedRegion.
+func (c *HotRegionCache) search(key []byte, isEndKey bool, ts int64) *Region {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var r *Region
+	c.sorted.DescendLessOrEqual(newBtreeSearchItem(key), func(item btree.Item) bool {
+		r = item.(*btreeItem).cachedRegion
+		if isEndKey && bytes.Equal(r.StartKey(), key) {
+			r = nil
+			return true
+		}
+		return false
+	})
+	if r != nil && !r.checkRegionCacheTTL(ts) {
+		c.removeLocked(r)
+		r = nil
+	}
+	if r == nil || (!isEndKey && !r.Contains(key)) || (isEndKey && !r.ContainsByEnd(key)) {
+		atomic.AddUint64(&c.misses, 1)
+		return nil
+	}
+	c.lru.MoveToFront(c.entries[string(r.StartKey())])
+	atomic.AddUint64(&c.hits, 1)
+	return r
+}
+
+// add promotes a region found in the full cache.
+func (c *HotRegionCache) add(r *Region) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	startKey := string(r.StartKey())
+	if elem, ok := c.entries[startKey]; ok {
+		elem.Value = r
+		c.lru.MoveToFront(elem)
+		c.sorted.ReplaceOrInsert(newBtreeItem(r))
+		return
+	}
+	c.entries[startKey] = c.lru.PushFront(r)
+	c.sorted.ReplaceOrInsert(newBtreeItem(r))
+	if c.lru.Len() > c.capacity {
+		c.removeLocked(c.lru.Back().Value.(*Region))
+	}
+}
+
+func (c *HotRegionCache) removeLocked(r *Region) {
+	startKey := string(r.StartKey())
+	if elem, ok := c.entries[startKey]; ok && elem.Value.(*Region) == r {
+		c.lru.Remove(elem)
+		delete(c.entries, startKey)
+		c.sorted.Delete(newBtreeSearchItem(r.StartKey()))
+	}
+}
+
+func (c *HotRegionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sorted = btree.New(btreeDegree)
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// hitRatio returns the ratio of the lookups hitting the cache since the last
+// call, or -1 if there are no lookups.
+func (c *HotRegionCache) hitRatio() float64 {
+	hits, misses := atomic.SwapUint64(&c.hits, 0), atomic.SwapUint64(&c.misses, 0)
+	if hits+misses == 0 {
+		return -1
+	}
+	return float64(hits) / float64(hits+misses)
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotRegionCache(t *testing.T) {
+	ts := time.Now().Unix()
+	newRegion := func(id uint64, start, end string) *Region {
+		return &Region{
+			meta:       &metapb.Region{Id: id, StartKey: []byte(start), EndKey: []byte(end)},
+			lastAccess: ts,
+		}
+	}
+	c := NewHotRegionCache(2)
+	r1, r2, r3 := newRegion(1, "a", "c"), newRegion(2, "c", "e"), newRegion(3, "e", "g")
+	require.Nil(t, c.search([]byte("b"), false, ts))
+	c.add(r1)
+	c.add(r2)
+	require.Equal(t, r1, c.search([]byte("b"), false, ts))
+	require.Equal(t, r1, c.search([]byte("c"), true, ts))
+	require.Nil(t, c.search([]byte("f"), false, ts))
+
+	// r2 is the least recently used one.
+	c.add(r3)
+	require.Nil(t, c.search([]byte("d"), false, ts))
+	require.Equal(t, r3, c.search([]byte("f"), false, ts))
+	require.Equal(t, 2, c.lru.Len())
+
+	// An invalidated region is dropped.
+	r1.invalidate(Other)
+	require.Nil(t, c.search([]byte("b"), false, ts))
+	require.Equal(t, 1, c.lru.Len())
+	require.Equal(t, 1, c.sorted.Len())
+
+	require.Equal(t, float64(3)/float64(7), c.hitRatio())
+	require.Equal(t, float64(-1), c.hitRatio())
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locate
+
+import (
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/client-go/v2/internal/client"
+)
+
+// PeerType is the role of a peer in its region.
+type PeerType int
+
+// Peer types.
+const (
+	PeerTypeLeader PeerType = iota
+	PeerTypeFollower
+	PeerTypeLearner
+)
+
+// PeerSelectionPolicy decides the order in which the peers of a region are
+// tried by the read requests. The peers of the types not in the policy are
+// never tried.
+type PeerSelectionPolicy struct {
+	order []PeerType
+}
+
+// Ordered creates a PeerSelectionPolicy that tries the peers by their types in
+// the order of types. For example, Ordered([]PeerType{PeerTypeFollower,
+// PeerTypeLeader}) offloads the reads to the followers and falls back to the
+// leader, and Ordered([]PeerType{PeerTypeLeader}) never reads from other peers.
+func Ordered(types []PeerType) PeerSelectionPolicy {
+	return PeerSelectionPolicy{order: append([]PeerType(nil), types...)}
+}
+
+// WithPeerSelectionPolicy makes the sender choose the peers of the read
+// requests that target the leader by policy. The requests to non-leader peers
+// are sent as replica reads. Write requests and requests with other replica
+// read types are not affected.
+func WithPeerSelectionPolicy(policy PeerSelectionPolicy) SenderOption {
+	return func(s *RegionRequestSender) {
+		s.peerPolicy = &policy
+	}
+}
+
+// NewRegionRequestSenderWithPolicy creates a sender that chooses the peers of
+// the read requests by policy.
+func NewRegionRequestSenderWithPolicy(regionCache *RegionCache, client client.Client, policy PeerSelectionPolicy, opts ...SenderOption) *RegionRequestSender {
+	return NewRegionRequestSender(regionCache, client, append(opts, WithPeerSelectionPolicy(policy))...)
+}
+
+func peerTypeOf(r *replica, leader *replica) PeerType {
+	if r == leader {
+		return PeerTypeLeader
+	}
+	if r.peer.GetRole() == metapb.PeerRole_Learner {
+		return PeerTypeLearner
+	}
+	return PeerTypeFollower
+}
+
+// arrange returns the replicas in the order of the policy.
+func (p *PeerSelectionPolicy) arrange(replicas []*replica, leader *replica) []*replica {
+	arranged := make([]*replica, 0, len(replicas))
+	for _, tp := range p.order {
+		for _, r := range replicas {
+			if peerTypeOf(r, leader) == tp {
+				arranged = append(arranged, r)
+			}
+		}
+	}
+	return arranged
+}
@@ -337,6 +337,11 @@ type RegionCache struct {
 		latestVersions map[uint64]RegionVerID  // cache the map from regionID to its latest RegionVerID
 		sorted         *btree.BTree            // cache regions are organized as sorted key to region ref mapping
 	}
+	// sortedSnapshot is a copy-on-write clone of mu.sorted, published after
+	// every change of it, so looking up regions by key doesn't take mu. The
+	// published trees are never modified.
+	sortedSnapshot atomic.Value // *btree.BTree
+
 	storeMu struct {
 		sync.RWMutex
 		stores map[uint64]*Store
@@ -344,6 +349,26 @@ type RegionCache struct {
 	notifyCheckCh chan struct{}
 	closeCh       chan struct{}
 
+	// ctx is cancelled by Close, and Close waits for wg, they bound the
+	// background tasks started on the requests of the cache.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// epochVerifier is nil if Config.EnableRegionEpochCheck is off.
+	epochVerifier *EpochVerifier
+	// hotRegions is nil if TiKVClient.HotRegionCacheSize is 0.
+	hotRegions *HotRegionCache
+	// maxRegions is the max number of the cached regions, 0 means no limit.
+	maxRegions int
+	// storeInfo is nil if TiKVClient.StoreInfoCacheSize is 0.
+	storeInfo *StoreInfoCache
+
+	splitObservers struct {
+		sync.RWMutex
+		observers []RegionSplitObserver
+	}
+
 	testingKnobs struct {
 		// Replace the requestLiveness function for test purpose. Note that in unit tests, if this is not set,
 		// requestLiveness always returns unreachable.
@@ -359,30 +384,104 @@ func NewRegionCache(pdClient pd.Client) *RegionCache {
 	c.mu.regions = make(map[RegionVerID]*Region)
 	c.mu.latestVersions = make(map[uint64]RegionVerID)
 	c.mu.sorted = btree.New(btreeDegree)
+	c.publishSorted()
 	c.storeMu.stores = make(map[uint64]*Store)
 	c.notifyCheckCh = make(chan struct{}, 1)
 	c.closeCh = make(chan struct{})
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 	interval := config.GetGlobalConfig().StoresRefreshInterval
 	go c.asyncCheckAndResolveLoop(time.Duration(interval) * time.Second)
 	c.enableForwarding = config.GetGlobalConfig().EnableForwarding
+	if config.GetGlobalConfig().EnableRegionEpochCheck {
+		c.epochVerifier = NewEpochVerifier()
+	}
+	if size := config.GetGlobalConfig().TiKVClient.HotRegionCacheSize; size > 0 {
+		c.hotRegions = NewHotRegionCache(int(size))
+		go c.hotRegionMetricsLoop(hotRegionMetricsInterval * time.Second)
+	}
+	c.maxRegions = int(config.GetGlobalConfig().TiKVClient.MaxRegionCacheSize)
+	if size := config.GetGlobalConfig().TiKVClient.StoreInfoCacheSize; size > 0 {
+		c.storeInfo = NewStoreInfoCache(int(size))
+	}
 	return c
 }
 
+// StoreInfoCache returns the cache of the store metas loaded from PD, it's nil
+// if disabled.
+func (c *RegionCache) StoreInfoCache() *StoreInfoCache {
+	return c.storeInfo
+}
+
+// loadStore loads the meta of a store from PD.
+func (c *RegionCache) loadStore(ctx context.Context, storeID uint64) (*metapb.Store, error) {
+	store, err := c.pdClient.GetStore(ctx, storeID)
+	if err != nil {
+		metrics.RegionCacheCounterWithGetStoreError.Inc()
+	} else {
+		metrics.RegionCacheCounterWithGetStoreOK.Inc()
+	}
+	return store, err
+}
+
+func (c *RegionCache) hotRegionMetricsLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			if ratio := c.hotRegions.hitRatio(); ratio >= 0 {
+				metrics.TiKVRegionCacheL1HitRatio.Set(ratio)
+			}
+		}
+	}
+}
+
+// checkRegionEpoch records the epoch of a request that succeeded. For a read
+// request it first checks that the epoch is not older than the known one, if it
+// isn't, an ErrStaleRegionEpoch is returned. The known version may be learned
+// from a request racing with this one, so the cached region is only invalidated
+// if final is set, otherwise the caller retries the read first.
+func (c *RegionCache) checkRegionEpoch(ctx *RPCContext, isRead, final bool) error {
+	if c.epochVerifier == nil {
+		return nil
+	}
+	if isRead {
+		if err := c.epochVerifier.Verify(ctx.Region.id, ctx.Region.ver); err != nil {
+			if !final {
+				return err
+			}
+			logutil.BgLogger().Warn("read from stale region epoch", zap.Error(err))
+			c.InvalidateCachedRegion(ctx.Region)
+			return err
+		}
+	}
+	c.epochVerifier.Observe(ctx.Region.id, ctx.Region.ver)
+	return nil
+}
+
 // clear clears all cached data in the RegionCache. It's only used in tests.
 func (c *RegionCache) clear() {
 	c.mu.Lock()
 	c.mu.regions = make(map[RegionVerID]*Region)
 	c.mu.latestVersions = make(map[uint64]RegionVerID)
 	c.mu.sorted = btree.New(btreeDegree)
+	c.publishSorted()
 	c.mu.Unlock()
 	c.storeMu.Lock()
 	c.storeMu.stores = make(map[uint64]*Store)
 	c.storeMu.Unlock()
+	if c.hotRegions != nil {
+		c.hotRegions.clear()
+	}
 }
 
 // Close releases region cache's resource.
 func (c *RegionCache) Close() {
+	c.cancel()
 	close(c.closeCh)
+	c.wg.Wait()
 }
 
 // asyncCheckAndResolveLoop with
@@ -703,6 +802,15 @@ func (c *RegionCache) GetTiFlashRPCContext(bo *retry.Backoffer, id RegionVerID,
 	return nil, nil
 }
 
+// hasTiFlashPeer returns whether the cached region has a peer on TiFlash.
+func (c *RegionCache) hasTiFlashPeer(id RegionVerID) bool {
+	cachedRegion := c.GetCachedRegionWithRLock(id)
+	if cachedRegion == nil {
+		return false
+	}
+	return cachedRegion.getStore().accessStoreNum(tiFlashOnly) > 0
+}
+
 // KeyLocation is the region and range that a key is located.
 type KeyLocation struct {
 	Region   RegionVerID
@@ -734,6 +842,21 @@ func (c *RegionCache) LocateKey(bo *retry.Backoffer, key []byte) (*KeyLocation,
 	}, nil
 }
 
+// TryLocateKey searches for the region and range that the key is located, but
+// only looks up the cache. It returns nil on cache miss and never loads the
+// region from PD.
+func (c *RegionCache) TryLocateKey(key []byte) *KeyLocation {
+	r := c.searchCachedRegion(key, false)
+	if r == nil {
+		return nil
+	}
+	return &KeyLocation{
+		Region:   r.VerID(),
+		StartKey: r.StartKey(),
+		EndKey:   r.EndKey(),
+	}
+}
+
 // LocateEndKey searches for the region and range that the key is located.
 // Unlike LocateKey, start key of a region is exclusive and end key is inclusive.
 func (c *RegionCache) LocateEndKey(bo *retry.Backoffer, key []byte) (*KeyLocation, error) {
@@ -1106,6 +1229,19 @@ func (c *RegionCache) UpdateLeader(regionID RegionVerID, leader *metapb.Peer, cu
 	}
 }
 
+// SwitchLeaderIfPresent makes the requests to the region be sent to the peer
+// if it's a peer of the cached region. Unlike UpdateLeader, the region is not
+// invalidated if the peer is not found, so it's used for the leader hints that
+// may be outdated. It only switches the work leader in the cache, nothing is
+// sent to TiKV.
+func (c *RegionCache) SwitchLeaderIfPresent(regionID RegionVerID, leader *metapb.Peer) bool {
+	r := c.GetCachedRegionWithRLock(regionID)
+	if r == nil || !r.isValid() {
+		return false
+	}
+	return c.switchWorkLeaderToPeer(r, leader)
+}
+
 // removeVersionFromCache removes a RegionVerID from cache, tries to cleanup
 // both c.mu.regions and c.mu.versions. Note this function is not thread-safe.
 func (c *RegionCache) removeVersionFromCache(oldVer RegionVerID, regionID uint64) {
@@ -1146,18 +1282,72 @@ func (c *RegionCache) insertRegionToCache(cachedRegion *Region) {
 	if !ok || latest.GetVer() < newVer.GetVer() || latest.GetConfVer() < newVer.GetConfVer() {
 		c.mu.latestVersions[cachedRegion.VerID().id] = newVer
 	}
+	if c.maxRegions > 0 && c.mu.sorted.Len() > c.maxRegions {
+		c.evictRegions(cachedRegion)
+	}
+	c.publishSorted()
+}
+
+// publishSorted publishes a clone of c.mu.sorted to the lookups by key. The
+// clone is cheap, the nodes shared by the trees are copied when c.mu.sorted
+// is modified. It should be protected by c.mu.Lock().
+func (c *RegionCache) publishSorted() {
+	c.sortedSnapshot.Store(c.mu.sorted.Clone())
 }
 
-// searchCachedRegion finds a region from cache by key. Like `getCachedRegion`,
-// it should be called with c.mu.RLock(), and the returned Region should not be
-// used after c.mu is RUnlock().
+// regionEvictionSamples is the number of the regions sampled to evict the
+// least recently used one among them. It approximates LRU without sorting or
+// tracking the order of all the cached regions.
+const regionEvictionSamples = 16
+
+// evictRegions evicts the regions except inserted until the cache doesn't
+// exceed the limit. Each evicted region is the least recently used one of
+// regionEvictionSamples regions sampled from the cache, it's expired, so it's
+// loaded again on next access. It should be protected by c.mu.Lock().
+func (c *RegionCache) evictRegions(inserted *Region) {
+	evicted := 0
+	for c.mu.sorted.Len() > c.maxRegions {
+		var victim *Region
+		sampled := 0
+		// The iteration of a map starts at a random position.
+		for _, r := range c.mu.regions {
+			if r == inserted {
+				continue
+			}
+			if victim == nil || atomic.LoadInt64(&r.lastAccess) < atomic.LoadInt64(&victim.lastAccess) {
+				victim = r
+			}
+			if sampled++; sampled >= regionEvictionSamples {
+				break
+			}
+		}
+		if victim == nil {
+			break
+		}
+		if item := c.mu.sorted.Get(newBtreeItem(victim)); item != nil && item.(*btreeItem).cachedRegion == victim {
+			c.mu.sorted.Delete(item)
+			evicted++
+		}
+		c.removeVersionFromCache(victim.VerID(), victim.GetID())
+		atomic.StoreInt64(&victim.lastAccess, invalidatedLastAccessTime)
+	}
+	metrics.TiKVRegionCacheEvictionCounter.Add(float64(evicted))
+}
+
+// searchCachedRegion finds a region from cache by key. It looks up the hot
+// regions first, then searches the latest published btree without locking.
 // If the given key is the end key of the region that you want, you may set the second argument to true. This is useful
 // when processing in reverse order.
 func (c *RegionCache) searchCachedRegion(key []byte, isEndKey bool) *Region {
 	ts := time.Now().Unix()
+	if c.hotRegions != nil {
+		if r := c.hotRegions.search(key, isEndKey, ts); r != nil {
+			return r
+		}
+	}
 	var r *Region
-	c.mu.RLock()
-	c.mu.sorted.DescendLessOrEqual(newBtreeSearchItem(key), func(item btree.Item) bool {
+	sorted := c.sortedSnapshot.Load().(*btree.BTree)
+	sorted.DescendLessOrEqual(newBtreeSearchItem(key), func(item btree.Item) bool {
 		r = item.(*btreeItem).cachedRegion
 		if isEndKey && bytes.Equal(r.StartKey(), key) {
 			r = nil     // clear result
@@ -1169,8 +1359,10 @@ func (c *RegionCache) searchCachedRegion(key []byte, isEndKey bool) *Region {
 		}
 		return false
 	})
-	c.mu.RUnlock()
 	if r != nil && (!isEndKey && r.Contains(key) || isEndKey && r.ContainsByEnd(key)) {
+		if c.hotRegions != nil {
+			c.hotRegions.add(r)
+		}
 		return r
 	}
 	return nil
@@ -1541,6 +1733,11 @@ func (c *RegionCache) getStoresByLabels(labels []*metapb.StoreLabel) []*Store {
 // OnRegionEpochNotMatch removes the old region and inserts new regions into the cache.
 // It returns whether retries the request because it's possible the region epoch is ahead of TiKV's due to slow appling.
 func (c *RegionCache) OnRegionEpochNotMatch(bo *retry.Backoffer, ctx *RPCContext, currentRegions []*metapb.Region) (bool, error) {
+	if c.epochVerifier != nil {
+		for _, meta := range currentRegions {
+			c.epochVerifier.Observe(meta.GetId(), meta.GetRegionEpoch().GetVersion())
+		}
+	}
 	if len(currentRegions) == 0 {
 		c.InvalidateCachedRegionWithReason(ctx.Region, EpochNotMatch)
 		return false, nil
@@ -1585,6 +1782,8 @@ func (c *RegionCache) OnRegionEpochNotMatch(bo *retry.Backoffer, ctx *RPCContext
 		}
 	}
 	c.mu.Lock()
+	// The old region is replaced if a new region starts with the same key.
+	oldRegion := c.mu.regions[ctx.Region]
 	for _, region := range newRegions {
 		c.insertRegionToCache(region)
 	}
@@ -1595,6 +1794,9 @@ func (c *RegionCache) OnRegionEpochNotMatch(bo *retry.Backoffer, ctx *RPCContext
 		}
 	}
 	c.mu.Unlock()
+	if needInvalidateOld && oldRegion != nil && len(newRegions) == 2 {
+		c.notifySplit(oldRegion, newRegions[0], newRegions[1])
+	}
 	return false, nil
 }
 
@@ -1953,12 +2155,14 @@ func (s *Store) initResolve(bo *retry.Backoffer, c *RegionCache) (addr string, e
 		return
 	}
 	var store *metapb.Store
+	load := func() (*metapb.Store, error) {
+		return c.loadStore(bo.GetCtx(), s.storeID)
+	}
 	for {
-		store, err = c.pdClient.GetStore(bo.GetCtx(), s.storeID)
-		if err != nil {
-			metrics.RegionCacheCounterWithGetStoreError.Inc()
+		if c.storeInfo != nil {
+			store, err = c.storeInfo.get(s.storeID, load)
 		} else {
-			metrics.RegionCacheCounterWithGetStoreOK.Inc()
+			store, err = load()
 		}
 		if bo.GetCtx().Err() != nil && errors.Cause(bo.GetCtx().Err()) == context.Canceled {
 			return
@@ -2000,12 +2204,7 @@ func isStoreNotFoundError(err error) bool {
 // deleted.
 func (s *Store) reResolve(c *RegionCache) (bool, error) {
 	var addr string
-	store, err := c.pdClient.GetStore(context.Background(), s.storeID)
-	if err != nil {
-		metrics.RegionCacheCounterWithGetStoreError.Inc()
-	} else {
-		metrics.RegionCacheCounterWithGetStoreOK.Inc()
-	}
+	store, err := c.loadStore(context.Background(), s.storeID)
 	// `err` here can mean either "load Store from PD failed" or "store not found"
 	// If load Store from PD is successful but PD didn't find the store
 	// the err should be handled by next `if` instead of here
@@ -2014,6 +2213,13 @@ func (s *Store) reResolve(c *RegionCache) (bool, error) {
 		// we cannot do backoff in reResolve loop but try check other store and wait tick.
 		return false, err
 	}
+	if c.storeInfo != nil {
+		if store == nil {
+			c.storeInfo.Invalidate(s.storeID)
+		} else {
+			c.storeInfo.put(store)
+		}
+	}
 	if store == nil {
 		// store has be removed in PD, we should invalidate all regions using those store.
 		logutil.BgLogger().Info("invalidate regions in removed store",
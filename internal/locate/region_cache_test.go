@@ -37,6 +37,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -208,6 +209,19 @@ func (s *testRegionCacheSuite) TestSimple() {
 	s.Nil(r)
 }
 
+func (s *testRegionCacheSuite) TestTryLocateKey() {
+	// Cache miss does not load the region from PD.
+	s.Nil(s.cache.TryLocateKey([]byte("a")))
+	s.checkCache(0)
+
+	loc, err := s.cache.LocateKey(s.bo, []byte("a"))
+	s.Nil(err)
+	cached := s.cache.TryLocateKey([]byte("z"))
+	s.NotNil(cached)
+	s.Equal(loc.Region, cached.Region)
+	s.checkCache(1)
+}
+
 // TestResolveStateTransition verifies store's resolve state transition. For example,
 // a newly added store is in unresolved state and will be resolved soon if it's an up store,
 // or in tombstone state if it's a tombstone.
@@ -1488,6 +1502,60 @@ func (s *testRegionCacheSuite) TestSwitchPeerWhenNoLeader() {
 	}
 }
 
+// BenchmarkConcurrentLocateKey locates keys with 32 goroutines on a cache of
+// 10k regions, while a writer keeps reloading the regions.
+func BenchmarkConcurrentLocateKey(b *testing.B) {
+	regionCnt := 10000
+	cluster := createClusterWithStoresAndRegions(regionCnt, 3)
+	cache := NewRegionCache(mocktikv.NewPDClient(cluster))
+	defer cache.Close()
+	loadRegionsToCache(cache, regionCnt)
+	keys := make([][]byte, regionCnt)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf(regionSplitKeyFormat, i))
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		bo := retry.NewBackofferWithVars(context.Background(), 1000, nil)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			r, err := cache.loadRegion(bo, keys[i%regionCnt], false)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			cache.mu.Lock()
+			cache.insertRegionToCache(r)
+			cache.mu.Unlock()
+		}
+	}()
+
+	b.SetParallelism(32)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		bo := retry.NewBackofferWithVars(context.Background(), 1000, nil)
+		i := rand.Intn(regionCnt)
+		for pb.Next() {
+			if _, err := cache.LocateKey(bo, keys[i%regionCnt]); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
 func BenchmarkOnRequestFail(b *testing.B) {
 	/*
 			This benchmark simulate many concurrent requests call OnSendRequestFail method
@@ -1528,3 +1596,164 @@ func BenchmarkOnRequestFail(b *testing.B) {
 		b.Fatal(len(cache.mu.regions))
 	}
 }
+
+func (s *testRegionCacheSuite) TestStoreTopologyWatcher() {
+	_, err := s.cache.LocateKey(s.bo, []byte("a"))
+	s.Nil(err)
+	store1 := s.cache.getStoreByStoreID(s.store1)
+	store2 := s.cache.getStoreByStoreID(s.store2)
+	s.Equal(resolved, store1.getResolveState())
+
+	w := NewStoreTopologyWatcher(s.cache, time.Hour)
+	ctx := context.Background()
+	s.Nil(w.Refresh(ctx))
+	s.Equal(store1, s.cache.getStoreByStoreID(s.store1))
+
+	store3 := s.cluster.AllocID()
+	s.cluster.AddStore(store3, s.storeAddr(store3))
+	s.cluster.UpdateStoreAddr(s.store1, "store1-new")
+	s.cluster.RemoveStore(s.store2)
+	s.Nil(w.Refresh(ctx))
+
+	s.Equal(s.storeAddr(store3), s.cache.getStoreByStoreID(store3).addr)
+	s.Equal(resolved, s.cache.getStoreByStoreID(store3).getResolveState())
+	s.Equal(deleted, store1.getResolveState())
+	s.Equal("store1-new", s.cache.getStoreByStoreID(s.store1).addr)
+	s.Equal(tombstone, store2.getResolveState())
+
+	// Nothing is changed.
+	store3Cached := s.cache.getStoreByStoreID(store3)
+	s.Nil(w.Refresh(ctx))
+	s.Equal(store3Cached, s.cache.getStoreByStoreID(store3))
+}
+
+type splitRecorder struct {
+	oldRegion, newRegion1, newRegion2 *Region
+}
+
+func (r *splitRecorder) OnSplit(oldRegion, newRegion1, newRegion2 *Region) {
+	r.oldRegion, r.newRegion1, r.newRegion2 = oldRegion, newRegion1, newRegion2
+}
+
+func (s *testRegionCacheSuite) TestRegionSplitObserver() {
+	recorder := &splitRecorder{}
+	s.cache.RegisterSplitObserver(recorder)
+
+	loc, err := s.cache.LocateKey(s.bo, []byte("a"))
+	s.Nil(err)
+	ctx, err := s.cache.GetTiKVRPCContext(s.bo, loc.Region, kv.ReplicaReadLeader, 0)
+	s.Nil(err)
+
+	region2 := s.cluster.AllocID()
+	newPeers := s.cluster.AllocIDs(2)
+	s.cluster.Split(s.region1, region2, []byte("m"), newPeers, newPeers[0])
+	meta1, _ := s.cluster.GetRegion(s.region1)
+	meta2, _ := s.cluster.GetRegion(region2)
+
+	_, err = s.cache.OnRegionEpochNotMatch(s.bo, ctx, []*metapb.Region{meta1, meta2})
+	s.Nil(err)
+	s.NotNil(recorder.oldRegion)
+	s.Equal(loc.Region, recorder.oldRegion.VerID())
+	s.Equal(s.region1, recorder.newRegion1.GetID())
+	s.Equal(region2, recorder.newRegion2.GetID())
+	s.Equal([]byte("m"), recorder.newRegion1.EndKey())
+}
+
+func (s *testRegionCacheSuite) TestRegionCacheEviction() {
+	regionID := s.region1
+	for _, key := range []string{"b", "c", "d"} {
+		newRegionID, newPeers := s.cluster.AllocID(), s.cluster.AllocIDs(2)
+		s.cluster.Split(regionID, newRegionID, []byte(key), newPeers, newPeers[0])
+		regionID = newRegionID
+	}
+	s.cache.maxRegions = 3
+
+	locate := func(key string) *Region {
+		loc, err := s.cache.LocateKey(s.bo, []byte(key))
+		s.Nil(err)
+		return s.cache.GetCachedRegionWithRLock(loc.Region)
+	}
+	cached := func(key string) bool {
+		return s.cache.searchCachedRegion([]byte(key), false) != nil
+	}
+	now := time.Now().Unix()
+	a, b, c := locate("a"), locate("b"), locate("c")
+	s.Equal(3, s.cache.mu.sorted.Len())
+	atomic.StoreInt64(&a.lastAccess, now)
+	atomic.StoreInt64(&b.lastAccess, now-2)
+	atomic.StoreInt64(&c.lastAccess, now-1)
+
+	// "b" is the least recently used one.
+	locate("d")
+	s.Equal(3, s.cache.mu.sorted.Len())
+	s.False(cached("b"))
+	s.True(cached("a"))
+	s.True(cached("c"))
+	s.True(cached("d"))
+
+	// The evicted region is loaded again.
+	atomic.StoreInt64(&c.lastAccess, now-1)
+	s.NotNil(locate("b"))
+	s.Equal(3, s.cache.mu.sorted.Len())
+	s.False(cached("c"))
+}
+
+func (s *testRegionCacheSuite) TestStoreInfoCache() {
+	s.Nil(s.cache.StoreInfoCache())
+	s.cache.storeInfo = NewStoreInfoCache(16)
+	defer func() { s.cache.storeInfo = nil }()
+	cache := s.cache.StoreInfoCache()
+	resolve := func() string {
+		addr, err := (&Store{storeID: s.store1}).initResolve(s.bo, s.cache)
+		s.Nil(err)
+		return addr
+	}
+	stats := cache.Stats()
+	addr := resolve()
+	s.Equal(stats.Misses+1, cache.Stats().Misses)
+
+	// The cached meta is used until it's invalidated.
+	s.cluster.UpdateStoreAddr(s.store1, "new-addr")
+	s.Equal(addr, resolve())
+	s.Equal(stats.Hits+1, cache.Stats().Hits)
+	// The metas cached from now on expire immediately.
+	cache.ttl = 0
+	cache.Invalidate(s.store1)
+	s.Equal("new-addr", resolve())
+	s.Equal(stats.Misses+2, cache.Stats().Misses)
+
+	// Expired metas are loaded again.
+	s.cluster.UpdateStoreAddr(s.store1, addr)
+	s.Equal(addr, resolve())
+	s.Equal(stats.Misses+3, cache.Stats().Misses)
+
+	cache = NewStoreInfoCache(1)
+	cache.put(&metapb.Store{Id: 1})
+	cache.put(&metapb.Store{Id: 2})
+	s.Equal(1, cache.Stats().Size)
+	store, err := cache.get(2, func() (*metapb.Store, error) { return nil, errors.New("unexpected") })
+	s.Nil(err)
+	s.Equal(uint64(2), store.GetId())
+}
+
+func (s *testRegionCacheSuite) TestLeaderConnPrewarmerStopsOnClose() {
+	cache := NewRegionCache(&CodecPDClient{mocktikv.NewPDClient(s.cluster)})
+	loc, err := cache.LocateKey(s.bo, []byte("a"))
+	s.Nil(err)
+	region := cache.GetCachedRegionWithRLock(loc.Region)
+
+	sent := make(chan struct{})
+	var sendErr error
+	client := &fnClient{fn: func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+		close(sent)
+		<-ctx.Done()
+		sendErr = ctx.Err()
+		return nil, sendErr
+	}}
+	NewLeaderConnPrewarmer(cache, client).OnSplit(region, region, region)
+	<-sent
+
+	// Closing the cache cancels the prewarm and waits for it.
+	cache.Close()
+	s.Equal(context.Canceled, sendErr)
+}
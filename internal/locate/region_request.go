@@ -103,9 +103,67 @@ type RegionRequestSender struct {
 	leaderReplicaSelector *replicaSelector
 	failStoreIDs          map[uint64]struct{}
 	failProxyStoreIDs     map[uint64]struct{}
+	leaderWritePolicy     LeaderWritePolicy
+	storeType             tikvrpc.EndpointType
+	timeouts              client.Timeouts
+	// peerPolicy chooses the peers of the read requests if it's not nil.
+	peerPolicy *PeerSelectionPolicy
+	// adaptiveTimeout sets the timeout of the retries if it's not nil.
+	adaptiveTimeout *AdaptiveTimeoutPolicy
 	RegionRequestRuntimeStats
 }
 
+// SenderOption configures a RegionRequestSender.
+type SenderOption func(*RegionRequestSender)
+
+// WithStoreType makes the sender route the read requests that target TiKV to
+// the stores of storeType. Only TiFlash is supported now. Only the coprocessor
+// and MPP requests, which are the reads TiFlash serves, are routed, and they
+// fall back to TiKV if the region has no TiFlash peer. The other requests,
+// including all writes, are always sent to TiKV.
+func WithStoreType(storeType tikvrpc.EndpointType) SenderOption {
+	return func(s *RegionRequestSender) {
+		s.storeType = storeType
+	}
+}
+
+// LeaderWritePolicy decides how RegionRequestSender routes write requests.
+type LeaderWritePolicy int
+
+const (
+	// LeaderWriteByRequest routes write requests according to the replica read
+	// type and store selector options of the request.
+	LeaderWriteByRequest LeaderWritePolicy = iota
+	// LeaderWriteAlways always routes write requests to the current leader and
+	// ignores the replica read type and store selector options of the request.
+	// The write requests are the transactional and raw writes, see isWriteCmd.
+	LeaderWriteAlways
+)
+
+// isWriteCmd returns true if the command writes data or locks, of a
+// transaction or of the raw KV API.
+func isWriteCmd(cmd tikvrpc.CmdType) bool {
+	switch cmd {
+	case tikvrpc.CmdPrewrite, tikvrpc.CmdCommit, tikvrpc.CmdCleanup, tikvrpc.CmdBatchRollback,
+		tikvrpc.CmdPessimisticLock, tikvrpc.CmdPessimisticRollback, tikvrpc.CmdTxnHeartBeat,
+		tikvrpc.CmdDeleteRange,
+		tikvrpc.CmdRawPut, tikvrpc.CmdRawBatchPut, tikvrpc.CmdRawDelete, tikvrpc.CmdRawBatchDelete,
+		tikvrpc.CmdRawDeleteRange:
+		return true
+	}
+	return false
+}
+
+// isTiFlashReadCmd returns true if the command is a read served by TiFlash.
+func isTiFlashReadCmd(cmd tikvrpc.CmdType) bool {
+	switch cmd {
+	case tikvrpc.CmdCop, tikvrpc.CmdCopStream, tikvrpc.CmdBatchCop,
+		tikvrpc.CmdMPPTask, tikvrpc.CmdMPPConn, tikvrpc.CmdMPPCancel:
+		return true
+	}
+	return false
+}
+
 // RegionRequestRuntimeStats records the runtime stats of send region requests.
 type RegionRequestRuntimeStats struct {
 	Stats map[tikvrpc.CmdType]*RPCRuntimeStats
@@ -180,11 +238,22 @@ func RecordRegionRequestRuntimeStats(stats map[tikvrpc.CmdType]*RPCRuntimeStats,
 }
 
 // NewRegionRequestSender creates a new sender.
-func NewRegionRequestSender(regionCache *RegionCache, client client.Client) *RegionRequestSender {
-	return &RegionRequestSender{
+func NewRegionRequestSender(regionCache *RegionCache, client client.Client, opts ...SenderOption) *RegionRequestSender {
+	s := &RegionRequestSender{
 		regionCache: regionCache,
 		client:      client,
+		timeouts:    globalTimeouts(),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// globalTimeouts is client.GlobalTimeouts, which is shadowed by the parameter
+// of NewRegionRequestSender.
+func globalTimeouts() client.Timeouts {
+	return client.GlobalTimeouts()
 }
 
 // GetRegionCache returns the region cache.
@@ -207,6 +276,16 @@ func (s *RegionRequestSender) GetStoreAddr() string {
 	return s.storeAddr
 }
 
+// SetLeaderWritePolicy sets the policy of routing write requests.
+func (s *RegionRequestSender) SetLeaderWritePolicy(policy LeaderWritePolicy) {
+	s.leaderWritePolicy = policy
+}
+
+// GetLeaderWritePolicy returns the policy of routing write requests.
+func (s *RegionRequestSender) GetLeaderWritePolicy() LeaderWritePolicy {
+	return s.leaderWritePolicy
+}
+
 // GetRPCError returns the RPC error.
 func (s *RegionRequestSender) GetRPCError() error {
 	return s.rpcError
@@ -235,13 +314,19 @@ type replicaSelector struct {
 	regionCache *RegionCache
 	region      *Region
 	// replicas contains all TiKV replicas for now and the leader is at the
-	// head of the slice.
+	// head of the slice, unless they are arranged by a PeerSelectionPolicy.
 	replicas []*replica
 	// nextReplicaIdx points to the candidate for the next attempt.
 	nextReplicaIdx int
+	// leader is the leader replica in the cached region, it's not in replicas
+	// if it's excluded by the policy.
+	leader *replica
+	// replicaRead is set if the replicas are arranged by a PeerSelectionPolicy,
+	// the requests to the non-leader replicas are replica reads then.
+	replicaRead bool
 }
 
-func newReplicaSelector(regionCache *RegionCache, regionID RegionVerID) (*replicaSelector, error) {
+func newReplicaSelector(regionCache *RegionCache, regionID RegionVerID, policy *PeerSelectionPolicy) (*replicaSelector, error) {
 	cachedRegion := regionCache.GetCachedRegionWithRLock(regionID)
 	if cachedRegion == nil || !cachedRegion.isValid() {
 		return nil, nil
@@ -258,14 +343,28 @@ func newReplicaSelector(regionCache *RegionCache, regionID RegionVerID) (*replic
 	}
 	// Move the leader to the first slot.
 	replicas[regionStore.workTiKVIdx], replicas[0] = replicas[0], replicas[regionStore.workTiKVIdx]
+	leader := replicas[0]
+	if policy != nil {
+		replicas = policy.arrange(replicas, leader)
+		if len(replicas) == 0 {
+			return nil, errors.Errorf("no peer of region %d matches the peer selection policy", regionID.GetID())
+		}
+	}
 	return &replicaSelector{
-		regionCache,
-		cachedRegion,
-		replicas,
-		0,
+		regionCache:    regionCache,
+		region:         cachedRegion,
+		replicas:       replicas,
+		nextReplicaIdx: 0,
+		leader:         leader,
+		replicaRead:    policy != nil,
 	}, nil
 }
 
+// isLeaderTarget returns true if the current candidate is the leader.
+func (s *replicaSelector) isLeaderTarget() bool {
+	return s.replicas[s.nextReplicaIdx-1] == s.leader
+}
+
 // isExhausted returns true if runs out of all replicas.
 func (s *replicaSelector) isExhausted() bool {
 	return s.nextReplicaIdx >= len(s.replicas)
@@ -350,6 +449,10 @@ func (s *replicaSelector) onSendFailure(bo *retry.Backoffer, err error) {
 // is only used for leader request. It's called when the request is sent to the
 // replica successfully.
 func (s *replicaSelector) OnSendSuccess() {
+	// A replica read succeeding on a follower doesn't mean it's the leader.
+	if s.replicaRead {
+		return
+	}
 	// The successful replica is not at the head of replicas which means it's not the
 	// leader in the cached region, so update leader.
 	if s.nextReplicaIdx-1 != 0 {
@@ -416,13 +519,21 @@ func (s *RegionRequestSender) getRPCContext(
 		// TODO(youjiali1995): make all requests use the replica selector.
 		if !s.regionCache.enableForwarding && req.ReplicaReadType == kv.ReplicaReadLeader {
 			if s.leaderReplicaSelector == nil {
-				selector, err := newReplicaSelector(s.regionCache, regionID)
+				var policy *PeerSelectionPolicy
+				if isDataReadCmd(req.Type) {
+					policy = s.peerPolicy
+				}
+				selector, err := newReplicaSelector(s.regionCache, regionID, policy)
 				if selector == nil || err != nil {
 					return nil, err
 				}
 				s.leaderReplicaSelector = selector
 			}
-			return s.leaderReplicaSelector.next(bo)
+			rpcCtx, err := s.leaderReplicaSelector.next(bo)
+			if rpcCtx != nil && s.leaderReplicaSelector.replicaRead {
+				req.ReplicaRead = !s.leaderReplicaSelector.isLeaderTarget()
+			}
+			return rpcCtx, err
 		}
 
 		var seed uint32
@@ -500,14 +611,31 @@ func (s *RegionRequestSender) SendReqCtx(
 		}
 	}
 
+	timeout = s.timeouts.Scale(timeout)
+
 	// If the MaxExecutionDurationMs is not set yet, we set it to be the RPC timeout duration
 	// so TiKV can give up the requests whose response TiDB cannot receive due to timeout.
 	if req.Context.MaxExecutionDurationMs == 0 {
 		req.Context.MaxExecutionDurationMs = uint64(timeout.Milliseconds())
 	}
 
+	// Write requests can only be served by the leader, drop any follower selection
+	// settings so the request won't be sent to a follower and retried.
+	if s.leaderWritePolicy == LeaderWriteAlways && et == tikvrpc.TiKV && isWriteCmd(req.Type) {
+		req.ReplicaReadType = kv.ReplicaReadLeader
+		req.ReplicaReadSeed = nil
+		req.ReplicaRead = false
+		opts = nil
+	}
+
+	if s.storeType == tikvrpc.TiFlash && et == tikvrpc.TiKV && isTiFlashReadCmd(req.Type) && s.regionCache.hasTiFlashPeer(regionID) {
+		et = tikvrpc.TiFlash
+		req.StoreTp = tikvrpc.TiFlash
+	}
+
 	s.reset()
 	tryTimes := 0
+	epochRetried := false
 	defer func() {
 		if tryTimes > 0 {
 			metrics.TiKVRequestRetryTimesHistogram.Observe(float64(tryTimes))
@@ -548,8 +676,12 @@ func (s *RegionRequestSender) SendReqCtx(
 
 		logutil.Eventf(bo.GetCtx(), "send %s request to region %d at %s", req.Type, regionID.id, rpcCtx.Addr)
 		s.storeAddr = rpcCtx.Addr
+		rpcTimeout := timeout
+		if tryTimes > 0 && s.adaptiveTimeout != nil {
+			rpcTimeout = s.adaptiveTimeout.Timeout(req.Type, timeout)
+		}
 		var retry bool
-		resp, retry, err = s.sendReqToRegion(bo, rpcCtx, req, timeout)
+		resp, retry, err = s.sendReqToRegion(bo, rpcCtx, req, rpcTimeout)
 		if err != nil {
 			return nil, nil, errors.Trace(err)
 		}
@@ -587,6 +719,17 @@ func (s *RegionRequestSender) SendReqCtx(
 			if s.leaderReplicaSelector != nil {
 				s.leaderReplicaSelector.OnSendSuccess()
 			}
+			if err = s.regionCache.checkRegionEpoch(rpcCtx, isDataReadCmd(req.Type), epochRetried); err != nil {
+				if !epochRetried {
+					// Read it again once, TiKV rejects it with EpochNotMatch if
+					// the epoch is really stale.
+					epochRetried = true
+					s.reset()
+					tryTimes++
+					continue
+				}
+				return nil, nil, err
+			}
 		}
 		return resp, rpcCtx, nil
 	}
@@ -698,6 +841,9 @@ func (s *RegionRequestSender) sendReqToRegion(bo *retry.Backoffer, rpcCtx *RPCCo
 	if !injectFailOnSend {
 		start := time.Now()
 		resp, err = s.client.SendRequest(ctx, sendToAddr, req, timeout)
+		if err == nil && s.adaptiveTimeout != nil {
+			s.adaptiveTimeout.Observe(req.Type, time.Since(start))
+		}
 		if s.Stats != nil {
 			RecordRegionRequestRuntimeStats(s.Stats, req.Type, time.Since(start))
 			if val, err := util.EvalFailpoint("tikvStoreRespResult"); err == nil {
@@ -757,6 +903,11 @@ func (s *RegionRequestSender) sendReqToRegion(bo *retry.Backoffer, rpcCtx *RPCCo
 	if err != nil {
 		s.rpcError = err
 
+		// The quota of the keyspace is exceeded, retrying only makes it worse.
+		if tikverr.Is(err, &tikverr.ErrQuotaExceeded{}) {
+			return nil, false, err
+		}
+
 		// Because in rpc logic, context.Cancel() will be transferred to rpcContext.Cancel error. For rpcContext cancel,
 		// we need to retry the request. But for context cancel active, for example, limitExec gets the required rows,
 		// we shouldn't retry the request, it will go to backoff and hang in retry logic.
@@ -890,6 +1041,16 @@ func regionErrorToLabel(e *errorpb.Error) string {
 	return "unknown"
 }
 
+// retryAfterHint returns the time in milliseconds that TiKV suggests to wait
+// before retrying the request, which overrides the sleep computed by the
+// backoffer.
+func retryAfterHint(regionErr *errorpb.Error) (int, bool) {
+	if ms := regionErr.GetServerIsBusy().GetBackoffMs(); ms > 0 {
+		return int(ms), true
+	}
+	return 0, false
+}
+
 func (s *RegionRequestSender) onRegionError(bo *retry.Backoffer, ctx *RPCContext, req *tikvrpc.Request, regionErr *errorpb.Error, opts *[]StoreSelectorOption) (shouldRetry bool, err error) {
 	if span := opentracing.SpanFromContext(bo.GetCtx()); span != nil && span.Tracer() != nil {
 		span1 := span.Tracer().StartSpan("tikv.onRegionError", opentracing.ChildOf(span.Context()))
@@ -976,10 +1137,15 @@ func (s *RegionRequestSender) onRegionError(bo *retry.Backoffer, ctx *RPCContext
 		logutil.BgLogger().Warn("tikv reports `ServerIsBusy` retry later",
 			zap.String("reason", regionErr.GetServerIsBusy().GetReason()),
 			zap.Stringer("ctx", ctx))
+		cfg := retry.BoTiKVServerBusy
 		if ctx != nil && ctx.Store != nil && ctx.Store.storeType == tikvrpc.TiFlash {
-			err = bo.Backoff(retry.BoTiFlashServerBusy, errors.Errorf("server is busy, ctx: %v", ctx))
+			cfg = retry.BoTiFlashServerBusy
+		}
+		busyErr := errors.Errorf("server is busy, ctx: %v", ctx)
+		if retryAfterMs, ok := retryAfterHint(regionErr); ok {
+			err = bo.BackoffWithRetryAfter(cfg, retryAfterMs, busyErr)
 		} else {
-			err = bo.Backoff(retry.BoTiKVServerBusy, errors.Errorf("server is busy, ctx: %v", ctx))
+			err = bo.Backoff(cfg, busyErr)
 		}
 		if err != nil {
 			return false, errors.Trace(err)
@@ -1012,6 +1178,9 @@ func (s *RegionRequestSender) onRegionError(bo *retry.Backoffer, ctx *RPCContext
 		logutil.BgLogger().Debug("tikv reports `StoreNotMatch` retry later",
 			zap.Stringer("storeNotMatch", storeNotMatch),
 			zap.Stringer("ctx", ctx))
+		if s.regionCache.storeInfo != nil {
+			s.regionCache.storeInfo.Invalidate(ctx.Store.storeID)
+		}
 		ctx.Store.markNeedCheck(s.regionCache.notifyCheckCh)
 		s.regionCache.InvalidateCachedRegion(ctx.Region)
 		return false, nil
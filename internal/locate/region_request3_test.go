@@ -324,7 +324,7 @@ func (s *testRegionRequestToThreeStoresSuite) TestReplicaSelector() {
 	cache.insertRegionToCache(region)
 
 	// Verify creating the replicaSelector.
-	replicaSelector, err := newReplicaSelector(cache, regionLoc.Region)
+	replicaSelector, err := newReplicaSelector(cache, regionLoc.Region, nil)
 	s.NotNil(replicaSelector)
 	s.Nil(err)
 	s.Equal(replicaSelector.region, region)
@@ -379,7 +379,7 @@ func (s *testRegionRequestToThreeStoresSuite) TestReplicaSelector() {
 	s.False(replicaSelector.region.isValid())
 
 	region.lastAccess = time.Now().Unix()
-	replicaSelector, err = newReplicaSelector(cache, regionLoc.Region)
+	replicaSelector, err = newReplicaSelector(cache, regionLoc.Region, nil)
 	s.Nil(err)
 	s.NotNil(replicaSelector)
 	cache.testingKnobs.mockRequestLiveness = func(s *Store, bo *retry.Backoffer) livenessState {
@@ -402,7 +402,7 @@ func (s *testRegionRequestToThreeStoresSuite) TestReplicaSelector() {
 	s.Equal(replicaSelector.nextReplicaIdx, 2)
 
 	// Verify updating leader.
-	replicaSelector, _ = newReplicaSelector(cache, regionLoc.Region)
+	replicaSelector, _ = newReplicaSelector(cache, regionLoc.Region, nil)
 	replicaSelector.next(s.bo)
 	// The leader is the 3rd replica. After updating leader, it should be the next.
 	leader := replicaSelector.replicas[2]
@@ -417,7 +417,7 @@ func (s *testRegionRequestToThreeStoresSuite) TestReplicaSelector() {
 	s.Equal(leaderStore, leader.store)
 	s.Equal(leaderPeer, leader.peer)
 
-	replicaSelector, _ = newReplicaSelector(cache, regionLoc.Region)
+	replicaSelector, _ = newReplicaSelector(cache, regionLoc.Region, nil)
 	replicaSelector.next(s.bo)
 	replicaSelector.next(s.bo)
 	replicaSelector.next(s.bo)
@@ -439,7 +439,7 @@ func (s *testRegionRequestToThreeStoresSuite) TestReplicaSelector() {
 	s.Equal(leaderPeer, leader.peer)
 
 	// Give the leader one more chance even if it exceeds the maxReplicaAttempt.
-	replicaSelector, _ = newReplicaSelector(cache, regionLoc.Region)
+	replicaSelector, _ = newReplicaSelector(cache, regionLoc.Region, nil)
 	leader = replicaSelector.replicas[0]
 	leader.attempts = maxReplicaAttempt
 	replicaSelector.updateLeader(leader.peer)
@@ -459,7 +459,7 @@ func (s *testRegionRequestToThreeStoresSuite) TestReplicaSelector() {
 
 	// Verify on send success.
 	region.lastAccess = time.Now().Unix()
-	replicaSelector, _ = newReplicaSelector(cache, regionLoc.Region)
+	replicaSelector, _ = newReplicaSelector(cache, regionLoc.Region, nil)
 	replicaSelector.next(s.bo)
 	rpcCtx, err = replicaSelector.next(s.bo)
 	s.Nil(err)
@@ -701,3 +701,107 @@ func (s *testRegionRequestToThreeStoresSuite) TestSendReqWithReplicaSelector() {
 		s.cluster.StartStore(store)
 	}
 }
+
+func (s *testRegionRequestToThreeStoresSuite) TestPeerSelectionPolicy() {
+	leaderStore, leaderAddr := s.loadAndGetLeaderStore()
+	region, err := s.cache.LocateRegionByID(s.bo, s.regionID)
+	s.Nil(err)
+
+	var addrs []string
+	var replicaReads []bool
+	failedAddrs := make(map[string]struct{})
+	client := &fnClient{fn: func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+		addrs = append(addrs, addr)
+		replicaReads = append(replicaReads, req.ReplicaRead)
+		if _, ok := failedAddrs[addr]; ok {
+			return nil, errors.New("store unreachable")
+		}
+		if req.Type == tikvrpc.CmdRawPut {
+			return &tikvrpc.Response{Resp: &kvrpcpb.RawPutResponse{}}, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.GetResponse{Value: []byte("value")}}, nil
+	}}
+	send := func(policy PeerSelectionPolicy, req *tikvrpc.Request) {
+		addrs, replicaReads = nil, nil
+		sender := NewRegionRequestSenderWithPolicy(s.cache, client, policy)
+		resp, err := sender.SendReq(retry.NewBackoffer(context.Background(), -1), req, region.Region, time.Second)
+		s.Nil(err)
+		s.NotNil(resp)
+	}
+	getReq := func() *tikvrpc.Request {
+		return tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("a")})
+	}
+
+	// Reads go to a follower first.
+	followerFirst := Ordered([]PeerType{PeerTypeFollower, PeerTypeLeader})
+	send(followerFirst, getReq())
+	s.Len(addrs, 1)
+	s.NotEqual(leaderAddr, addrs[0])
+	s.True(replicaReads[0])
+
+	// The leader isn't changed by the successful replica reads.
+	send(followerFirst, getReq())
+	cachedRegion := s.cache.GetCachedRegionWithRLock(region.Region)
+	s.Equal(s.leaderPeer, cachedRegion.GetLeaderPeerID())
+
+	// Writes aren't affected by the policy.
+	send(followerFirst, tikvrpc.NewRequest(tikvrpc.CmdRawPut, &kvrpcpb.RawPutRequest{Key: []byte("a"), Value: []byte("v")}))
+	s.Equal([]string{leaderAddr}, addrs)
+	s.Equal([]bool{false}, replicaReads)
+
+	// No peer matches the policy.
+	sender := NewRegionRequestSenderWithPolicy(s.cache, client, Ordered([]PeerType{PeerTypeLearner}))
+	_, err = sender.SendReq(retry.NewBackoffer(context.Background(), -1), getReq(), region.Region, time.Second)
+	s.NotNil(err)
+
+	// Reads fall back to the leader if all the followers fail.
+	for _, storeID := range s.storeIDs {
+		if storeID != leaderStore.storeID {
+			failedAddrs[s.cluster.GetStore(storeID).GetAddress()] = struct{}{}
+		}
+	}
+	send(followerFirst, getReq())
+	s.Len(addrs, 3)
+	s.Equal(leaderAddr, addrs[2])
+	s.False(replicaReads[2])
+}
+
+func (s *testRegionRequestToThreeStoresSuite) TestLeaderWriteAlways() {
+	_, leaderAddr := s.loadAndGetLeaderStore()
+	region, err := s.cache.LocateRegionByID(s.bo, s.regionID)
+	s.Nil(err)
+
+	var addrs []string
+	client := &fnClient{fn: func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+		addrs = append(addrs, addr)
+		if req.Type == tikvrpc.CmdRawPut {
+			return &tikvrpc.Response{Resp: &kvrpcpb.RawPutResponse{}}, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.PrewriteResponse{}}, nil
+	}}
+	var seed uint32
+	send := func(policy LeaderWritePolicy, req *tikvrpc.Request) string {
+		addrs = nil
+		sender := NewRegionRequestSender(s.cache, client)
+		sender.SetLeaderWritePolicy(policy)
+		resp, err := sender.SendReq(retry.NewBackoffer(context.Background(), -1), req, region.Region, time.Second)
+		s.Nil(err)
+		s.NotNil(resp)
+		s.Len(addrs, 1)
+		return addrs[0]
+	}
+	prewriteReq := func() *tikvrpc.Request {
+		return tikvrpc.NewReplicaReadRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{}, kv.ReplicaReadFollower, &seed)
+	}
+	rawPutReq := func() *tikvrpc.Request {
+		return tikvrpc.NewReplicaReadRequest(tikvrpc.CmdRawPut, &kvrpcpb.RawPutRequest{Key: []byte("a"), Value: []byte("v")}, kv.ReplicaReadFollower, &seed)
+	}
+
+	// The follower read options of the request are followed by default.
+	s.NotEqual(leaderAddr, send(LeaderWriteByRequest, prewriteReq()))
+	s.NotEqual(leaderAddr, send(LeaderWriteByRequest, rawPutReq()))
+
+	// The transactional and raw writes go to the leader.
+	s.Equal(leaderAddr, send(LeaderWriteAlways, prewriteReq()))
+	s.Equal(leaderAddr, send(LeaderWriteAlways, rawPutReq()))
+}
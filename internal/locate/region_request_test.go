@@ -50,6 +50,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/tikvpb"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/client-go/v2/config"
+	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/internal/client"
 	"github.com/tikv/client-go/v2/internal/retry"
 	"github.com/tikv/client-go/v2/mockstore/mocktikv"
@@ -218,6 +219,52 @@ func (s *testRegionRequestToSingleStoreSuite) TestSendReqCtx() {
 	s.NotNil(ctx)
 }
 
+func (s *testRegionRequestToSingleStoreSuite) TestSendReqWithStoreType() {
+	var addr string
+	client := &fnClient{fn: func(ctx context.Context, target string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+		addr = target
+		return &tikvrpc.Response{Resp: &kvrpcpb.GetResponse{}}, nil
+	}}
+	sender := NewRegionRequestSender(s.cache, client, WithStoreType(tikvrpc.TiFlash))
+	send := func(cmd tikvrpc.CmdType) string {
+		loc, err := s.cache.LocateKey(s.bo, []byte("a"))
+		s.Nil(err)
+		var req *tikvrpc.Request
+		switch cmd {
+		case tikvrpc.CmdCop:
+			req = tikvrpc.NewRequest(cmd, &coprocessor.Request{})
+		case tikvrpc.CmdPrewrite:
+			req = tikvrpc.NewRequest(cmd, &kvrpcpb.PrewriteRequest{})
+		case tikvrpc.CmdRawPut:
+			req = tikvrpc.NewRequest(cmd, &kvrpcpb.RawPutRequest{Key: []byte("a")})
+		case tikvrpc.CmdResolveLock:
+			req = tikvrpc.NewRequest(cmd, &kvrpcpb.ResolveLockRequest{})
+		default:
+			req = tikvrpc.NewRequest(cmd, &kvrpcpb.GetRequest{Key: []byte("a")})
+		}
+		_, _, err = sender.SendReqCtx(s.bo, req, loc.Region, time.Second, tikvrpc.TiKV)
+		s.Nil(err)
+		return addr
+	}
+	tikvAddr := s.cluster.GetStore(s.store).GetAddress()
+
+	// Fall back to TiKV if the region has no TiFlash peer.
+	s.Equal(tikvAddr, send(tikvrpc.CmdCop))
+
+	ids := s.cluster.AllocIDs(2)
+	s.cluster.AddStore(ids[0], "tiflash", &metapb.StoreLabel{Key: "engine", Value: "tiflash"})
+	s.cluster.AddPeer(s.region, ids[0], ids[1])
+	loc, err := s.cache.LocateKey(s.bo, []byte("a"))
+	s.Nil(err)
+	s.cache.InvalidateCachedRegion(loc.Region)
+	s.Equal("tiflash", send(tikvrpc.CmdCop))
+	// Only the reads TiFlash serves are routed, the others and the writes are
+	// always sent to TiKV.
+	for _, cmd := range []tikvrpc.CmdType{tikvrpc.CmdGet, tikvrpc.CmdPrewrite, tikvrpc.CmdRawPut, tikvrpc.CmdResolveLock} {
+		s.Equal(tikvAddr, send(cmd), cmd)
+	}
+}
+
 func (s *testRegionRequestToSingleStoreSuite) TestOnSendFailedWithCancelled() {
 	req := tikvrpc.NewRequest(tikvrpc.CmdRawPut, &kvrpcpb.RawPutRequest{
 		Key:   []byte("key"),
@@ -520,6 +567,127 @@ func (s *testRegionRequestToSingleStoreSuite) TestOnMaxTimestampNotSyncedError()
 	}()
 }
 
+func (s *testRegionRequestToSingleStoreSuite) TestServerIsBusyRetryAfterHint() {
+	req := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{})
+	region, err := s.cache.LocateRegionByID(s.bo, s.region)
+	s.Nil(err)
+	s.NotNil(region)
+
+	oc := s.regionRequestSender.client
+	defer func() {
+		s.regionRequestSender.client = oc
+	}()
+	count := 0
+	s.regionRequestSender.client = &fnClient{func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (response *tikvrpc.Response, err error) {
+		count++
+		if count == 1 {
+			return &tikvrpc.Response{Resp: &kvrpcpb.PrewriteResponse{
+				RegionError: &errorpb.Error{ServerIsBusy: &errorpb.ServerIsBusy{BackoffMs: 20}},
+			}}, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.PrewriteResponse{}}, nil
+	}}
+	bo := retry.NewBackofferWithVars(context.Background(), 5000, nil)
+	resp, err := s.regionRequestSender.SendReq(bo, req, region.Region, time.Second)
+	s.Nil(err)
+	s.NotNil(resp)
+	s.Equal(2, count)
+	// The hint overrides the backoff of tikvServerBusy, which is at least 1s.
+	sleep := bo.GetBackoffSleepMS()[retry.BoTiKVServerBusy.String()]
+	s.GreaterOrEqual(sleep, 20)
+	s.LessOrEqual(sleep, 22)
+}
+
+func (s *testRegionRequestToSingleStoreSuite) TestStaleRegionEpochRetry() {
+	region, err := s.cache.LocateRegionByID(s.bo, s.region)
+	s.Nil(err)
+	s.NotNil(region)
+	s.cache.epochVerifier = NewEpochVerifier()
+	s.cache.epochVerifier.Observe(region.Region.id, region.Region.ver+1)
+
+	oc := s.regionRequestSender.client
+	defer func() {
+		s.regionRequestSender.client = oc
+	}()
+	count := 0
+	var secondResp *tikvrpc.Response
+	s.regionRequestSender.client = &fnClient{func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (response *tikvrpc.Response, err error) {
+		count++
+		if count == 2 && secondResp != nil {
+			return secondResp, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.GetResponse{}}, nil
+	}}
+
+	// The read is retried once before the stale epoch is reported.
+	req := tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{})
+	_, err = s.regionRequestSender.SendReq(s.bo, req, region.Region, time.Second)
+	s.True(tikverr.Is(err, &tikverr.ErrStaleRegionEpoch{}), "%v", err)
+	s.Equal(2, count)
+	s.False(s.cache.GetCachedRegionWithRLock(region.Region).isValid())
+
+	// The retry gets the EpochNotMatch of TiKV instead.
+	region, err = s.cache.LocateRegionByID(s.bo, s.region)
+	s.Nil(err)
+	count = 0
+	secondResp = &tikvrpc.Response{Resp: &kvrpcpb.GetResponse{RegionError: &errorpb.Error{EpochNotMatch: &errorpb.EpochNotMatch{}}}}
+	resp, err := s.regionRequestSender.SendReq(s.bo, req, region.Region, time.Second)
+	s.Nil(err)
+	s.Equal(2, count)
+	regionErr, err := resp.GetRegionError()
+	s.Nil(err)
+	s.NotNil(regionErr.GetEpochNotMatch())
+}
+
+func (s *testRegionRequestToSingleStoreSuite) TestAdaptiveTimeout() {
+	policy := NewAdaptiveTimeoutPolicy(20*time.Millisecond, time.Second)
+	s.Equal(time.Second, policy.Timeout(tikvrpc.CmdPrewrite, time.Second))
+	for i := 0; i < adaptiveTimeoutMinSamples; i++ {
+		policy.Observe(tikvrpc.CmdPrewrite, 100*time.Millisecond)
+	}
+	s.Equal(150*time.Millisecond, policy.Timeout(tikvrpc.CmdPrewrite, time.Second))
+	s.Equal(time.Second, policy.Timeout(tikvrpc.CmdCommit, time.Second))
+	policy.MaxTimeout = 120 * time.Millisecond
+	s.Equal(120*time.Millisecond, policy.Timeout(tikvrpc.CmdPrewrite, time.Second))
+	policy.MinTimeout, policy.MaxTimeout = 200*time.Millisecond, time.Second
+	s.Equal(200*time.Millisecond, policy.Timeout(tikvrpc.CmdPrewrite, time.Second))
+
+	// The P99 follows the recent latencies. The zero value policy is usable.
+	policy = &AdaptiveTimeoutPolicy{}
+	for i := 0; i < adaptiveTimeoutWindow+adaptiveTimeoutMinSamples; i++ {
+		d := time.Second
+		if i >= adaptiveTimeoutWindow {
+			d = 10 * time.Millisecond
+		}
+		policy.Observe(tikvrpc.CmdPrewrite, d)
+	}
+	s.Equal(15*time.Millisecond, policy.Timeout(tikvrpc.CmdPrewrite, time.Second))
+
+	req := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{})
+	region, err := s.cache.LocateRegionByID(s.bo, s.region)
+	s.Nil(err)
+	s.NotNil(region)
+	sender := NewRegionRequestSender(s.cache, nil, WithAdaptiveTimeout(policy))
+	var timeouts []time.Duration
+	sender.client = &fnClient{func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (response *tikvrpc.Response, err error) {
+		timeouts = append(timeouts, timeout)
+		if len(timeouts) == 1 {
+			return &tikvrpc.Response{Resp: &kvrpcpb.PrewriteResponse{
+				RegionError: &errorpb.Error{MaxTimestampNotSynced: &errorpb.MaxTimestampNotSynced{}},
+			}}, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.PrewriteResponse{}}, nil
+	}}
+	bo := retry.NewBackofferWithVars(context.Background(), 5000, nil)
+	resp, err := sender.SendReq(bo, req, region.Region, time.Second)
+	s.Nil(err)
+	s.NotNil(resp)
+	s.Len(timeouts, 2)
+	// Only the retry uses the adaptive timeout.
+	s.Equal(sender.timeouts.Scale(time.Second), timeouts[0])
+	s.Equal(15*time.Millisecond, timeouts[1])
+}
+
 func (s *testRegionRequestToSingleStoreSuite) TestGetRegionByIDFromCache() {
 	region, err := s.cache.LocateRegionByID(s.bo, s.region)
 	s.Nil(err)
@@ -0,0 +1,96 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locate
+
+import (
+	"context"
+
+	"github.com/pingcap/kvproto/pkg/tikvpb"
+	"github.com/tikv/client-go/v2/internal/client"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/internal/retry"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"go.uber.org/zap"
+)
+
+// RegionSplitObserver is notified when the RegionCache learns from an
+// EpochNotMatch error that a cached region is split into two regions.
+// OnSplit is called synchronously on the request path, so it must not block.
+type RegionSplitObserver interface {
+	OnSplit(oldRegion, newRegion1, newRegion2 *Region)
+}
+
+// RegisterSplitObserver registers an observer of the region splits.
+func (c *RegionCache) RegisterSplitObserver(o RegionSplitObserver) {
+	c.splitObservers.Lock()
+	defer c.splitObservers.Unlock()
+	c.splitObservers.observers = append(c.splitObservers.observers, o)
+}
+
+func (c *RegionCache) notifySplit(oldRegion, newRegion1, newRegion2 *Region) {
+	c.splitObservers.RLock()
+	observers := c.splitObservers.observers
+	c.splitObservers.RUnlock()
+	for _, o := range observers {
+		o.OnSplit(oldRegion, newRegion1, newRegion2)
+	}
+}
+
+type leaderConnPrewarmer struct {
+	cache  *RegionCache
+	client client.Client
+}
+
+// NewLeaderConnPrewarmer creates a RegionSplitObserver that connects to the
+// leaders of the new regions in the background, so the first requests to the
+// new regions don't pay for dialing a store the client has not talked to.
+func NewLeaderConnPrewarmer(cache *RegionCache, client client.Client) RegionSplitObserver {
+	return &leaderConnPrewarmer{cache: cache, client: client}
+}
+
+func (p *leaderConnPrewarmer) OnSplit(oldRegion, newRegion1, newRegion2 *Region) {
+	ctx := p.cache.ctx
+	if ctx.Err() != nil {
+		return
+	}
+	p.cache.wg.Add(1)
+	go func() {
+		defer p.cache.wg.Done()
+		p.prewarm(ctx, newRegion1, newRegion2)
+	}()
+}
+
+func (p *leaderConnPrewarmer) prewarm(ctx context.Context, regions ...*Region) {
+	bo := retry.NewNoopBackoff(ctx)
+	warmed := make(map[string]struct{}, len(regions))
+	for _, region := range regions {
+		if ctx.Err() != nil {
+			return
+		}
+		store, _, _, _ := region.WorkStorePeer(region.getStore())
+		addr, err := p.cache.getStoreAddr(bo, region, store)
+		if err != nil || addr == "" {
+			continue
+		}
+		if _, ok := warmed[addr]; ok {
+			continue
+		}
+		warmed[addr] = struct{}{}
+		// Sending an empty request makes the client dial the store.
+		req := tikvrpc.NewRequest(tikvrpc.CmdEmpty, &tikvpb.BatchCommandsEmptyRequest{})
+		if _, err := p.client.SendRequest(ctx, addr, req, client.GlobalTimeouts().ReadShort); err != nil {
+			logutil.BgLogger().Debug("prewarm connection failed", zap.String("addr", addr), zap.Error(err))
+		}
+	}
+}
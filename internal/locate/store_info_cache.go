@@ -0,0 +1,128 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locate
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/client-go/v2/metrics"
+)
+
+// storeInfoCacheTTL is how long a store meta loaded from PD is used.
+const storeInfoCacheTTL = time.Minute
+
+// StoreInfoCache is a fixed-size LRU cache of the store metas loaded from PD.
+// The region cache resolves a store every time it creates a Store for it, which
+// happens again after the cache is cleared or the store is invalidated, so a
+// cluster of many stores can keep PD busy. The metas expire after a minute, and
+// the meta of a store is dropped when TiKV reports StoreNotMatch for it.
+type StoreInfoCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	// entries maps the store ID to its element in lru.
+	entries map[uint64]*list.Element
+	lru     *list.List
+
+	hits   uint64
+	misses uint64
+}
+
+type storeInfoEntry struct {
+	store  *metapb.Store
+	expire time.Time
+}
+
+// StoreInfoCacheStats is the statistics of a StoreInfoCache.
+type StoreInfoCacheStats struct {
+	Size   int
+	Hits   uint64
+	Misses uint64
+}
+
+// NewStoreInfoCache creates a StoreInfoCache holding at most capacity stores.
+func NewStoreInfoCache(capacity int) *StoreInfoCache {
+	return &StoreInfoCache{
+		capacity: capacity,
+		ttl:      storeInfoCacheTTL,
+		entries:  make(map[uint64]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns the cached meta of the store, or loads it by load and caches it.
+// The stores not found are not cached.
+func (c *StoreInfoCache) get(storeID uint64, load func() (*metapb.Store, error)) (*metapb.Store, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[storeID]; ok {
+		entry := e.Value.(*storeInfoEntry)
+		if time.Now().Before(entry.expire) {
+			c.lru.MoveToFront(e)
+			c.hits++
+			c.mu.Unlock()
+			return entry.store, nil
+		}
+		c.removeLocked(e)
+	}
+	c.misses++
+	c.mu.Unlock()
+	metrics.TiKVStoreInfoCacheMissCounter.Inc()
+
+	store, err := load()
+	if err == nil && store != nil {
+		c.put(store)
+	}
+	return store, err
+}
+
+// put caches the meta of a store loaded from PD.
+func (c *StoreInfoCache) put(store *metapb.Store) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &storeInfoEntry{store: store, expire: time.Now().Add(c.ttl)}
+	if e, ok := c.entries[store.GetId()]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[store.GetId()] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.capacity {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// Invalidate drops the cached meta of the store.
+func (c *StoreInfoCache) Invalidate(storeID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[storeID]; ok {
+		c.removeLocked(e)
+	}
+}
+
+func (c *StoreInfoCache) removeLocked(e *list.Element) {
+	delete(c.entries, e.Value.(*storeInfoEntry).store.GetId())
+	c.lru.Remove(e)
+}
+
+// Stats returns the number of the cached stores and the hits and misses of
+// the lookups.
+func (c *StoreInfoCache) Stats() StoreInfoCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return StoreInfoCacheStats{Size: c.lru.Len(), Hits: c.hits, Misses: c.misses}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package locate
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+)
+
+// Store topology event types.
+const (
+	storeTopologyAdd    = "add"
+	storeTopologyRemove = "remove"
+	storeTopologyUpdate = "update"
+)
+
+// StoreTopologyWatcher keeps the stores of a RegionCache in sync with the
+// store list of PD, so the stores joining or leaving the cluster are known
+// before any request is sent to them. The RegionCache itself only resolves
+// the stores of the regions it loads and re-resolves the stores it has seen.
+//
+// PD has no RPC to watch the store list, so the watcher polls it.
+type StoreTopologyWatcher struct {
+	cache    *RegionCache
+	interval time.Duration
+}
+
+// NewStoreTopologyWatcher creates a StoreTopologyWatcher that loads the store
+// list every interval.
+func NewStoreTopologyWatcher(cache *RegionCache, interval time.Duration) *StoreTopologyWatcher {
+	return &StoreTopologyWatcher{cache: cache, interval: interval}
+}
+
+// Run refreshes the stores until ctx is done.
+func (w *StoreTopologyWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Refresh(ctx); err != nil {
+				logutil.BgLogger().Warn("refresh store topology failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Refresh loads the store list from PD and applies the changes to the
+// RegionCache.
+func (w *StoreTopologyWatcher) Refresh(ctx context.Context) error {
+	stores, err := w.cache.pdClient.GetAllStores(ctx, pd.WithExcludeTombstone())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w.cache.applyStoreTopology(stores)
+	return nil
+}
+
+// applyStoreTopology adds the new stores, replaces the stores whose address or
+// labels are changed and marks the removed stores tombstone. The stores being
+// resolved are left to initResolve.
+func (c *RegionCache) applyStoreTopology(stores []*metapb.Store) {
+	c.storeMu.Lock()
+	defer c.storeMu.Unlock()
+	alive := make(map[uint64]struct{}, len(stores))
+	for _, meta := range stores {
+		if meta.GetState() == metapb.StoreState_Tombstone || meta.GetAddress() == "" {
+			continue
+		}
+		alive[meta.GetId()] = struct{}{}
+		s, ok := c.storeMu.stores[meta.GetId()]
+		if ok {
+			state := s.getResolveState()
+			if state != resolved && state != needCheck {
+				continue
+			}
+			if s.addr == meta.GetAddress() && s.IsSameLabels(meta.GetLabels()) {
+				continue
+			}
+		}
+		c.storeMu.stores[meta.GetId()] = &Store{
+			storeID:   meta.GetId(),
+			addr:      meta.GetAddress(),
+			saddr:     meta.GetStatusAddress(),
+			storeType: tikvrpc.GetStoreTypeByMeta(meta),
+			labels:    meta.GetLabels(),
+			state:     uint64(resolved),
+		}
+		if ok {
+			s.setResolveState(deleted)
+			logutil.BgLogger().Info("store topology updated", zap.Uint64("store", meta.GetId()), zap.String("addr", meta.GetAddress()))
+			metrics.TiKVStoreTopologyChangeTotal.WithLabelValues(storeTopologyUpdate).Inc()
+		} else {
+			logutil.BgLogger().Info("store topology added", zap.Uint64("store", meta.GetId()), zap.String("addr", meta.GetAddress()))
+			metrics.TiKVStoreTopologyChangeTotal.WithLabelValues(storeTopologyAdd).Inc()
+		}
+	}
+	for id, s := range c.storeMu.stores {
+		if _, ok := alive[id]; ok {
+			continue
+		}
+		if state := s.getResolveState(); state != resolved && state != needCheck {
+			continue
+		}
+		// Same as reResolve, the regions on the store are invalidated.
+		atomic.AddUint32(&s.epoch, 1)
+		s.setResolveState(tombstone)
+		logutil.BgLogger().Info("store topology removed", zap.Uint64("store", id), zap.String("addr", s.addr))
+		metrics.TiKVStoreTopologyChangeTotal.WithLabelValues(storeTopologyRemove).Inc()
+	}
+}
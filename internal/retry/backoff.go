@@ -36,6 +36,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"math/rand"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -127,6 +128,40 @@ func (b *Backoffer) BackoffWithMaxSleepTxnLockFast(maxSleepMs int, err error) er
 // BackoffWithCfgAndMaxSleep sleeps a while base on the Config and records the error message
 // and never sleep more than maxSleepMs for each sleep.
 func (b *Backoffer) BackoffWithCfgAndMaxSleep(cfg *Config, maxSleepMs int, err error) error {
+	return b.backoff(cfg, err, func() int {
+		// Lazy initialize.
+		if b.fn == nil {
+			b.fn = make(map[string]backoffFn)
+		}
+		f, ok := b.fn[cfg.name]
+		if !ok {
+			f = cfg.createBackoffFn(b.vars)
+			b.fn[cfg.name] = f
+		}
+		return f(b.ctx, maxSleepMs)
+	})
+}
+
+// BackoffWithRetryAfter sleeps retryAfterMs milliseconds plus a jitter of up to
+// a tenth of it instead of the sleep computed by the Config, and records the
+// error message. It's used when the server suggests when to retry. The sleep
+// never exceeds the remaining budget of the Backoffer.
+func (b *Backoffer) BackoffWithRetryAfter(cfg *Config, retryAfterMs int, err error) error {
+	return b.backoff(cfg, err, func() int {
+		sleep := retryAfterMs + rand.Intn(retryAfterMs/10+1)
+		if b.maxSleep > 0 && sleep > b.maxSleep-b.totalSleep {
+			sleep = b.maxSleep - b.totalSleep
+		}
+		select {
+		case <-time.After(time.Duration(sleep) * time.Millisecond):
+			return sleep
+		case <-b.ctx.Done():
+			return 0
+		}
+	})
+}
+
+func (b *Backoffer) backoff(cfg *Config, err error, sleep func() int) error {
 	if strings.Contains(err.Error(), tikverr.MismatchClusterID) {
 		logutil.BgLogger().Fatal("critical error", zap.Error(err))
 	}
@@ -151,16 +186,14 @@ func (b *Backoffer) BackoffWithCfgAndMaxSleep(cfg *Config, maxSleepMs int, err e
 		return b.configs[0].err
 	}
 
-	// Lazy initialize.
-	if b.fn == nil {
-		b.fn = make(map[string]backoffFn)
-	}
-	f, ok := b.fn[cfg.name]
-	if !ok {
-		f = cfg.createBackoffFn(b.vars)
-		b.fn[cfg.name] = f
+	realSleep := sleep()
+	if extra := globalRetryLimiter.extraSleep(cfg); extra > 0 {
+		select {
+		case <-time.After(extra):
+		case <-b.ctx.Done():
+		}
+		realSleep += int(extra / time.Millisecond)
 	}
-	realSleep := f(b.ctx, maxSleepMs)
 	if cfg.metric != nil {
 		(*cfg.metric).Observe(float64(realSleep) / 1000)
 	}
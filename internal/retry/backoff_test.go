@@ -36,6 +36,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -47,3 +48,36 @@ func TestBackoffWithMax(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 30, b.totalSleep)
 }
+
+func TestBackoffWithRetryAfter(t *testing.T) {
+	b := NewBackofferWithVars(context.TODO(), 20, nil)
+	err := b.BackoffWithRetryAfter(BoTiKVServerBusy, 10, errors.New("test"))
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, b.totalSleep, 10)
+	assert.LessOrEqual(t, b.totalSleep, 11)
+
+	// The server hint is clamped to the remaining budget.
+	start := time.Now()
+	err = b.BackoffWithRetryAfter(BoTiKVServerBusy, 60000, errors.New("test"))
+	assert.Nil(t, err)
+	assert.Equal(t, b.maxSleep, b.totalSleep)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	// The budget is used up.
+	assert.NotNil(t, b.BackoffWithRetryAfter(BoTiKVServerBusy, 10, errors.New("test")))
+}
+
+func TestGlobalRetryLimiter(t *testing.T) {
+	l := newGlobalRetryLimiter(5)
+	assert.Equal(t, time.Duration(0), l.extraSleep(BoRegionMiss))
+
+	l.SetMaxRetryRPS(BoRegionMiss, 4)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, time.Duration(0), l.extraSleep(BoRegionMiss))
+	}
+	// 5 % 4 + 1 = 2 refill intervals of 250ms.
+	assert.Equal(t, 500*time.Millisecond, l.extraSleep(BoRegionMiss))
+	assert.Equal(t, time.Duration(0), l.extraSleep(BoTxnLock))
+
+	l.SetMaxRetryRPS(BoRegionMiss, 0)
+	assert.Equal(t, time.Duration(0), l.extraSleep(BoRegionMiss))
+}
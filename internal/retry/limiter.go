@@ -0,0 +1,107 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tikv/client-go/v2/metrics"
+)
+
+// GlobalRetryLimiter limits the rate of the backoffs of each type of all
+// Backoffers in the process by token buckets. A backoff exceeding the limit
+// still retries, but sleeps longer by a jitter decided by the client ID, so the
+// retries of the clients hitting the same failure are spread over the refill
+// time of the bucket instead of arriving at the same time.
+type GlobalRetryLimiter struct {
+	clientID uint64
+
+	mu      sync.Mutex
+	buckets map[string]*retryBucket
+}
+
+type retryBucket struct {
+	maxRetryRPS float64
+	size        float64
+	tokens      float64
+	last        time.Time
+}
+
+var globalRetryLimiter = newGlobalRetryLimiter(newClientID())
+
+// newClientID returns a random ID of the process. The global math/rand is not
+// seeded before Go 1.20, so it would give every process the same ID.
+func newClientID() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err == nil {
+		return binary.LittleEndian.Uint64(b[:])
+	}
+	return uint64(os.Getpid())<<32 ^ uint64(time.Now().UnixNano())
+}
+
+func newGlobalRetryLimiter(clientID uint64) *GlobalRetryLimiter {
+	return &GlobalRetryLimiter{clientID: clientID, buckets: make(map[string]*retryBucket)}
+}
+
+// GetGlobalRetryLimiter returns the GlobalRetryLimiter shared by all
+// Backoffers.
+func GetGlobalRetryLimiter() *GlobalRetryLimiter {
+	return globalRetryLimiter
+}
+
+// SetMaxRetryRPS limits the backoffs of the type to maxRetryRPS per second,
+// with a bucket size of one second's worth of retries. Zero removes the limit,
+// which is the default.
+func (l *GlobalRetryLimiter) SetMaxRetryRPS(cfg *Config, maxRetryRPS float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if maxRetryRPS <= 0 {
+		delete(l.buckets, cfg.name)
+		return
+	}
+	size := math.Max(1, math.Ceil(maxRetryRPS))
+	l.buckets[cfg.name] = &retryBucket{
+		maxRetryRPS: maxRetryRPS,
+		size:        size,
+		tokens:      size,
+		last:        time.Now(),
+	}
+}
+
+// extraSleep takes a token for a backoff of the type. If there is no token, it
+// returns the extra sleep time, which is (clientID % size + 1) refill intervals
+// of a token.
+func (l *GlobalRetryLimiter) extraSleep(cfg *Config) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[cfg.name]
+	if !ok {
+		return 0
+	}
+	now := time.Now()
+	b.tokens = math.Min(b.size, b.tokens+now.Sub(b.last).Seconds()*b.maxRetryRPS)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	metrics.TiKVRetryLimiterThrottleCounter.WithLabelValues(cfg.name).Inc()
+	slot := l.clientID%uint64(b.size) + 1
+	return time.Duration(float64(slot) * float64(time.Second) / b.maxRetryRPS)
+}
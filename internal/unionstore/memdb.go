@@ -39,6 +39,7 @@ import (
 	"sync"
 	"unsafe"
 
+	"github.com/pingcap/errors"
 	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/kv"
 )
@@ -291,6 +292,36 @@ func (db *MemDB) Dirty() bool {
 	return db.dirty
 }
 
+// Clone returns a deep copy of the MemDB with the same keys, values and flags.
+// The staging buffers are not copied, so it must be called when there is no
+// staging buffer.
+func (db *MemDB) Clone() (*MemDB, error) {
+	db.RLock()
+	defer db.RUnlock()
+	if len(db.stages) > 0 {
+		return nil, errors.New("cannot clone memdb with staging buffers")
+	}
+	if db.vlogInvalid {
+		return nil, errors.New("cannot clone memdb with discarded values")
+	}
+
+	var err error
+	clone := newMemDB()
+	clone.entrySizeLimit = db.entrySizeLimit
+	clone.bufferSizeLimit = db.bufferSizeLimit
+	clone.dirty = db.dirty
+	it := db.IterWithFlags(nil, nil)
+	for ; it.Valid(); err = it.Next() {
+		x := clone.traverse(it.Key(), true)
+		x.setKeyFlags(it.Flags())
+		if it.HasValue() {
+			clone.setValue(x, it.Value())
+		}
+	}
+	_ = err // memdbIterator will never fail
+	return clone, nil
+}
+
 func (db *MemDB) set(key []byte, value []byte, ops ...kv.FlagsOp) error {
 	if db.vlogInvalid {
 		// panic for easier debugging.
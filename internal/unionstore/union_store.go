@@ -88,6 +88,18 @@ func NewUnionStore(snapshot uSnapshot) *KVUnionStore {
 	}
 }
 
+// Clone builds a new unionStore on snapshot with a deep copy of the MemBuffer.
+func (us *KVUnionStore) Clone(snapshot uSnapshot) (*KVUnionStore, error) {
+	memBuffer, err := us.memBuffer.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return &KVUnionStore{
+		snapshot:  snapshot,
+		memBuffer: memBuffer,
+	}, nil
+}
+
 // GetMemBuffer return the MemBuffer binding to this unionStore.
 func (us *KVUnionStore) GetMemBuffer() *MemDB {
 	return us.memBuffer
@@ -0,0 +1,147 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// FastHistogram buffers the observations of a Prometheus histogram in shards
+// and flushes them to the histogram periodically. An observation only adds to
+// the bucket of a shard by atomic operations, and the shards are picked per P,
+// so the concurrent observations don't contend on the same counters as they do
+// on the histogram.
+//
+// There is no long-running goroutine. The first observation after
+// flushInterval starts a goroutine to flush the buffered observations, so they
+// are exposed with a delay of at least flushInterval, and the observation
+// doesn't wait for the flush.
+type FastHistogram struct {
+	h             prometheus.Histogram
+	upperBounds   []float64
+	shards        []fastHistogramShard
+	flushInterval int64
+	lastFlush     int64
+
+	// shardIdx hands out the shard indexes, its items are kept per P.
+	shardIdx  sync.Pool
+	nextShard uint32
+	flushMu   sync.Mutex
+}
+
+type fastHistogramShard struct {
+	// counts and sums are the number and the sum of the observations of each
+	// bucket, the last one is the +Inf bucket. The sums are float64 bits.
+	counts []uint64
+	sums   []uint64
+	// Keep the shards written by different Ps apart.
+	_ [64]byte
+}
+
+// NewFastHistogram creates a FastHistogram that buffers the observations of h
+// in shards and flushes them every flushInterval.
+func NewFastHistogram(h prometheus.Histogram, shards int, flushInterval time.Duration) *FastHistogram {
+	if shards <= 0 {
+		shards = 1
+	}
+	var m dto.Metric
+	var upperBounds []float64
+	if err := h.Write(&m); err == nil {
+		for _, b := range m.GetHistogram().GetBucket() {
+			if !math.IsInf(b.GetUpperBound(), +1) {
+				upperBounds = append(upperBounds, b.GetUpperBound())
+			}
+		}
+	}
+	fh := &FastHistogram{
+		h:             h,
+		upperBounds:   upperBounds,
+		shards:        make([]fastHistogramShard, shards),
+		flushInterval: int64(flushInterval),
+		lastFlush:     time.Now().UnixNano(),
+	}
+	for i := range fh.shards {
+		fh.shards[i].counts = make([]uint64, len(upperBounds)+1)
+		fh.shards[i].sums = make([]uint64, len(upperBounds)+1)
+	}
+	fh.shardIdx.New = func() interface{} {
+		idx := int(atomic.AddUint32(&fh.nextShard, 1)-1) % len(fh.shards)
+		return &idx
+	}
+	return fh
+}
+
+// Observe implements prometheus.Observer.
+func (fh *FastHistogram) Observe(v float64) {
+	i := sort.SearchFloat64s(fh.upperBounds, v)
+	idx := fh.shardIdx.Get().(*int)
+	shard := &fh.shards[*idx]
+	fh.shardIdx.Put(idx)
+	atomic.AddUint64(&shard.counts[i], 1)
+	for {
+		old := atomic.LoadUint64(&shard.sums[i])
+		if atomic.CompareAndSwapUint64(&shard.sums[i], old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&fh.lastFlush)
+	if now-last >= fh.flushInterval && atomic.CompareAndSwapInt64(&fh.lastFlush, last, now) {
+		go fh.Flush()
+	}
+}
+
+// Flush flushes the buffered observations to the histogram. The observations
+// of a bucket are flushed as their mean, so the buckets, the count and the
+// sum of the histogram are kept.
+func (fh *FastHistogram) Flush() {
+	fh.flushMu.Lock()
+	defer fh.flushMu.Unlock()
+	for i := 0; i <= len(fh.upperBounds); i++ {
+		var count uint64
+		var sum float64
+		for j := range fh.shards {
+			shard := &fh.shards[j]
+			count += atomic.SwapUint64(&shard.counts[i], 0)
+			sum += math.Float64frombits(atomic.SwapUint64(&shard.sums[i], 0))
+		}
+		if count == 0 {
+			continue
+		}
+		mean := fh.clamp(i, sum/float64(count))
+		for ; count > 0; count-- {
+			fh.h.Observe(mean)
+		}
+	}
+}
+
+// clamp keeps v in the i-th bucket. The count and the sum of a bucket are not
+// swapped atomically together, so the mean may be off a little.
+func (fh *FastHistogram) clamp(i int, v float64) float64 {
+	if i < len(fh.upperBounds) && v > fh.upperBounds[i] {
+		v = fh.upperBounds[i]
+	}
+	if i > 0 && v <= fh.upperBounds[i-1] {
+		v = math.Nextafter(fh.upperBounds[i-1], math.Inf(+1))
+	}
+	return v
+}
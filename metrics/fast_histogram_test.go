@@ -0,0 +1,96 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func readHistogram(t *testing.T, h prometheus.Histogram) *dto.Histogram {
+	var m dto.Metric
+	require.Nil(t, h.Write(&m))
+	return m.GetHistogram()
+}
+
+func TestFastHistogram(t *testing.T) {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test", Buckets: []float64{1, 10}})
+	fh := NewFastHistogram(h, 4, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				fh.Observe(0.5)
+				fh.Observe(5)
+				fh.Observe(100)
+			}
+		}()
+	}
+	wg.Wait()
+	// Nothing is flushed before the interval.
+	require.Equal(t, uint64(0), readHistogram(t, h).GetSampleCount())
+
+	fh.Flush()
+	m := readHistogram(t, h)
+	require.Equal(t, uint64(2400), m.GetSampleCount())
+	require.InDelta(t, 800*(0.5+5+100), m.GetSampleSum(), 1e-6)
+	require.Equal(t, uint64(800), m.GetBucket()[0].GetCumulativeCount())
+	require.Equal(t, uint64(1600), m.GetBucket()[1].GetCumulativeCount())
+
+	// The observations after the interval flush the buffered ones.
+	fh = NewFastHistogram(h, 4, 0)
+	fh.Observe(1)
+	require.Eventually(t, func() bool { return readHistogram(t, h).GetSampleCount() == 2401 }, time.Second, time.Millisecond)
+	m = readHistogram(t, h)
+	require.Equal(t, uint64(801), m.GetBucket()[0].GetCumulativeCount())
+}
+
+func TestFastHistogramClamp(t *testing.T) {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test", Buckets: []float64{1, 10}})
+	fh := NewFastHistogram(h, 1, time.Hour)
+	require.Equal(t, 1.0, fh.clamp(0, 2))
+	require.Equal(t, 10.0, fh.clamp(1, 11))
+	require.Greater(t, fh.clamp(1, 1), 1.0)
+	require.Greater(t, fh.clamp(2, 5), 10.0)
+	require.Equal(t, 5.0, fh.clamp(1, 5))
+}
+
+func BenchmarkHistogramObserve(b *testing.B) {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test", Buckets: prometheus.ExponentialBuckets(1, 2, 20)})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.Observe(100)
+		}
+	})
+}
+
+func BenchmarkFastHistogramObserve(b *testing.B) {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test", Buckets: prometheus.ExponentialBuckets(1, 2, 20)})
+	fh := NewFastHistogram(h, runtime.GOMAXPROCS(0), time.Second)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			fh.Observe(100)
+		}
+	})
+	fh.Flush()
+}
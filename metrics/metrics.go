@@ -54,6 +54,7 @@ var (
 	TiKVSecondaryLockCleanupFailureCounter *prometheus.CounterVec
 	TiKVRegionCacheCounter                 *prometheus.CounterVec
 	TiKVLocalLatchWaitTimeHistogram        prometheus.Histogram
+	TiKVIntentCoalesceWaitHistogram        prometheus.Histogram
 	TiKVStatusDuration                     *prometheus.HistogramVec
 	TiKVStatusCounter                      *prometheus.CounterVec
 	TiKVBatchWaitDuration                  prometheus.Histogram
@@ -85,6 +86,24 @@ var (
 	TiKVTxnCommitBackoffSeconds            prometheus.Histogram
 	TiKVTxnCommitBackoffCount              prometheus.Histogram
 	TiKVSmallReadDuration                  prometheus.Histogram
+	TiKVCDCEventsReceivedTotal             *prometheus.CounterVec
+	TiKVCDCEventLag                        prometheus.Histogram
+	TiKVWriteStallThrottleTotal            prometheus.Counter
+	TiKVWriteStallDuration                 prometheus.Histogram
+	TiKVWriteAmplificationRatio            prometheus.Histogram
+	TiKVRetryLimiterThrottleCounter        *prometheus.CounterVec
+	TiKVTxnWriteRatio                      prometheus.Histogram
+	TiKVTxnAutoReadOnlyCounter             prometheus.Counter
+	TiKVRegionCacheL1HitRatio              prometheus.Gauge
+	TiKVStoreTopologyChangeTotal           *prometheus.CounterVec
+	TiKVRegionCacheEvictionCounter         prometheus.Counter
+	TiKVSnapshotKeepAliveCounter           *prometheus.CounterVec
+	TiKVGRPCConnectionsActive              *prometheus.GaugeVec
+	TiKVGRPCConnectionCreateTotal          *prometheus.CounterVec
+	TiKVGRPCConnectionReuseTotal           *prometheus.CounterVec
+	TiKVStoreInfoCacheMissCounter          prometheus.Counter
+	TiKVSecondaryCommitWaveRetryCounter    prometheus.Counter
+	TiKVOnePCConfirmAsyncCounter           prometheus.Counter
 )
 
 // Label constants.
@@ -103,6 +122,9 @@ const (
 	LblAddress         = "address"
 	LblFromStore       = "from_store"
 	LblToStore         = "to_store"
+	LblEventType       = "event_type"
+	LblStoreAddr       = "store_addr"
+	LblState           = "state"
 )
 
 func initMetrics(namespace, subsystem string) {
@@ -236,6 +258,15 @@ func initMetrics(namespace, subsystem string) {
 			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 20), // 0.5ms ~ 262s
 		})
 
+	TiKVIntentCoalesceWaitHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "intent_coalesce_wait_seconds",
+			Help:      "Wait time of a txn for the in-flight txns writing the same keys.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 20), // 0.5ms ~ 262s
+		})
+
 	TiKVStatusDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
@@ -499,6 +530,154 @@ func initMetrics(namespace, subsystem string) {
 			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 28), // 0.5ms ~ 74h
 		})
 
+	TiKVCDCEventsReceivedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "cdc_events_received_total",
+			Help:      "Counter of events emitted by the CDC client.",
+		}, []string{LblType})
+
+	TiKVCDCEventLag = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "cdc_event_lag_seconds",
+			Help:      "Bucketed histogram of the lag between the commit time of a row event and the time it is emitted by the CDC client.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 22), // 1ms ~ 2097s
+		})
+
+	TiKVWriteStallThrottleTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "write_stall_throttle_total",
+			Help:      "Counter of the times the dispatch of transaction writes is throttled by write stalls.",
+		})
+
+	TiKVWriteStallDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "write_stall_duration_seconds",
+			Help:      "Bucketed histogram of the duration the dispatch of transaction writes is throttled by write stalls.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16), // 10ms ~ 327s
+		})
+
+	TiKVWriteAmplificationRatio = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "write_amplification_ratio",
+			Help:      "Bucketed histogram of the ratio of the prewrite RPC bytes to the mutation bytes of transactions.",
+			Buckets:   prometheus.ExponentialBuckets(1, 1.25, 16), // 1 ~ 28.4
+		})
+
+	TiKVRetryLimiterThrottleCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "retry_limiter_throttle_total",
+			Help:      "Counter of the backoffs delayed by the global retry limiter.",
+		}, []string{LblType})
+
+	TiKVTxnWriteRatio = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "txn_write_ratio",
+			Help:      "Bucketed histogram of the ratio of the write operations to all the operations of committed transactions.",
+			Buckets:   prometheus.LinearBuckets(0, 0.1, 11), // 0 ~ 1
+		})
+
+	TiKVTxnAutoReadOnlyCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "txn_auto_read_only_total",
+			Help:      "Counter of the transactions committed as read-only by auto isolation.",
+		})
+
+	TiKVRegionCacheL1HitRatio = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "region_cache_l1_hit_ratio",
+			Help:      "Ratio of the region lookups by key hitting the hot region cache.",
+		})
+
+	TiKVStoreTopologyChangeTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "store_topology_change_total",
+			Help:      "Counter of the stores added, removed or updated in the region cache by the store topology watcher.",
+		}, []string{LblEventType})
+
+	TiKVRegionCacheEvictionCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "region_cache_eviction_total",
+			Help:      "Counter of the regions evicted from the region cache because it exceeds max-region-cache-size.",
+		})
+
+	TiKVSnapshotKeepAliveCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "snapshot_keepalive_total",
+			Help:      "Counter of the service GC safe point updates that keep the snapshots from being GCed.",
+		}, []string{LblResult})
+
+	TiKVGRPCConnectionsActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "grpc_connections",
+			Help:      "Number of the gRPC connections to the stores, which are active or idle.",
+		}, []string{LblStoreAddr, LblState})
+
+	TiKVGRPCConnectionCreateTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "grpc_connection_create_total",
+			Help:      "Counter of the gRPC connections dialed to the stores.",
+		}, []string{LblStoreAddr})
+
+	TiKVGRPCConnectionReuseTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "grpc_connection_reuse_total",
+			Help:      "Counter of the requests sent through the existing gRPC connections to the stores.",
+		}, []string{LblStoreAddr})
+
+	TiKVStoreInfoCacheMissCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "store_info_cache_miss_total",
+			Help:      "Counter of the store metas not found in the store info cache and loaded from PD.",
+		})
+
+	TiKVSecondaryCommitWaveRetryCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "secondary_commit_wave_retry_total",
+			Help:      "Counter of the slow secondary commit batches sent again in the next wave.",
+		})
+
+	TiKVOnePCConfirmAsyncCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "one_pc_confirm_async_total",
+			Help:      "Counter of the 1PC transactions returned before the prewrite responses and confirmed in background.",
+		})
+
 	initShortcuts()
 }
 
@@ -529,6 +708,7 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TiKVSecondaryLockCleanupFailureCounter)
 	prometheus.MustRegister(TiKVRegionCacheCounter)
 	prometheus.MustRegister(TiKVLocalLatchWaitTimeHistogram)
+	prometheus.MustRegister(TiKVIntentCoalesceWaitHistogram)
 	prometheus.MustRegister(TiKVStatusDuration)
 	prometheus.MustRegister(TiKVStatusCounter)
 	prometheus.MustRegister(TiKVBatchWaitDuration)
@@ -560,6 +740,24 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TiKVTxnCommitBackoffSeconds)
 	prometheus.MustRegister(TiKVTxnCommitBackoffCount)
 	prometheus.MustRegister(TiKVSmallReadDuration)
+	prometheus.MustRegister(TiKVCDCEventsReceivedTotal)
+	prometheus.MustRegister(TiKVCDCEventLag)
+	prometheus.MustRegister(TiKVWriteStallThrottleTotal)
+	prometheus.MustRegister(TiKVWriteStallDuration)
+	prometheus.MustRegister(TiKVWriteAmplificationRatio)
+	prometheus.MustRegister(TiKVRetryLimiterThrottleCounter)
+	prometheus.MustRegister(TiKVTxnWriteRatio)
+	prometheus.MustRegister(TiKVTxnAutoReadOnlyCounter)
+	prometheus.MustRegister(TiKVRegionCacheL1HitRatio)
+	prometheus.MustRegister(TiKVStoreTopologyChangeTotal)
+	prometheus.MustRegister(TiKVRegionCacheEvictionCounter)
+	prometheus.MustRegister(TiKVSnapshotKeepAliveCounter)
+	prometheus.MustRegister(TiKVGRPCConnectionsActive)
+	prometheus.MustRegister(TiKVGRPCConnectionCreateTotal)
+	prometheus.MustRegister(TiKVGRPCConnectionReuseTotal)
+	prometheus.MustRegister(TiKVStoreInfoCacheMissCounter)
+	prometheus.MustRegister(TiKVSecondaryCommitWaveRetryCounter)
+	prometheus.MustRegister(TiKVOnePCConfirmAsyncCounter)
 }
 
 // readCounter reads the value of a prometheus.Counter.
@@ -0,0 +1,431 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mockserver
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/tikvpb"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// MVCCVersion is a committed version of a key. Op is one of Op_Put, Op_Del and
+// Op_Rollback.
+type MVCCVersion struct {
+	StartTS  uint64
+	CommitTS uint64
+	Op       kvrpcpb.Op
+	Value    []byte
+}
+
+type mvccLock struct {
+	primary []byte
+	startTS uint64
+	ttl     uint64
+	op      kvrpcpb.Op
+	value   []byte
+}
+
+// MockTiKVServer is an in-memory TiKV gRPC server for testing the client
+// without TiKV or PD. It supports KvPrewrite, KvCommit, KvBatchRollback,
+// KvGet, KvScan and KvBatchGet, by unary calls or in BatchCommands, on a single
+// region covering all keys. The region in the request context is not checked.
+// The other RPCs fail with codes.Unimplemented.
+//
+// The versions of a key are kept in map[string][]MVCCVersion with the newest
+// version first.
+type MockTiKVServer struct {
+	unimplementedTikvServer
+
+	mu       sync.Mutex
+	versions map[string][]MVCCVersion
+	locks    map[string]*mvccLock
+	errs     map[string]error
+
+	grpcServer *grpc.Server
+}
+
+// NewMockTiKVServer creates a MockTiKVServer with no data.
+func NewMockTiKVServer() *MockTiKVServer {
+	return &MockTiKVServer{
+		versions: make(map[string][]MVCCVersion),
+		locks:    make(map[string]*mvccLock),
+		errs:     make(map[string]error),
+	}
+}
+
+// InjectError makes the RPC named rpc, such as "KvPrewrite", fail with err. A
+// nil err removes the injected error. In BatchCommands, the injected error
+// fails the stream.
+func (s *MockTiKVServer) InjectError(rpc string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.errs, rpc)
+		return
+	}
+	s.errs[rpc] = err
+}
+
+// Start serves the gRPC requests on addr, such as "127.0.0.1:0", and returns
+// the address listened.
+func (s *MockTiKVServer) Start(addr string) (string, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	s.grpcServer = grpc.NewServer()
+	tikvpb.RegisterTikvServer(s.grpcServer, s)
+	go func() {
+		if err := s.grpcServer.Serve(lis); err != nil {
+			logutil.BgLogger().Warn("mock tikv server stopped", zap.Error(err))
+		}
+	}()
+	return lis.Addr().String(), nil
+}
+
+// Stop stops serving the gRPC requests.
+func (s *MockTiKVServer) Stop() {
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+}
+
+func (s *MockTiKVServer) injectedError(rpc string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.errs[rpc]
+}
+
+// KvPrewrite implements tikvpb.TikvServer.
+func (s *MockTiKVServer) KvPrewrite(ctx context.Context, req *kvrpcpb.PrewriteRequest) (*kvrpcpb.PrewriteResponse, error) {
+	if err := s.injectedError("KvPrewrite"); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keyErrs []*kvrpcpb.KeyError
+	for _, m := range req.GetMutations() {
+		if keyErr := s.checkPrewrite(m.GetKey(), req); keyErr != nil {
+			keyErrs = append(keyErrs, keyErr)
+		}
+	}
+	if len(keyErrs) > 0 {
+		return &kvrpcpb.PrewriteResponse{Errors: keyErrs}, nil
+	}
+	for _, m := range req.GetMutations() {
+		op := m.GetOp()
+		if op == kvrpcpb.Op_Insert {
+			op = kvrpcpb.Op_Put
+		}
+		s.locks[string(m.GetKey())] = &mvccLock{
+			primary: req.GetPrimaryLock(),
+			startTS: req.GetStartVersion(),
+			ttl:     req.GetLockTtl(),
+			op:      op,
+			value:   m.GetValue(),
+		}
+	}
+	return &kvrpcpb.PrewriteResponse{}, nil
+}
+
+func (s *MockTiKVServer) checkPrewrite(key []byte, req *kvrpcpb.PrewriteRequest) *kvrpcpb.KeyError {
+	if lock, ok := s.locks[string(key)]; ok && lock.startTS != req.GetStartVersion() {
+		return &kvrpcpb.KeyError{Locked: lock.info(key)}
+	}
+	for _, v := range s.versions[string(key)] {
+		// The rollbacks of other transactions don't conflict.
+		if v.Op == kvrpcpb.Op_Rollback && v.StartTS != req.GetStartVersion() {
+			continue
+		}
+		if v.CommitTS >= req.GetStartVersion() {
+			return &kvrpcpb.KeyError{Conflict: &kvrpcpb.WriteConflict{
+				StartTs:          req.GetStartVersion(),
+				ConflictTs:       v.StartTS,
+				ConflictCommitTs: v.CommitTS,
+				Key:              key,
+				Primary:          req.GetPrimaryLock(),
+			}}
+		}
+		break
+	}
+	return nil
+}
+
+// KvCommit implements tikvpb.TikvServer.
+func (s *MockTiKVServer) KvCommit(ctx context.Context, req *kvrpcpb.CommitRequest) (*kvrpcpb.CommitResponse, error) {
+	if err := s.injectedError("KvCommit"); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range req.GetKeys() {
+		lock, ok := s.locks[string(key)]
+		if ok && lock.startTS == req.GetStartVersion() {
+			continue
+		}
+		// The key may be committed already.
+		if v, ok := s.findVersion(key, req.GetStartVersion()); ok && v.Op != kvrpcpb.Op_Rollback {
+			continue
+		}
+		return &kvrpcpb.CommitResponse{Error: &kvrpcpb.KeyError{
+			TxnNotFound: &kvrpcpb.TxnNotFound{StartTs: req.GetStartVersion(), PrimaryKey: key},
+		}}, nil
+	}
+	for _, key := range req.GetKeys() {
+		lock, ok := s.locks[string(key)]
+		if !ok || lock.startTS != req.GetStartVersion() {
+			continue
+		}
+		delete(s.locks, string(key))
+		if lock.op == kvrpcpb.Op_Lock {
+			continue
+		}
+		s.addVersion(key, MVCCVersion{
+			StartTS:  lock.startTS,
+			CommitTS: req.GetCommitVersion(),
+			Op:       lock.op,
+			Value:    lock.value,
+		})
+	}
+	return &kvrpcpb.CommitResponse{CommitVersion: req.GetCommitVersion()}, nil
+}
+
+// KvBatchRollback implements tikvpb.TikvServer.
+func (s *MockTiKVServer) KvBatchRollback(ctx context.Context, req *kvrpcpb.BatchRollbackRequest) (*kvrpcpb.BatchRollbackResponse, error) {
+	if err := s.injectedError("KvBatchRollback"); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range req.GetKeys() {
+		if v, ok := s.findVersion(key, req.GetStartVersion()); ok {
+			if v.Op == kvrpcpb.Op_Rollback {
+				continue
+			}
+			return &kvrpcpb.BatchRollbackResponse{Error: &kvrpcpb.KeyError{
+				Abort: "already committed",
+			}}, nil
+		}
+	}
+	for _, key := range req.GetKeys() {
+		if lock, ok := s.locks[string(key)]; ok && lock.startTS == req.GetStartVersion() {
+			delete(s.locks, string(key))
+		}
+		if _, ok := s.findVersion(key, req.GetStartVersion()); !ok {
+			s.addVersion(key, MVCCVersion{
+				StartTS:  req.GetStartVersion(),
+				CommitTS: req.GetStartVersion(),
+				Op:       kvrpcpb.Op_Rollback,
+			})
+		}
+	}
+	return &kvrpcpb.BatchRollbackResponse{}, nil
+}
+
+// KvGet implements tikvpb.TikvServer.
+func (s *MockTiKVServer) KvGet(ctx context.Context, req *kvrpcpb.GetRequest) (*kvrpcpb.GetResponse, error) {
+	if err := s.injectedError("KvGet"); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, keyErr := s.get(req.GetKey(), req.GetVersion())
+	if keyErr != nil {
+		return &kvrpcpb.GetResponse{Error: keyErr}, nil
+	}
+	return &kvrpcpb.GetResponse{Value: value, NotFound: value == nil}, nil
+}
+
+// KvBatchGet implements tikvpb.TikvServer.
+func (s *MockTiKVServer) KvBatchGet(ctx context.Context, req *kvrpcpb.BatchGetRequest) (*kvrpcpb.BatchGetResponse, error) {
+	if err := s.injectedError("KvBatchGet"); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pairs []*kvrpcpb.KvPair
+	for _, key := range req.GetKeys() {
+		value, keyErr := s.get(key, req.GetVersion())
+		if keyErr != nil {
+			pairs = append(pairs, &kvrpcpb.KvPair{Key: key, Error: keyErr})
+		} else if value != nil {
+			pairs = append(pairs, &kvrpcpb.KvPair{Key: key, Value: value})
+		}
+	}
+	return &kvrpcpb.BatchGetResponse{Pairs: pairs}, nil
+}
+
+// KvScan implements tikvpb.TikvServer.
+func (s *MockTiKVServer) KvScan(ctx context.Context, req *kvrpcpb.ScanRequest) (*kvrpcpb.ScanResponse, error) {
+	if err := s.injectedError("KvScan"); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// In reverse scan, StartKey is the exclusive upper bound and EndKey is the
+	// inclusive lower bound.
+	lower, upper := req.GetStartKey(), req.GetEndKey()
+	if req.GetReverse() {
+		lower, upper = upper, lower
+	}
+	var keys []string
+	for key := range s.keySet() {
+		if bytes.Compare([]byte(key), lower) < 0 || (len(upper) > 0 && bytes.Compare([]byte(key), upper) >= 0) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if req.GetReverse() {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	var pairs []*kvrpcpb.KvPair
+	for _, key := range keys {
+		if req.GetLimit() > 0 && uint32(len(pairs)) >= req.GetLimit() {
+			break
+		}
+		value, keyErr := s.get([]byte(key), req.GetVersion())
+		if keyErr != nil {
+			pairs = append(pairs, &kvrpcpb.KvPair{Key: []byte(key), Error: keyErr})
+		} else if value != nil {
+			if req.GetKeyOnly() {
+				value = nil
+			}
+			pairs = append(pairs, &kvrpcpb.KvPair{Key: []byte(key), Value: value})
+		}
+	}
+	return &kvrpcpb.ScanResponse{Pairs: pairs}, nil
+}
+
+// BatchCommands implements tikvpb.TikvServer.
+func (s *MockTiKVServer) BatchCommands(stream tikvpb.Tikv_BatchCommandsServer) error {
+	ctx := stream.Context()
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		resps := make([]*tikvpb.BatchCommandsResponse_Response, 0, len(req.GetRequests()))
+		for _, r := range req.GetRequests() {
+			resp, err := s.handleBatchRequest(ctx, r)
+			if err != nil {
+				return err
+			}
+			resps = append(resps, resp)
+		}
+		err = stream.Send(&tikvpb.BatchCommandsResponse{Responses: resps, RequestIds: req.GetRequestIds()})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *MockTiKVServer) handleBatchRequest(ctx context.Context, r *tikvpb.BatchCommandsRequest_Request) (*tikvpb.BatchCommandsResponse_Response, error) {
+	switch req := r.GetCmd().(type) {
+	case *tikvpb.BatchCommandsRequest_Request_Get:
+		resp, err := s.KvGet(ctx, req.Get)
+		return &tikvpb.BatchCommandsResponse_Response{Cmd: &tikvpb.BatchCommandsResponse_Response_Get{Get: resp}}, err
+	case *tikvpb.BatchCommandsRequest_Request_BatchGet:
+		resp, err := s.KvBatchGet(ctx, req.BatchGet)
+		return &tikvpb.BatchCommandsResponse_Response{Cmd: &tikvpb.BatchCommandsResponse_Response_BatchGet{BatchGet: resp}}, err
+	case *tikvpb.BatchCommandsRequest_Request_Scan:
+		resp, err := s.KvScan(ctx, req.Scan)
+		return &tikvpb.BatchCommandsResponse_Response{Cmd: &tikvpb.BatchCommandsResponse_Response_Scan{Scan: resp}}, err
+	case *tikvpb.BatchCommandsRequest_Request_Prewrite:
+		resp, err := s.KvPrewrite(ctx, req.Prewrite)
+		return &tikvpb.BatchCommandsResponse_Response{Cmd: &tikvpb.BatchCommandsResponse_Response_Prewrite{Prewrite: resp}}, err
+	case *tikvpb.BatchCommandsRequest_Request_Commit:
+		resp, err := s.KvCommit(ctx, req.Commit)
+		return &tikvpb.BatchCommandsResponse_Response{Cmd: &tikvpb.BatchCommandsResponse_Response_Commit{Commit: resp}}, err
+	case *tikvpb.BatchCommandsRequest_Request_BatchRollback:
+		resp, err := s.KvBatchRollback(ctx, req.BatchRollback)
+		return &tikvpb.BatchCommandsResponse_Response{Cmd: &tikvpb.BatchCommandsResponse_Response_BatchRollback{BatchRollback: resp}}, err
+	case *tikvpb.BatchCommandsRequest_Request_Empty:
+		return &tikvpb.BatchCommandsResponse_Response{Cmd: &tikvpb.BatchCommandsResponse_Response_Empty{
+			Empty: &tikvpb.BatchCommandsEmptyResponse{TestId: req.Empty.GetTestId()},
+		}}, nil
+	default:
+		return nil, errors.Errorf("unsupported batch command %T", req)
+	}
+}
+
+// get returns the value of key at version, or nil if it doesn't exist.
+func (s *MockTiKVServer) get(key []byte, version uint64) ([]byte, *kvrpcpb.KeyError) {
+	if lock, ok := s.locks[string(key)]; ok && lock.startTS <= version && lock.op != kvrpcpb.Op_Lock {
+		return nil, &kvrpcpb.KeyError{Locked: lock.info(key)}
+	}
+	for _, v := range s.versions[string(key)] {
+		if v.CommitTS > version || v.Op == kvrpcpb.Op_Rollback {
+			continue
+		}
+		if v.Op == kvrpcpb.Op_Put {
+			return v.Value, nil
+		}
+		return nil, nil
+	}
+	return nil, nil
+}
+
+// findVersion returns the version of key written by the transaction of startTS.
+func (s *MockTiKVServer) findVersion(key []byte, startTS uint64) (MVCCVersion, bool) {
+	for _, v := range s.versions[string(key)] {
+		if v.StartTS == startTS {
+			return v, true
+		}
+	}
+	return MVCCVersion{}, false
+}
+
+// addVersion adds v to the versions of key, which are sorted by the commit ts
+// in descending order.
+func (s *MockTiKVServer) addVersion(key []byte, v MVCCVersion) {
+	versions := s.versions[string(key)]
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].CommitTS < v.CommitTS })
+	versions = append(versions, MVCCVersion{})
+	copy(versions[i+1:], versions[i:])
+	versions[i] = v
+	s.versions[string(key)] = versions
+}
+
+func (s *MockTiKVServer) keySet() map[string]struct{} {
+	keys := make(map[string]struct{}, len(s.versions)+len(s.locks))
+	for key := range s.versions {
+		keys[key] = struct{}{}
+	}
+	for key := range s.locks {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+func (l *mvccLock) info(key []byte) *kvrpcpb.LockInfo {
+	return &kvrpcpb.LockInfo{
+		PrimaryLock: l.primary,
+		LockVersion: l.startTS,
+		Key:         key,
+		LockTtl:     l.ttl,
+		LockType:    l.op,
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mockserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/internal/client"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+func prewriteReq(startTS uint64, kvs ...string) *kvrpcpb.PrewriteRequest {
+	req := &kvrpcpb.PrewriteRequest{PrimaryLock: []byte(kvs[0]), StartVersion: startTS, LockTtl: 3000}
+	for i := 0; i < len(kvs); i += 2 {
+		op := kvrpcpb.Op_Put
+		if kvs[i+1] == "" {
+			op = kvrpcpb.Op_Del
+		}
+		req.Mutations = append(req.Mutations, &kvrpcpb.Mutation{Op: op, Key: []byte(kvs[i]), Value: []byte(kvs[i+1])})
+	}
+	return req
+}
+
+func TestMVCC(t *testing.T) {
+	s := NewMockTiKVServer()
+	ctx := context.Background()
+	get := func(key string, version uint64) *kvrpcpb.GetResponse {
+		resp, err := s.KvGet(ctx, &kvrpcpb.GetRequest{Key: []byte(key), Version: version})
+		require.Nil(t, err)
+		return resp
+	}
+
+	prewrite, err := s.KvPrewrite(ctx, prewriteReq(10, "a", "1", "b", "2", "c", "3"))
+	require.Nil(t, err)
+	require.Empty(t, prewrite.GetErrors())
+	require.NotNil(t, get("a", 20).GetError().GetLocked())
+	// The lock is invisible to the earlier reads.
+	require.True(t, get("a", 5).GetNotFound())
+
+	commit, err := s.KvCommit(ctx, &kvrpcpb.CommitRequest{StartVersion: 10, Keys: [][]byte{[]byte("a"), []byte("b"), []byte("c")}, CommitVersion: 11})
+	require.Nil(t, err)
+	require.Nil(t, commit.GetError())
+	require.Equal(t, []byte("1"), get("a", 20).GetValue())
+	require.True(t, get("a", 10).GetNotFound())
+
+	// Write conflict.
+	prewrite, err = s.KvPrewrite(ctx, prewriteReq(9, "a", "x"))
+	require.Nil(t, err)
+	require.NotNil(t, prewrite.GetErrors()[0].GetConflict())
+
+	// Delete and roll back.
+	_, err = s.KvPrewrite(ctx, prewriteReq(20, "b", ""))
+	require.Nil(t, err)
+	_, err = s.KvCommit(ctx, &kvrpcpb.CommitRequest{StartVersion: 20, Keys: [][]byte{[]byte("b")}, CommitVersion: 21})
+	require.Nil(t, err)
+	require.True(t, get("b", 30).GetNotFound())
+	_, err = s.KvPrewrite(ctx, prewriteReq(30, "c", "4"))
+	require.Nil(t, err)
+	rollback, err := s.KvBatchRollback(ctx, &kvrpcpb.BatchRollbackRequest{StartVersion: 30, Keys: [][]byte{[]byte("c")}})
+	require.Nil(t, err)
+	require.Nil(t, rollback.GetError())
+	require.Equal(t, []byte("3"), get("c", 40).GetValue())
+	// The rolled back transaction can't be committed.
+	commit, err = s.KvCommit(ctx, &kvrpcpb.CommitRequest{StartVersion: 30, Keys: [][]byte{[]byte("c")}, CommitVersion: 31})
+	require.Nil(t, err)
+	require.NotNil(t, commit.GetError().GetTxnNotFound())
+
+	batchGet, err := s.KvBatchGet(ctx, &kvrpcpb.BatchGetRequest{Keys: [][]byte{[]byte("a"), []byte("b"), []byte("c")}, Version: 40})
+	require.Nil(t, err)
+	require.Len(t, batchGet.GetPairs(), 2)
+
+	scan, err := s.KvScan(ctx, &kvrpcpb.ScanRequest{StartKey: []byte("a"), Version: 40, Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, scan.GetPairs(), 2)
+	require.Equal(t, []byte("a"), scan.GetPairs()[0].GetKey())
+	require.Equal(t, []byte("c"), scan.GetPairs()[1].GetKey())
+	scan, err = s.KvScan(ctx, &kvrpcpb.ScanRequest{StartKey: []byte("z"), Version: 40, Limit: 1, Reverse: true})
+	require.Nil(t, err)
+	require.Len(t, scan.GetPairs(), 1)
+	require.Equal(t, []byte("c"), scan.GetPairs()[0].GetKey())
+}
+
+func TestServeAndInjectError(t *testing.T) {
+	s := NewMockTiKVServer()
+	addr, err := s.Start("127.0.0.1:0")
+	require.Nil(t, err)
+	defer s.Stop()
+
+	for _, maxBatchSize := range []uint{0, 128} {
+		restore := config.UpdateGlobal(func(conf *config.Config) {
+			conf.TiKVClient.MaxBatchSize = maxBatchSize
+		})
+		rpcClient := client.NewRPCClient(config.Security{})
+		send := func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+			return rpcClient.SendRequest(context.Background(), addr, req, 10*time.Second)
+		}
+		startTS := uint64(maxBatchSize) + 1
+
+		resp, err := send(tikvrpc.NewRequest(tikvrpc.CmdPrewrite, prewriteReq(startTS, "k", "v")))
+		require.Nil(t, err)
+		require.Empty(t, resp.Resp.(*kvrpcpb.PrewriteResponse).GetErrors())
+		_, err = send(tikvrpc.NewRequest(tikvrpc.CmdCommit, &kvrpcpb.CommitRequest{StartVersion: startTS, Keys: [][]byte{[]byte("k")}, CommitVersion: startTS + 1}))
+		require.Nil(t, err)
+		resp, err = send(tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k"), Version: startTS + 2}))
+		require.Nil(t, err)
+		require.Equal(t, []byte("v"), resp.Resp.(*kvrpcpb.GetResponse).GetValue())
+
+		s.InjectError("KvGet", errors.New("injected"))
+		_, err = send(tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k"), Version: startTS + 2}))
+		require.NotNil(t, err)
+		s.InjectError("KvGet", nil)
+
+		if maxBatchSize == 0 {
+			_, err = send(tikvrpc.NewRequest(tikvrpc.CmdRawGet, &kvrpcpb.RawGetRequest{Key: []byte("k")}))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "method RawGet not implemented")
+		}
+
+		require.Nil(t, rpcClient.Close())
+		restore()
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mockserver
+
+import (
+	"context"
+
+	"github.com/pingcap/kvproto/pkg/coprocessor"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/mpp"
+	"github.com/pingcap/kvproto/pkg/tikvpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unimplementedTikvServer fails every RPC with codes.Unimplemented. The
+// generated tikvpb package has no UnimplementedTikvServer, so MockTiKVServer
+// embeds this one.
+type unimplementedTikvServer struct{}
+
+var _ tikvpb.TikvServer = unimplementedTikvServer{}
+
+func (unimplementedTikvServer) KvGet(context.Context, *kvrpcpb.GetRequest) (*kvrpcpb.GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvGet not implemented")
+}
+
+func (unimplementedTikvServer) KvScan(context.Context, *kvrpcpb.ScanRequest) (*kvrpcpb.ScanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvScan not implemented")
+}
+
+func (unimplementedTikvServer) KvPrewrite(context.Context, *kvrpcpb.PrewriteRequest) (*kvrpcpb.PrewriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvPrewrite not implemented")
+}
+
+func (unimplementedTikvServer) KvPessimisticLock(context.Context, *kvrpcpb.PessimisticLockRequest) (*kvrpcpb.PessimisticLockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvPessimisticLock not implemented")
+}
+
+func (unimplementedTikvServer) KVPessimisticRollback(context.Context, *kvrpcpb.PessimisticRollbackRequest) (*kvrpcpb.PessimisticRollbackResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KVPessimisticRollback not implemented")
+}
+
+func (unimplementedTikvServer) KvTxnHeartBeat(context.Context, *kvrpcpb.TxnHeartBeatRequest) (*kvrpcpb.TxnHeartBeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvTxnHeartBeat not implemented")
+}
+
+func (unimplementedTikvServer) KvCheckTxnStatus(context.Context, *kvrpcpb.CheckTxnStatusRequest) (*kvrpcpb.CheckTxnStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvCheckTxnStatus not implemented")
+}
+
+func (unimplementedTikvServer) KvCheckSecondaryLocks(context.Context, *kvrpcpb.CheckSecondaryLocksRequest) (*kvrpcpb.CheckSecondaryLocksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvCheckSecondaryLocks not implemented")
+}
+
+func (unimplementedTikvServer) KvCommit(context.Context, *kvrpcpb.CommitRequest) (*kvrpcpb.CommitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvCommit not implemented")
+}
+
+func (unimplementedTikvServer) KvImport(context.Context, *kvrpcpb.ImportRequest) (*kvrpcpb.ImportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvImport not implemented")
+}
+
+func (unimplementedTikvServer) KvCleanup(context.Context, *kvrpcpb.CleanupRequest) (*kvrpcpb.CleanupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvCleanup not implemented")
+}
+
+func (unimplementedTikvServer) KvBatchGet(context.Context, *kvrpcpb.BatchGetRequest) (*kvrpcpb.BatchGetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvBatchGet not implemented")
+}
+
+func (unimplementedTikvServer) KvBatchRollback(context.Context, *kvrpcpb.BatchRollbackRequest) (*kvrpcpb.BatchRollbackResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvBatchRollback not implemented")
+}
+
+func (unimplementedTikvServer) KvScanLock(context.Context, *kvrpcpb.ScanLockRequest) (*kvrpcpb.ScanLockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvScanLock not implemented")
+}
+
+func (unimplementedTikvServer) KvResolveLock(context.Context, *kvrpcpb.ResolveLockRequest) (*kvrpcpb.ResolveLockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvResolveLock not implemented")
+}
+
+func (unimplementedTikvServer) KvGC(context.Context, *kvrpcpb.GCRequest) (*kvrpcpb.GCResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvGC not implemented")
+}
+
+func (unimplementedTikvServer) KvDeleteRange(context.Context, *kvrpcpb.DeleteRangeRequest) (*kvrpcpb.DeleteRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KvDeleteRange not implemented")
+}
+
+func (unimplementedTikvServer) RawGet(context.Context, *kvrpcpb.RawGetRequest) (*kvrpcpb.RawGetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawGet not implemented")
+}
+
+func (unimplementedTikvServer) RawBatchGet(context.Context, *kvrpcpb.RawBatchGetRequest) (*kvrpcpb.RawBatchGetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawBatchGet not implemented")
+}
+
+func (unimplementedTikvServer) RawPut(context.Context, *kvrpcpb.RawPutRequest) (*kvrpcpb.RawPutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawPut not implemented")
+}
+
+func (unimplementedTikvServer) RawBatchPut(context.Context, *kvrpcpb.RawBatchPutRequest) (*kvrpcpb.RawBatchPutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawBatchPut not implemented")
+}
+
+func (unimplementedTikvServer) RawDelete(context.Context, *kvrpcpb.RawDeleteRequest) (*kvrpcpb.RawDeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawDelete not implemented")
+}
+
+func (unimplementedTikvServer) RawBatchDelete(context.Context, *kvrpcpb.RawBatchDeleteRequest) (*kvrpcpb.RawBatchDeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawBatchDelete not implemented")
+}
+
+func (unimplementedTikvServer) RawScan(context.Context, *kvrpcpb.RawScanRequest) (*kvrpcpb.RawScanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawScan not implemented")
+}
+
+func (unimplementedTikvServer) RawDeleteRange(context.Context, *kvrpcpb.RawDeleteRangeRequest) (*kvrpcpb.RawDeleteRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawDeleteRange not implemented")
+}
+
+func (unimplementedTikvServer) RawBatchScan(context.Context, *kvrpcpb.RawBatchScanRequest) (*kvrpcpb.RawBatchScanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawBatchScan not implemented")
+}
+
+func (unimplementedTikvServer) RawGetKeyTTL(context.Context, *kvrpcpb.RawGetKeyTTLRequest) (*kvrpcpb.RawGetKeyTTLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawGetKeyTTL not implemented")
+}
+
+func (unimplementedTikvServer) RawCompareAndSwap(context.Context, *kvrpcpb.RawCASRequest) (*kvrpcpb.RawCASResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawCompareAndSwap not implemented")
+}
+
+func (unimplementedTikvServer) UnsafeDestroyRange(context.Context, *kvrpcpb.UnsafeDestroyRangeRequest) (*kvrpcpb.UnsafeDestroyRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnsafeDestroyRange not implemented")
+}
+
+func (unimplementedTikvServer) RegisterLockObserver(context.Context, *kvrpcpb.RegisterLockObserverRequest) (*kvrpcpb.RegisterLockObserverResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterLockObserver not implemented")
+}
+
+func (unimplementedTikvServer) CheckLockObserver(context.Context, *kvrpcpb.CheckLockObserverRequest) (*kvrpcpb.CheckLockObserverResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckLockObserver not implemented")
+}
+
+func (unimplementedTikvServer) RemoveLockObserver(context.Context, *kvrpcpb.RemoveLockObserverRequest) (*kvrpcpb.RemoveLockObserverResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveLockObserver not implemented")
+}
+
+func (unimplementedTikvServer) PhysicalScanLock(context.Context, *kvrpcpb.PhysicalScanLockRequest) (*kvrpcpb.PhysicalScanLockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PhysicalScanLock not implemented")
+}
+
+func (unimplementedTikvServer) Coprocessor(context.Context, *coprocessor.Request) (*coprocessor.Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Coprocessor not implemented")
+}
+
+func (unimplementedTikvServer) CoprocessorStream(*coprocessor.Request, tikvpb.Tikv_CoprocessorStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method CoprocessorStream not implemented")
+}
+
+func (unimplementedTikvServer) BatchCoprocessor(*coprocessor.BatchRequest, tikvpb.Tikv_BatchCoprocessorServer) error {
+	return status.Errorf(codes.Unimplemented, "method BatchCoprocessor not implemented")
+}
+
+func (unimplementedTikvServer) RawCoprocessor(context.Context, *kvrpcpb.RawCoprocessorRequest) (*kvrpcpb.RawCoprocessorResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RawCoprocessor not implemented")
+}
+
+func (unimplementedTikvServer) Raft(tikvpb.Tikv_RaftServer) error {
+	return status.Errorf(codes.Unimplemented, "method Raft not implemented")
+}
+
+func (unimplementedTikvServer) BatchRaft(tikvpb.Tikv_BatchRaftServer) error {
+	return status.Errorf(codes.Unimplemented, "method BatchRaft not implemented")
+}
+
+func (unimplementedTikvServer) Snapshot(tikvpb.Tikv_SnapshotServer) error {
+	return status.Errorf(codes.Unimplemented, "method Snapshot not implemented")
+}
+
+func (unimplementedTikvServer) SplitRegion(context.Context, *kvrpcpb.SplitRegionRequest) (*kvrpcpb.SplitRegionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SplitRegion not implemented")
+}
+
+func (unimplementedTikvServer) ReadIndex(context.Context, *kvrpcpb.ReadIndexRequest) (*kvrpcpb.ReadIndexResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadIndex not implemented")
+}
+
+func (unimplementedTikvServer) MvccGetByKey(context.Context, *kvrpcpb.MvccGetByKeyRequest) (*kvrpcpb.MvccGetByKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MvccGetByKey not implemented")
+}
+
+func (unimplementedTikvServer) MvccGetByStartTs(context.Context, *kvrpcpb.MvccGetByStartTsRequest) (*kvrpcpb.MvccGetByStartTsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MvccGetByStartTs not implemented")
+}
+
+func (unimplementedTikvServer) BatchCommands(tikvpb.Tikv_BatchCommandsServer) error {
+	return status.Errorf(codes.Unimplemented, "method BatchCommands not implemented")
+}
+
+func (unimplementedTikvServer) DispatchMPPTask(context.Context, *mpp.DispatchTaskRequest) (*mpp.DispatchTaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DispatchMPPTask not implemented")
+}
+
+func (unimplementedTikvServer) CancelMPPTask(context.Context, *mpp.CancelTaskRequest) (*mpp.CancelTaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelMPPTask not implemented")
+}
+
+func (unimplementedTikvServer) EstablishMPPConnection(*mpp.EstablishMPPConnectionRequest, tikvpb.Tikv_EstablishMPPConnectionServer) error {
+	return status.Errorf(codes.Unimplemented, "method EstablishMPPConnection not implemented")
+}
+
+func (unimplementedTikvServer) CheckLeader(context.Context, *kvrpcpb.CheckLeaderRequest) (*kvrpcpb.CheckLeaderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckLeader not implemented")
+}
+
+func (unimplementedTikvServer) GetStoreSafeTS(context.Context, *kvrpcpb.StoreSafeTSRequest) (*kvrpcpb.StoreSafeTSResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStoreSafeTS not implemented")
+}
+
+func (unimplementedTikvServer) GetLockWaitInfo(context.Context, *kvrpcpb.GetLockWaitInfoRequest) (*kvrpcpb.GetLockWaitInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLockWaitInfo not implemented")
+}
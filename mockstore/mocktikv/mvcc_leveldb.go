@@ -1353,6 +1353,7 @@ func (mvcc *MVCCLevelDB) ScanLock(startKey, endKey []byte, maxTS uint64) ([]*kvr
 				PrimaryLock: dec.lock.primary,
 				LockVersion: dec.lock.startTS,
 				Key:         currKey,
+				LockTtl:     dec.lock.ttl,
 			})
 		}
 
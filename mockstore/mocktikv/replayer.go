@@ -0,0 +1,180 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mocktikv
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+const replayTimeout = 5 * time.Second
+
+// TxnLogEntry is an RPC sent by a transaction.
+type TxnLogEntry struct {
+	Type    tikvrpc.CmdType
+	Request proto.Message
+	// Response is the expected response. Nil means the response is not checked.
+	Response proto.Message
+}
+
+// TxnLogReplayer replays the RPCs of transactions against mocktikv and checks
+// the invariants of the commit protocol: the primary key of a transaction must
+// be committed before its secondary keys, and the commit timestamp, for both
+// 2PC and 1PC, must be greater than the start timestamp.
+//
+// Only the commands with keys are supported, the regions of the requests are
+// located by their first keys.
+type TxnLogReplayer struct {
+	cluster *Cluster
+	client  *RPCClient
+	// primaries is the primary key of each transaction, indexed by start ts.
+	primaries map[uint64][]byte
+	// committed is the set of transactions whose primary keys are committed.
+	committed map[uint64]bool
+}
+
+// NewTxnLogReplayer creates a TxnLogReplayer.
+func NewTxnLogReplayer(cluster *Cluster, mvccStore MVCCStore) *TxnLogReplayer {
+	return &TxnLogReplayer{
+		cluster:   cluster,
+		client:    NewRPCClient(cluster, mvccStore, nil),
+		primaries: make(map[uint64][]byte),
+		committed: make(map[uint64]bool),
+	}
+}
+
+// Close closes the replayer.
+func (r *TxnLogReplayer) Close() error {
+	return r.client.Close()
+}
+
+// Replay sends the requests of entries in order. It returns an error if a
+// response doesn't match the expected one or an invariant is broken.
+func (r *TxnLogReplayer) Replay(ctx context.Context, entries []TxnLogEntry) error {
+	for i, entry := range entries {
+		resp, err := r.send(ctx, entry)
+		if err != nil {
+			return errors.Annotatef(err, "entry %d", i)
+		}
+		if entry.Response != nil && !proto.Equal(entry.Response, resp) {
+			return errors.Errorf("entry %d: unexpected %s response, expected: %v, got: %v", i, entry.Type, entry.Response, resp)
+		}
+		if err = r.check(entry, resp); err != nil {
+			return errors.Annotatef(err, "entry %d", i)
+		}
+	}
+	return nil
+}
+
+func (r *TxnLogReplayer) send(ctx context.Context, entry TxnLogEntry) (proto.Message, error) {
+	key, err := requestKey(entry.Request)
+	if err != nil {
+		return nil, err
+	}
+	region, peer := r.cluster.GetRegionByKey(NewMvccKey(key))
+	if region == nil || peer == nil {
+		return nil, errors.Errorf("region not found for key %q", key)
+	}
+	req := tikvrpc.NewRequest(entry.Type, entry.Request)
+	if err = tikvrpc.SetContext(req, region, peer); err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := r.client.SendRequest(ctx, r.cluster.GetStore(peer.GetStoreId()).GetAddress(), req, replayTimeout)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	msg, ok := resp.Resp.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("unexpected %s response %T", entry.Type, resp.Resp)
+	}
+	return msg, nil
+}
+
+func requestKey(req proto.Message) ([]byte, error) {
+	switch r := req.(type) {
+	case *kvrpcpb.PrewriteRequest:
+		if len(r.GetMutations()) > 0 {
+			return r.GetMutations()[0].GetKey(), nil
+		}
+	case *kvrpcpb.PessimisticLockRequest:
+		if len(r.GetMutations()) > 0 {
+			return r.GetMutations()[0].GetKey(), nil
+		}
+	case *kvrpcpb.CommitRequest:
+		if len(r.GetKeys()) > 0 {
+			return r.GetKeys()[0], nil
+		}
+	case *kvrpcpb.BatchRollbackRequest:
+		if len(r.GetKeys()) > 0 {
+			return r.GetKeys()[0], nil
+		}
+	case *kvrpcpb.PessimisticRollbackRequest:
+		if len(r.GetKeys()) > 0 {
+			return r.GetKeys()[0], nil
+		}
+	case *kvrpcpb.CleanupRequest:
+		return r.GetKey(), nil
+	case *kvrpcpb.CheckTxnStatusRequest:
+		return r.GetPrimaryKey(), nil
+	case *kvrpcpb.TxnHeartBeatRequest:
+		return r.GetPrimaryLock(), nil
+	case *kvrpcpb.GetRequest:
+		return r.GetKey(), nil
+	default:
+		return nil, errors.Errorf("unsupported request %T", req)
+	}
+	return nil, errors.Errorf("request %T has no key", req)
+}
+
+func (r *TxnLogReplayer) check(entry TxnLogEntry, resp proto.Message) error {
+	switch req := entry.Request.(type) {
+	case *kvrpcpb.PrewriteRequest:
+		r.primaries[req.GetStartVersion()] = req.GetPrimaryLock()
+		prewriteResp := resp.(*kvrpcpb.PrewriteResponse)
+		if commitTS := prewriteResp.GetOnePcCommitTs(); commitTS != 0 {
+			if commitTS <= req.GetStartVersion() {
+				return errors.Errorf("1PC commit ts %d is not greater than start ts %d", commitTS, req.GetStartVersion())
+			}
+			r.committed[req.GetStartVersion()] = true
+		}
+	case *kvrpcpb.CommitRequest:
+		commitResp := resp.(*kvrpcpb.CommitResponse)
+		if commitResp.GetRegionError() != nil || commitResp.GetError() != nil {
+			return nil
+		}
+		if req.GetCommitVersion() <= req.GetStartVersion() {
+			return errors.Errorf("commit ts %d is not greater than start ts %d", req.GetCommitVersion(), req.GetStartVersion())
+		}
+		primary, ok := r.primaries[req.GetStartVersion()]
+		if !ok {
+			return errors.Errorf("txn %d is committed without prewrite", req.GetStartVersion())
+		}
+		for _, key := range req.GetKeys() {
+			if bytes.Equal(key, primary) {
+				r.committed[req.GetStartVersion()] = true
+				return nil
+			}
+		}
+		if !r.committed[req.GetStartVersion()] {
+			return errors.Errorf("secondary keys of txn %d are committed before the primary key %q", req.GetStartVersion(), primary)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mocktikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+func newReplayer(t *testing.T) (*TxnLogReplayer, func()) {
+	mvccStore := MustNewMVCCStore()
+	cluster := NewCluster(mvccStore)
+	_, _, regionID := BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("b"), []uint64{ids[1]}, ids[1])
+	r := NewTxnLogReplayer(cluster, mvccStore)
+	return r, func() {
+		// Closing the client also closes mvccStore.
+		require.Nil(t, r.Close())
+	}
+}
+
+func prewriteEntry(key string, primary string, startTS uint64) TxnLogEntry {
+	return TxnLogEntry{
+		Type: tikvrpc.CmdPrewrite,
+		Request: &kvrpcpb.PrewriteRequest{
+			Mutations:    putMutations(key, key),
+			PrimaryLock:  []byte(primary),
+			StartVersion: startTS,
+			LockTtl:      3000,
+		},
+		Response: &kvrpcpb.PrewriteResponse{},
+	}
+}
+
+func commitEntry(key string, startTS, commitTS uint64) TxnLogEntry {
+	return TxnLogEntry{
+		Type: tikvrpc.CmdCommit,
+		Request: &kvrpcpb.CommitRequest{
+			Keys:          [][]byte{[]byte(key)},
+			StartVersion:  startTS,
+			CommitVersion: commitTS,
+		},
+		Response: &kvrpcpb.CommitResponse{},
+	}
+}
+
+func TestTxnLogReplayer(t *testing.T) {
+	r, clean := newReplayer(t)
+	defer clean()
+	err := r.Replay(context.Background(), []TxnLogEntry{
+		prewriteEntry("a", "a", 10),
+		prewriteEntry("c", "a", 10),
+		commitEntry("a", 10, 20),
+		commitEntry("c", 10, 20),
+		{
+			Type:     tikvrpc.CmdGet,
+			Request:  &kvrpcpb.GetRequest{Key: []byte("c"), Version: 30},
+			Response: &kvrpcpb.GetResponse{Value: []byte("c")},
+		},
+	})
+	require.Nil(t, err)
+}
+
+func TestTxnLogReplayerSecondaryCommittedFirst(t *testing.T) {
+	r, clean := newReplayer(t)
+	defer clean()
+	err := r.Replay(context.Background(), []TxnLogEntry{
+		prewriteEntry("a", "a", 10),
+		prewriteEntry("c", "a", 10),
+		commitEntry("c", 10, 20),
+	})
+	require.Regexp(t, "committed before the primary key", err.Error())
+}
+
+func TestTxnLogReplayerCommitTSNotGreater(t *testing.T) {
+	r, clean := newReplayer(t)
+	defer clean()
+	err := r.Replay(context.Background(), []TxnLogEntry{
+		prewriteEntry("a", "a", 10),
+		{
+			Type:    tikvrpc.CmdCommit,
+			Request: &kvrpcpb.CommitRequest{Keys: [][]byte{[]byte("a")}, StartVersion: 10, CommitVersion: 5},
+		},
+	})
+	require.Regexp(t, "not greater than start ts", err.Error())
+}
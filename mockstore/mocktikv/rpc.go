@@ -933,6 +933,9 @@ func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.R
 				Name:  "mvcc.num_rows",
 				Value: strconv.Itoa(len(scanResp.Pairs)),
 			}}}
+	// Compaction is a no-op in mock tikv.
+	case tikvrpc.CmdDebugCompact:
+		resp.Resp = &debugpb.CompactResponse{}
 	default:
 		return nil, errors.Errorf("unsupported this request type %v", req.Type)
 	}
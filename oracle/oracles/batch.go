@@ -0,0 +1,174 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oracles
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/oracle"
+	"go.uber.org/zap"
+)
+
+// batchRefreshRatio is the ratio of the consumed timestamps in the current
+// block that triggers pre-fetching the next block.
+const batchRefreshRatio = 0.2
+
+// BatchTimestampAllocator pre-fetches blocks of timestamps from an Oracle and
+// hands them out locally in ascending order. The timestamps of a block are
+// requested concurrently, so the PD client merges them into one TSO RPC.
+//
+// Every timestamp is allocated by the Oracle so it is unique, and the
+// timestamps returned by one allocator are strictly ascending. But a timestamp
+// may be fetched long before it is returned, so it can be smaller than the
+// commitTS of a transaction that has committed before Get is called. Only use
+// it for start timestamps of transactions that tolerate such staleness.
+type BatchTimestampAllocator struct {
+	oracle    oracle.Oracle
+	txnScope  string
+	batchSize int
+
+	mu struct {
+		sync.Mutex
+		// cur is the remaining timestamps in the current block.
+		cur []uint64
+		// next is the pre-fetched block.
+		next []uint64
+		// last is the last returned timestamp.
+		last       uint64
+		refreshing bool
+	}
+	refreshCh chan struct{}
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewBatchTimestampAllocator creates a BatchTimestampAllocator which fetches
+// batchSize timestamps of txnScope from o at a time.
+func NewBatchTimestampAllocator(o oracle.Oracle, txnScope string, batchSize int) *BatchTimestampAllocator {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	a := &BatchTimestampAllocator{
+		oracle:    o,
+		txnScope:  txnScope,
+		batchSize: batchSize,
+		refreshCh: make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+	}
+	a.wg.Add(1)
+	go a.refreshLoop()
+	return a
+}
+
+// GetTimestamp returns the next timestamp of the current block. It fetches a
+// new block synchronously if there is no timestamp left.
+func (a *BatchTimestampAllocator) GetTimestamp(ctx context.Context) (uint64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.mu.cur) == 0 {
+		if err := a.switchBlock(ctx); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	ts := a.mu.cur[0]
+	a.mu.cur = a.mu.cur[1:]
+	a.mu.last = ts
+	if a.mu.next == nil && !a.mu.refreshing && float64(a.batchSize-len(a.mu.cur)) >= float64(a.batchSize)*batchRefreshRatio {
+		a.mu.refreshing = true
+		select {
+		case a.refreshCh <- struct{}{}:
+		default:
+		}
+	}
+	return ts, nil
+}
+
+// switchBlock replaces the drained current block with the pre-fetched one, or
+// a newly fetched one if the pre-fetched block is not ready or invalid.
+func (a *BatchTimestampAllocator) switchBlock(ctx context.Context) error {
+	if next := a.mu.next; next != nil {
+		a.mu.next = nil
+		if next[0] > a.mu.last {
+			a.mu.cur = next
+			return nil
+		}
+		logutil.Logger(ctx).Warn("discard non-monotonic timestamp block",
+			zap.Uint64("lastTS", a.mu.last),
+			zap.Uint64("blockFirstTS", next[0]))
+	}
+	block, err := a.fetchBlock(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if block[0] <= a.mu.last {
+		return errors.Errorf("timestamp %d fetched from oracle is not greater than last timestamp %d", block[0], a.mu.last)
+	}
+	a.mu.cur = block
+	return nil
+}
+
+func (a *BatchTimestampAllocator) fetchBlock(ctx context.Context) ([]uint64, error) {
+	opt := &oracle.Option{TxnScope: a.txnScope}
+	futures := make([]oracle.Future, a.batchSize)
+	for i := range futures {
+		futures[i] = a.oracle.GetTimestampAsync(ctx, opt)
+	}
+	block := make([]uint64, 0, a.batchSize)
+	for _, f := range futures {
+		ts, err := f.Wait()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		block = append(block, ts)
+	}
+	sort.Slice(block, func(i, j int) bool { return block[i] < block[j] })
+	for i := 1; i < len(block); i++ {
+		if block[i] == block[i-1] {
+			return nil, errors.Errorf("duplicated timestamp %d fetched from oracle", block[i])
+		}
+	}
+	return block, nil
+}
+
+func (a *BatchTimestampAllocator) refreshLoop() {
+	defer a.wg.Done()
+	ctx := context.Background()
+	for {
+		select {
+		case <-a.quit:
+			return
+		case <-a.refreshCh:
+		}
+		block, err := a.fetchBlock(ctx)
+		if err != nil {
+			logutil.BgLogger().Warn("pre-fetch timestamp block failed", zap.Error(err))
+		}
+		a.mu.Lock()
+		if err == nil {
+			a.mu.next = block
+		}
+		a.mu.refreshing = false
+		a.mu.Unlock()
+	}
+}
+
+// Close stops the refresh goroutine of the allocator.
+func (a *BatchTimestampAllocator) Close() {
+	close(a.quit)
+	a.wg.Wait()
+}
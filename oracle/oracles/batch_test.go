@@ -0,0 +1,45 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oracles_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/oracle/oracles"
+)
+
+func TestBatchTimestampAllocator(t *testing.T) {
+	o := oracles.NewLocalOracle()
+	defer o.Close()
+	a := oracles.NewBatchTimestampAllocator(o, oracle.GlobalTxnScope, 10)
+	defer a.Close()
+
+	ctx := context.Background()
+	var last uint64
+	// Cross several batch boundaries.
+	for i := 0; i < 100; i++ {
+		ts, err := a.GetTimestamp(ctx)
+		require.Nil(t, err)
+		require.Greater(t, ts, last)
+		last = ts
+	}
+
+	// Timestamps fetched later from the oracle are greater than all handed out.
+	ts, err := o.GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	require.Nil(t, err)
+	require.Greater(t, ts, last)
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oracles
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tikv/client-go/v2/oracle"
+)
+
+var _ oracle.Oracle = &HLCOracle{}
+
+// HLCOracle is an Oracle for tests which issues timestamps by a hybrid logical
+// clock instead of PD. A timestamp is max(wall clock, last timestamp + 1) in
+// the TSO layout, so the timestamps follow the wall clock while staying
+// strictly increasing.
+//
+// The wall clock is time.Now or the clock given to NewHLCOracle, shifted by
+// the duration passed to Advance, which lets tests move the time forward, e.g.
+// to expire locks or advance the GC safe point, without waiting.
+type HLCOracle struct {
+	mu     sync.Mutex
+	clock  func() time.Time
+	offset time.Duration
+	lastTS uint64
+}
+
+// NewHLCOracle creates a HLCOracle reading the wall clock from clock, or from
+// time.Now if clock is nil.
+func NewHLCOracle(clock func() time.Time) *HLCOracle {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &HLCOracle{clock: clock}
+}
+
+// Advance moves the wall clock of the oracle forward by d.
+func (o *HLCOracle) Advance(d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.offset += d
+}
+
+// Observe updates the clock with a timestamp issued elsewhere, so the
+// following timestamps are larger than it.
+func (o *HLCOracle) Observe(ts uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if ts > o.lastTS {
+		o.lastTS = ts
+	}
+}
+
+func (o *HLCOracle) now() time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.clock().Add(o.offset)
+}
+
+// GetTimestamp implements oracle.Oracle interface.
+func (o *HLCOracle) GetTimestamp(ctx context.Context, _ *oracle.Option) (uint64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ts := oracle.GoTimeToTS(o.clock().Add(o.offset))
+	if ts <= o.lastTS {
+		ts = o.lastTS + 1
+	}
+	o.lastTS = ts
+	return ts, nil
+}
+
+type hlcFuture struct {
+	o   *HLCOracle
+	ctx context.Context
+}
+
+func (f *hlcFuture) Wait() (uint64, error) {
+	return f.o.GetTimestamp(f.ctx, &oracle.Option{})
+}
+
+// GetTimestampAsync implements oracle.Oracle interface.
+func (o *HLCOracle) GetTimestampAsync(ctx context.Context, _ *oracle.Option) oracle.Future {
+	return &hlcFuture{o, ctx}
+}
+
+// GetLowResolutionTimestamp implements oracle.Oracle interface.
+func (o *HLCOracle) GetLowResolutionTimestamp(ctx context.Context, opt *oracle.Option) (uint64, error) {
+	return o.GetTimestamp(ctx, opt)
+}
+
+// GetLowResolutionTimestampAsync implements oracle.Oracle interface.
+func (o *HLCOracle) GetLowResolutionTimestampAsync(ctx context.Context, opt *oracle.Option) oracle.Future {
+	return o.GetTimestampAsync(ctx, opt)
+}
+
+// GetStaleTimestamp implements oracle.Oracle interface.
+func (o *HLCOracle) GetStaleTimestamp(ctx context.Context, txnScope string, prevSecond uint64) (uint64, error) {
+	return oracle.GoTimeToTS(o.now().Add(-time.Second * time.Duration(prevSecond))), nil
+}
+
+// IsExpired implements oracle.Oracle interface.
+func (o *HLCOracle) IsExpired(lockTimestamp, TTL uint64, _ *oracle.Option) bool {
+	expire := oracle.GetTimeFromTS(lockTimestamp).Add(time.Duration(TTL) * time.Millisecond)
+	return !o.now().Before(expire)
+}
+
+// UntilExpired implements oracle.Oracle interface.
+func (o *HLCOracle) UntilExpired(lockTimeStamp, TTL uint64, _ *oracle.Option) int64 {
+	return oracle.ExtractPhysical(lockTimeStamp) + int64(TTL) - oracle.GetPhysical(o.now())
+}
+
+// Close implements oracle.Oracle interface.
+func (o *HLCOracle) Close() {
+}
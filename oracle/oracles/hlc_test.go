@@ -0,0 +1,54 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oracles_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/oracle/oracles"
+)
+
+func TestHLCOracle(t *testing.T) {
+	now := time.Now()
+	o := oracles.NewHLCOracle(func() time.Time { return now })
+	defer o.Close()
+	ctx := context.Background()
+
+	// The clock is stopped, the logical part is increased.
+	ts1, err := o.GetTimestamp(ctx, &oracle.Option{})
+	require.Nil(t, err)
+	require.Equal(t, oracle.GoTimeToTS(now), ts1)
+	ts2, err := o.GetTimestamp(ctx, &oracle.Option{})
+	require.Nil(t, err)
+	require.Equal(t, ts1+1, ts2)
+
+	o.Advance(time.Second)
+	ts3, err := o.GetTimestamp(ctx, &oracle.Option{})
+	require.Nil(t, err)
+	require.Equal(t, oracle.GoTimeToTS(now.Add(time.Second)), ts3)
+	require.True(t, o.IsExpired(ts1, 1000, &oracle.Option{}))
+	require.False(t, o.IsExpired(ts1, 1001, &oracle.Option{}))
+	require.Equal(t, int64(500), o.UntilExpired(ts3, 500, &oracle.Option{}))
+
+	// The timestamps are larger than the observed ones even if the clock is
+	// behind.
+	o.Observe(oracle.GoTimeToTS(now.Add(time.Hour)))
+	ts4, err := o.GetTimestamp(ctx, &oracle.Option{})
+	require.Nil(t, err)
+	require.Equal(t, oracle.GoTimeToTS(now.Add(time.Hour))+1, ts4)
+}
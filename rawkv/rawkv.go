@@ -65,6 +65,18 @@ func (c *Client) ClusterID() uint64 {
 	return c.client.ClusterID()
 }
 
+// SetKeyHasher makes the client hash the keys with h before sending them to
+// TiKV, see tikv.RawKVClient.SetKeyHasher.
+func (c *Client) SetKeyHasher(h tikv.KeyHasher) {
+	c.client.SetKeyHasher(h)
+}
+
+// WithKeyEncoder returns a view of the client that encodes the typed keys by
+// enc, see tikv.RawKVClient.WithKeyEncoder.
+func (c *Client) WithKeyEncoder(enc tikv.KeyEncoder) *tikv.KeyEncodedRawKVClient {
+	return c.client.WithKeyEncoder(enc)
+}
+
 // Get queries value with the key. When the key does not exist, it returns `nil, nil`.
 // TODO: use ctx after moving all rawkv code out.
 func (c *Client) Get(ctx context.Context, key []byte) ([]byte, error) {
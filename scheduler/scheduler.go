@@ -0,0 +1,184 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// Pacer paces the dispatch of the writes, Wait blocks until the next write can
+// be dispatched.
+type Pacer interface {
+	Wait(ctx context.Context) error
+}
+
+// SchedulerStats is the number of the writes waiting in a PriorityScheduler
+// by priority.
+type SchedulerStats struct {
+	WaitingHigh   int
+	WaitingNormal int
+	WaitingLow    int
+}
+
+// PriorityScheduler schedules the writes of the transactions by priority.
+// The writes take the dispatch slots of the pacer one by one, the waiting
+// writes of high priority take them before the normal ones and the normal
+// ones before the low ones. The writes of the same priority are scheduled
+// in the arrival order.
+//
+// The pacer doesn't block when TiKV isn't under pressure, so the writes pass
+// through the scheduler without waiting then.
+type PriorityScheduler struct {
+	pacer Pacer
+
+	mu    sync.Mutex
+	queue waiterQueue
+	seq   uint64
+	// busy is set when a waiter holds the turn to wait for the pacer.
+	busy  bool
+	stats SchedulerStats
+}
+
+// NewPriorityScheduler creates a PriorityScheduler with the pacer.
+func NewPriorityScheduler(pacer Pacer) *PriorityScheduler {
+	return &PriorityScheduler{pacer: pacer}
+}
+
+// Stats returns the number of the waiting writes.
+func (s *PriorityScheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Wait blocks until a write of priority pri takes its turn and the pacer lets
+// it be dispatched.
+func (s *PriorityScheduler) Wait(ctx context.Context, pri kvrpcpb.CommandPri) error {
+	w := &waiter{pri: pri, ready: make(chan struct{})}
+	s.mu.Lock()
+	s.seq++
+	w.seq = s.seq
+	heap.Push(&s.queue, w)
+	s.adjustStats(pri, 1)
+	if !s.busy {
+		s.dispatchLocked()
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&s.queue, w.index)
+			s.adjustStats(pri, -1)
+			s.mu.Unlock()
+			return errors.Trace(ctx.Err())
+		}
+		s.mu.Unlock()
+		// The turn is passed to w already, pass it on.
+		s.release()
+		return errors.Trace(ctx.Err())
+	}
+	err := s.pacer.Wait(ctx)
+	s.release()
+	return errors.Trace(err)
+}
+
+func (s *PriorityScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy = false
+	s.dispatchLocked()
+}
+
+// dispatchLocked passes the turn to the first waiter.
+func (s *PriorityScheduler) dispatchLocked() {
+	if s.queue.Len() == 0 {
+		return
+	}
+	w := heap.Pop(&s.queue).(*waiter)
+	s.adjustStats(w.pri, -1)
+	s.busy = true
+	close(w.ready)
+}
+
+func (s *PriorityScheduler) adjustStats(pri kvrpcpb.CommandPri, delta int) {
+	switch pri {
+	case kvrpcpb.CommandPri_High:
+		s.stats.WaitingHigh += delta
+	case kvrpcpb.CommandPri_Low:
+		s.stats.WaitingLow += delta
+	default:
+		s.stats.WaitingNormal += delta
+	}
+}
+
+type waiter struct {
+	pri   kvrpcpb.CommandPri
+	seq   uint64
+	ready chan struct{}
+	// index is the position in the queue, -1 after it's popped.
+	index int
+}
+
+// rank orders the priorities, kvrpcpb.CommandPri_Normal is 0 so the values
+// can't be compared directly.
+func rank(pri kvrpcpb.CommandPri) int {
+	switch pri {
+	case kvrpcpb.CommandPri_High:
+		return 0
+	case kvrpcpb.CommandPri_Low:
+		return 2
+	default:
+		return 1
+	}
+}
+
+type waiterQueue []*waiter
+
+func (q waiterQueue) Len() int { return len(q) }
+
+func (q waiterQueue) Less(i, j int) bool {
+	if ri, rj := rank(q[i].pri), rank(q[j].pri); ri != rj {
+		return ri < rj
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waiterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waiterQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waiterQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+)
+
+// stepPacer lets a write go for every value sent to its channel.
+type stepPacer chan struct{}
+
+func (p stepPacer) Wait(ctx context.Context) error {
+	select {
+	case <-p:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestPriorityScheduler(t *testing.T) {
+	pacer := make(stepPacer)
+	s := NewPriorityScheduler(pacer)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	schedule := func(ctx context.Context, name string, pri kvrpcpb.CommandPri) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Wait(ctx, pri)
+			if ctx.Err() == nil {
+				require.Nil(t, err)
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+			} else {
+				require.NotNil(t, err)
+			}
+		}()
+	}
+	waitStats := func(expected SchedulerStats) {
+		require.Eventually(t, func() bool { return s.Stats() == expected }, time.Second, time.Millisecond)
+	}
+
+	// The first one takes the turn and waits for the pacer.
+	schedule(context.Background(), "first", kvrpcpb.CommandPri_Low)
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.busy
+	}, time.Second, time.Millisecond)
+	schedule(context.Background(), "low", kvrpcpb.CommandPri_Low)
+	waitStats(SchedulerStats{WaitingLow: 1})
+	schedule(context.Background(), "normal", kvrpcpb.CommandPri_Normal)
+	waitStats(SchedulerStats{WaitingLow: 1, WaitingNormal: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	schedule(ctx, "canceled", kvrpcpb.CommandPri_High)
+	waitStats(SchedulerStats{WaitingLow: 1, WaitingNormal: 1, WaitingHigh: 1})
+	cancel()
+	waitStats(SchedulerStats{WaitingLow: 1, WaitingNormal: 1})
+	schedule(context.Background(), "high", kvrpcpb.CommandPri_High)
+	waitStats(SchedulerStats{WaitingLow: 1, WaitingNormal: 1, WaitingHigh: 1})
+
+	for i := 1; i <= 4; i++ {
+		pacer <- struct{}{}
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(order) == i
+		}, time.Second, time.Millisecond)
+	}
+	wg.Wait()
+	require.Equal(t, []string{"first", "high", "normal", "low"}, order)
+	require.Equal(t, SchedulerStats{}, s.Stats())
+}
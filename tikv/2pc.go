@@ -38,6 +38,7 @@ import (
 	"encoding/hex"
 	"math"
 	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -46,6 +47,7 @@ import (
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/parser/terror"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/tikv/client-go/v2/config"
@@ -90,6 +92,8 @@ type twoPhaseCommitter struct {
 	cleanWg             sync.WaitGroup
 	detail              unsafe.Pointer
 	txnSize             int
+	prewriteRPCBytes    int64  // prewriteRPCBytes is the bytes of the prewrite requests, it's updated atomically.
+	regionEpochChanges  uint32 // regionEpochChanges counts the batches relocated by EpochNotMatch, it's updated atomically.
 	hasNoNeedCommitKeys bool
 
 	primaryKey  []byte
@@ -100,7 +104,9 @@ type twoPhaseCommitter struct {
 		undeterminedErr error // undeterminedErr saves the rpc error we encounter when commit primary key.
 		committed       bool
 	}
-	syncLog bool
+	syncLogMode SyncLogMode
+	// rollbackStrategy decides the locks rolled back after failing to commit.
+	rollbackStrategy RollbackStrategy
 	// For pessimistic transaction
 	isPessimistic bool
 	isFirstLock   bool
@@ -123,6 +129,10 @@ type twoPhaseCommitter struct {
 	useOnePC          uint32
 	onePCCommitTS     uint64
 
+	// prewriteCancel cancels the contexts of all the batches of the ongoing
+	// prewrite, it's nil if no prewrite is ongoing.
+	prewriteCancel context.CancelFunc
+
 	hasTriedAsyncCommit bool
 	hasTriedOnePC       bool
 
@@ -185,6 +195,11 @@ func (m *memBufferMutations) Slice(from, to int) CommitterMutations {
 	}
 }
 
+// EstimatedRegionCount returns the approximate number of regions the mutations span.
+func (m *memBufferMutations) EstimatedRegionCount(cache *RegionCache) int {
+	return estimateRegionCount(cache, m)
+}
+
 func (m *memBufferMutations) Push(op kvrpcpb.Op, isPessimisticLock bool, handle unionstore.MemKeyHandle) {
 	aux := uint16(op) << 1
 	if isPessimisticLock {
@@ -295,6 +310,11 @@ func (c *PlainMutations) IsPessimisticLock(i int) bool {
 	return c.isPessimisticLock[i]
 }
 
+// EstimatedRegionCount returns the approximate number of regions the mutations span.
+func (c *PlainMutations) EstimatedRegionCount(cache *RegionCache) int {
+	return estimateRegionCount(cache, c)
+}
+
 // PlainMutation represents a single transaction operation.
 type PlainMutation struct {
 	KeyOp             kvrpcpb.Op
@@ -303,12 +323,37 @@ type PlainMutation struct {
 	IsPessimisticLock bool
 }
 
-// MergeMutations append input mutations into current mutations.
-func (c *PlainMutations) MergeMutations(mutations PlainMutations) {
-	c.ops = append(c.ops, mutations.ops...)
-	c.keys = append(c.keys, mutations.keys...)
-	c.values = append(c.values, mutations.values...)
-	c.isPessimisticLock = append(c.isPessimisticLock, mutations.isPessimisticLock...)
+// MergeMutations merges two mutation sets sorted by key into a new sorted one
+// like merge sort. It returns ErrDuplicateKey if a key appears in both sets.
+func MergeMutations(a, b CommitterMutations) (CommitterMutations, error) {
+	res := NewPlainMutations(a.Len() + b.Len())
+	i, j := 0, 0
+	for i < a.Len() || j < b.Len() {
+		var from CommitterMutations
+		var idx int
+		switch {
+		case j == b.Len():
+			from, idx = a, i
+			i++
+		case i == a.Len():
+			from, idx = b, j
+			j++
+		default:
+			cmp := bytes.Compare(a.GetKey(i), b.GetKey(j))
+			if cmp == 0 {
+				return nil, errors.WithStack(&tikverr.ErrDuplicateKey{Key: a.GetKey(i)})
+			}
+			if cmp < 0 {
+				from, idx = a, i
+				i++
+			} else {
+				from, idx = b, j
+				j++
+			}
+		}
+		res.Push(from.GetOp(idx), from.GetKey(idx), from.GetValue(idx), from.IsPessimisticLock(idx))
+	}
+	return &res, nil
 }
 
 // AppendMutation merges a single Mutation into the current mutations.
@@ -377,7 +422,16 @@ func (c *twoPhaseCommitter) initKeysAndMutations() error {
 		} else {
 			value = it.Value()
 			if len(value) > 0 {
-				isUnnecessaryKV := filter != nil && filter.IsUnnecessaryKeyValue(key, value, flags)
+				var isUnnecessaryKV bool
+				if filter != nil {
+					err = util.SafeCall(func() error {
+						isUnnecessaryKV = filter.IsUnnecessaryKeyValue(key, value, flags)
+						return nil
+					}, errors.Errorf("KVFilter panicked, key: %s", kv.StrKey(key)))
+					if err != nil {
+						return err
+					}
+				}
 				if isUnnecessaryKV {
 					if !flags.HasLocked() {
 						continue
@@ -416,15 +470,24 @@ func (c *twoPhaseCommitter) initKeysAndMutations() error {
 		}
 		c.mutations.Push(op, isPessimistic, it.Handle())
 		size += len(key) + len(value)
-
-		if len(c.primaryKey) == 0 && op != kvrpcpb.Op_CheckNotExists {
-			c.primaryKey = key
-		}
 	}
 
 	if c.mutations.Len() == 0 {
 		return nil
 	}
+	if len(c.primaryKey) == 0 {
+		strategy := txn.primaryKeyStrategy
+		if strategy == nil {
+			strategy = FirstKeyStrategy{}
+		}
+		err = util.SafeCall(func() error {
+			c.primaryKey = strategy.Select(c.mutations)
+			return nil
+		}, errors.New("PrimaryKeyStrategy panicked"))
+		if err != nil {
+			return err
+		}
+	}
 	c.txnSize = size
 
 	const logEntryCount = 10000
@@ -457,7 +520,8 @@ func (c *twoPhaseCommitter) initKeysAndMutations() error {
 	c.hasNoNeedCommitKeys = checkCnt > 0
 	c.lockTTL = txnLockTTL(txn.startTime, size)
 	c.priority = txn.priority.ToPB()
-	c.syncLog = txn.syncLog
+	c.syncLogMode = txn.syncLogMode
+	c.rollbackStrategy = txn.rollbackStrategy
 	c.resourceGroupTag = txn.resourceGroupTag
 	c.setDetail(commitDetail)
 	return nil
@@ -512,6 +576,75 @@ func txnLockTTL(startTime time.Time, txnSize int) uint64 {
 var preSplitDetectThreshold uint32 = 100000
 var preSplitSizeThreshold uint32 = 32 << 20
 
+// regionEstimateSampleStep is the distance between two sampled keys when
+// estimating the number of regions that the mutations span.
+var regionEstimateSampleStep uint32 = 16
+
+// regionEstimateExactKeys is the max number of mutations whose keys are all
+// located when the samples are too coarse to count the regions.
+const regionEstimateExactKeys = 1024
+
+// estimateRegionCount estimates the number of regions the sorted mutations span
+// by locating every N-th key in the region cache, without querying PD.
+// Samples that miss the cache are ignored. If every sample falls into a
+// different region, the sampling is coarser than the regions. Then every key
+// of a small mutation set is located, and the result of a large one is
+// extrapolated.
+func estimateRegionCount(cache *RegionCache, m CommitterMutations) int {
+	n := m.Len()
+	if n == 0 {
+		return 0
+	}
+	step := int(atomic.LoadUint32(&regionEstimateSampleStep))
+	if step <= 0 {
+		step = 1
+	}
+	samples, distinct := sampleRegions(cache, m, step)
+	if distinct == 0 {
+		// Nothing is cached, assume the mutations fit in one region.
+		return 1
+	}
+	if distinct > 1 && distinct == samples && step > 1 {
+		if n <= regionEstimateExactKeys {
+			_, distinct = sampleRegions(cache, m, 1)
+			return distinct
+		}
+		estimated := distinct * step
+		if estimated > n {
+			estimated = n
+		}
+		return estimated
+	}
+	return distinct
+}
+
+// sampleRegions locates every step-th key and the last key of the mutations
+// in the region cache. It returns the number of keys found in the cache and
+// the number of distinct regions among them.
+func sampleRegions(cache *RegionCache, m CommitterMutations, step int) (samples, distinct int) {
+	var lastLoc *KeyLocation
+	sample := func(key []byte) {
+		if lastLoc != nil && lastLoc.Contains(key) {
+			samples++
+			return
+		}
+		if loc := cache.TryLocateKey(key); loc != nil {
+			samples++
+			distinct++
+			lastLoc = loc
+		}
+	}
+	n := m.Len()
+	for i := 0; i < n; i += step {
+		sample(m.GetKey(i))
+	}
+	// Always sample the last key so that the whole range is covered.
+	if (n-1)%step != 0 {
+		sample(m.GetKey(n - 1))
+	}
+	return samples, distinct
+}
+
 // doActionOnMutations groups keys into primary batch and secondary batches, if primary batch exists in the key,
 // it does action on primary batch first, then on secondary batches. If action is commit, secondary batches
 // is done in background goroutine.
@@ -537,33 +670,26 @@ type groupedMutations struct {
 }
 
 // groupSortedMutationsByRegion separates keys into groups by their belonging Regions.
+// The end of each group is found by binary search, so it takes O(r*log(n)) key
+// comparisons for n mutations in r regions.
 func groupSortedMutationsByRegion(c *RegionCache, bo *retry.Backoffer, m CommitterMutations) ([]groupedMutations, error) {
-	var (
-		groups  []groupedMutations
-		lastLoc *KeyLocation
-	)
-	lastUpperBound := 0
-	for i := 0; i < m.Len(); i++ {
-		if lastLoc == nil || !lastLoc.Contains(m.GetKey(i)) {
-			if lastLoc != nil {
-				groups = append(groups, groupedMutations{
-					region:    lastLoc.Region,
-					mutations: m.Slice(lastUpperBound, i),
-				})
-				lastUpperBound = i
-			}
-			var err error
-			lastLoc, err = c.LocateKey(bo, m.GetKey(i))
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
+	var groups []groupedMutations
+	for i := 0; i < m.Len(); {
+		loc, err := c.LocateKey(bo, m.GetKey(i))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		end := m.Len()
+		if len(loc.EndKey) > 0 {
+			end = i + sort.Search(m.Len()-i, func(j int) bool {
+				return bytes.Compare(m.GetKey(i+j), loc.EndKey) >= 0
+			})
 		}
-	}
-	if lastLoc != nil {
 		groups = append(groups, groupedMutations{
-			region:    lastLoc.Region,
-			mutations: m.Slice(lastUpperBound, m.Len()),
+			region:    loc.Region,
+			mutations: m.Slice(i, end),
 		})
+		i = end
 	}
 	return groups, nil
 }
@@ -623,11 +749,18 @@ func (c *twoPhaseCommitter) doActionOnGroupMutations(bo *Backoffer, action twoPh
 		}
 	}
 
+	batchSize := txnCommitBatchSize
+	if _, ok := action.(actionCleanup); ok && c.rollbackStrategy == RollbackCoalesced {
+		batchSize = rollbackCoalescedBatchSize
+	}
 	batchBuilder := newBatched(c.primary())
 	for _, group := range groups {
-		batchBuilder.appendBatchMutationsBySize(group.region, group.mutations, sizeFunc, txnCommitBatchSize)
+		batchBuilder.appendBatchMutationsBySize(group.region, group.mutations, sizeFunc, batchSize)
 	}
 	firstIsPrimary := batchBuilder.setPrimary()
+	if _, ok := action.(actionPrewrite); ok && len(c.txn.leaderHints) > 0 {
+		batchBuilder.setLeaderHints(c.txn.leaderHints)
+	}
 
 	actionCommit, actionIsCommit := action.(actionCommit)
 	_, actionIsCleanup := action.(actionCleanup)
@@ -693,7 +826,12 @@ func (c *twoPhaseCommitter) doActionOnGroupMutations(bo *Backoffer, action twoPh
 				}
 			}
 
-			e := c.doActionOnBatches(secondaryBo, action, batchBuilder.allBatches())
+			var e error
+			if batcher := c.store.secondaryBatcher; batcher != nil {
+				e = batcher.commit(c, secondaryBo, batchBuilder.allBatches())
+			} else {
+				e = c.doActionOnBatches(secondaryBo, action, batchBuilder.allBatches())
+			}
 			if e != nil {
 				logutil.BgLogger().Debug("2PC async doActionOnBatches",
 					zap.Uint64("session", c.sessionID),
@@ -721,7 +859,14 @@ func (c *twoPhaseCommitter) doActionOnBatches(bo *Backoffer, action twoPhaseComm
 		}
 	}
 	if noNeedFork {
-		for _, b := range batches {
+		_, collectAll := action.(actionCommit)
+		var batchErrs []tikverr.BatchError
+		epochChanges := atomic.LoadUint32(&c.regionEpochChanges)
+		for i := 0; i < len(batches); i++ {
+			if i > 0 {
+				batches = c.mergePendingBatches(batches, i, &epochChanges)
+			}
+			b := batches[i]
 			e := action.handleSingleBatch(c, bo, b)
 			if e != nil {
 				logutil.BgLogger().Debug("2PC doActionOnBatches failed",
@@ -729,9 +874,15 @@ func (c *twoPhaseCommitter) doActionOnBatches(bo *Backoffer, action twoPhaseComm
 					zap.Stringer("action type", action),
 					zap.Error(e),
 					zap.Uint64("txnStartTS", c.startTS))
-				return errors.Trace(e)
+				if !collectAll || len(batches) == 1 {
+					return errors.Trace(e)
+				}
+				batchErrs = append(batchErrs, tikverr.BatchError{RegionID: b.region.GetID(), Err: e})
 			}
 		}
+		if len(batchErrs) > 0 {
+			return errors.Trace(&tikverr.MultiError{Errors: batchErrs})
+		}
 		return nil
 	}
 	rateLim := len(batches)
@@ -742,7 +893,17 @@ func (c *twoPhaseCommitter) doActionOnBatches(bo *Backoffer, action twoPhaseComm
 	if rateLim > config.GetGlobalConfig().CommitterConcurrency {
 		rateLim = config.GetGlobalConfig().CommitterConcurrency
 	}
+	pipelined := false
+	if depth := c.txn.prewritePipelineDepth; depth > 0 {
+		if _, ok := action.(actionPrewrite); ok {
+			pipelined = true
+			if rateLim > depth {
+				rateLim = depth
+			}
+		}
+	}
 	batchExecutor := newBatchExecutor(rateLim, c, action, bo)
+	batchExecutor.pipelined = pipelined
 	err := batchExecutor.process(batches)
 	return errors.Trace(err)
 }
@@ -839,7 +1000,7 @@ func (tm *ttlManager) keepAlive(c *twoPhaseCommitter) {
 			logutil.Logger(bo.GetCtx()).Info("send TxnHeartBeat",
 				zap.Uint64("startTS", c.startTS), zap.Uint64("newTTL", newTTL))
 			startTime := time.Now()
-			_, stopHeartBeat, err := sendTxnHeartBeat(bo, c.store, c.primary(), c.startTS, newTTL)
+			_, stopHeartBeat, err := sendTxnHeartBeat(bo, c.store, c.primary(), c.startTS, newTTL, c.txn.requestMetadata)
 			if err != nil {
 				keepFail++
 				metrics.TxnHeartBeatHistogramError.Observe(time.Since(startTime).Seconds())
@@ -861,12 +1022,13 @@ func (tm *ttlManager) keepAlive(c *twoPhaseCommitter) {
 	}
 }
 
-func sendTxnHeartBeat(bo *Backoffer, store *KVStore, primary []byte, startTS, ttl uint64) (newTTL uint64, stopHeartBeat bool, err error) {
+func sendTxnHeartBeat(bo *Backoffer, store *KVStore, primary []byte, startTS, ttl uint64, md map[string]string) (newTTL uint64, stopHeartBeat bool, err error) {
 	req := tikvrpc.NewRequest(tikvrpc.CmdTxnHeartBeat, &kvrpcpb.TxnHeartBeatRequest{
 		PrimaryLock:   primary,
 		StartVersion:  startTS,
 		AdviseLockTtl: ttl,
 	})
+	req.Metadata = md
 	for {
 		loc, err := store.GetRegionCache().LocateKey(bo, primary)
 		if err != nil {
@@ -910,6 +1072,10 @@ func (c *twoPhaseCommitter) checkAsyncCommit() bool {
 		return false
 	}
 
+	if caps := c.store.getCapabilities(); caps != nil && !caps.AsyncCommit {
+		return false
+	}
+
 	asyncCommitCfg := config.GetGlobalConfig().TiKVClient.AsyncCommit
 	// TODO the keys limit need more tests, this value makes the unit test pass by now.
 	// Async commit is not compatible with Binlog because of the non unique timestamp issue.
@@ -935,7 +1101,25 @@ func (c *twoPhaseCommitter) checkOnePC() bool {
 		return false
 	}
 
-	return c.sessionID > 0 && !c.shouldWriteBinlog() && c.txn.enable1PC
+	if c.sessionID == 0 || c.shouldWriteBinlog() || !c.txn.enable1PC {
+		return false
+	}
+	if caps := c.store.getCapabilities(); caps != nil && !caps.OnePC {
+		return false
+	}
+	// 1PC is only possible if all mutations are in the same region. Avoid
+	// trying it if the region cache already tells us it isn't the case.
+	return c.mutations.EstimatedRegionCount(c.store.regionCache) <= 1
+}
+
+// predictCommitMode returns the fastest commit protocol predicted by the
+// PrewriteModePredictor of the store, or OnePCMode if there is none.
+func (c *twoPhaseCommitter) predictCommitMode() CommitMode {
+	predictor := c.store.prewriteModePredictor
+	if predictor == nil {
+		return OnePCMode
+	}
+	return predictor.Predict(c.txnSize, c.mutations.EstimatedRegionCount(c.store.regionCache))
 }
 
 func (c *twoPhaseCommitter) needLinearizability() bool {
@@ -997,7 +1181,7 @@ func (c *twoPhaseCommitter) cleanup(ctx context.Context) {
 		cleanupKeysCtx := context.WithValue(c.storeCtx, retry.TxnStartKey, ctx.Value(retry.TxnStartKey))
 		var err error
 		if !c.isOnePC() {
-			err = c.cleanupMutations(retry.NewBackofferWithVars(cleanupKeysCtx, cleanupMaxBackoff, c.txn.vars), c.mutations)
+			err = c.cleanupMutations(retry.NewBackofferWithVars(cleanupKeysCtx, cleanupMaxBackoff, c.txn.vars), c.rollbackMutations())
 		} else if c.isPessimistic {
 			err = c.pessimisticRollbackMutations(retry.NewBackofferWithVars(cleanupKeysCtx, cleanupMaxBackoff, c.txn.vars), c.mutations)
 		}
@@ -1068,14 +1252,15 @@ func (c *twoPhaseCommitter) execute(ctx context.Context) (err error) {
 	}()
 
 	commitTSMayBeCalculated := false
+	mode := c.predictCommitMode()
 	// Check async commit is available or not.
-	if c.checkAsyncCommit() {
+	if mode <= AsyncCommitMode && c.checkAsyncCommit() {
 		commitTSMayBeCalculated = true
 		c.setAsyncCommit(true)
 		c.hasTriedAsyncCommit = true
 	}
 	// Check if 1PC is enabled.
-	if c.checkOnePC() {
+	if mode == OnePCMode && c.checkOnePC() {
 		commitTSMayBeCalculated = true
 		c.setOnePC(true)
 		c.hasTriedOnePC = true
@@ -1107,6 +1292,11 @@ func (c *twoPhaseCommitter) execute(ctx context.Context) (err error) {
 	}
 	// Calculate maxCommitTS if necessary
 	if commitTSMayBeCalculated {
+		if margin := c.txn.maxCommitTSMargin; margin > 0 {
+			if err = c.setMaxCommitTSFromOracle(ctx, margin); err != nil {
+				return errors.Trace(err)
+			}
+		}
 		if err = c.calculateMaxCommitTS(ctx); err != nil {
 			return errors.Trace(err)
 		}
@@ -1140,6 +1330,9 @@ func (c *twoPhaseCommitter) execute(ctx context.Context) (err error) {
 
 	commitDetail := c.getDetail()
 	commitDetail.PrewriteTime = time.Since(start)
+	if err == nil {
+		c.recordWriteAmplification(ctx)
+	}
 	if bo.GetTotalSleep() > 0 {
 		boSleep := int64(bo.GetTotalSleep()) * int64(time.Millisecond)
 		commitDetail.Mu.Lock()
@@ -1299,6 +1492,11 @@ func (c *twoPhaseCommitter) execute(ctx context.Context) (err error) {
 }
 
 func (c *twoPhaseCommitter) commitTxn(ctx context.Context, commitDetail *util.CommitDetails) error {
+	if c.txn.wal != nil {
+		if err := c.txn.wal.journalCommit(c.startTS, c.commitTS); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	c.txn.GetMemBuffer().DiscardValues()
 	start := time.Now()
 
@@ -1314,11 +1512,22 @@ func (c *twoPhaseCommitter) commitTxn(ctx context.Context, commitDetail *util.Co
 	}
 	if err != nil {
 		if undeterminedErr := c.getUndeterminedErr(); undeterminedErr != nil {
-			logutil.Logger(ctx).Error("2PC commit result undetermined",
-				zap.Error(err),
-				zap.NamedError("rpcErr", undeterminedErr),
-				zap.Uint64("txnStartTS", c.startTS))
-			err = errors.Trace(terror.ErrResultUndetermined)
+			checkBo := retry.NewBackofferWithVars(ctx, cleanupMaxBackoff, c.txn.vars)
+			committed, status, checkErr := c.checkCommitted(checkBo)
+			// The result is determined if the primary key is committed or
+			// rolled back. Otherwise the primary lock is still there and the
+			// lost request may still commit it.
+			if committed {
+				c.commitSecondariesAsync()
+			}
+			if !committed && (checkErr != nil || status.TTL() > 0) {
+				logutil.Logger(ctx).Error("2PC commit result undetermined",
+					zap.Error(err),
+					zap.NamedError("rpcErr", undeterminedErr),
+					zap.NamedError("checkErr", checkErr),
+					zap.Uint64("txnStartTS", c.startTS))
+				err = errors.Trace(terror.ErrResultUndetermined)
+			}
 		}
 		if !c.mu.committed {
 			logutil.Logger(ctx).Debug("2PC failed on commit",
@@ -1333,6 +1542,29 @@ func (c *twoPhaseCommitter) commitTxn(ctx context.Context, commitDetail *util.Co
 	return nil
 }
 
+// commitSecondariesAsync commits the secondary keys in background after the
+// primary key is found committed by checkCommitted. The failed commit of the
+// primary batch has not started committing the secondary batches, so the
+// secondary locks would otherwise wait for the readers to resolve them.
+func (c *twoPhaseCommitter) commitSecondariesAsync() {
+	c.storeWg.Add(1)
+	go func() {
+		defer c.storeWg.Done()
+		secondaries := NewPlainMutations(c.mutations.Len())
+		for i := 0; i < c.mutations.Len(); i++ {
+			if !bytes.Equal(c.mutations.GetKey(i), c.primary()) {
+				secondaries.Push(c.mutations.GetOp(i), c.mutations.GetKey(i), nil, c.mutations.IsPessimisticLock(i))
+			}
+		}
+		commitBo := retry.NewBackofferWithVars(c.storeCtx, CommitSecondaryMaxBackoff, c.txn.vars)
+		if err := c.doActionOnMutations(commitBo, actionCommit{retry: true}, &secondaries); err != nil {
+			logutil.BgLogger().Debug("2PC commit secondaries after checking the primary failed",
+				zap.Uint64("txnStartTS", c.startTS), zap.Uint64("commitTS", c.commitTS), zap.Error(err))
+			metrics.SecondaryLockCleanupFailureCounterCommit.Inc()
+		}
+	}()
+}
+
 func (c *twoPhaseCommitter) stripNoNeedCommitKeys() {
 	if !c.hasNoNeedCommitKeys {
 		return
@@ -1546,6 +1778,10 @@ func (c *twoPhaseCommitter) calculateMaxCommitTS(ctx context.Context) error {
 
 	safeWindow := config.GetGlobalConfig().TiKVClient.AsyncCommit.SafeWindow
 	maxCommitTS := oracle.ComposeTS(int64(safeWindow/time.Millisecond), 0) + currentTS
+	// Don't lower the maxCommitTS set by setMaxCommitTSFromOracle.
+	if maxCommitTS < c.maxCommitTS {
+		maxCommitTS = c.maxCommitTS
+	}
 	logutil.BgLogger().Debug("calculate MaxCommitTS",
 		zap.Time("startTime", c.txn.startTime),
 		zap.Duration("safeWindow", safeWindow),
@@ -1556,6 +1792,18 @@ func (c *twoPhaseCommitter) calculateMaxCommitTS(ctx context.Context) error {
 	return nil
 }
 
+// setMaxCommitTSFromOracle sets maxCommitTS to the current ts of the oracle
+// plus margin, see KVTxn.SetMaxCommitTSFromOracle.
+func (c *twoPhaseCommitter) setMaxCommitTSFromOracle(ctx context.Context, margin time.Duration) error {
+	bo := retry.NewBackofferWithVars(ctx, tsoMaxBackoff, c.txn.vars)
+	currentTS, err := c.store.getTimestampWithRetry(bo, c.txn.GetScope())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.maxCommitTS = oracle.ComposeTS(int64(margin/time.Millisecond), 0) + currentTS
+	return nil
+}
+
 func (c *twoPhaseCommitter) shouldWriteBinlog() bool {
 	return c.binlog != nil
 }
@@ -1568,6 +1816,72 @@ type batchMutations struct {
 	region    locate.RegionVerID
 	mutations CommitterMutations
 	isPrimary bool
+	// leaderHint is the peer expected to be the leader of the region soon, the
+	// prewrite request is sent to it if it's a peer of the region. It's only
+	// advisory, TiKV redirects the request by NotLeader if the hint is wrong.
+	leaderHint *metapb.Peer
+}
+
+// relocateBatch relocates the batch after its region returns EpochNotMatch.
+// The pending batches of the action are checked for region merges before they
+// are sent.
+func (c *twoPhaseCommitter) relocateBatch(bo *Backoffer, batch *batchMutations) (bool, error) {
+	atomic.AddUint32(&c.regionEpochChanges, 1)
+	return batch.relocate(bo, c.store.regionCache)
+}
+
+// mergePendingBatches merges the adjacent batches from batches[from:] that
+// were grouped into different regions but are located in the same region now,
+// which happens after the regions are merged. Each merged batch is sent by one
+// RPC instead of one RPC per original region. The check is skipped if no batch
+// has been relocated since the last check recorded in epochChanges. It
+// returns the batches up to from unchanged, followed by the merged batches.
+func (c *twoPhaseCommitter) mergePendingBatches(batches []batchMutations, from int, epochChanges *uint32) []batchMutations {
+	changes := atomic.LoadUint32(&c.regionEpochChanges)
+	if changes == *epochChanges {
+		return batches
+	}
+	*epochChanges = changes
+
+	merged := make([]batchMutations, from, len(batches))
+	copy(merged, batches[:from])
+	var (
+		prevLoc  *KeyLocation
+		prevSize int
+	)
+	for _, b := range batches[from:] {
+		size := 0
+		for i := 0; i < b.mutations.Len(); i++ {
+			size += c.keyValueSize(b.mutations.GetKey(i), b.mutations.GetValue(i))
+		}
+		loc := c.store.regionCache.TryLocateKey(b.mutations.GetKey(0))
+		if loc == nil || !loc.Contains(b.mutations.GetKey(b.mutations.Len()-1)) {
+			merged = append(merged, b)
+			prevLoc = nil
+			continue
+		}
+		if prevLoc != nil && prevLoc.Region == loc.Region && prevSize+size <= txnCommitBatchSize {
+			// Batches of the same region are split by size and are kept apart.
+			prev := &merged[len(merged)-1]
+			if prev.region != b.region {
+				if mutations, err := MergeMutations(prev.mutations, b.mutations); err == nil {
+					prev.mutations = mutations
+					prev.region = loc.Region
+					prev.isPrimary = prev.isPrimary || b.isPrimary
+					prev.leaderHint = nil
+					prevSize += size
+					continue
+				}
+			}
+		}
+		merged = append(merged, b)
+		prevLoc, prevSize = loc, size
+	}
+	if n := len(batches) - len(merged); n > 0 {
+		logutil.BgLogger().Info("merge batches after region merge",
+			zap.Uint64("txnStartTS", c.startTS), zap.Int("merged", n))
+	}
+	return merged
 }
 
 func (b *batchMutations) relocate(bo *Backoffer, c *RegionCache) (bool, error) {
@@ -1596,6 +1910,14 @@ func newBatched(primaryKey []byte) *batched {
 	}
 }
 
+// setLeaderHints sets the hinted leader of the region of each batch, see
+// KVTxn.SetLeaderHint.
+func (b *batched) setLeaderHints(hints map[uint64]*metapb.Peer) {
+	for i := range b.batches {
+		b.batches[i].leaderHint = hints[b.batches[i].region.GetID()]
+	}
+}
+
 // appendBatchMutationsBySize appends mutations to b. It may split the keys to make
 // sure each batch's size does not exceed the limit.
 func (b *batched) appendBatchMutationsBySize(region locate.RegionVerID, mutations CommitterMutations, sizeFn func(k, v []byte) int, limit int) {
@@ -1603,14 +1925,24 @@ func (b *batched) appendBatchMutationsBySize(region locate.RegionVerID, mutation
 		limit = 1
 	}
 
+	cfg := config.GetGlobalConfig()
+	maxKeys, maxBytes := cfg.CommitterMaxBatchKeys, cfg.CommitterMaxBatchBytes
+
 	var start, end int
 	for start = 0; start < mutations.Len(); start = end {
 		var size int
 		for end = start; end < mutations.Len() && size < limit; end++ {
+			if maxKeys > 0 && end-start >= maxKeys {
+				break
+			}
 			var k, v []byte
 			k = mutations.GetKey(end)
 			v = mutations.GetValue(end)
-			size += sizeFn(k, v)
+			entrySize := sizeFn(k, v)
+			if maxBytes > 0 && end > start && size+entrySize > maxBytes {
+				break
+			}
+			size += entrySize
 			if b.primaryIdx < 0 && bytes.Equal(k, b.primaryKey) {
 				b.primaryIdx = len(b.batches)
 			}
@@ -1661,13 +1993,14 @@ type batchExecutor struct {
 	action            twoPhaseCommitAction // the work action type
 	backoffer         *Backoffer           // Backoffer
 	tokenWaitDuration time.Duration        // get token wait time
+	pipelined         bool                 // send the batches in order, each after the previous one is sent
 }
 
 // newBatchExecutor create processor to handle concurrent batch works(prewrite/commit etc)
 func newBatchExecutor(rateLimit int, committer *twoPhaseCommitter,
 	action twoPhaseCommitAction, backoffer *Backoffer) *batchExecutor {
 	return &batchExecutor{rateLimit, nil, committer,
-		action, backoffer, 0}
+		action, backoffer, 0, false}
 }
 
 // initUtils do initialize batchExecutor related policies like rateLimit util
@@ -1677,13 +2010,32 @@ func (batchExe *batchExecutor) initUtils() error {
 	return nil
 }
 
+type batchResult struct {
+	region RegionVerID
+	err    error
+}
+
 // startWork concurrently do the work for each batch considering rate limit
-func (batchExe *batchExecutor) startWorker(exitCh chan struct{}, ch chan error, batches []batchMutations) {
-	for idx, batch1 := range batches {
+func (batchExe *batchExecutor) startWorker(exitCh chan struct{}, ch chan batchResult, batches []batchMutations) {
+	epochChanges := atomic.LoadUint32(&batchExe.committer.regionEpochChanges)
+	for idx := 0; idx < len(batches); idx++ {
 		waitStart := time.Now()
 		if exit := batchExe.rateLimiter.GetToken(exitCh); !exit {
 			batchExe.tokenWaitDuration += time.Since(waitStart)
-			batch := batch1
+			if idx > 0 {
+				n := len(batches)
+				batches = batchExe.committer.mergePendingBatches(batches, idx, &epochChanges)
+				// process waits for a result of every original batch, the batches
+				// merged into others are reported as done.
+				for i := len(batches); i < n; i++ {
+					ch <- batchResult{}
+				}
+			}
+			batch := batches[idx]
+			var sent chan struct{}
+			if batchExe.pipelined {
+				sent = make(chan struct{})
+			}
 			go func() {
 				defer batchExe.rateLimiter.PutToken()
 				var singleBatchBackoffer *Backoffer
@@ -1701,7 +2053,22 @@ func (batchExe *batchExecutor) startWorker(exitCh chan struct{}, ch chan error,
 					singleBatchBackoffer, singleBatchCancel = batchExe.backoffer.Fork()
 					defer singleBatchCancel()
 				}
-				ch <- batchExe.action.handleSingleBatch(batchExe.committer, singleBatchBackoffer, batch)
+				if sent != nil {
+					var once sync.Once
+					onSent := func() { once.Do(func() { close(sent) }) }
+					// The next batch is not blocked if this one fails before sending.
+					defer onSent()
+					singleBatchBackoffer.SetCtx(context.WithValue(singleBatchBackoffer.GetCtx(), prewriteSentKey{}, onSent))
+				}
+				var err error
+				if _, ok := batchExe.action.(actionPrewrite); ok {
+					// Pace the prewrite batches if TiKV is stalled.
+					err = batchExe.committer.waitWriteStall(singleBatchBackoffer.GetCtx())
+				}
+				if err == nil {
+					err = batchExe.action.handleSingleBatch(batchExe.committer, singleBatchBackoffer, batch)
+				}
+				ch <- batchResult{region: batch.region, err: err}
 				commitDetail := batchExe.committer.getDetail()
 				// For prewrite, we record the max backoff time
 				if _, ok := batchExe.action.(actionPrewrite); ok {
@@ -1716,6 +2083,9 @@ func (batchExe *batchExecutor) startWorker(exitCh chan struct{}, ch chan error,
 				// Backoff time in the 2nd phase of a non-async-commit txn is added
 				// in the commitTxn method, so we don't add it here.
 			}()
+			if sent != nil {
+				<-sent
+			}
 		} else {
 			logutil.Logger(batchExe.backoffer.GetCtx()).Info("break startWorker",
 				zap.Stringer("action", batchExe.action), zap.Int("batch size", len(batches)),
@@ -1725,7 +2095,9 @@ func (batchExe *batchExecutor) startWorker(exitCh chan struct{}, ch chan error,
 	}
 }
 
-// process will start worker routine and collect results
+// process will start worker routine and collect results. For commit, which is
+// idempotent, all batches are processed and the errors of all failed batches
+// are returned as a MultiError. For other actions, the first error is returned.
 func (batchExe *batchExecutor) process(batches []batchMutations) error {
 	var err error
 	err = batchExe.initUtils()
@@ -1740,13 +2112,16 @@ func (batchExe *batchExecutor) process(batches []batchMutations) error {
 		batchExe.backoffer, cancel = batchExe.backoffer.Fork()
 		defer cancel()
 	}
+	_, collectAll := batchExe.action.(actionCommit)
+	var batchErrs []tikverr.BatchError
 	// concurrently do the work for each batch.
-	ch := make(chan error, len(batches))
+	ch := make(chan batchResult, len(batches))
 	exitCh := make(chan struct{})
 	go batchExe.startWorker(exitCh, ch, batches)
 	// check results
 	for i := 0; i < len(batches); i++ {
-		if e := <-ch; e != nil {
+		r := <-ch
+		if e := r.err; e != nil {
 			logutil.Logger(batchExe.backoffer.GetCtx()).Debug("2PC doActionOnBatch failed",
 				zap.Uint64("session", batchExe.committer.sessionID),
 				zap.Stringer("action type", batchExe.action),
@@ -1761,6 +2136,9 @@ func (batchExe *batchExecutor) process(batches []batchMutations) error {
 				atomic.StoreUint32(&batchExe.committer.prewriteCancelled, 1)
 				cancel()
 			}
+			if collectAll {
+				batchErrs = append(batchErrs, tikverr.BatchError{RegionID: r.region.GetID(), Err: e})
+			}
 			if err == nil {
 				err = e
 			}
@@ -1770,6 +2148,9 @@ func (batchExe *batchExecutor) process(batches []batchMutations) error {
 	if batchExe.tokenWaitDuration > 0 {
 		metrics.TiKVTokenWaitDuration.Observe(float64(batchExe.tokenWaitDuration.Nanoseconds()))
 	}
+	if len(batchErrs) > 0 {
+		return errors.Trace(&tikverr.MultiError{Errors: batchErrs})
+	}
 	return err
 }
 
@@ -1777,6 +2158,16 @@ func (c *twoPhaseCommitter) setDetail(d *util.CommitDetails) {
 	atomic.StorePointer(&c.detail, unsafe.Pointer(d))
 }
 
+// waitWriteStall waits for the write stall detector to pace a prewrite batch,
+// the batches of high priority take the dispatch slots first if priority
+// scheduling is enabled.
+func (c *twoPhaseCommitter) waitWriteStall(ctx context.Context) error {
+	if c.store.priorityScheduler != nil {
+		return c.store.priorityScheduler.Wait(ctx, c.priority)
+	}
+	return c.store.writeStall.Wait(ctx)
+}
+
 func (c *twoPhaseCommitter) getDetail() *util.CommitDetails {
 	return (*util.CommitDetails)(atomic.LoadPointer(&c.detail))
 }
@@ -0,0 +1,473 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/config"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+func TestMergeMutations(t *testing.T) {
+	newMutations := func(keys ...string) *PlainMutations {
+		m := NewPlainMutations(len(keys))
+		for _, k := range keys {
+			m.Push(kvrpcpb.Op_Put, []byte(k), []byte("v"+k), false)
+		}
+		return &m
+	}
+
+	merged, err := MergeMutations(newMutations("a", "c", "e"), newMutations("b", "d", "f", "g"))
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e"), []byte("f"), []byte("g")}, merged.GetKeys())
+	require.Equal(t, []byte("vd"), merged.GetValue(3))
+
+	merged, err = MergeMutations(newMutations(), newMutations("a"))
+	require.Nil(t, err)
+	require.Equal(t, 1, merged.Len())
+
+	_, err = MergeMutations(newMutations("a", "b"), newMutations("b", "c"))
+	require.True(t, tikverr.Is(err, &tikverr.ErrDuplicateKey{}))
+}
+
+func TestBatchLimits(t *testing.T) {
+	m := NewPlainMutations(5)
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		m.Push(kvrpcpb.Op_Put, []byte(k), []byte("vvv"), false)
+	}
+	batchLens := func() []int {
+		b := newBatched(nil)
+		b.appendBatchMutationsBySize(RegionVerID{}, &m, func(k, v []byte) int { return len(k) + len(v) }, txnCommitBatchSize)
+		var lens []int
+		for _, batch := range b.allBatches() {
+			lens = append(lens, batch.mutations.Len())
+		}
+		return lens
+	}
+	require.Equal(t, []int{5}, batchLens())
+
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.CommitterMaxBatchKeys = 2
+	})()
+	require.Equal(t, []int{2, 2, 1}, batchLens())
+
+	config.UpdateGlobal(func(conf *config.Config) {
+		conf.CommitterMaxBatchKeys = 0
+		conf.CommitterMaxBatchBytes = 12
+	})
+	require.Equal(t, []int{3, 2}, batchLens())
+
+	// A batch contains at least one key.
+	config.UpdateGlobal(func(conf *config.Config) {
+		conf.CommitterMaxBatchBytes = 1
+	})
+	require.Equal(t, []int{1, 1, 1, 1, 1}, batchLens())
+}
+
+func TestEstimatedRegionCount(t *testing.T) {
+	defer ConfigProbe{}.StoreRegionEstimateSampleStep(ConfigProbe{}.LoadRegionEstimateSampleStep())
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+	cluster := mocktikv.NewCluster(mvccStore)
+	// 100 keys in 4 regions of about 25 keys.
+	var keys []string
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("k%03d", i))
+	}
+	mocktikv.BootstrapWithMultiRegions(cluster, []byte("k025"), []byte("k050"), []byte("k075"))
+	cache := NewRegionCache(mocktikv.NewPDClient(cluster))
+	defer cache.Close()
+	m := NewPlainMutations(len(keys))
+	for _, k := range keys {
+		m.Push(kvrpcpb.Op_Put, []byte(k), nil, false)
+	}
+
+	// Nothing is cached.
+	require.Equal(t, 1, m.EstimatedRegionCount(cache))
+
+	// Samples that miss the cache are ignored.
+	bo := NewBackofferWithVars(context.Background(), 5000, nil)
+	_, err := cache.LocateKey(bo, []byte("k000"))
+	require.Nil(t, err)
+	_, err = cache.LocateKey(bo, []byte("k099"))
+	require.Nil(t, err)
+	require.Equal(t, 2, m.EstimatedRegionCount(cache))
+
+	// The samples are finer than the regions, so they are counted exactly.
+	for _, k := range []string{"k030", "k060"} {
+		_, err = cache.LocateKey(bo, []byte(k))
+		require.Nil(t, err)
+	}
+	for _, step := range []uint32{1, 16} {
+		ConfigProbe{}.StoreRegionEstimateSampleStep(step)
+		require.Equal(t, 4, m.EstimatedRegionCount(cache), step)
+	}
+
+	// Every sample falls in a different region, so the regions may be smaller
+	// than the step. The mutations are few, so every key is located instead of
+	// extrapolating. Samples k000, k033, k066 and k099.
+	ConfigProbe{}.StoreRegionEstimateSampleStep(33)
+	require.Equal(t, 4, m.EstimatedRegionCount(cache))
+	few := NewPlainMutations(4)
+	for _, k := range []string{"k000", "k030", "k060", "k090"} {
+		few.Push(kvrpcpb.Op_Put, []byte(k), nil, false)
+	}
+	// Samples k000 and k060, plus the last key k090.
+	ConfigProbe{}.StoreRegionEstimateSampleStep(2)
+	require.Equal(t, 4, few.EstimatedRegionCount(cache))
+	// k000 and k010 fall in the same region.
+	ConfigProbe{}.StoreRegionEstimateSampleStep(1)
+	few = NewPlainMutations(3)
+	for _, k := range []string{"k000", "k010", "k090"} {
+		few.Push(kvrpcpb.Op_Put, []byte(k), nil, false)
+	}
+	require.Equal(t, 2, few.EstimatedRegionCount(cache))
+}
+
+func TestAsyncCommitStrict(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	// mocktikv doesn't calculate the min commit ts, so async commit always
+	// falls back to 2PC.
+	txn, err := store.Begin(WithAsyncCommitStrict())
+	require.Nil(t, err)
+	txn.SetEnableAsyncCommit(true)
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	committer, err := newTwoPhaseCommitterWithInit(txn, 1)
+	require.Nil(t, err)
+	err = committer.execute(context.Background())
+	require.True(t, tikverr.Is(err, &tikverr.ErrAsyncCommitFailed{}))
+
+	// The transaction is rolled back.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	_, err = txn.Get(context.Background(), []byte("a"))
+	require.True(t, tikverr.IsErrNotFound(err))
+}
+
+func TestSetMaxCommitTSFromOracle(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	committer, err := newTwoPhaseCommitterWithInit(txn, 1)
+	require.Nil(t, err)
+	margin := time.Minute
+	require.Nil(t, committer.setMaxCommitTSFromOracle(ctx, margin))
+	lower := oracle.GetTimeFromTS(txn.StartTS()).Add(margin)
+	require.False(t, oracle.GetTimeFromTS(committer.maxCommitTS).Before(lower))
+
+	// The max commit ts calculated with the safe window doesn't lower it.
+	maxCommitTS := committer.maxCommitTS
+	require.Nil(t, committer.calculateMaxCommitTS(ctx))
+	require.Equal(t, maxCommitTS, committer.maxCommitTS)
+
+	// The margin set on the transaction is applied when an async commit starts.
+	txn, err = store.Begin(WithMaxCommitTSFromOracle(margin))
+	require.Nil(t, err)
+	txn.SetEnableAsyncCommit(true)
+	require.Nil(t, txn.Set([]byte("b"), []byte("1")))
+	committer, err = newTwoPhaseCommitterWithInit(txn, 1)
+	require.Nil(t, err)
+	// mocktikv falls back to 2PC, but the async commit was tried.
+	require.Nil(t, committer.execute(ctx))
+	require.True(t, committer.hasTriedAsyncCommit)
+	lower = oracle.GetTimeFromTS(txn.StartTS()).Add(margin)
+	require.False(t, oracle.GetTimeFromTS(committer.maxCommitTS).Before(lower))
+}
+
+func TestWriteAmplificationStats(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Zero(t, txn.WriteAmplificationStats().Ratio())
+	for _, k := range []string{"a", "b", "c"} {
+		require.Nil(t, txn.Set([]byte(k), []byte("v")))
+	}
+	require.Nil(t, txn.Commit(context.Background()))
+
+	// The keys and values are tiny, so the request fields dominate.
+	stats := txn.WriteAmplificationStats()
+	require.Equal(t, int64(6), stats.MutationBytes)
+	require.Greater(t, stats.Ratio(), writeAmplificationWarnRatio)
+
+	// The warning is only logged for large transactions, at most once per
+	// interval.
+	atomic.StoreInt64(&lastWriteAmplificationWarn, 0)
+	now := time.Now()
+	require.False(t, shouldWarnWriteAmplification(stats, now))
+	large := WriteAmplificationStats{MutationBytes: writeAmplificationWarnMinBytes, RPCBytes: 4 * writeAmplificationWarnMinBytes}
+	require.True(t, shouldWarnWriteAmplification(large, now))
+	require.False(t, shouldWarnWriteAmplification(large, now.Add(time.Second)))
+	require.True(t, shouldWarnWriteAmplification(large, now.Add(writeAmplificationWarnInterval)))
+	large.RPCBytes = 2 * writeAmplificationWarnMinBytes
+	require.False(t, shouldWarnWriteAmplification(large, now.Add(2*writeAmplificationWarnInterval)))
+}
+
+func TestMergePendingBatches(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, peerID, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	newRegionID := cluster.AllocID()
+	cluster.Split(regionID, newRegionID, []byte("b"), []uint64{cluster.AllocID()}, peerID)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for _, k := range []string{"a", "c"} {
+		require.Nil(t, txn.Set([]byte(k), []byte("v")))
+	}
+	committer, err := newTwoPhaseCommitterWithInit(txn, 1)
+	require.Nil(t, err)
+	bo := NewBackofferWithVars(context.Background(), 1000, nil)
+	groups, err := committer.groupMutations(bo, committer.mutations)
+	require.Nil(t, err)
+	b := newBatched(nil)
+	for _, group := range groups {
+		b.appendBatchMutationsBySize(group.region, group.mutations, committer.keyValueSize, txnCommitBatchSize)
+	}
+	batches := b.allBatches()
+	require.Len(t, batches, 2)
+
+	// Nothing is merged before any batch is relocated.
+	var epochChanges uint32
+	require.Len(t, committer.mergePendingBatches(batches, 0, &epochChanges), 2)
+
+	cluster.Merge(regionID, newRegionID)
+	store.regionCache.InvalidateCachedRegion(batches[0].region)
+	store.regionCache.InvalidateCachedRegion(batches[1].region)
+	_, err = committer.relocateBatch(bo, &batches[0])
+	require.Nil(t, err)
+	merged := committer.mergePendingBatches(batches, 0, &epochChanges)
+	require.Len(t, merged, 1)
+	require.Equal(t, [][]byte{[]byte("a"), []byte("c")}, merged[0].mutations.GetKeys())
+	require.Equal(t, regionID, merged[0].region.GetID())
+
+	// The batches before from are kept.
+	atomic.AddUint32(&committer.regionEpochChanges, 1)
+	require.Len(t, committer.mergePendingBatches(batches, 1, &epochChanges), 2)
+}
+
+func TestRollbackStrategy(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	// n is 0 so the client only records the requests.
+	client := &splittingClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	// rollback prewrites 40 keys of 1 KiB, which exceed the batch size limit,
+	// and rolls them back by the strategy.
+	rollback := func(strategy RollbackStrategy) (locked []string, requests int) {
+		txn, err := store.Begin(WithRollbackStrategy(strategy))
+		require.Nil(t, err)
+		for i := 0; i < 40; i++ {
+			key := append([]byte(fmt.Sprintf("%s-%02d-", strategy, i)), make([]byte, 1024)...)
+			require.Nil(t, txn.Set(key, []byte("v")))
+		}
+		committer, err := newTwoPhaseCommitterWithInit(txn, 0)
+		require.Nil(t, err)
+		require.Nil(t, committer.prewriteMutations(NewBackofferWithVars(context.Background(), 5000, nil), committer.mutations))
+		before := len(client.sentOfType(tikvrpc.CmdBatchRollback))
+		committer.cleanup(context.Background())
+		committer.cleanWg.Wait()
+
+		locks, err := mvccStore.ScanLock([]byte(strategy.String()), []byte(strategy.String()+"."), math.MaxUint64)
+		require.Nil(t, err)
+		for _, lock := range locks {
+			locked = append(locked, string(lock.Key[:len(strategy.String())+3]))
+		}
+		return locked, len(client.sentOfType(tikvrpc.CmdBatchRollback)) - before
+	}
+
+	locked, requests := rollback(RollbackImmediate)
+	require.Empty(t, locked)
+	require.Equal(t, 3, requests)
+
+	locked, requests = rollback(RollbackPrimary)
+	require.Len(t, locked, 39)
+	require.NotContains(t, locked, "primary-00")
+	require.Equal(t, 1, requests)
+
+	// All the keys of the region, including the primary key, are rolled back
+	// in one request.
+	locked, requests = rollback(RollbackCoalesced)
+	require.Empty(t, locked)
+	require.Equal(t, 1, requests)
+}
+
+// failingPrewriteClient fails the prewrite of the region containing failKey,
+// and holds the prewrites of the other regions until they are canceled.
+type failingPrewriteClient struct {
+	Client
+	failKey  []byte
+	canceled chan error
+}
+
+func (c *failingPrewriteClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type != tikvrpc.CmdPrewrite {
+		return c.Client.SendRequest(ctx, addr, req, timeout)
+	}
+	for _, m := range req.Prewrite().Mutations {
+		if bytes.Equal(m.Key, c.failKey) {
+			return &tikvrpc.Response{Resp: &kvrpcpb.PrewriteResponse{
+				Errors: []*kvrpcpb.KeyError{{Abort: "injected"}},
+			}}, nil
+		}
+	}
+	<-ctx.Done()
+	c.canceled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestPrewriteCancelOnError(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("b"), []uint64{ids[1]}, ids[1])
+	client := &failingPrewriteClient{
+		Client:   mocktikv.NewRPCClient(cluster, mvccStore, nil),
+		failKey:  []byte("a"),
+		canceled: make(chan error, 1),
+	}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	require.Nil(t, txn.Set([]byte("c"), []byte("1")))
+	require.NotNil(t, txn.Commit(context.Background()))
+	select {
+	case err := <-client.canceled:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the prewrite of the other region is not canceled")
+	}
+}
+
+// failingCommitClient fails the commit requests containing the keys in
+// failKeys.
+type failingCommitClient struct {
+	Client
+	failKeys map[string]struct{}
+}
+
+func (c *failingCommitClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdCommit {
+		for _, key := range req.Commit().Keys {
+			if _, ok := c.failKeys[string(key)]; ok {
+				return &tikvrpc.Response{Resp: &kvrpcpb.CommitResponse{
+					Error: &kvrpcpb.KeyError{Abort: "injected"},
+				}}, nil
+			}
+		}
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestCommitMultiError(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithMultiRegions(cluster, []byte("b"), []byte("c"), []byte("d"))
+	client := &failingCommitClient{
+		Client:   mocktikv.NewRPCClient(cluster, mvccStore, nil),
+		failKeys: map[string]struct{}{"b": {}, "d": {}},
+	}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		require.Nil(t, txn.Set([]byte(key), []byte("v")))
+	}
+	committer, err := newTwoPhaseCommitterWithInit(txn, 1)
+	require.Nil(t, err)
+	bo := NewBackofferWithVars(context.Background(), 5000, nil)
+	require.Nil(t, committer.prewriteMutations(bo, committer.mutations))
+	committer.commitTS, err = store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+
+	// Commit the secondaries, each in its own region.
+	groups, err := committer.groupMutations(bo, committer.mutations.Slice(1, 4))
+	require.Nil(t, err)
+	require.Len(t, groups, 3)
+	var batches []batchMutations
+	for _, g := range groups {
+		batches = append(batches, batchMutations{region: g.region, mutations: g.mutations})
+	}
+	failedRegions := []uint64{groups[0].region.GetID(), groups[2].region.GetID()}
+	sort.Slice(failedRegions, func(i, j int) bool { return failedRegions[i] < failedRegions[j] })
+
+	// Both the concurrent and the sequential paths list every failed region.
+	for _, action := range []actionCommit{{}, {retry: true}} {
+		err = committer.doActionOnBatches(bo, action, batches)
+		multiErr, ok := errors.Cause(err).(*tikverr.MultiError)
+		require.True(t, ok, "%v", err)
+		regions := multiErr.FailedRegions()
+		sort.Slice(regions, func(i, j int) bool { return regions[i] < regions[j] })
+		require.Equal(t, failedRegions, regions)
+		require.True(t, tikverr.Is(err, &tikverr.MultiError{}))
+	}
+
+	// The batch that didn't fail is committed.
+	snapshot := store.GetSnapshot(committer.commitTS)
+	val, err := snapshot.Get(context.Background(), []byte("c"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v"), val)
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/debugpb"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/util/codec"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// compactStoreTimeout is the timeout of a manual compaction of a store, which
+// may take a long time after bulk imports.
+const compactStoreTimeout = 2 * time.Hour
+
+// CompactionType is the type of a manual compaction.
+type CompactionType int
+
+const (
+	// CompactionBottom compacts the write column family, which holds the commit
+	// records of transactions, down to the bottommost level. It collapses the
+	// MVCC versions removed by GC.
+	CompactionBottom CompactionType = iota
+	// CompactionWrite compacts the default column family, the main one which
+	// holds the values written by transactions, without forcing the bottommost
+	// level.
+	CompactionWrite
+)
+
+func (t CompactionType) String() string {
+	switch t {
+	case CompactionBottom:
+		return "bottom"
+	case CompactionWrite:
+		return "write"
+	}
+	return "unknown"
+}
+
+// AdminClient sends administrative requests to the TiKV stores of a KVStore.
+type AdminClient struct {
+	store *KVStore
+}
+
+// NewAdminClient creates an AdminClient.
+func NewAdminClient(store *KVStore) *AdminClient {
+	return &AdminClient{store: store}
+}
+
+// CompactStore triggers a manual compaction of the keys in [startKey, endKey)
+// on a store and waits for it. Empty keys mean unbounded.
+func (a *AdminClient) CompactStore(ctx context.Context, storeID uint64, startKey, endKey []byte, compactionType CompactionType) error {
+	store, err := a.store.GetPDClient().GetStore(ctx, storeID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if store == nil {
+		return errors.Errorf("store %d not found", storeID)
+	}
+	req := &debugpb.CompactRequest{
+		Db:      debugpb.DB_KV,
+		FromKey: encodeDataKey(startKey),
+		ToKey:   encodeDataKey(endKey),
+	}
+	switch compactionType {
+	case CompactionBottom:
+		req.Cf = "write"
+		req.BottommostLevelCompaction = debugpb.BottommostLevelCompaction_Force
+	case CompactionWrite:
+		req.Cf = "default"
+		req.BottommostLevelCompaction = debugpb.BottommostLevelCompaction_Skip
+	default:
+		return errors.Errorf("invalid compaction type %d", compactionType)
+	}
+	start := time.Now()
+	_, err = a.store.GetTiKVClient().SendRequest(ctx, store.GetAddress(), tikvrpc.NewRequest(tikvrpc.CmdDebugCompact, req), compactStoreTimeout)
+	if err != nil {
+		return errors.Annotatef(err, "compact store %d", storeID)
+	}
+	logutil.Logger(ctx).Info("compacted store",
+		zap.Uint64("storeID", storeID),
+		zap.Stringer("type", compactionType),
+		zap.Duration("duration", time.Since(start)))
+	return nil
+}
+
+// CompactAllStores runs CompactStore on all TiKV stores, at most concurrency
+// of them at the same time. TiFlash stores and tombstone stores are skipped.
+// It stops starting new compactions and returns the first error if one fails.
+func (a *AdminClient) CompactAllStores(ctx context.Context, startKey, endKey []byte, compactionType CompactionType, concurrency int) error {
+	if concurrency <= 0 {
+		return errors.Errorf("invalid concurrency %d", concurrency)
+	}
+	stores, err := a.store.GetPDClient().GetAllStores(ctx, pd.WithExcludeTombstone())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, concurrency)
+	for _, store := range stores {
+		if tikvrpc.GetStoreTypeByMeta(store) != tikvrpc.TiKV {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-gctx.Done():
+			return g.Wait()
+		}
+		storeID := store.GetId()
+		g.Go(func() error {
+			defer func() { <-sem }()
+			return a.CompactStore(gctx, storeID, startKey, endKey, compactionType)
+		})
+	}
+	return g.Wait()
+}
+
+// encodeDataKey converts a key to the key stored in the KV RocksDB of TiKV.
+func encodeDataKey(key []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+	return append([]byte{'z'}, codec.EncodeBytes(nil, key)...)
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/debugpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/util/codec"
+)
+
+// compactRecordClient records the compact requests and the max number of
+// them in flight.
+type compactRecordClient struct {
+	Client
+	mu          sync.Mutex
+	addrs       []string
+	reqs        []*debugpb.CompactRequest
+	inflight    int
+	maxInflight int
+}
+
+func (c *compactRecordClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdDebugCompact {
+		c.mu.Lock()
+		c.addrs = append(c.addrs, addr)
+		c.reqs = append(c.reqs, req.DebugCompact())
+		c.inflight++
+		if c.inflight > c.maxInflight {
+			c.maxInflight = c.inflight
+		}
+		c.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		defer func() {
+			c.mu.Lock()
+			c.inflight--
+			c.mu.Unlock()
+		}()
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestAdminCompactStore(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	storeIDs, _, _, _ := mocktikv.BootstrapWithMultiStores(cluster, 3)
+	tiflashID := cluster.AllocID()
+	cluster.AddStore(tiflashID, "tiflash", &metapb.StoreLabel{Key: "engine", Value: "tiflash"})
+	client := &compactRecordClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	admin := NewAdminClient(store)
+	ctx := context.Background()
+
+	// The compaction types map to the column families.
+	require.Nil(t, admin.CompactStore(ctx, storeIDs[0], []byte("a"), nil, CompactionBottom))
+	require.Nil(t, admin.CompactStore(ctx, storeIDs[0], nil, []byte("b"), CompactionWrite))
+	addr := cluster.GetStore(storeIDs[0]).GetAddress()
+	require.Equal(t, []string{addr, addr}, client.addrs)
+	require.Equal(t, "write", client.reqs[0].Cf)
+	require.Equal(t, debugpb.BottommostLevelCompaction_Force, client.reqs[0].BottommostLevelCompaction)
+	require.Equal(t, "default", client.reqs[1].Cf)
+	require.Equal(t, debugpb.BottommostLevelCompaction_Skip, client.reqs[1].BottommostLevelCompaction)
+	// The keys are encoded like the data keys of TiKV.
+	require.Equal(t, append([]byte{'z'}, codec.EncodeBytes(nil, []byte("a"))...), client.reqs[0].FromKey)
+	require.Nil(t, client.reqs[0].ToKey)
+	require.Nil(t, client.reqs[1].FromKey)
+
+	require.NotNil(t, admin.CompactStore(ctx, storeIDs[0], nil, nil, CompactionType(100)))
+	require.NotNil(t, admin.CompactStore(ctx, 1000, nil, nil, CompactionBottom))
+
+	// All TiKV stores are compacted, but not the TiFlash one.
+	client.addrs, client.reqs, client.maxInflight = nil, nil, 0
+	require.Nil(t, admin.CompactAllStores(ctx, nil, nil, CompactionBottom, 2))
+	var addrs []string
+	for _, id := range storeIDs {
+		addrs = append(addrs, cluster.GetStore(id).GetAddress())
+	}
+	sort.Strings(addrs)
+	sort.Strings(client.addrs)
+	require.Equal(t, addrs, client.addrs)
+	require.LessOrEqual(t, client.maxInflight, 2)
+
+	client.maxInflight = 0
+	require.Nil(t, admin.CompactAllStores(ctx, nil, nil, CompactionWrite, 1))
+	require.Equal(t, 1, client.maxInflight)
+
+	require.NotNil(t, admin.CompactAllStores(ctx, nil, nil, CompactionBottom, 0))
+}
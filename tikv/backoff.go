@@ -104,5 +104,13 @@ func NewGcResolveLockMaxBackoffer(ctx context.Context) *Backoffer {
 	return retry.NewBackofferWithVars(ctx, gcResolveLockMaxBackoff, nil)
 }
 
+// SetMaxRetryRPS limits the backoffs of the type of all Backoffers in the
+// process to maxRetryRPS per second. The backoffs exceeding the limit sleep
+// longer by a jitter to spread the retries of clients over time. Zero removes
+// the limit.
+func SetMaxRetryRPS(cfg *BackoffConfig, maxRetryRPS float64) {
+	retry.GetGlobalRetryLimiter().SetMaxRetryRPS(cfg, maxRetryRPS)
+}
+
 // NewNoopBackoff create a Backoffer do nothing just return error directly
 var NewNoopBackoff = retry.NewNoopBackoff
@@ -0,0 +1,91 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	pd "github.com/tikv/pd/client"
+)
+
+// asyncCommitMinVersion is the first TiKV version where async commit and 1PC
+// are generally available.
+var asyncCommitMinVersion = *semver.New("5.0.0")
+
+// ServerCapabilities is the set of features supported by all TiKV stores of
+// the cluster.
+type ServerCapabilities struct {
+	// MinVersion is the minimum version of the TiKV stores.
+	MinVersion  string
+	AsyncCommit bool
+	OnePC       bool
+}
+
+// NegotiateCapabilities gets the versions of all TiKV stores from PD and
+// returns the features supported by the minimum version. TiFlash stores and
+// tombstone stores are ignored.
+//
+// TiKV has no RPC reporting its version, so the versions are taken from the
+// store metas, which TiKV reports to PD when it starts. A store upgraded or
+// downgraded later is seen once it reports the new meta.
+func (s *KVStore) NegotiateCapabilities(ctx context.Context) (ServerCapabilities, error) {
+	stores, err := s.pdClient.GetAllStores(ctx, pd.WithExcludeTombstone())
+	if err != nil {
+		return ServerCapabilities{}, errors.Trace(err)
+	}
+	return negotiateCapabilities(stores)
+}
+
+func negotiateCapabilities(stores []*metapb.Store) (ServerCapabilities, error) {
+	var minVersion *semver.Version
+	for _, store := range stores {
+		if tikvrpc.GetStoreTypeByMeta(store) != tikvrpc.TiKV {
+			continue
+		}
+		v, err := semver.NewVersion(strings.TrimPrefix(store.GetVersion(), "v"))
+		if err != nil {
+			return ServerCapabilities{}, errors.Annotatef(err, "invalid version of store %d", store.GetId())
+		}
+		if minVersion == nil || v.LessThan(*minVersion) {
+			minVersion = v
+		}
+	}
+	if minVersion == nil {
+		return ServerCapabilities{}, errors.New("no TiKV store found")
+	}
+	supportAsyncCommit := !minVersion.LessThan(asyncCommitMinVersion)
+	return ServerCapabilities{
+		MinVersion:  minVersion.String(),
+		AsyncCommit: supportAsyncCommit,
+		OnePC:       supportAsyncCommit,
+	}, nil
+}
+
+// SetCapabilities restricts the features used by the transactions of the
+// store, which are not restricted by default. It's safe to call it while the
+// store is in use, the transactions committing then may use either one.
+func (s *KVStore) SetCapabilities(c ServerCapabilities) {
+	s.capabilities.Store(&c)
+}
+
+// getCapabilities returns nil if the features are not restricted.
+func (s *KVStore) getCapabilities() *ServerCapabilities {
+	c, _ := s.capabilities.Load().(*ServerCapabilities)
+	return c
+}
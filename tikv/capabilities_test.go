@@ -0,0 +1,89 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util"
+)
+
+func TestNegotiateCapabilities(t *testing.T) {
+	tiflash := []*metapb.StoreLabel{{Key: "engine", Value: "tiflash"}}
+	for _, c := range []struct {
+		stores []*metapb.Store
+		caps   ServerCapabilities
+		err    bool
+	}{
+		{
+			stores: []*metapb.Store{{Id: 1, Version: "v5.0.1"}, {Id: 2, Version: "5.2.0"}},
+			caps:   ServerCapabilities{MinVersion: "5.0.1", AsyncCommit: true, OnePC: true},
+		},
+		{
+			// The minimum version decides.
+			stores: []*metapb.Store{{Id: 1, Version: "5.1.0"}, {Id: 2, Version: "v4.0.13"}, {Id: 3, Version: "5.0.0"}},
+			caps:   ServerCapabilities{MinVersion: "4.0.13"},
+		},
+		{
+			stores: []*metapb.Store{{Id: 1, Version: "5.0.0-rc.1"}},
+			caps:   ServerCapabilities{MinVersion: "5.0.0-rc.1"},
+		},
+		{
+			// TiFlash stores are ignored.
+			stores: []*metapb.Store{{Id: 1, Version: "5.0.0"}, {Id: 2, Version: "v4.0.0", Labels: tiflash}},
+			caps:   ServerCapabilities{MinVersion: "5.0.0", AsyncCommit: true, OnePC: true},
+		},
+		{stores: []*metapb.Store{{Id: 1, Version: "5.0.0"}, {Id: 2, Version: "unknown"}}, err: true},
+		{stores: []*metapb.Store{{Id: 1, Version: "5.0.0", Labels: tiflash}}, err: true},
+	} {
+		caps, err := negotiateCapabilities(c.stores)
+		if c.err {
+			require.NotNil(t, err)
+			continue
+		}
+		require.Nil(t, err)
+		require.Equal(t, c.caps, caps)
+	}
+}
+
+func TestCapabilitiesGateCommitProtocols(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.WithValue(context.Background(), util.SessionID, uint64(1))
+
+	commit := func(key string, onePC bool) *KVTxn {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		txn.SetEnable1PC(onePC)
+		txn.SetEnableAsyncCommit(true)
+		require.Nil(t, txn.Set([]byte(key), []byte("v")))
+		require.Nil(t, txn.Commit(ctx))
+		return txn
+	}
+
+	// Not restricted by default.
+	require.True(t, commit("k1", true).committer.hasTriedOnePC)
+	require.True(t, commit("k2", false).committer.hasTriedAsyncCommit)
+
+	store.SetCapabilities(ServerCapabilities{MinVersion: "4.0.0"})
+	txn := commit("k3", true)
+	require.False(t, txn.committer.hasTriedOnePC)
+	require.False(t, txn.committer.hasTriedAsyncCommit)
+
+	store.SetCapabilities(ServerCapabilities{MinVersion: "5.0.0", AsyncCommit: true, OnePC: true})
+	require.True(t, commit("k4", true).committer.hasTriedOnePC)
+}
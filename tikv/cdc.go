@@ -0,0 +1,519 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/cdcpb"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/internal/client"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/internal/retry"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/util/codec"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// CDCEventType is the type of a CDCEvent.
+type CDCEventType int
+
+const (
+	// CDCEventRow means a row is committed.
+	CDCEventRow CDCEventType = iota
+	// CDCEventResolved means all rows in the subscribed range committed no later
+	// than CommitTS have been emitted.
+	CDCEventResolved
+)
+
+// String implements fmt.Stringer interface.
+func (t CDCEventType) String() string {
+	if t == CDCEventResolved {
+		return "resolved"
+	}
+	return "row"
+}
+
+// CDCOpType is the operation of a row event.
+type CDCOpType int
+
+const (
+	// CDCOpPut means the key is written.
+	CDCOpPut CDCOpType = iota
+	// CDCOpDelete means the key is deleted.
+	CDCOpDelete
+)
+
+// CDCEvent is an event emitted by CDCClient. Key, Value and OpType are only
+// set for CDCEventRow.
+type CDCEvent struct {
+	Type     CDCEventType
+	Key      []byte
+	Value    []byte
+	CommitTS uint64
+	OpType   CDCOpType
+}
+
+// cdcProtocolVersion is sent to TiKV as the TiCDC version, TiKV uses it to
+// decide the features of the protocol.
+const cdcProtocolVersion = "5.0.0"
+
+const cdcRegionMaxBackoff = 20000
+
+// CDCClient subscribes to the changes of key ranges from the ChangeData service
+// of TiKV.
+type CDCClient struct {
+	store     *KVStore
+	security  config.Security
+	requestID uint64
+
+	mu struct {
+		sync.Mutex
+		conns map[string]*grpc.ClientConn
+	}
+}
+
+// NewCDCClient creates a CDCClient. It connects to TiKV with the security
+// config of the global config.
+func NewCDCClient(store *KVStore) *CDCClient {
+	c := &CDCClient{
+		store:    store,
+		security: config.GetGlobalConfig().Security,
+	}
+	c.mu.conns = make(map[string]*grpc.ClientConn)
+	return c
+}
+
+// Close closes the connections to TiKV.
+func (c *CDCClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var firstErr error
+	for addr, conn := range c.mu.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = errors.Trace(err)
+		}
+		delete(c.mu.conns, addr)
+	}
+	return firstErr
+}
+
+func (c *CDCClient) getConn(addr string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.mu.conns[addr]; ok {
+		return conn, nil
+	}
+	opt := grpc.WithInsecure()
+	if len(c.security.ClusterSSLCA) != 0 {
+		tlsConfig, err := c.security.ToTLSConfig()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		opt = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	cfg := config.GetGlobalConfig()
+	conn, err := grpc.Dial(
+		addr,
+		opt,
+		grpc.WithInitialWindowSize(client.GrpcInitialWindowSize),
+		grpc.WithInitialConnWindowSize(client.GrpcInitialConnWindowSize),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(client.MaxRecvMsgSize)),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(cfg.TiKVClient.GrpcKeepAliveTime) * time.Second,
+			Timeout:             time.Duration(cfg.TiKVClient.GrpcKeepAliveTimeout) * time.Second,
+			PermitWithoutStream: true,
+		}),
+	)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	c.mu.conns[addr] = conn
+	return conn, nil
+}
+
+// Subscribe subscribes to the changes of keys in [startKey, endKey) committed
+// after startTS, and sends them to eventCh. An empty endKey means the end of
+// the key space. It blocks until ctx is done or an unrecoverable error occurs.
+//
+// Every region in the range is subscribed by a separate stream. When a region
+// is split, merged or its leader is transferred, the new regions covering its
+// range are subscribed from the last resolved timestamp of the region, so rows
+// may be emitted more than once, but never lost.
+//
+// Events are emitted in commit order per key. A CDCEventResolved event is
+// emitted when the minimal resolved timestamp of all the regions advances.
+// Sending to eventCh blocks the stream of the region, so a slow consumer slows
+// down TiKV through the flow control of gRPC instead of buffering events.
+func (c *CDCClient) Subscribe(ctx context.Context, startKey, endKey []byte, startTS uint64, eventCh chan<- CDCEvent) error {
+	g, gctx := errgroup.WithContext(ctx)
+	s := &cdcSubscription{
+		client:    c,
+		ctx:       gctx,
+		g:         g,
+		clusterID: c.store.GetPDClient().GetClusterID(ctx),
+		eventCh:   eventCh,
+	}
+	s.mu.resolved = make(map[uint64]uint64)
+	s.mu.lastResolved = startTS
+	g.Go(func() error {
+		bo := retry.NewBackofferWithVars(gctx, cdcRegionMaxBackoff, nil)
+		return s.subscribeRange(bo, startKey, endKey, startTS)
+	})
+	return g.Wait()
+}
+
+type cdcSubscription struct {
+	client    *CDCClient
+	ctx       context.Context
+	g         *errgroup.Group
+	clusterID uint64
+	eventCh   chan<- CDCEvent
+
+	mu struct {
+		sync.Mutex
+		// resolved is the resolved timestamp of each region, indexed by the
+		// request ID.
+		resolved     map[uint64]uint64
+		lastResolved uint64
+	}
+}
+
+// cdcRegion is the subscription of a region.
+type cdcRegion struct {
+	verID RegionVerID
+	// startKey and endKey are the intersection of the region and the range of
+	// the subscription.
+	startKey     []byte
+	endKey       []byte
+	checkpointTS uint64
+	requestID    uint64
+	bo           *retry.Backoffer
+}
+
+func (r *cdcRegion) contains(key []byte) bool {
+	return bytes.Compare(key, r.startKey) >= 0 && (len(r.endKey) == 0 || bytes.Compare(key, r.endKey) < 0)
+}
+
+// cdcRetryableError means the range of the region should be subscribed again
+// after backoff.
+type cdcRetryableError struct {
+	cfg *retry.Config
+	err error
+}
+
+func (e *cdcRetryableError) Error() string {
+	return e.err.Error()
+}
+
+// subscribeRange starts a subscription for each region in [startKey, endKey).
+func (s *cdcSubscription) subscribeRange(bo *retry.Backoffer, startKey, endKey []byte, checkpointTS uint64) error {
+	for {
+		loc, err := s.client.store.GetRegionCache().LocateKey(bo, startKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		regionEndKey := loc.EndKey
+		if len(endKey) > 0 && (len(regionEndKey) == 0 || bytes.Compare(regionEndKey, endKey) > 0) {
+			regionEndKey = endKey
+		}
+		r := &cdcRegion{
+			verID:        loc.Region,
+			startKey:     startKey,
+			endKey:       regionEndKey,
+			checkpointTS: checkpointTS,
+			requestID:    atomic.AddUint64(&s.client.requestID, 1),
+			bo:           bo.Clone(),
+		}
+		s.addRegion(r)
+		s.g.Go(func() error {
+			return s.runRegion(r)
+		})
+		if len(regionEndKey) == 0 || (len(endKey) > 0 && bytes.Compare(regionEndKey, endKey) >= 0) {
+			return nil
+		}
+		startKey = regionEndKey
+	}
+}
+
+func (s *cdcSubscription) runRegion(r *cdcRegion) error {
+	err := s.receiveRegion(r)
+	retryErr, ok := err.(*cdcRetryableError)
+	if !ok {
+		return err
+	}
+	logutil.Logger(s.ctx).Info("resubscribe cdc region",
+		zap.Stringer("region", &r.verID),
+		zap.Uint64("checkpointTS", r.checkpointTS),
+		zap.Error(retryErr.err))
+	if err := r.bo.Backoff(retryErr.cfg, retryErr.err); err != nil {
+		return errors.Trace(err)
+	}
+	// Subscribe to the new regions before removing the old one, so the resolved
+	// timestamp never passes the checkpoint of the range.
+	if err := s.subscribeRange(r.bo, r.startKey, r.endKey, r.checkpointTS); err != nil {
+		return err
+	}
+	s.removeRegion(r)
+	return nil
+}
+
+func (s *cdcSubscription) receiveRegion(r *cdcRegion) error {
+	cache := s.client.store.GetRegionCache()
+	rpcCtx, err := cache.GetTiKVRPCContext(r.bo, r.verID, kv.ReplicaReadLeader, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if rpcCtx == nil {
+		return &cdcRetryableError{cfg: retry.BoRegionMiss, err: errors.Errorf("region %v is not in cache", r.verID)}
+	}
+	conn, err := s.client.getConn(rpcCtx.Addr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	stream, err := cdcpb.NewChangeDataClient(conn).EventFeed(ctx)
+	if err != nil {
+		return s.onStreamError(r, rpcCtx, err)
+	}
+	req := &cdcpb.ChangeDataRequest{
+		Header: &cdcpb.Header{
+			ClusterId:    s.clusterID,
+			TicdcVersion: cdcProtocolVersion,
+		},
+		RegionId:     r.verID.GetID(),
+		RegionEpoch:  rpcCtx.Meta.GetRegionEpoch(),
+		CheckpointTs: r.checkpointTS,
+		StartKey:     codec.EncodeBytes(nil, r.startKey),
+		RequestId:    r.requestID,
+		Request:      &cdcpb.ChangeDataRequest_Register_{Register: &cdcpb.ChangeDataRequest_Register{}},
+	}
+	if len(r.endKey) > 0 {
+		req.EndKey = codec.EncodeBytes(nil, r.endKey)
+	}
+	if err = stream.Send(req); err != nil {
+		return s.onStreamError(r, rpcCtx, err)
+	}
+
+	m := newCDCMatcher()
+	received := false
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return s.onStreamError(r, rpcCtx, err)
+		}
+		if !received {
+			// The region is subscribed successfully, reset the backoff.
+			received = true
+			r.bo = retry.NewBackofferWithVars(s.ctx, cdcRegionMaxBackoff, nil)
+		}
+		for _, event := range resp.GetEvents() {
+			if event.GetRegionId() != r.verID.GetID() {
+				continue
+			}
+			switch e := event.GetEvent().(type) {
+			case *cdcpb.Event_Entries_:
+				for _, row := range e.Entries.GetEntries() {
+					if err := s.handleRow(r, m, row); err != nil {
+						return err
+					}
+				}
+			case *cdcpb.Event_ResolvedTs:
+				if err := s.handleResolved(r, m, e.ResolvedTs); err != nil {
+					return err
+				}
+			case *cdcpb.Event_Error:
+				return s.onRegionError(r, rpcCtx, e.Error)
+			}
+		}
+		if resolved := resp.GetResolvedTs(); resolved != nil {
+			for _, id := range resolved.GetRegions() {
+				if id == r.verID.GetID() {
+					if err := s.handleResolved(r, m, resolved.GetTs()); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+	}
+}
+
+func (s *cdcSubscription) onStreamError(r *cdcRegion, rpcCtx *RPCContext, err error) error {
+	if s.ctx.Err() != nil {
+		return errors.Trace(s.ctx.Err())
+	}
+	s.client.store.GetRegionCache().OnSendFail(r.bo, rpcCtx, true, err)
+	return &cdcRetryableError{cfg: retry.BoTiKVRPC, err: err}
+}
+
+func (s *cdcSubscription) onRegionError(r *cdcRegion, rpcCtx *RPCContext, regionErr *cdcpb.Error) error {
+	cache := s.client.store.GetRegionCache()
+	err := errors.Errorf("cdc region error: %s", regionErr.String())
+	switch {
+	case regionErr.GetNotLeader() != nil:
+		cache.UpdateLeader(r.verID, regionErr.GetNotLeader().GetLeader(), rpcCtx.AccessIdx)
+	case regionErr.GetEpochNotMatch() != nil, regionErr.GetRegionNotFound() != nil:
+		cache.InvalidateCachedRegion(r.verID)
+	default:
+		return err
+	}
+	return &cdcRetryableError{cfg: retry.BoRegionMiss, err: err}
+}
+
+type cdcMatchKey struct {
+	startTS uint64
+	key     string
+}
+
+// cdcMatcher matches the commit rows of a region with the prewrite rows which
+// carry the values.
+type cdcMatcher struct {
+	initialized bool
+	prewrites   map[cdcMatchKey]*cdcpb.Event_Row
+	// unmatched are the commit rows received before the initial scan finishes,
+	// whose prewrite rows may be sent by the initial scan later.
+	unmatched []*cdcpb.Event_Row
+}
+
+func newCDCMatcher() *cdcMatcher {
+	return &cdcMatcher{prewrites: make(map[cdcMatchKey]*cdcpb.Event_Row)}
+}
+
+func (m *cdcMatcher) match(commit *cdcpb.Event_Row) (*cdcpb.Event_Row, bool) {
+	k := cdcMatchKey{startTS: commit.GetStartTs(), key: string(commit.GetKey())}
+	prewrite, ok := m.prewrites[k]
+	if ok {
+		delete(m.prewrites, k)
+	}
+	return prewrite, ok
+}
+
+func (s *cdcSubscription) handleRow(r *cdcRegion, m *cdcMatcher, row *cdcpb.Event_Row) error {
+	switch row.GetType() {
+	case cdcpb.Event_INITIALIZED:
+		m.initialized = true
+		for _, commit := range m.unmatched {
+			prewrite, ok := m.match(commit)
+			if !ok {
+				return errors.Errorf("cdc prewrite not found, region: %v, startTS: %d, key: %q", r.verID, commit.GetStartTs(), commit.GetKey())
+			}
+			if err := s.emitRow(r, prewrite, commit.GetCommitTs()); err != nil {
+				return err
+			}
+		}
+		m.unmatched = nil
+	case cdcpb.Event_COMMITTED:
+		if row.GetCommitTs() > r.checkpointTS {
+			return s.emitRow(r, row, row.GetCommitTs())
+		}
+	case cdcpb.Event_PREWRITE:
+		m.prewrites[cdcMatchKey{startTS: row.GetStartTs(), key: string(row.GetKey())}] = row
+	case cdcpb.Event_COMMIT:
+		prewrite, ok := m.match(row)
+		if row.GetCommitTs() <= r.checkpointTS {
+			return nil
+		}
+		if !ok {
+			if !m.initialized {
+				m.unmatched = append(m.unmatched, row)
+				return nil
+			}
+			return errors.Errorf("cdc prewrite not found, region: %v, startTS: %d, key: %q", r.verID, row.GetStartTs(), row.GetKey())
+		}
+		return s.emitRow(r, prewrite, row.GetCommitTs())
+	case cdcpb.Event_ROLLBACK:
+		m.match(row)
+	}
+	return nil
+}
+
+func (s *cdcSubscription) emitRow(r *cdcRegion, row *cdcpb.Event_Row, commitTS uint64) error {
+	if !r.contains(row.GetKey()) {
+		return nil
+	}
+	event := CDCEvent{
+		Type:     CDCEventRow,
+		Key:      row.GetKey(),
+		Value:    row.GetValue(),
+		CommitTS: commitTS,
+		OpType:   CDCOpPut,
+	}
+	if row.GetOpType() == cdcpb.Event_Row_DELETE {
+		event.OpType = CDCOpDelete
+	}
+	return s.emit(event)
+}
+
+func (s *cdcSubscription) handleResolved(r *cdcRegion, m *cdcMatcher, ts uint64) error {
+	// The resolved timestamp is meaningless before the initial scan finishes.
+	if !m.initialized || ts <= r.checkpointTS {
+		return nil
+	}
+	r.checkpointTS = ts
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.resolved[r.requestID] = ts
+	minResolved := ts
+	for _, resolved := range s.mu.resolved {
+		if resolved < minResolved {
+			minResolved = resolved
+		}
+	}
+	if minResolved <= s.mu.lastResolved {
+		return nil
+	}
+	s.mu.lastResolved = minResolved
+	// Emit with the lock held to keep resolved events in order.
+	return s.emit(CDCEvent{Type: CDCEventResolved, CommitTS: minResolved})
+}
+
+func (s *cdcSubscription) addRegion(r *cdcRegion) {
+	s.mu.Lock()
+	s.mu.resolved[r.requestID] = r.checkpointTS
+	s.mu.Unlock()
+}
+
+func (s *cdcSubscription) removeRegion(r *cdcRegion) {
+	s.mu.Lock()
+	delete(s.mu.resolved, r.requestID)
+	s.mu.Unlock()
+}
+
+func (s *cdcSubscription) emit(event CDCEvent) error {
+	select {
+	case s.eventCh <- event:
+	case <-s.ctx.Done():
+		return errors.Trace(s.ctx.Err())
+	}
+	metrics.TiKVCDCEventsReceivedTotal.WithLabelValues(event.Type.String()).Inc()
+	if event.Type == CDCEventRow {
+		metrics.TiKVCDCEventLag.Observe(time.Since(oracle.GetTimeFromTS(event.CommitTS)).Seconds())
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/cdcpb"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"google.golang.org/grpc"
+)
+
+type mockEventFeed struct {
+	req    *cdcpb.ChangeDataRequest
+	stream cdcpb.ChangeData_EventFeedServer
+}
+
+type mockChangeDataServer struct {
+	feeds chan *mockEventFeed
+}
+
+func (s *mockChangeDataServer) EventFeed(stream cdcpb.ChangeData_EventFeedServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	s.feeds <- &mockEventFeed{req: req, stream: stream}
+	<-stream.Context().Done()
+	return nil
+}
+
+func (f *mockEventFeed) send(t *testing.T, events ...*cdcpb.Event) {
+	for _, e := range events {
+		e.RegionId = f.req.GetRegionId()
+		e.RequestId = f.req.GetRequestId()
+	}
+	require.Nil(t, f.stream.Send(&cdcpb.ChangeDataEvent{Events: events}))
+}
+
+func cdcEntries(rows ...*cdcpb.Event_Row) *cdcpb.Event {
+	return &cdcpb.Event{Event: &cdcpb.Event_Entries_{Entries: &cdcpb.Event_Entries{Entries: rows}}}
+}
+
+func TestCDCClient(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+	cluster := mocktikv.NewCluster(mvccStore)
+	storeID, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	server := grpc.NewServer()
+	cdcServer := &mockChangeDataServer{feeds: make(chan *mockEventFeed, 4)}
+	cdcpb.RegisterChangeDataServer(server, cdcServer)
+	go server.Serve(lis)
+	defer server.Stop()
+	cluster.UpdateStoreAddr(storeID, lis.Addr().String())
+
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	cdc := NewCDCClient(store)
+	defer cdc.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventCh := make(chan CDCEvent)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cdc.Subscribe(ctx, nil, nil, 5, eventCh)
+	}()
+
+	feed := <-cdcServer.feeds
+	require.Equal(t, regionID, feed.req.GetRegionId())
+	require.Equal(t, uint64(5), feed.req.GetCheckpointTs())
+	feed.send(t,
+		cdcEntries(&cdcpb.Event_Row{Type: cdcpb.Event_PREWRITE, StartTs: 6, Key: []byte("a"), Value: []byte("1"), OpType: cdcpb.Event_Row_PUT}),
+		cdcEntries(&cdcpb.Event_Row{Type: cdcpb.Event_INITIALIZED}),
+		cdcEntries(&cdcpb.Event_Row{Type: cdcpb.Event_COMMIT, StartTs: 6, CommitTs: 10, Key: []byte("a")}),
+		&cdcpb.Event{Event: &cdcpb.Event_ResolvedTs{ResolvedTs: 20}},
+	)
+	require.Equal(t, CDCEvent{Type: CDCEventRow, Key: []byte("a"), Value: []byte("1"), CommitTS: 10, OpType: CDCOpPut}, <-eventCh)
+	require.Equal(t, CDCEvent{Type: CDCEventResolved, CommitTS: 20}, <-eventCh)
+
+	// Split the region, the client should subscribe to both regions from the
+	// resolved timestamp.
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("m"), []uint64{ids[1]}, ids[1])
+	feed.send(t, &cdcpb.Event{Event: &cdcpb.Event_Error{Error: &cdcpb.Error{EpochNotMatch: &errorpb.EpochNotMatch{}}}})
+
+	feeds := make(map[uint64]*mockEventFeed)
+	for i := 0; i < 2; i++ {
+		feed = <-cdcServer.feeds
+		require.Equal(t, uint64(20), feed.req.GetCheckpointTs())
+		feeds[feed.req.GetRegionId()] = feed
+	}
+	require.Contains(t, feeds, regionID)
+	require.Contains(t, feeds, ids[0])
+
+	feeds[ids[0]].send(t,
+		cdcEntries(&cdcpb.Event_Row{Type: cdcpb.Event_COMMITTED, StartTs: 25, CommitTs: 30, Key: []byte("z"), OpType: cdcpb.Event_Row_DELETE}),
+		cdcEntries(&cdcpb.Event_Row{Type: cdcpb.Event_INITIALIZED}),
+	)
+	require.Equal(t, CDCEvent{Type: CDCEventRow, Key: []byte("z"), CommitTS: 30, OpType: CDCOpDelete}, <-eventCh)
+
+	// The resolved timestamp only advances when all regions are resolved.
+	feeds[ids[0]].send(t, &cdcpb.Event{Event: &cdcpb.Event_ResolvedTs{ResolvedTs: 40}})
+	feeds[regionID].send(t,
+		cdcEntries(&cdcpb.Event_Row{Type: cdcpb.Event_INITIALIZED}),
+		&cdcpb.Event{Event: &cdcpb.Event_ResolvedTs{ResolvedTs: 35}},
+	)
+	require.Equal(t, CDCEvent{Type: CDCEventResolved, CommitTS: 35}, <-eventCh)
+
+	cancel()
+	require.Equal(t, context.Canceled, errors.Cause(<-errCh))
+}
@@ -33,6 +33,8 @@
 package tikv
 
 import (
+	"fmt"
+
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/prometheus/client_golang/prometheus"
@@ -44,6 +46,49 @@ import (
 	"go.uber.org/zap"
 )
 
+// RollbackStrategy decides how the prewritten locks of a transaction failing
+// to commit are rolled back.
+type RollbackStrategy int
+
+const (
+	// RollbackImmediate rolls back all the locks, in one request per batch.
+	RollbackImmediate RollbackStrategy = iota
+	// RollbackPrimary only rolls back the primary lock. The secondary locks
+	// are resolved by the readers and writers meeting them, who find the
+	// transaction rolled back by checking the primary lock, or are rolled back
+	// after they expire.
+	RollbackPrimary
+	// RollbackCoalesced rolls back all the locks of a region in one request,
+	// without splitting them into batches of the size limit of the other
+	// requests. TiKV can't roll back the locks of different regions in one
+	// request, so it sends one request per region.
+	RollbackCoalesced
+)
+
+// rollbackCoalescedBatchSize is the max size of the keys rolled back in one
+// request by RollbackCoalesced.
+const rollbackCoalescedBatchSize = 1024 * 1024
+
+// String implements fmt.Stringer interface.
+func (s RollbackStrategy) String() string {
+	switch s {
+	case RollbackImmediate:
+		return "immediate"
+	case RollbackPrimary:
+		return "primary"
+	case RollbackCoalesced:
+		return "coalesced"
+	}
+	return fmt.Sprintf("RollbackStrategy(%d)", int(s))
+}
+
+// WithRollbackStrategy sets the RollbackStrategy of the transaction.
+func WithRollbackStrategy(s RollbackStrategy) TxnOption {
+	return func(txn *KVTxn) {
+		txn.rollbackStrategy = s
+	}
+}
+
 type actionCleanup struct{}
 
 var _ twoPhaseCommitAction = actionCleanup{}
@@ -60,7 +105,8 @@ func (actionCleanup) handleSingleBatch(c *twoPhaseCommitter, bo *Backoffer, batc
 	req := tikvrpc.NewRequest(tikvrpc.CmdBatchRollback, &kvrpcpb.BatchRollbackRequest{
 		Keys:         batch.mutations.GetKeys(),
 		StartVersion: c.startTS,
-	}, kvrpcpb.Context{Priority: c.priority, SyncLog: c.syncLog, ResourceGroupTag: c.resourceGroupTag})
+	}, kvrpcpb.Context{Priority: c.priority, SyncLog: c.syncLogMode.syncLog(batch.isPrimary), ResourceGroupTag: c.resourceGroupTag})
+	req.Metadata = c.txn.requestMetadata
 	resp, err := c.store.SendReq(bo, req, batch.region, client.ReadTimeoutShort)
 	if err != nil {
 		return errors.Trace(err)
@@ -90,3 +136,14 @@ func (actionCleanup) handleSingleBatch(c *twoPhaseCommitter, bo *Backoffer, batc
 func (c *twoPhaseCommitter) cleanupMutations(bo *Backoffer, mutations CommitterMutations) error {
 	return c.doActionOnMutations(bo, actionCleanup{}, mutations)
 }
+
+// rollbackMutations returns the mutations rolled back by the cleanup after the
+// transaction fails to commit.
+func (c *twoPhaseCommitter) rollbackMutations() CommitterMutations {
+	if c.rollbackStrategy != RollbackPrimary || len(c.primaryKey) == 0 {
+		return c.mutations
+	}
+	mutations := NewPlainMutations(1)
+	mutations.Push(kvrpcpb.Op_Put, c.primaryKey, nil, false)
+	return &mutations
+}
@@ -33,8 +33,11 @@
 package tikv
 
 import (
+	"io"
+
 	"github.com/tikv/client-go/v2/config"
 	"github.com/tikv/client-go/v2/internal/client"
+	"go.uber.org/zap"
 )
 
 // Client is a client that sends RPC.
@@ -47,7 +50,65 @@ const (
 	ReadTimeoutShort  = client.ReadTimeoutShort
 )
 
+// ClientOption configures the RPC client created by NewRPCClient.
+type ClientOption = client.ClientOption
+
 // NewRPCClient creates a client that manages connections and rpc calls with tikv-servers.
-func NewRPCClient(security config.Security, opts ...func(c *client.RPCClient)) *client.RPCClient {
+func NewRPCClient(security config.Security, opts ...ClientOption) *client.RPCClient {
 	return client.NewRPCClient(security, opts...)
 }
+
+// WithRPCAuditLog makes the RPC client log the type, destination and all
+// kvrpcpb.Context fields of every outgoing RPC at DEBUG level.
+func WithRPCAuditLog(logger *zap.Logger) ClientOption {
+	return client.WithRPCAuditLog(logger)
+}
+
+// TraceSampler decides whether the RPCs of a transaction are traced.
+type TraceSampler = client.TraceSampler
+
+// WithTraceSampler makes the RPC client record the requests and responses of
+// the transactions sampled by s, and emit them when the transactions finish.
+func WithTraceSampler(s TraceSampler) ClientOption {
+	return client.WithTraceSampler(s)
+}
+
+// WithTraceWriter sets the writer the traces of the sampled transactions are
+// written to, they are logged if it's not set.
+func WithTraceWriter(w io.Writer) ClientOption {
+	return client.WithTraceWriter(w)
+}
+
+// QuotaEnforcer limits the reads and writes of the keyspaces sharing a cluster.
+type QuotaEnforcer = client.QuotaEnforcer
+
+// QuotaLimit is the limit of the reads or writes of a keyspace.
+type QuotaLimit = client.QuotaLimit
+
+// TokenBucketEnforcer is a QuotaEnforcer based on token buckets.
+type TokenBucketEnforcer = client.TokenBucketEnforcer
+
+// NewTokenBucketEnforcer creates a TokenBucketEnforcer with the default read
+// and write limits of the keyspaces.
+func NewTokenBucketEnforcer(read, write QuotaLimit) *TokenBucketEnforcer {
+	return client.NewTokenBucketEnforcer(read, write)
+}
+
+// WithQuotaEnforcer makes the RPC client check the prewrite requests against
+// the enforcer before sending them, and the get and scan requests after the
+// responses are received.
+func WithQuotaEnforcer(e QuotaEnforcer) ClientOption {
+	return client.WithQuotaEnforcer(e)
+}
+
+// WithKeyspaceID sets the keyspace the RPC client serves, the requests are
+// charged to it by the QuotaEnforcer.
+func WithKeyspaceID(id uint32) ClientOption {
+	return client.WithKeyspaceID(id)
+}
+
+// WithConfigWatcher makes the RPC client apply the TiKVClient config updates
+// from w to the global config without restarting.
+func WithConfigWatcher(w config.ConfigWatcher) ClientOption {
+	return client.WithConfigWatcher(w)
+}
@@ -53,6 +53,7 @@ type ClientHelper struct {
 	resolvedLocks *util.TSSet
 	client        Client
 	resolveLite   bool
+	senderOpts    []SenderOption
 	locate.RegionRequestRuntimeStats
 }
 
@@ -63,6 +64,7 @@ func NewClientHelper(store *KVStore, resolvedLocks *util.TSSet) *ClientHelper {
 		regionCache:   store.GetRegionCache(),
 		resolvedLocks: resolvedLocks,
 		client:        store.GetTiKVClient(),
+		senderOpts:    store.senderOptions(),
 	}
 }
 
@@ -93,7 +95,7 @@ func (ch *ClientHelper) ResolveLocks(bo *Backoffer, callerStartTS uint64, locks
 
 // SendReqCtx wraps the SendReqCtx function and use the resolved lock result in the kvrpcpb.Context.
 func (ch *ClientHelper) SendReqCtx(bo *Backoffer, req *tikvrpc.Request, regionID locate.RegionVerID, timeout time.Duration, et tikvrpc.EndpointType, directStoreAddr string, opts ...locate.StoreSelectorOption) (*tikvrpc.Response, *locate.RPCContext, string, error) {
-	sender := locate.NewRegionRequestSender(ch.regionCache, ch.client)
+	sender := locate.NewRegionRequestSender(ch.regionCache, ch.client, ch.senderOpts...)
 	if len(directStoreAddr) > 0 {
 		sender.SetStoreAddr(directStoreAddr)
 	}
@@ -68,14 +68,23 @@ func (actionCommit) handleSingleBatch(c *twoPhaseCommitter, bo *Backoffer, batch
 		StartVersion:  c.startTS,
 		Keys:          keys,
 		CommitVersion: c.commitTS,
-	}, kvrpcpb.Context{Priority: c.priority, SyncLog: c.syncLog, ResourceGroupTag: c.resourceGroupTag})
+	}, kvrpcpb.Context{Priority: c.priority, SyncLog: c.syncLogMode.syncLog(batch.isPrimary), ResourceGroupTag: c.resourceGroupTag})
+	req.Metadata = c.txn.requestMetadata
 
 	tBegin := time.Now()
 	attempts := 0
 
-	sender := NewRegionRequestSender(c.store.regionCache, c.store.GetTiKVClient())
+	sender := NewRegionRequestSender(c.store.regionCache, c.store.GetTiKVClient(), c.store.senderOptions()...)
+	sender.SetLeaderWritePolicy(LeaderWriteAlways)
 	for {
 		attempts++
+		// A previous request may have committed the primary key even though
+		// its response is lost, check it before sending the request again.
+		if batch.isPrimary && !c.isAsyncCommit() && c.getUndeterminedErr() != nil {
+			if committed, _, err := c.checkCommitted(bo); err == nil && committed {
+				return nil
+			}
+		}
 		if time.Since(tBegin) > slowRequestThreshold {
 			logutil.BgLogger().Warn("slow commit request", zap.Uint64("startTS", c.startTS), zap.Stringer("region", &batch.region), zap.Int("attempts", attempts))
 			tBegin = time.Now()
@@ -110,7 +119,7 @@ func (actionCommit) handleSingleBatch(c *twoPhaseCommitter, bo *Backoffer, batch
 					return errors.Trace(err)
 				}
 			}
-			same, err := batch.relocate(bo, c.store.regionCache)
+			same, err := c.relocateBatch(bo, &batch)
 			if err != nil {
 				return errors.Trace(err)
 			}
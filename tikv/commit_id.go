@@ -0,0 +1,83 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/pingcap/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+// CommitIDKeyPrefix is the prefix of the keys written by transactions with
+// commit IDs. The keys with the prefix are reserved and must not be written
+// by users.
+var CommitIDKeyPrefix = []byte("_tikv_commit_id/")
+
+// WithCommitID sets the idempotency key of the transaction. If a transaction
+// with the same commit ID has been committed, committing the transaction
+// writes nothing and its CommitTS is the commit ts of the committed one. It
+// makes resubmitting a transaction after an undetermined error safe.
+//
+// The commit ID is written to a key with CommitIDKeyPrefix in the same
+// transaction, the key maps the commit ID to the start ts and the primary key
+// of the transaction.
+func WithCommitID(id []byte) TxnOption {
+	return func(txn *KVTxn) {
+		txn.SetCommitID(id)
+	}
+}
+
+func commitIDKey(id []byte) []byte {
+	key := make([]byte, 0, len(CommitIDKeyPrefix)+len(id))
+	key = append(key, CommitIDKeyPrefix...)
+	return append(key, id...)
+}
+
+// checkCommitID returns true if a transaction with the same commit ID has been
+// committed. Otherwise it writes the commit ID to the transaction, and makes
+// it the primary key if the primary key is not decided.
+func (txn *KVTxn) checkCommitID(ctx context.Context, committer *twoPhaseCommitter) (bool, error) {
+	key := commitIDKey(txn.commitID)
+	value, err := txn.snapshot.Get(ctx, key)
+	if err == nil {
+		if len(value) < 8 {
+			return false, errors.Errorf("invalid commit ID value, len: %d", len(value))
+		}
+		startTS := binary.BigEndian.Uint64(value)
+		status, err := txn.store.lockResolver.GetTxnStatus(startTS, txn.startTS, value[8:])
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if !status.IsCommitted() {
+			return false, errors.Errorf("txn %d with the same commit ID is not committed", startTS)
+		}
+		txn.commitTS = status.CommitTS()
+		return true, nil
+	}
+	if !tikverr.IsErrNotFound(err) {
+		return false, errors.Trace(err)
+	}
+
+	primary := committer.primaryKey
+	if len(primary) == 0 {
+		primary = key
+		committer.primaryKey = key
+	}
+	value = make([]byte, 8, 8+len(primary))
+	binary.BigEndian.PutUint64(value, txn.startTS)
+	value = append(value, primary...)
+	return false, errors.Trace(txn.us.GetMemBuffer().Set(key, value))
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/internal/retry"
+	"go.uber.org/zap"
+)
+
+// CommitIdempotencyCheck checks the primary key of the transaction by
+// CheckTxnStatus and returns true with the commitTS if the transaction is
+// committed. It tells whether a commit that failed with an undetermined result
+// has succeeded, so the caller doesn't commit it again. It never rolls back the
+// transaction.
+func (txn *KVTxn) CommitIdempotencyCheck(ctx context.Context) (bool, uint64, error) {
+	if txn.committer == nil || txn.committer.mutations == nil || txn.committer.mutations.Len() == 0 {
+		return false, 0, errors.New("transaction has no prewritten keys to check")
+	}
+	bo := retry.NewBackofferWithVars(ctx, cleanupMaxBackoff, txn.vars)
+	status, err := txn.committer.checkTxnStatus(bo)
+	if err != nil {
+		return false, 0, errors.Trace(err)
+	}
+	return status.IsCommitted(), status.CommitTS(), nil
+}
+
+// checkTxnStatus gets the status of the transaction from its primary key. The
+// current TS is 0, so the primary lock isn't rolled back even if it's expired,
+// and a missing primary lock is reported as rolled back.
+func (c *twoPhaseCommitter) checkTxnStatus(bo *Backoffer) (TxnStatus, error) {
+	status, err := c.store.lockResolver.getTxnStatus(bo, c.startTS, c.primary(), 0, 0, false, false, nil)
+	if err != nil {
+		if _, ok := errors.Cause(err).(txnNotFoundErr); ok {
+			return TxnStatus{}, nil
+		}
+		return TxnStatus{}, errors.Trace(err)
+	}
+	return status, nil
+}
+
+// checkCommitted checks whether the primary key has been committed by a commit
+// request whose response is lost, and marks the transaction committed if so.
+// It returns true if the transaction is committed, and false with the status
+// otherwise.
+func (c *twoPhaseCommitter) checkCommitted(bo *Backoffer) (bool, TxnStatus, error) {
+	status, err := c.checkTxnStatus(bo)
+	if err != nil {
+		return false, status, errors.Trace(err)
+	}
+	if !status.IsCommitted() {
+		return false, status, nil
+	}
+	logutil.Logger(bo.GetCtx()).Info("2PC found the primary key committed by an undetermined commit request",
+		zap.Uint64("txnStartTS", c.startTS), zap.Uint64("commitTS", status.CommitTS()))
+	c.mu.Lock()
+	c.commitTS = status.CommitTS()
+	c.mu.committed = true
+	c.mu.undeterminedErr = nil
+	c.mu.Unlock()
+	return true, status, nil
+}
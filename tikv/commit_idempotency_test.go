@@ -0,0 +1,151 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+// lostCommitRespClient sends the commit requests to TiKV but reports them as
+// failed, like the responses are lost.
+type lostCommitRespClient struct {
+	Client
+}
+
+func (c *lostCommitRespClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	resp, err := c.Client.SendRequest(ctx, addr, req, timeout)
+	if req.Type == tikvrpc.CmdCommit && err == nil {
+		return nil, errors.New("commit response lost")
+	}
+	return resp, err
+}
+
+func TestCommitIdempotencyCheck(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k"), []byte("v")))
+	_, _, err = txn.CommitIdempotencyCheck(ctx)
+	require.NotNil(t, err)
+	require.Nil(t, txn.Commit(ctx))
+	committed, commitTS, err := txn.CommitIdempotencyCheck(ctx)
+	require.Nil(t, err)
+	require.True(t, committed)
+	require.Equal(t, txn.CommitTS(), commitTS)
+}
+
+func TestCommitResultDeterminedByCheck(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := &lostCommitRespClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	atomic.StoreUint64(&VeryLongMaxBackoff, 100)
+	defer atomic.StoreUint64(&VeryLongMaxBackoff, 600000)
+
+	// The commit succeeds although all its responses are lost.
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k"), []byte("v")))
+	require.Nil(t, txn.Commit(context.Background()))
+	committed, commitTS, err := txn.CommitIdempotencyCheck(context.Background())
+	require.Nil(t, err)
+	require.True(t, committed)
+	require.Equal(t, txn.CommitTS(), commitTS)
+}
+
+// lostPrimaryRespClient loses the response of the first commit request of the
+// primary key, and fails the later requests of the primary key and the first
+// CheckTxnStatus by invalid responses, so the committer gives up committing
+// the primary key before it finds the key committed. The committed secondary
+// keys are recorded.
+type lostPrimaryRespClient struct {
+	Client
+	primary []byte
+
+	mu        sync.Mutex
+	lost      bool
+	checked   bool
+	committed []string
+}
+
+func (c *lostPrimaryRespClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	invalid := &tikvrpc.Response{Resp: &kvrpcpb.Mutation{}}
+	switch req.Type {
+	case tikvrpc.CmdCheckTxnStatus:
+		if !c.checked {
+			c.checked = true
+			return invalid, nil
+		}
+	case tikvrpc.CmdCommit:
+		keys := req.Commit().Keys
+		if len(keys) > 0 && bytes.Equal(keys[0], c.primary) {
+			if c.lost {
+				return invalid, nil
+			}
+			c.lost = true
+			if _, err := c.Client.SendRequest(ctx, addr, req, timeout); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("commit response lost")
+		}
+		for _, key := range keys {
+			c.committed = append(c.committed, string(key))
+		}
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestCommitSecondariesAfterCheck(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("k2"), []uint64{ids[1]}, ids[1])
+	client := &lostPrimaryRespClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil), primary: []byte("k1")}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	// The commit of the primary key fails, then the check finds the primary key
+	// committed, and the secondary key is committed in background.
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k1"), []byte("v1")))
+	require.Nil(t, txn.Set([]byte("k2"), []byte("v2")))
+	require.Nil(t, txn.Commit(context.Background()))
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.committed) == 1 && client.committed[0] == "k2"
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+// CommitMode is the commit protocol of a transaction.
+type CommitMode int
+
+// The commit protocols, from the fastest to the most general one.
+const (
+	// OnePCMode commits the transaction by the prewrite requests.
+	OnePCMode CommitMode = iota
+	// AsyncCommitMode returns once the prewrite requests succeed and commits
+	// the keys in background.
+	AsyncCommitMode
+	// TwoPCMode commits the primary key after all keys are prewritten.
+	TwoPCMode
+)
+
+func (m CommitMode) String() string {
+	switch m {
+	case OnePCMode:
+		return "1pc"
+	case AsyncCommitMode:
+		return "async-commit"
+	case TwoPCMode:
+		return "2pc"
+	}
+	return "unknown"
+}
+
+// PrewriteModePredictor predicts the fastest commit protocol of a transaction
+// by the estimated size of its mutations in bytes and the estimated number of
+// regions they belong to. The prediction only narrows down the protocols
+// enabled for the transaction: 1PC and async commit are still used only if
+// they are enabled and the transaction is eligible for them.
+type PrewriteModePredictor interface {
+	Predict(estimatedBytes, regionCount int) CommitMode
+}
+
+const (
+	defaultOnePCMaxBytes         = 1 << 20
+	defaultAsyncCommitMaxBytes   = 16 << 20
+	defaultAsyncCommitMaxRegions = 16
+)
+
+// DefaultPrewriteModePredictor predicts the commit protocol by thresholds.
+// 1PC writes all mutations in one raft proposal, which blocks the region
+// longer than the pipelined prewrite and commit of async commit when it's
+// large, so 1PC is only used for small single-region transactions. Async
+// commit has to check the secondaries of the transaction on resolving locks,
+// so it's not used for the transactions of many regions or bytes.
+type DefaultPrewriteModePredictor struct {
+	// OnePCMaxBytes is the max size of a 1PC transaction.
+	OnePCMaxBytes int
+	// AsyncCommitMaxBytes is the max size of an async commit transaction.
+	AsyncCommitMaxBytes int
+	// AsyncCommitMaxRegions is the max number of regions of an async commit
+	// transaction.
+	AsyncCommitMaxRegions int
+}
+
+// NewDefaultPrewriteModePredictor creates a DefaultPrewriteModePredictor with
+// the default thresholds.
+func NewDefaultPrewriteModePredictor() *DefaultPrewriteModePredictor {
+	return &DefaultPrewriteModePredictor{
+		OnePCMaxBytes:         defaultOnePCMaxBytes,
+		AsyncCommitMaxBytes:   defaultAsyncCommitMaxBytes,
+		AsyncCommitMaxRegions: defaultAsyncCommitMaxRegions,
+	}
+}
+
+// Predict implements PrewriteModePredictor.
+func (p *DefaultPrewriteModePredictor) Predict(estimatedBytes, regionCount int) CommitMode {
+	if regionCount <= 1 && estimatedBytes <= p.OnePCMaxBytes {
+		return OnePCMode
+	}
+	if regionCount <= p.AsyncCommitMaxRegions && estimatedBytes <= p.AsyncCommitMaxBytes {
+		return AsyncCommitMode
+	}
+	return TwoPCMode
+}
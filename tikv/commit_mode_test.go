@@ -0,0 +1,63 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util"
+)
+
+type fixedPrewriteModePredictor CommitMode
+
+func (p fixedPrewriteModePredictor) Predict(int, int) CommitMode {
+	return CommitMode(p)
+}
+
+func TestDefaultPrewriteModePredictor(t *testing.T) {
+	p := NewDefaultPrewriteModePredictor()
+	for _, c := range []struct {
+		bytes   int
+		regions int
+		mode    CommitMode
+	}{
+		{100, 1, OnePCMode},
+		{defaultOnePCMaxBytes, 1, OnePCMode},
+		{defaultOnePCMaxBytes + 1, 1, AsyncCommitMode},
+		{100, 2, AsyncCommitMode},
+		{defaultAsyncCommitMaxBytes, defaultAsyncCommitMaxRegions, AsyncCommitMode},
+		{defaultAsyncCommitMaxBytes + 1, 2, TwoPCMode},
+		{100, defaultAsyncCommitMaxRegions + 1, TwoPCMode},
+	} {
+		require.Equal(t, c.mode, p.Predict(c.bytes, c.regions), "%d bytes, %d regions", c.bytes, c.regions)
+	}
+}
+
+func TestPrewriteModePredictor(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.WithValue(context.Background(), util.SessionID, uint64(1))
+
+	for _, mode := range []CommitMode{OnePCMode, TwoPCMode} {
+		store.SetPrewriteModePredictor(fixedPrewriteModePredictor(mode))
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		txn.SetEnable1PC(true)
+		require.Nil(t, txn.Set([]byte("k"), []byte(mode.String())))
+		require.Nil(t, txn.Commit(ctx))
+		require.Equal(t, mode == OnePCMode, txn.committer.hasTriedOnePC, mode.String())
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/util/codec"
+)
+
+// committerStateVersion is the version of the encoding format of CommitterState.
+const committerStateVersion byte = 1
+
+// CommitterStateFlags records the transaction options that affect how the
+// committer commits the mutations.
+type CommitterStateFlags uint64
+
+const (
+	// CommitterStatePessimistic means the transaction is pessimistic.
+	CommitterStatePessimistic CommitterStateFlags = 1 << iota
+	// CommitterStateAsyncCommit means the transaction tries to use async commit.
+	CommitterStateAsyncCommit
+	// CommitterStateOnePC means the transaction tries to use 1PC.
+	CommitterStateOnePC
+	// CommitterStateCausalConsistency means the transaction doesn't need linearizability.
+	CommitterStateCausalConsistency
+)
+
+// CommitterState is the state of a committer that can be handed off to another
+// process, which restores it into a transaction with the same startTS and
+// completes the commit.
+type CommitterState struct {
+	StartTS     uint64
+	ForUpdateTS uint64
+	MinCommitTS uint64
+	MaxCommitTS uint64
+	PrimaryKey  []byte
+	Mutations   PlainMutations
+	Flags       CommitterStateFlags
+}
+
+// Marshal encodes the state into a compact binary format.
+func (s *CommitterState) Marshal() ([]byte, error) {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, committerStateVersion)
+	buf = codec.EncodeUvarint(buf, s.StartTS)
+	buf = codec.EncodeUvarint(buf, s.ForUpdateTS)
+	buf = codec.EncodeUvarint(buf, s.MinCommitTS)
+	buf = codec.EncodeUvarint(buf, s.MaxCommitTS)
+	buf = codec.EncodeUvarint(buf, uint64(s.Flags))
+	buf = encodeStateBytes(buf, s.PrimaryKey)
+	m := &s.Mutations
+	buf = codec.EncodeUvarint(buf, uint64(m.Len()))
+	for i := 0; i < m.Len(); i++ {
+		buf = codec.EncodeUvarint(buf, uint64(m.GetOp(i)))
+		if m.IsPessimisticLock(i) {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		buf = encodeStateBytes(buf, m.GetKey(i))
+		buf = encodeStateBytes(buf, m.GetValue(i))
+	}
+	return buf, nil
+}
+
+// UnmarshalCommitterState decodes a CommitterState encoded by CommitterState.Marshal.
+func UnmarshalCommitterState(data []byte) (*CommitterState, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty committer state")
+	}
+	if data[0] != committerStateVersion {
+		return nil, errors.Errorf("unsupported committer state version %d", data[0])
+	}
+	b := data[1:]
+	var (
+		s     CommitterState
+		flags uint64
+		cnt   uint64
+		err   error
+	)
+	for _, v := range []*uint64{&s.StartTS, &s.ForUpdateTS, &s.MinCommitTS, &s.MaxCommitTS, &flags} {
+		if b, *v, err = codec.DecodeUvarint(b); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	s.Flags = CommitterStateFlags(flags)
+	if b, s.PrimaryKey, err = decodeStateBytes(b); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if b, cnt, err = codec.DecodeUvarint(b); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cnt > uint64(len(b)) {
+		return nil, errors.Errorf("invalid mutation count %d", cnt)
+	}
+	s.Mutations = NewPlainMutations(int(cnt))
+	for i := uint64(0); i < cnt; i++ {
+		var (
+			op         uint64
+			key, value []byte
+		)
+		if b, op, err = codec.DecodeUvarint(b); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(b) == 0 {
+			return nil, errors.New("insufficient bytes to decode mutation")
+		}
+		isPessimisticLock := b[0] == 1
+		b = b[1:]
+		if b, key, err = decodeStateBytes(b); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if b, value, err = decodeStateBytes(b); err != nil {
+			return nil, errors.Trace(err)
+		}
+		s.Mutations.Push(kvrpcpb.Op(op), key, value, isPessimisticLock)
+	}
+	if len(b) != 0 {
+		return nil, errors.Errorf("%d bytes left after decoding committer state", len(b))
+	}
+	return &s, nil
+}
+
+func encodeStateBytes(b []byte, data []byte) []byte {
+	b = codec.EncodeUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func decodeStateBytes(b []byte) ([]byte, []byte, error) {
+	b, n, err := codec.DecodeUvarint(b)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if uint64(len(b)) < n {
+		return nil, nil, errors.New("insufficient bytes to decode value")
+	}
+	if n == 0 {
+		return b, nil, nil
+	}
+	data := make([]byte, n)
+	copy(data, b[:n])
+	return b[n:], data, nil
+}
+
+// state captures the current state of the committer. The mutations must be
+// initialized by initKeysAndMutations.
+func (c *twoPhaseCommitter) state() *CommitterState {
+	s := &CommitterState{
+		StartTS:     c.startTS,
+		ForUpdateTS: c.forUpdateTS,
+		MinCommitTS: c.minCommitTS,
+		MaxCommitTS: c.maxCommitTS,
+		PrimaryKey:  c.primary(),
+		Mutations:   NewPlainMutations(c.mutations.Len()),
+	}
+	for i := 0; i < c.mutations.Len(); i++ {
+		s.Mutations.Push(c.mutations.GetOp(i), c.mutations.GetKey(i), c.mutations.GetValue(i), c.mutations.IsPessimisticLock(i))
+	}
+	if c.isPessimistic {
+		s.Flags |= CommitterStatePessimistic
+	}
+	if c.txn.enableAsyncCommit {
+		s.Flags |= CommitterStateAsyncCommit
+	}
+	if c.txn.enable1PC {
+		s.Flags |= CommitterStateOnePC
+	}
+	if c.txn.causalConsistency {
+		s.Flags |= CommitterStateCausalConsistency
+	}
+	return s
+}
+
+// RestoreState restores the committer from the state encoded by CommitterState.Marshal.
+// The mutations are written back to the memory buffer of the transaction, so
+// the transaction must have the same startTS as the state and must be empty.
+func (c *twoPhaseCommitter) RestoreState(state []byte) error {
+	s, err := UnmarshalCommitterState(state)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	txn := c.txn
+	if s.StartTS != txn.StartTS() {
+		return errors.Errorf("committer state startTS %d doesn't match txn startTS %d", s.StartTS, txn.StartTS())
+	}
+	if txn.Len() != 0 {
+		return errors.Errorf("cannot restore committer state into a non-empty txn %d", txn.StartTS())
+	}
+
+	txn.SetPessimistic(s.Flags&CommitterStatePessimistic != 0)
+	txn.SetEnableAsyncCommit(s.Flags&CommitterStateAsyncCommit != 0)
+	txn.SetEnable1PC(s.Flags&CommitterStateOnePC != 0)
+	txn.SetCausalConsistency(s.Flags&CommitterStateCausalConsistency != 0)
+
+	memBuf := txn.GetMemBuffer()
+	for i := 0; i < s.Mutations.Len(); i++ {
+		key, value := s.Mutations.GetKey(i), s.Mutations.GetValue(i)
+		var ops []kv.FlagsOp
+		if s.Mutations.IsPessimisticLock(i) {
+			ops = append(ops, kv.SetKeyLocked)
+			txn.lockedCnt++
+		}
+		switch op := s.Mutations.GetOp(i); op {
+		case kvrpcpb.Op_Put:
+			err = memBuf.SetWithFlags(key, value, ops...)
+		case kvrpcpb.Op_Insert:
+			err = memBuf.SetWithFlags(key, value, append(ops, kv.SetPresumeKeyNotExists)...)
+		case kvrpcpb.Op_Del:
+			err = memBuf.DeleteWithFlags(key, ops...)
+		case kvrpcpb.Op_CheckNotExists:
+			err = memBuf.DeleteWithFlags(key, append(ops, kv.SetPresumeKeyNotExists)...)
+		case kvrpcpb.Op_Lock:
+			memBuf.UpdateFlags(key, append(ops, kv.SetKeyLocked)...)
+		default:
+			err = errors.Errorf("unexpected mutation op %s in committer state", op)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	c.isPessimistic = txn.IsPessimistic()
+	c.forUpdateTS = s.ForUpdateTS
+	c.minCommitTS = s.MinCommitTS
+	c.maxCommitTS = s.MaxCommitTS
+	c.primaryKey = s.PrimaryKey
+	return nil
+}
+
+// GetCommitterState initializes the committer of the transaction and returns
+// its state, which can be used to complete the commit in another process by
+// RestoreCommitterState.
+func (txn *KVTxn) GetCommitterState(sessionID uint64) (*CommitterState, error) {
+	if txn.committer == nil {
+		committer, err := newTwoPhaseCommitter(txn, sessionID)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		txn.committer = committer
+	}
+	if err := txn.committer.initKeysAndMutations(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return txn.committer.state(), nil
+}
+
+// RestoreCommitterState restores the committer state encoded by CommitterState.Marshal
+// into the transaction. The transaction must be started with the same startTS and
+// can be committed by Commit afterwards.
+func (txn *KVTxn) RestoreCommitterState(sessionID uint64, state []byte) error {
+	committer, err := newTwoPhaseCommitter(txn, sessionID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = committer.RestoreState(state); err != nil {
+		return errors.Trace(err)
+	}
+	txn.committer = committer
+	return nil
+}
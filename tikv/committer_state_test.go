@@ -0,0 +1,80 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+)
+
+func TestCommitterStateMarshal(t *testing.T) {
+	s := &CommitterState{
+		StartTS:     100,
+		ForUpdateTS: 101,
+		MinCommitTS: 102,
+		MaxCommitTS: 200,
+		PrimaryKey:  []byte("a"),
+		Mutations:   NewPlainMutations(3),
+		Flags:       CommitterStatePessimistic | CommitterStateAsyncCommit,
+	}
+	s.Mutations.Push(kvrpcpb.Op_Put, []byte("a"), []byte("v"), true)
+	s.Mutations.Push(kvrpcpb.Op_Del, []byte("b"), nil, false)
+	s.Mutations.Push(kvrpcpb.Op_Lock, []byte("c"), nil, true)
+
+	data, err := s.Marshal()
+	require.Nil(t, err)
+	s2, err := UnmarshalCommitterState(data)
+	require.Nil(t, err)
+	require.Equal(t, s, s2)
+
+	_, err = UnmarshalCommitterState(data[:len(data)-1])
+	require.NotNil(t, err)
+	_, err = UnmarshalCommitterState(append(data, 0))
+	require.NotNil(t, err)
+}
+
+func TestCommitterStateRestore(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k1"), []byte("v1")))
+	require.Nil(t, txn.Set([]byte("k2"), []byte("v2")))
+	state, err := txn.GetCommitterState(0)
+	require.Nil(t, err)
+	data, err := state.Marshal()
+	require.Nil(t, err)
+
+	txn2, err := store.BeginWithOption(DefaultStartTSOption().SetStartTS(txn.StartTS()))
+	require.Nil(t, err)
+	require.Nil(t, txn2.RestoreCommitterState(0, data))
+	require.Equal(t, []byte("k1"), txn2.committer.primary())
+	require.Nil(t, txn2.Commit(context.Background()))
+
+	snap := store.GetSnapshot(txn2.commitTS)
+	val, err := snap.Get(context.Background(), []byte("k2"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v2"), val)
+}
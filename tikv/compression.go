@@ -0,0 +1,134 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"encoding/binary"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/util"
+)
+
+// ValueCompressor compresses the values written by transactions. The values
+// are compressed before they are encrypted.
+type ValueCompressor interface {
+	Compress(value []byte) ([]byte, error)
+}
+
+// ValueDecompressor decompresses the values compressed by a ValueCompressor.
+// The values are decompressed after they are decrypted.
+type ValueDecompressor interface {
+	Decompress(value []byte) ([]byte, error)
+}
+
+// WithValueCompression makes the transaction compress the values by c when
+// prewriting and decompress the values read by its snapshot by d. All values
+// in the keyspace accessed by the transaction must be compressed by c.
+func WithValueCompression(c ValueCompressor, d ValueDecompressor) TxnOption {
+	return func(txn *KVTxn) {
+		txn.SetValueCompression(c, d)
+	}
+}
+
+func compressValue(c ValueCompressor, value []byte) ([]byte, error) {
+	var compressed []byte
+	err := util.SafeCall(func() (err error) {
+		compressed, err = c.Compress(value)
+		return err
+	}, errors.New("ValueCompressor panicked on Compress"))
+	return compressed, errors.Trace(err)
+}
+
+func decompressValue(d ValueDecompressor, value []byte) ([]byte, error) {
+	var decompressed []byte
+	err := util.SafeCall(func() (err error) {
+		decompressed, err = d.Decompress(value)
+		return err
+	}, errors.New("ValueDecompressor panicked on Decompress"))
+	return decompressed, errors.Trace(err)
+}
+
+// Delta encoding formats, stored in the first byte of the compressed values.
+const (
+	deltaFormatRaw byte = iota
+	deltaFormatDelta
+)
+
+// deltaWordLen is the length of the numbers in the delta encoded values.
+const deltaWordLen = 8
+
+// DeltaValueEncoder is a ValueCompressor for time-series values. A value that
+// is a series of big-endian 8-byte numbers, such as int64s or the bits of
+// float64s, is encoded as the first number followed by the deltas of the
+// consecutive numbers in zigzag varints, which takes 1 or 2 bytes per number
+// for smooth series. Other values and the values not shortened by the encoding
+// are stored as is.
+type DeltaValueEncoder struct{}
+
+// Compress implements ValueCompressor.
+func (DeltaValueEncoder) Compress(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	if len(value) >= 2*deltaWordLen && len(value)%deltaWordLen == 0 {
+		buf := make([]byte, 1, len(value))
+		buf[0] = deltaFormatDelta
+		var prev uint64
+		var tmp [binary.MaxVarintLen64]byte
+		for i := 0; i < len(value) && len(buf) < len(value); i += deltaWordLen {
+			word := binary.BigEndian.Uint64(value[i:])
+			n := binary.PutVarint(tmp[:], int64(word-prev))
+			buf = append(buf, tmp[:n]...)
+			prev = word
+		}
+		if len(buf) < len(value) {
+			return buf, nil
+		}
+	}
+	buf := make([]byte, 1, 1+len(value))
+	buf[0] = deltaFormatRaw
+	return append(buf, value...), nil
+}
+
+// DeltaValueDecoder is the ValueDecompressor of DeltaValueEncoder.
+type DeltaValueDecoder struct{}
+
+// Decompress implements ValueDecompressor.
+func (DeltaValueDecoder) Decompress(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	switch value[0] {
+	case deltaFormatRaw:
+		return value[1:], nil
+	case deltaFormatDelta:
+		b := value[1:]
+		buf := make([]byte, 0, len(b)*deltaWordLen)
+		var word uint64
+		var tmp [deltaWordLen]byte
+		for len(b) > 0 {
+			delta, n := binary.Varint(b)
+			if n <= 0 {
+				return nil, errors.New("invalid delta encoded value")
+			}
+			b = b[n:]
+			word += uint64(delta)
+			binary.BigEndian.PutUint64(tmp[:], word)
+			buf = append(buf, tmp[:]...)
+		}
+		return buf, nil
+	default:
+		return nil, errors.Errorf("unknown delta encoding format %d", value[0])
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func encodeSeries(n int, f func(i int) uint64) []byte {
+	b := make([]byte, n*8)
+	for i := 0; i < n; i++ {
+		binary.BigEndian.PutUint64(b[i*8:], f(i))
+	}
+	return b
+}
+
+func TestDeltaValueEncoding(t *testing.T) {
+	ints := encodeSeries(100, func(i int) uint64 { return uint64(int64(1000000 + i*3 - (i%5)*7)) })
+	decreasing := encodeSeries(100, func(i int) uint64 { return uint64(int64(-i * 5)) })
+	floats := encodeSeries(100, func(i int) uint64 { return math.Float64bits(20 + float64(i)*0.5) })
+	random := encodeSeries(4, func(i int) uint64 { return uint64(i) * 0x9e3779b97f4a7c15 })
+	cases := []struct {
+		value    []byte
+		minRatio int
+	}{
+		{ints, 3},
+		{decreasing, 3},
+		{floats, 1},
+		{random, 0},
+		{[]byte("not a number"), 0},
+		{[]byte{}, 0},
+	}
+	var enc DeltaValueEncoder
+	var dec DeltaValueDecoder
+	for _, c := range cases {
+		compressed, err := enc.Compress(c.value)
+		require.Nil(t, err)
+		require.LessOrEqual(t, len(compressed), len(c.value)+1)
+		if c.minRatio > 0 {
+			require.GreaterOrEqual(t, len(c.value)/len(compressed), c.minRatio)
+		}
+		value, err := dec.Decompress(compressed)
+		require.Nil(t, err)
+		require.Equal(t, c.value, value)
+	}
+
+	_, err := dec.Decompress([]byte{deltaFormatDelta, 0x80})
+	require.NotNil(t, err)
+	_, err = dec.Decompress([]byte{0xff})
+	require.NotNil(t, err)
+}
+
+func TestValueCompression(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+	series := encodeSeries(64, func(i int) uint64 { return uint64(1600000000 + i*10) })
+
+	txn, err := store.Begin(WithValueCompression(DeltaValueEncoder{}, DeltaValueDecoder{}))
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("s1"), series))
+	require.Nil(t, txn.Set([]byte("s2"), []byte("v2")))
+	require.Nil(t, txn.Commit(ctx))
+
+	// The values are stored compressed.
+	val, err := store.GetSnapshot(math.MaxUint64).Get(ctx, []byte("s1"))
+	require.Nil(t, err)
+	require.Less(t, len(val)*3, len(series))
+
+	txn, err = store.Begin(WithValueCompression(DeltaValueEncoder{}, DeltaValueDecoder{}))
+	require.Nil(t, err)
+	val, err = txn.Get(ctx, []byte("s1"))
+	require.Nil(t, err)
+	require.Equal(t, series, val)
+	vals, err := txn.BatchGet(ctx, [][]byte{[]byte("s1"), []byte("s2")})
+	require.Nil(t, err)
+	require.Equal(t, series, vals["s1"])
+	require.Equal(t, []byte("v2"), vals["s2"])
+	it, err := txn.Iter([]byte("s"), nil)
+	require.Nil(t, err)
+	require.Equal(t, series, it.Value())
+	require.Nil(t, it.Next())
+	require.Equal(t, []byte("v2"), it.Value())
+	it.Close()
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"math"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/internal/retry"
+	"github.com/tikv/client-go/v2/kv"
+)
+
+const bulkConflictCheckMaxBackoff = 20000
+
+// ConflictInfo describes a key of the mutations that is locked by another
+// transaction.
+type ConflictInfo struct {
+	ConflictingKey   []byte
+	LockOwnerStartTS uint64
+	LockTTL          uint64
+}
+
+// BulkConflictCheck finds the keys of mutations that are locked by other
+// transactions, which would block the prewrite of the mutations. It scans the
+// locks between the first and the last key of the mutations, which must be
+// sorted, and writes nothing.
+//
+// The result is only a hint: locks may be added or resolved after the check.
+// Because all locks in the range are scanned, the check is expensive if the
+// mutations are sparse in a large range.
+func (s *KVStore) BulkConflictCheck(ctx context.Context, mutations CommitterMutations) ([]ConflictInfo, error) {
+	if mutations.Len() == 0 {
+		return nil, nil
+	}
+	keys := make(map[string]struct{}, mutations.Len())
+	for i := 0; i < mutations.Len(); i++ {
+		keys[string(mutations.GetKey(i))] = struct{}{}
+	}
+	key := mutations.GetKey(0)
+	endKey := kv.NextKey(mutations.GetKey(mutations.Len() - 1))
+
+	var conflicts []ConflictInfo
+	bo := retry.NewBackofferWithVars(ctx, bulkConflictCheckMaxBackoff, nil)
+	for {
+		locks, loc, err := s.scanLocksInRegionWithStartKey(bo, key, math.MaxUint64, gcScanLockLimit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, lock := range locks {
+			if _, ok := keys[string(lock.Key)]; ok {
+				conflicts = append(conflicts, ConflictInfo{
+					ConflictingKey:   lock.Key,
+					LockOwnerStartTS: lock.TxnID,
+					LockTTL:          lock.TTL,
+				})
+			}
+		}
+		if len(locks) < gcScanLockLimit {
+			key = loc.EndKey
+		} else {
+			key = kv.NextKey(locks[len(locks)-1].Key)
+		}
+		if len(key) == 0 || bytes.Compare(key, endKey) >= 0 {
+			return conflicts, nil
+		}
+	}
+}
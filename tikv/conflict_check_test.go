@@ -0,0 +1,62 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+)
+
+func TestBulkConflictCheck(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("c"), []uint64{ids[1]}, ids[1])
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	// Leave locks on a, b, d by prewriting without commit.
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for _, k := range []string{"a", "b", "d"} {
+		require.Nil(t, txn.Set([]byte(k), []byte(k)))
+	}
+	committer, err := newTwoPhaseCommitterWithInit(txn, 0)
+	require.Nil(t, err)
+	committer.lockTTL = 3000
+	require.Nil(t, committer.prewriteMutations(NewBackofferWithVars(context.Background(), 5000, nil), committer.mutations))
+
+	mutations := NewPlainMutations(3)
+	for _, k := range []string{"b", "c", "d"} {
+		mutations.Push(kvrpcpb.Op_Put, []byte(k), []byte(k), false)
+	}
+	conflicts, err := store.BulkConflictCheck(context.Background(), &mutations)
+	require.Nil(t, err)
+	require.Equal(t, []ConflictInfo{
+		{ConflictingKey: []byte("b"), LockOwnerStartTS: txn.StartTS(), LockTTL: 3000},
+		{ConflictingKey: []byte("d"), LockOwnerStartTS: txn.StartTS(), LockTTL: 3000},
+	}, conflicts)
+
+	conflicts, err = store.BulkConflictCheck(context.Background(), &PlainMutations{})
+	require.Nil(t, err)
+	require.Empty(t, conflicts)
+}
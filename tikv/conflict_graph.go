@@ -0,0 +1,100 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import "sync"
+
+// ConflictGraph records which transactions of the KVStore are waiting for the
+// locks of which transactions when acquiring pessimistic locks. A cycle in the
+// graph is a deadlock among the transactions of this client, which is reported
+// without waiting for TiKV to detect it or for the lock wait to time out.
+//
+// TiKV runs its own deadlock detector covering the transactions of all the
+// clients, the graph only shortens the detection of the local ones.
+type ConflictGraph struct {
+	mu sync.Mutex
+	// edges[a][b] is the number of lock waits of a for the locks of b. A
+	// transaction waits in every region it locks in parallel, so the edges are
+	// counted.
+	edges map[uint64]map[uint64]int
+}
+
+// NewConflictGraph creates an empty ConflictGraph.
+func NewConflictGraph() *ConflictGraph {
+	return &ConflictGraph{edges: make(map[uint64]map[uint64]int)}
+}
+
+// waitFor records that txn a waits for a lock of txn b.
+func (g *ConflictGraph) waitFor(a, b uint64) {
+	if a == b {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	waits, ok := g.edges[a]
+	if !ok {
+		waits = make(map[uint64]int)
+		g.edges[a] = waits
+	}
+	waits[b]++
+}
+
+// removeWait removes an edge recorded by waitFor.
+func (g *ConflictGraph) removeWait(a, b uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	waits, ok := g.edges[a]
+	if !ok {
+		return
+	}
+	if waits[b]--; waits[b] <= 0 {
+		delete(waits, b)
+	}
+	if len(waits) == 0 {
+		delete(g.edges, a)
+	}
+}
+
+// findCycle returns the transactions in a cycle starting from txn, or nil if
+// txn is not in any cycle. The first and the last elements of the cycle are
+// both txn.
+func (g *ConflictGraph) findCycle(txn uint64) []uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	visited := make(map[uint64]struct{})
+	path := []uint64{txn}
+	var dfs func(cur uint64) bool
+	dfs = func(cur uint64) bool {
+		for next := range g.edges[cur] {
+			if next == txn {
+				path = append(path, next)
+				return true
+			}
+			if _, ok := visited[next]; ok {
+				continue
+			}
+			visited[next] = struct{}{}
+			path = append(path, next)
+			if dfs(next) {
+				return true
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+	if dfs(txn) {
+		return path
+	}
+	return nil
+}
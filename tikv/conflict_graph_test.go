@@ -0,0 +1,41 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConflictGraph(t *testing.T) {
+	g := NewConflictGraph()
+	g.waitFor(1, 2)
+	g.waitFor(2, 3)
+	g.waitFor(4, 1)
+	require.Nil(t, g.findCycle(1))
+
+	g.waitFor(3, 1)
+	require.Equal(t, []uint64{1, 2, 3, 1}, g.findCycle(1))
+	require.Equal(t, []uint64{3, 1, 2, 3}, g.findCycle(3))
+	require.Nil(t, g.findCycle(4))
+
+	// The edge is kept until all the waits recording it are removed.
+	g.waitFor(2, 3)
+	g.removeWait(2, 3)
+	require.NotNil(t, g.findCycle(1))
+	g.removeWait(2, 3)
+	require.Nil(t, g.findCycle(1))
+	require.NotContains(t, g.edges, uint64(2))
+}
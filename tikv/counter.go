@@ -0,0 +1,95 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	counterMaxAttempts = 100
+	counterBackoffBase = 2 * time.Millisecond
+	counterBackoffMax  = 200 * time.Millisecond
+)
+
+// DistributedCounter is an int64 counter stored in TiKV. Its value is
+// encoded in 8 bytes big-endian, an absent key counts as 0.
+//
+// TiKV has no compare-and-swap RPC for transactional keys, so the counter is
+// updated by an optimistic transaction that reads the value and writes the new
+// one. A concurrent update fails the commit with a write conflict, and the
+// update is retried with the new value like a CAS loop. The transactions use
+// 1PC if the counters are in one region and the ctx passed to the updates
+// carries a non-zero util.SessionID, which 1PC requires of any transaction.
+type DistributedCounter struct {
+	store *KVStore
+	key   []byte
+}
+
+// NewDistributedCounter creates a DistributedCounter stored at key.
+func NewDistributedCounter(store *KVStore, key []byte) *DistributedCounter {
+	return &DistributedCounter{store: store, key: key}
+}
+
+// Increment adds delta to the counter and returns the new value.
+func (c *DistributedCounter) Increment(ctx context.Context, delta int64) (int64, error) {
+	values, err := c.IncrementBatch(ctx, map[string]int64{string(c.key): delta})
+	if err != nil {
+		return 0, err
+	}
+	return values[string(c.key)], nil
+}
+
+// IncrementBatch adds the deltas to the counters at their keys in one
+// transaction, and returns the new values. The keys may include the key of c.
+func (c *DistributedCounter) IncrementBatch(ctx context.Context, deltas map[string]int64) (map[string]int64, error) {
+	keys := make([][]byte, 0, len(deltas))
+	for key := range deltas {
+		keys = append(keys, []byte(key))
+	}
+	var values map[string]int64
+	err := c.store.RunInTxn(ctx, func(txn *KVTxn) error {
+		txn.SetEnable1PC(true)
+		current, err := txn.BatchGet(ctx, keys)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		values = make(map[string]int64, len(deltas))
+		for key, delta := range deltas {
+			var value int64
+			if v, ok := current[key]; ok {
+				if len(v) != 8 {
+					return errors.Errorf("invalid counter value %x of key %x", v, key)
+				}
+				value = int64(binary.BigEndian.Uint64(v))
+			}
+			value += delta
+			buf := make([]byte, 8)
+			binary.BigEndian.PutUint64(buf, uint64(value))
+			if err = txn.Set([]byte(key), buf); err != nil {
+				return errors.Trace(err)
+			}
+			values[key] = value
+		}
+		return nil
+	}, WithOptimisticRetry(counterMaxAttempts, ExponentialBackoff{Base: counterBackoffBase, Max: counterBackoffMax}))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return values, nil
+}
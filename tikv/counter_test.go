@@ -0,0 +1,83 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/util"
+)
+
+func TestDistributedCounter(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("m"), []uint64{ids[1]}, ids[1])
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	counter := NewDistributedCounter(store, []byte("a"))
+	value, err := counter.Increment(ctx, 5)
+	require.Nil(t, err)
+	require.Equal(t, int64(5), value)
+	value, err = counter.Increment(ctx, -7)
+	require.Nil(t, err)
+	require.Equal(t, int64(-2), value)
+
+	// The concurrent increments are all applied.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := counter.Increment(ctx, 1)
+			require.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// The counters in different regions.
+	values, err := counter.IncrementBatch(ctx, map[string]int64{"a": 2, "x": 3})
+	require.Nil(t, err)
+	require.Equal(t, map[string]int64{"a": 10, "x": 3}, values)
+	value, err = NewDistributedCounter(store, []byte("x")).Increment(ctx, 0)
+	require.Nil(t, err)
+	require.Equal(t, int64(3), value)
+}
+
+func TestDistributedCounterOnePC(t *testing.T) {
+	store, client := newOnePCTestStore(t)
+	defer store.Close()
+	counter := NewDistributedCounter(store, []byte("a"))
+
+	// 1PC isn't tried without a session ID.
+	_, err := counter.Increment(context.Background(), 1)
+	require.Nil(t, err)
+	require.Equal(t, int32(0), atomic.LoadInt32(&client.tries))
+
+	ctx := context.WithValue(context.Background(), util.SessionID, uint64(1))
+	value, err := counter.Increment(ctx, 1)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), value)
+	require.Equal(t, int32(1), atomic.LoadInt32(&client.tries))
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"encoding/binary"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/util"
+)
+
+// EncryptionProvider encrypts the values written by transactions and decrypts
+// the values read by them.
+//
+// Encrypt should use the current key and returns its ID, so values encrypted by
+// an old key are re-encrypted by the current key whenever they are written.
+// Decrypt must be able to decrypt with all keys that may still be used by the
+// stored values.
+type EncryptionProvider interface {
+	Encrypt(plaintext []byte) (ciphertext []byte, keyID uint32, err error)
+	Decrypt(ciphertext []byte, keyID uint32) ([]byte, error)
+}
+
+// encryptionHeaderLen is the length of the key ID stored before the ciphertext.
+const encryptionHeaderLen = 4
+
+// WithEncryption makes the transaction encrypt the values when prewriting and
+// decrypt the values read by its snapshot. All values in the keyspace accessed
+// by the transaction must be encrypted, there is no way to tell an encrypted
+// value from a plain one.
+func WithEncryption(ep EncryptionProvider) TxnOption {
+	return func(txn *KVTxn) {
+		txn.SetEncryption(ep)
+	}
+}
+
+// encryptValue encrypts value and prepends the big-endian key ID to it.
+func encryptValue(ep EncryptionProvider, value []byte) ([]byte, error) {
+	var (
+		ciphertext []byte
+		keyID      uint32
+	)
+	err := util.SafeCall(func() (err error) {
+		ciphertext, keyID, err = ep.Encrypt(value)
+		return err
+	}, errors.New("EncryptionProvider panicked on Encrypt"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	buf := make([]byte, encryptionHeaderLen, encryptionHeaderLen+len(ciphertext))
+	binary.BigEndian.PutUint32(buf, keyID)
+	return append(buf, ciphertext...), nil
+}
+
+// decryptValue decrypts a value encoded by encryptValue.
+func decryptValue(ep EncryptionProvider, value []byte) ([]byte, error) {
+	if len(value) < encryptionHeaderLen {
+		return nil, errors.Errorf("encrypted value is too short, len: %d", len(value))
+	}
+	keyID := binary.BigEndian.Uint32(value)
+	var plaintext []byte
+	err := util.SafeCall(func() (err error) {
+		plaintext, err = ep.Decrypt(value[encryptionHeaderLen:], keyID)
+		return err
+	}, errors.New("EncryptionProvider panicked on Decrypt"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/kv"
+)
+
+// xorEncryption xors the values with the key ID, it can decrypt with any key.
+type xorEncryption struct {
+	keyID uint32
+}
+
+func xorBytes(b []byte, keyID uint32) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ byte(keyID)
+	}
+	return out
+}
+
+func (e xorEncryption) Encrypt(plaintext []byte) ([]byte, uint32, error) {
+	return xorBytes(plaintext, e.keyID), e.keyID, nil
+}
+
+func (e xorEncryption) Decrypt(ciphertext []byte, keyID uint32) ([]byte, error) {
+	if keyID == 0 {
+		return nil, errors.New("unknown key")
+	}
+	return xorBytes(ciphertext, keyID), nil
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin(WithEncryption(xorEncryption{keyID: 1}))
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("e1"), []byte("v1")))
+	require.Nil(t, txn.Set([]byte("e2"), []byte("v2")))
+	require.Nil(t, txn.Commit(ctx))
+
+	// The values are stored encrypted with the key ID.
+	val, err := store.GetSnapshot(math.MaxUint64).Get(ctx, []byte("e1"))
+	require.Nil(t, err)
+	require.Equal(t, append([]byte{0, 0, 0, 1}, xorBytes([]byte("v1"), 1)...), val)
+
+	// Rotate the key, the values of the old key are still readable.
+	txn, err = store.Begin(WithEncryption(xorEncryption{keyID: 2}))
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("e3"), []byte("v3")))
+	require.Nil(t, txn.Commit(ctx))
+
+	txn, err = store.Begin(WithEncryption(xorEncryption{keyID: 2}))
+	require.Nil(t, err)
+	val, err = txn.Get(ctx, []byte("e1"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v1"), val)
+	vals, err := txn.BatchGet(ctx, [][]byte{[]byte("e2"), []byte("e3")})
+	require.Nil(t, err)
+	require.Equal(t, map[string][]byte{"e2": []byte("v2"), "e3": []byte("v3")}, vals)
+	it, err := txn.Iter([]byte("e"), nil)
+	require.Nil(t, err)
+	for _, expected := range []string{"v1", "v2", "v3"} {
+		require.True(t, it.Valid())
+		require.Equal(t, []byte(expected), it.Value())
+		require.Nil(t, it.Next())
+	}
+	it.Close()
+
+	// The values returned by the pessimistic locks are decrypted.
+	txn, err = store.Begin(WithEncryption(xorEncryption{keyID: 2}))
+	require.Nil(t, err)
+	txn.SetPessimistic(true)
+	lockCtx := &kv.LockCtx{ForUpdateTS: txn.StartTS(), WaitStartTime: time.Now()}
+	lockCtx.InitReturnValues(2)
+	require.Nil(t, txn.LockKeys(ctx, lockCtx, []byte("e1"), []byte("e3")))
+	require.Equal(t, []byte("v1"), lockCtx.Values["e1"].Value)
+	require.Equal(t, []byte("v3"), lockCtx.Values["e3"].Value)
+	require.Nil(t, txn.Rollback())
+
+	// A value that can't be decrypted fails the read.
+	txn, err = store.Begin(WithEncryption(xorEncryption{}))
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("e4"), []byte("v4")))
+	require.Nil(t, txn.Commit(ctx))
+	txn, err = store.Begin(WithEncryption(xorEncryption{keyID: 2}))
+	require.Nil(t, err)
+	_, err = txn.Get(ctx, []byte("e4"))
+	require.NotNil(t, err)
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+const (
+	// flowControlOverloadThreshold is the overloaded score in percent above
+	// which the prewrite requests are paced.
+	flowControlOverloadThreshold = 80
+	// flowControlMaxSleep is the sleep when TiKV is fully overloaded.
+	flowControlMaxSleep = 100 * time.Millisecond
+)
+
+// FlowControlSignal extracts the overloaded score in percent of the write flow
+// control of TiKV from a prewrite response. It returns false if the response
+// carries no flow control advisory.
+type FlowControlSignal func(resp *kvrpcpb.PrewriteResponse) (overloadedScorePct uint32, ok bool)
+
+// FlowControlHandler paces the prewrite requests by the flow control advisory
+// of TiKV. When the overloaded score of a response is above 80%, the batch
+// sleeps in proportion to the score, 100ms at 100%, before the worker sends
+// the next batch, which keeps bulk writes from filling the write buffer of
+// TiKV.
+type FlowControlHandler struct {
+	signal FlowControlSignal
+}
+
+// NewFlowControlHandler creates a FlowControlHandler that reads the advisory
+// by signal.
+func NewFlowControlHandler(signal FlowControlSignal) *FlowControlHandler {
+	return &FlowControlHandler{signal: signal}
+}
+
+// sleepFor returns the sleep before the next prewrite request after resp.
+func (h *FlowControlHandler) sleepFor(resp *kvrpcpb.PrewriteResponse) time.Duration {
+	score, ok := h.signal(resp)
+	if !ok || score <= flowControlOverloadThreshold {
+		return 0
+	}
+	if score > 100 {
+		score = 100
+	}
+	return flowControlMaxSleep * time.Duration(score) / 100
+}
+
+func (h *FlowControlHandler) pace(ctx context.Context, resp *kvrpcpb.PrewriteResponse) error {
+	sleep := h.sleepFor(resp)
+	if sleep == 0 {
+		return nil
+	}
+	timer := time.NewTimer(sleep)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	}
+}
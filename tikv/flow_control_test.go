@@ -0,0 +1,67 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlowControlSleep(t *testing.T) {
+	for _, c := range []struct {
+		score uint32
+		ok    bool
+		sleep time.Duration
+	}{
+		{0, false, 0},
+		{95, false, 0},
+		{50, true, 0},
+		{80, true, 0},
+		{90, true, 90 * time.Millisecond},
+		{100, true, 100 * time.Millisecond},
+		{150, true, 100 * time.Millisecond},
+	} {
+		h := NewFlowControlHandler(func(*kvrpcpb.PrewriteResponse) (uint32, bool) {
+			return c.score, c.ok
+		})
+		require.Equal(t, c.sleep, h.sleepFor(&kvrpcpb.PrewriteResponse{}), c.score)
+	}
+}
+
+func TestPrewriteFlowControl(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	var calls int32
+	store.EnablePrewriteFlowControl(func(*kvrpcpb.PrewriteResponse) (uint32, bool) {
+		atomic.AddInt32(&calls, 1)
+		return 100, true
+	})
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k"), []byte("v")))
+	start := time.Now()
+	require.Nil(t, txn.Commit(context.Background()))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(flowControlMaxSleep))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NotNil(t, store.flowControl.pace(ctx, &kvrpcpb.PrewriteResponse{}))
+}
@@ -0,0 +1,148 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+// intentCoalescerMaxKeys is the max number of the keys of a transaction
+// tracked by the IntentCoalescer, larger transactions are not serialized.
+const intentCoalescerMaxKeys = 256
+
+type inflightIntent struct {
+	writers int
+	done    chan struct{}
+	// commitTS is the max commit ts of the writers, it's set when they finish.
+	commitTS uint64
+}
+
+// IntentCoalescer serializes the commits of the optimistic transactions of a
+// KVStore which write the same keys. When many transactions write a hot key
+// concurrently, their prewrites conflict on TiKV and the locks left by each
+// other have to be checked and resolved. Waiting until the in-flight
+// transactions writing the same keys finish lets the later ones see the
+// committed writes instead of the locks.
+//
+// It's only an optimization, the conflicts are still detected by TiKV. A
+// transaction waits at most for the max wait and then commits anyway, and a
+// waiting transaction doesn't hold any key, so the transactions never wait for
+// each other in a cycle. Like the local latches, a transaction fails fast with
+// ErrWriteConflictInLatch when a transaction it waited for commits a key after
+// its start ts, as the prewrite would meet a write conflict.
+type IntentCoalescer struct {
+	maxWait time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]*inflightIntent
+}
+
+// NewIntentCoalescer creates an IntentCoalescer which lets a transaction wait
+// for at most maxWait.
+func NewIntentCoalescer(maxWait time.Duration) *IntentCoalescer {
+	return &IntentCoalescer{
+		maxWait:  maxWait,
+		inflight: make(map[string]*inflightIntent),
+	}
+}
+
+// writtenKeys returns the keys written by the mutations, or nil if there are
+// too many of them to track.
+func writtenKeys(m CommitterMutations) [][]byte {
+	if m.Len() > intentCoalescerMaxKeys {
+		return nil
+	}
+	var keys [][]byte
+	for i := 0; i < m.Len(); i++ {
+		if m.GetOp(i) != kvrpcpb.Op_Lock && m.GetOp(i) != kvrpcpb.Op_CheckNotExists {
+			keys = append(keys, m.GetKey(i))
+		}
+	}
+	return keys
+}
+
+// acquire waits until none of the keys is written by in-flight transactions,
+// or until the max wait passes, and then marks the keys in flight. The returned
+// function must be called with the commit ts, or 0 if not committed, when the
+// transaction finishes. It returns true if the transaction had to wait.
+func (c *IntentCoalescer) acquire(ctx context.Context, startTS uint64, keys [][]byte) (release func(commitTS uint64), waited bool, err error) {
+	timer := time.NewTimer(c.maxWait)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		var busy *inflightIntent
+		for _, k := range keys {
+			if intent, ok := c.inflight[string(k)]; ok {
+				busy = intent
+				break
+			}
+		}
+		if busy == nil {
+			c.addLocked(keys)
+			c.mu.Unlock()
+			return func(commitTS uint64) { c.release(keys, commitTS) }, waited, nil
+		}
+		c.mu.Unlock()
+		waited = true
+		select {
+		case <-busy.done:
+			// commitTS is not changed after done is closed.
+			if busy.commitTS > startTS {
+				return nil, waited, &tikverr.ErrWriteConflictInLatch{StartTS: startTS}
+			}
+		case <-timer.C:
+			c.mu.Lock()
+			c.addLocked(keys)
+			c.mu.Unlock()
+			return func(commitTS uint64) { c.release(keys, commitTS) }, waited, nil
+		case <-ctx.Done():
+			return nil, waited, errors.Trace(ctx.Err())
+		}
+	}
+}
+
+func (c *IntentCoalescer) addLocked(keys [][]byte) {
+	for _, k := range keys {
+		intent, ok := c.inflight[string(k)]
+		if !ok {
+			intent = &inflightIntent{done: make(chan struct{})}
+			c.inflight[string(k)] = intent
+		}
+		intent.writers++
+	}
+}
+
+func (c *IntentCoalescer) release(keys [][]byte, commitTS uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		intent, ok := c.inflight[string(k)]
+		if !ok {
+			continue
+		}
+		if commitTS > intent.commitTS {
+			intent.commitTS = commitTS
+		}
+		if intent.writers--; intent.writers == 0 {
+			close(intent.done)
+			delete(c.inflight, string(k))
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+func TestIntentCoalescer(t *testing.T) {
+	c := NewIntentCoalescer(time.Hour)
+	ctx := context.Background()
+
+	release1, waited, err := c.acquire(ctx, 10, [][]byte{[]byte("a"), []byte("b")})
+	require.Nil(t, err)
+	require.False(t, waited)
+	release2, waited, err := c.acquire(ctx, 10, [][]byte{[]byte("c")})
+	require.Nil(t, err)
+	require.False(t, waited)
+
+	acquired := make(chan func(uint64))
+	go func() {
+		release, waited, err := c.acquire(ctx, 20, [][]byte{[]byte("b"), []byte("c")})
+		require.Nil(t, err)
+		require.True(t, waited)
+		acquired <- release
+	}()
+	release1(15)
+	select {
+	case <-acquired:
+		require.FailNow(t, "acquired keys written by an in-flight txn")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Not committed.
+	release2(0)
+	release3 := <-acquired
+	require.Len(t, c.inflight, 2)
+
+	// The txn fails fast once the txn it waits for commits after its start ts.
+	failed := make(chan error)
+	go func() {
+		_, waited, err := c.acquire(ctx, 22, [][]byte{[]byte("c")})
+		require.True(t, waited)
+		failed <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	release3(25)
+	require.True(t, tikverr.Is(<-failed, &tikverr.ErrWriteConflictInLatch{}))
+	require.Empty(t, c.inflight)
+
+	// The waiting txn returns if ctx is canceled.
+	release1, _, _ = c.acquire(ctx, 30, [][]byte{[]byte("a")})
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = c.acquire(cancelCtx, 30, [][]byte{[]byte("a")})
+	require.NotNil(t, err)
+	release1(0)
+	require.Empty(t, c.inflight)
+
+	// The txn commits anyway after the max wait.
+	c = NewIntentCoalescer(10 * time.Millisecond)
+	release1, _, _ = c.acquire(ctx, 10, [][]byte{[]byte("a")})
+	release2, waited, err = c.acquire(ctx, 10, [][]byte{[]byte("a")})
+	require.Nil(t, err)
+	require.True(t, waited)
+	require.Equal(t, 2, c.inflight["a"].writers)
+	release1(0)
+	release2(0)
+	require.Empty(t, c.inflight)
+}
@@ -80,3 +80,21 @@ type Storage interface {
 	// SupportDeleteRange gets the storage support delete range or not.
 	SupportDeleteRange() (supported bool)
 }
+
+// TxnClient is the transactional API of KVStore. The layers above the store
+// that only begin transactions and read snapshots can depend on it instead of
+// *KVStore, so they can be tested with a fake.
+type TxnClient interface {
+	// Begin a global transaction.
+	Begin(opts ...TxnOption) (*KVTxn, error)
+	// BeginWithOption begins a transaction with the given StartTSOption.
+	BeginWithOption(options StartTSOption, opts ...TxnOption) (*KVTxn, error)
+	// GetSnapshot gets a snapshot that is able to read any data which data is <= the given ts.
+	GetSnapshot(ts uint64) *KVSnapshot
+	// Close store
+	Close() error
+	// GetOracle gets a timestamp oracle client.
+	GetOracle() oracle.Oracle
+}
+
+var _ TxnClient = &KVStore{}
@@ -0,0 +1,143 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/oracle"
+)
+
+// keyDistributionMaxBackoff is the max sleep time of locating the regions of
+// the analyzed range.
+const keyDistributionMaxBackoff = 20000
+
+// RegionKeyCount is the number of the sampled keys of a region.
+type RegionKeyCount struct {
+	RegionID    uint64
+	StartKey    []byte
+	EndKey      []byte
+	SampledKeys int
+}
+
+// Distribution is the distribution of the keys of a range.
+type Distribution struct {
+	SampleRate float64
+	// Samples are the sampled keys in order.
+	Samples [][]byte
+	// Regions are the regions of the range in order, the start key of the
+	// first one and the end key of the last one are clipped to the range.
+	Regions []RegionKeyCount
+}
+
+// EstimatedKeys returns the estimated number of the keys of the range.
+func (d *Distribution) EstimatedKeys() int64 {
+	return int64(float64(len(d.Samples)) / d.SampleRate)
+}
+
+// SuggestedSplitPoints returns at most n split keys which split the range into
+// n+1 parts of about the same number of keys. Less keys are returned if there
+// are not enough distinct samples.
+func (d *Distribution) SuggestedSplitPoints(n int) [][]byte {
+	if n <= 0 || len(d.Samples) == 0 {
+		return nil
+	}
+	points := make([][]byte, 0, n)
+	for i := 1; i <= n; i++ {
+		key := d.Samples[i*len(d.Samples)/(n+1)]
+		// The first sample can't split the range, and duplicated keys split
+		// nothing.
+		if bytes.Equal(key, d.Samples[0]) || (len(points) > 0 && bytes.Equal(key, points[len(points)-1])) {
+			continue
+		}
+		points = append(points, key)
+	}
+	return points
+}
+
+// KeyDistributionAnalyzer samples the keys of a range to find out how they are
+// distributed, e.g. to split the range before loading data into it.
+//
+// The keys are scanned region by region from a snapshot of the current
+// timestamp without the values, and each of them is sampled with the sample
+// rate.
+type KeyDistributionAnalyzer struct {
+	store *KVStore
+	rand  *rand.Rand
+}
+
+// NewKeyDistributionAnalyzer creates a KeyDistributionAnalyzer.
+func NewKeyDistributionAnalyzer(store *KVStore) *KeyDistributionAnalyzer {
+	return &KeyDistributionAnalyzer{
+		store: store,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Analyze samples the keys in [startKey, endKey). An empty endKey means the end
+// of the key space. The sample rate should be in (0, 1].
+func (a *KeyDistributionAnalyzer) Analyze(ctx context.Context, startKey, endKey []byte, sampleRate float64) (*Distribution, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, errors.Errorf("invalid sample rate %v", sampleRate)
+	}
+	ts, err := a.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	snapshot := a.store.GetSnapshot(ts)
+	snapshot.SetKeyOnly(true)
+	snapshot.SetNotFillCache(true)
+
+	dist := &Distribution{SampleRate: sampleRate}
+	bo := NewBackofferWithVars(ctx, keyDistributionMaxBackoff, nil)
+	for {
+		loc, err := a.store.GetRegionCache().LocateKey(bo, startKey)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		regionEnd := loc.EndKey
+		if len(endKey) > 0 && (len(regionEnd) == 0 || bytes.Compare(endKey, regionEnd) < 0) {
+			regionEnd = endKey
+		}
+		region := RegionKeyCount{RegionID: loc.Region.GetID(), StartKey: startKey, EndKey: regionEnd}
+		iter, err := snapshot.Iter(startKey, regionEnd)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for iter.Valid() {
+			if a.rand.Float64() < sampleRate {
+				dist.Samples = append(dist.Samples, append([]byte(nil), iter.Key()...))
+				region.SampledKeys++
+			}
+			if err = iter.Next(); err != nil {
+				iter.Close()
+				return nil, errors.Trace(err)
+			}
+		}
+		iter.Close()
+		dist.Regions = append(dist.Regions, region)
+
+		if len(regionEnd) == 0 || bytes.Equal(regionEnd, endKey) {
+			return dist, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		startKey = regionEnd
+	}
+}
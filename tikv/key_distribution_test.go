@@ -0,0 +1,64 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+)
+
+func TestKeyDistributionAnalyzer(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	newPeerID := cluster.AllocID()
+	cluster.Split(regionID, cluster.AllocID(), []byte("k030"), []uint64{newPeerID}, newPeerID)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		require.Nil(t, txn.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v")))
+	}
+	require.Nil(t, txn.Commit(ctx))
+
+	analyzer := NewKeyDistributionAnalyzer(store)
+	dist, err := analyzer.Analyze(ctx, []byte("k010"), []byte("k090"), 1)
+	require.Nil(t, err)
+	require.Len(t, dist.Samples, 80)
+	require.Equal(t, int64(80), dist.EstimatedKeys())
+	require.Len(t, dist.Regions, 2)
+	require.Equal(t, []byte("k010"), dist.Regions[0].StartKey)
+	require.Equal(t, []byte("k030"), dist.Regions[0].EndKey)
+	require.Equal(t, 20, dist.Regions[0].SampledKeys)
+	require.Equal(t, []byte("k090"), dist.Regions[1].EndKey)
+	require.Equal(t, 60, dist.Regions[1].SampledKeys)
+	require.Equal(t, [][]byte{[]byte("k030"), []byte("k050"), []byte("k070")}, dist.SuggestedSplitPoints(3))
+
+	dist, err = analyzer.Analyze(ctx, nil, nil, 0.1)
+	require.Nil(t, err)
+	require.Len(t, dist.Regions, 2)
+	require.Less(t, len(dist.Samples), 100)
+
+	_, err = analyzer.Analyze(ctx, nil, nil, 0)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,223 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/util/codec"
+	"go.uber.org/zap"
+)
+
+// KeyEncoder serializes the typed keys of a KeyEncodedRawKVClient to the raw
+// keys stored in TiKV. Decode must return the typed key of Encode. The raw
+// keys should be ordered like the typed keys, or Scan returns the keys of a
+// range the caller doesn't expect.
+type KeyEncoder interface {
+	Encode(logical interface{}) ([]byte, error)
+	Decode(raw []byte) (interface{}, error)
+}
+
+// RawBytesEncoder stores the []byte keys as they are.
+type RawBytesEncoder struct{}
+
+// Encode implements KeyEncoder.
+func (RawBytesEncoder) Encode(logical interface{}) ([]byte, error) {
+	key, ok := logical.([]byte)
+	if !ok {
+		return nil, errors.Errorf("RawBytesEncoder: expect []byte key, got %T", logical)
+	}
+	return key, nil
+}
+
+// Decode implements KeyEncoder.
+func (RawBytesEncoder) Decode(raw []byte) (interface{}, error) {
+	return raw, nil
+}
+
+// StringEncoder stores the string keys as their bytes.
+type StringEncoder struct{}
+
+// Encode implements KeyEncoder.
+func (StringEncoder) Encode(logical interface{}) ([]byte, error) {
+	key, ok := logical.(string)
+	if !ok {
+		return nil, errors.Errorf("StringEncoder: expect string key, got %T", logical)
+	}
+	return []byte(key), nil
+}
+
+// Decode implements KeyEncoder.
+func (StringEncoder) Decode(raw []byte) (interface{}, error) {
+	return string(raw), nil
+}
+
+// BigEndianInt64Encoder stores the int64 keys in 8 bytes big-endian with the
+// sign bit flipped, so the negative keys are ordered before the positive ones.
+type BigEndianInt64Encoder struct{}
+
+// Encode implements KeyEncoder.
+func (BigEndianInt64Encoder) Encode(logical interface{}) ([]byte, error) {
+	key, ok := logical.(int64)
+	if !ok {
+		return nil, errors.Errorf("BigEndianInt64Encoder: expect int64 key, got %T", logical)
+	}
+	return codec.EncodeInt(nil, key), nil
+}
+
+// Decode implements KeyEncoder.
+func (BigEndianInt64Encoder) Decode(raw []byte) (interface{}, error) {
+	if len(raw) != 8 {
+		return nil, errors.Errorf("BigEndianInt64Encoder: expect 8 bytes, got %d", len(raw))
+	}
+	_, key, err := codec.DecodeInt(raw)
+	return key, errors.Trace(err)
+}
+
+// CompositeEncoder stores the []interface{} keys by concatenating the parts
+// encoded by its encoders in order. Every part but the last one is wrapped by
+// the memcomparable format of codec.EncodeBytes, so the parts can be split on
+// decoding and the composite keys are ordered by their parts.
+type CompositeEncoder struct {
+	encoders []KeyEncoder
+}
+
+// NewCompositeEncoder creates a CompositeEncoder encoding the i-th part of the
+// keys by encoders[i].
+func NewCompositeEncoder(encoders ...KeyEncoder) *CompositeEncoder {
+	return &CompositeEncoder{encoders: encoders}
+}
+
+// Encode implements KeyEncoder.
+func (e *CompositeEncoder) Encode(logical interface{}) ([]byte, error) {
+	parts, ok := logical.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("CompositeEncoder: expect []interface{} key, got %T", logical)
+	}
+	if len(parts) != len(e.encoders) {
+		return nil, errors.Errorf("CompositeEncoder: expect %d parts, got %d", len(e.encoders), len(parts))
+	}
+	var raw []byte
+	for i, part := range parts {
+		b, err := e.encoders[i].Encode(part)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if i < len(parts)-1 {
+			raw = codec.EncodeBytes(raw, b)
+		} else {
+			raw = append(raw, b...)
+		}
+	}
+	return raw, nil
+}
+
+// Decode implements KeyEncoder.
+func (e *CompositeEncoder) Decode(raw []byte) (interface{}, error) {
+	parts := make([]interface{}, len(e.encoders))
+	for i, enc := range e.encoders {
+		b := raw
+		if i < len(e.encoders)-1 {
+			var err error
+			raw, b, err = codec.DecodeBytes(raw, nil)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		part, err := enc.Decode(b)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		parts[i] = part
+	}
+	return parts, nil
+}
+
+// KeyEncodedRawKVClient is a RawKVClient accessed by the typed keys of a
+// KeyEncoder.
+type KeyEncodedRawKVClient struct {
+	client *RawKVClient
+	enc    KeyEncoder
+}
+
+// WithKeyEncoder returns a view of the client that encodes the typed keys by
+// enc. The views share the connections of the client, so only the client
+// needs to be closed.
+func (c *RawKVClient) WithKeyEncoder(enc KeyEncoder) *KeyEncodedRawKVClient {
+	return &KeyEncodedRawKVClient{client: c, enc: enc}
+}
+
+// Get queries value with the typed key. When the key does not exist, it
+// returns `nil, nil`.
+func (c *KeyEncodedRawKVClient) Get(key interface{}) ([]byte, error) {
+	raw, err := c.enc.Encode(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return c.client.Get(raw)
+}
+
+// Put stores a key-value pair with the typed key.
+func (c *KeyEncodedRawKVClient) Put(key interface{}, value []byte) error {
+	raw, err := c.enc.Encode(key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return c.client.Put(raw, value)
+}
+
+// Delete deletes the typed key.
+func (c *KeyEncodedRawKVClient) Delete(key interface{}) error {
+	raw, err := c.enc.Encode(key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return c.client.Delete(raw)
+}
+
+// Scan queries continuous kv pairs in range [startKey, endKey), up to limit
+// pairs, and returns their typed keys. A nil endKey means scanning to the end
+// of the keyspace. The keys that can't be decoded, such as the keys written
+// without the encoder, are logged and skipped, so fewer than limit pairs may
+// be returned before the end of the range.
+func (c *KeyEncodedRawKVClient) Scan(startKey, endKey interface{}, limit int) (keys []interface{}, values [][]byte, err error) {
+	rawStart, err := c.enc.Encode(startKey)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	var rawEnd []byte
+	if endKey != nil {
+		if rawEnd, err = c.enc.Encode(endKey); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+	}
+	rawKeys, rawValues, err := c.client.Scan(rawStart, rawEnd, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys = make([]interface{}, 0, len(rawKeys))
+	values = make([][]byte, 0, len(rawValues))
+	for i, raw := range rawKeys {
+		key, err := c.enc.Decode(raw)
+		if err != nil {
+			logutil.BgLogger().Warn("skip the key failed to decode",
+				zap.String("key", kv.StrKey(raw)), zap.Error(err))
+			continue
+		}
+		keys = append(keys, key)
+		values = append(values, rawValues[i])
+	}
+	return keys, values, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+)
+
+func TestKeyEncoders(t *testing.T) {
+	composite := NewCompositeEncoder(StringEncoder{}, BigEndianInt64Encoder{})
+	for _, c := range []struct {
+		enc  KeyEncoder
+		keys []interface{}
+	}{
+		{RawBytesEncoder{}, []interface{}{[]byte(""), []byte("a"), []byte("b\x00")}},
+		{StringEncoder{}, []interface{}{"", "a", "ab", "b"}},
+		{BigEndianInt64Encoder{}, []interface{}{int64(-1 << 63), int64(-1), int64(0), int64(1), int64(1<<63 - 1)}},
+		{composite, []interface{}{
+			[]interface{}{"a", int64(-1)},
+			[]interface{}{"a", int64(5)},
+			[]interface{}{"a\x00", int64(0)},
+			[]interface{}{"b", int64(-5)},
+		}},
+	} {
+		var prev []byte
+		for i, key := range c.keys {
+			raw, err := c.enc.Encode(key)
+			require.Nil(t, err)
+			decoded, err := c.enc.Decode(raw)
+			require.Nil(t, err)
+			require.Equal(t, key, decoded)
+			// The raw keys are ordered like the typed keys.
+			if i > 0 {
+				require.Equal(t, -1, bytes.Compare(prev, raw), key)
+			}
+			prev = raw
+		}
+	}
+
+	_, err := StringEncoder{}.Encode(int64(1))
+	require.NotNil(t, err)
+	_, err = BigEndianInt64Encoder{}.Decode([]byte("short"))
+	require.NotNil(t, err)
+	_, err = composite.Encode([]interface{}{"a"})
+	require.NotNil(t, err)
+}
+
+func TestRawKVWithKeyEncoder(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := &RawKVClient{
+		regionCache: NewRegionCache(mocktikv.NewPDClient(cluster)),
+		rpcClient:   mocktikv.NewRPCClient(cluster, mvccStore, nil),
+	}
+	defer client.Close()
+	c := client.WithKeyEncoder(BigEndianInt64Encoder{})
+
+	for _, key := range []int64{-2, -1, 0, 1, 2} {
+		require.Nil(t, c.Put(key, []byte{byte(key + 2)}))
+	}
+	val, err := c.Get(int64(-1))
+	require.Nil(t, err)
+	require.Equal(t, []byte{1}, val)
+	_, err = c.Get("not an int64")
+	require.NotNil(t, err)
+
+	require.Nil(t, c.Delete(int64(0)))
+	keys, values, err := c.Scan(int64(-2), int64(2), 10)
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{int64(-2), int64(-1), int64(1)}, keys)
+	require.Equal(t, [][]byte{{0}, {1}, {3}}, values)
+	keys, _, err = c.Scan(int64(1), nil, 10)
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{int64(1), int64(2)}, keys)
+
+	// The raw client sees the encoded keys.
+	val, err = client.Get([]byte{0x80, 0, 0, 0, 0, 0, 0, 2})
+	require.Nil(t, err)
+	require.Equal(t, []byte{4}, val)
+
+	// The keys failed to decode are skipped.
+	require.Nil(t, client.Put([]byte{0x80, 0, 0, 0, 0, 0, 0, 1, 0}, []byte{9}))
+	keys, values, err = c.Scan(int64(1), nil, 10)
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{int64(1), int64(2)}, keys)
+	require.Equal(t, [][]byte{{3}, {4}}, values)
+}
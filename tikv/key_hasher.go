@@ -0,0 +1,266 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/kv"
+)
+
+// KeyHasher maps the keys written by a RawKVClient to the keys stored in
+// TiKV, so the sequential keys are spread across regions instead of piling up
+// on the last one. UnhashKey must return the original key of HashKey.
+type KeyHasher interface {
+	HashKey(key []byte) []byte
+	UnhashKey(hashed []byte) []byte
+}
+
+// RangeKeyHasher is a KeyHasher whose hashed key is one of a few prefixes
+// followed by the original key. A range of the original keys is stored in one
+// range per prefix, so the range operations of a RawKVClient run on every
+// prefix and merge the results.
+type RangeKeyHasher interface {
+	KeyHasher
+	// HashPrefixes returns all the prefixes in ascending order.
+	HashPrefixes() [][]byte
+}
+
+// ErrRangeWithKeyHasher is returned by the range operations of a RawKVClient
+// with a KeyHasher which is not a RangeKeyHasher. The hashed keys aren't
+// ordered like the original keys, so a range of the original keys can't be
+// mapped to the stored keys.
+var ErrRangeWithKeyHasher = errors.New("range operations are not supported with the key hasher")
+
+const (
+	// sipHashPrefixLen is the length of the hash prefix added by
+	// SipHashKeyHasher.
+	sipHashPrefixLen = 4
+	// sipHashBuckets is the number of the prefixes of SipHashKeyHasher.
+	sipHashBuckets = 16
+)
+
+// SipHashKeyHasher prefixes every key with a 4-byte bucket picked by its
+// SipHash-2-4 digest. There are 16 buckets evenly spread over the prefix
+// space, so the sequential keys are written to up to 16 regions, and a range
+// operation takes one request per bucket.
+type SipHashKeyHasher struct {
+	k0, k1 uint64
+}
+
+var _ RangeKeyHasher = &SipHashKeyHasher{}
+
+// NewSipHashKeyHasher creates a SipHashKeyHasher with the 128-bit SipHash key.
+// All the clients accessing the keys must use the same key.
+func NewSipHashKeyHasher(key [16]byte) *SipHashKeyHasher {
+	return &SipHashKeyHasher{
+		k0: binary.LittleEndian.Uint64(key[:8]),
+		k1: binary.LittleEndian.Uint64(key[8:]),
+	}
+}
+
+// HashKey implements KeyHasher.
+func (h *SipHashKeyHasher) HashKey(key []byte) []byte {
+	hashed := make([]byte, sipHashPrefixLen, sipHashPrefixLen+len(key))
+	bucket := sipHash24(h.k0, h.k1, key) % sipHashBuckets
+	binary.BigEndian.PutUint32(hashed, sipHashPrefix(bucket))
+	return append(hashed, key...)
+}
+
+// UnhashKey implements KeyHasher.
+func (h *SipHashKeyHasher) UnhashKey(hashed []byte) []byte {
+	if len(hashed) < sipHashPrefixLen {
+		return nil
+	}
+	return hashed[sipHashPrefixLen:]
+}
+
+// HashPrefixes implements RangeKeyHasher.
+func (h *SipHashKeyHasher) HashPrefixes() [][]byte {
+	prefixes := make([][]byte, sipHashBuckets)
+	for i := range prefixes {
+		prefixes[i] = make([]byte, sipHashPrefixLen)
+		binary.BigEndian.PutUint32(prefixes[i], sipHashPrefix(uint64(i)))
+	}
+	return prefixes
+}
+
+func sipHashPrefix(bucket uint64) uint32 {
+	return uint32(bucket * (1 << 32 / sipHashBuckets))
+}
+
+// sipHash24 computes the SipHash-2-4 digest of msg.
+func sipHash24(k0, k1 uint64, msg []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	n := len(msg)
+	for len(msg) >= 8 {
+		m := binary.LittleEndian.Uint64(msg)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		msg = msg[8:]
+	}
+	var last [8]byte
+	copy(last[:], msg)
+	last[7] = byte(n)
+	m := binary.LittleEndian.Uint64(last[:])
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		round()
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// SetKeyHasher makes the client hash the keys with h before sending them to
+// TiKV, and un-hash the keys returned by scans. If h is not a RangeKeyHasher,
+// Scan, ReverseScan, DeleteRange and the coprocessor requests return
+// ErrRangeWithKeyHasher. It should be called before using the client.
+func (c *RawKVClient) SetKeyHasher(h KeyHasher) {
+	c.keyHasher = h
+}
+
+func (c *RawKVClient) hashKey(key []byte) []byte {
+	if c.keyHasher == nil {
+		return key
+	}
+	return c.keyHasher.HashKey(key)
+}
+
+func (c *RawKVClient) hashKeys(keys [][]byte) [][]byte {
+	if c.keyHasher == nil {
+		return keys
+	}
+	hashed := make([][]byte, len(keys))
+	for i, key := range keys {
+		hashed[i] = c.keyHasher.HashKey(key)
+	}
+	return hashed
+}
+
+// hashedRanges maps the range [startKey, endKey) of the original keys to the
+// ranges of the stored keys, one per prefix. An empty endKey means unbounded.
+func (c *RawKVClient) hashedRanges(startKey, endKey []byte) ([]kv.KeyRange, error) {
+	if c.keyHasher == nil {
+		return []kv.KeyRange{{StartKey: startKey, EndKey: endKey}}, nil
+	}
+	h, ok := c.keyHasher.(RangeKeyHasher)
+	if !ok {
+		return nil, errors.Trace(ErrRangeWithKeyHasher)
+	}
+	prefixes := h.HashPrefixes()
+	ranges := make([]kv.KeyRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		r := kv.KeyRange{StartKey: append(append([]byte(nil), p...), startKey...)}
+		if len(endKey) > 0 {
+			r.EndKey = append(append([]byte(nil), p...), endKey...)
+		} else {
+			r.EndKey = kv.PrefixNextKey(p)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// hashKeyRanges maps the sorted ranges of the original keys to the sorted
+// ranges of the stored keys.
+func (c *RawKVClient) hashKeyRanges(ranges []kv.KeyRange) ([]kv.KeyRange, error) {
+	if c.keyHasher == nil {
+		return ranges, nil
+	}
+	var hashed [][]kv.KeyRange
+	for _, r := range ranges {
+		rs, err := c.hashedRanges(r.StartKey, r.EndKey)
+		if err != nil {
+			return nil, err
+		}
+		hashed = append(hashed, rs)
+	}
+	var res []kv.KeyRange
+	for i := 0; len(hashed) > 0 && i < len(hashed[0]); i++ {
+		for _, rs := range hashed {
+			res = append(res, rs[i])
+		}
+	}
+	return res, nil
+}
+
+// unhashKeyRanges maps the ranges of the stored keys back to the original keys.
+func (c *RawKVClient) unhashKeyRanges(ranges []kv.KeyRange) []kv.KeyRange {
+	if c.keyHasher == nil {
+		return ranges
+	}
+	res := make([]kv.KeyRange, len(ranges))
+	for i, r := range ranges {
+		res[i] = kv.KeyRange{StartKey: c.keyHasher.UnhashKey(r.StartKey), EndKey: c.keyHasher.UnhashKey(r.EndKey)}
+	}
+	return res
+}
+
+// unhashPairs un-hashes the keys scanned from the stored ranges, and returns
+// the first limit pairs in the order of the original keys.
+func (c *RawKVClient) unhashPairs(keys, values [][]byte, limit int, reverse bool) ([][]byte, [][]byte) {
+	pairs := make([]kvPair, len(keys))
+	for i := range keys {
+		pairs[i] = kvPair{key: c.keyHasher.UnhashKey(keys[i]), value: values[i]}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(pairs[i].key, pairs[j].key) > 0
+		}
+		return bytes.Compare(pairs[i].key, pairs[j].key) < 0
+	})
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+	keys, values = make([][]byte, len(pairs)), make([][]byte, len(pairs))
+	for i, p := range pairs {
+		keys[i], values[i] = p.key, p.value
+	}
+	return keys, values
+}
+
+type kvPair struct {
+	key, value []byte
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+)
+
+func TestSipHash(t *testing.T) {
+	// The test vector of the SipHash paper.
+	var key [16]byte
+	msg := make([]byte, 15)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	h := NewSipHashKeyHasher(key)
+	require.Equal(t, uint64(0xa129ca6149be45e5), sipHash24(h.k0, h.k1, msg))
+
+	hashed := h.HashKey([]byte("key"))
+	require.Len(t, hashed, 7)
+	require.Equal(t, []byte("key"), h.UnhashKey(hashed))
+	require.Equal(t, hashed, h.HashKey([]byte("key")))
+}
+
+func TestRawKVWithKeyHasher(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := &RawKVClient{
+		regionCache: NewRegionCache(mocktikv.NewPDClient(cluster)),
+		rpcClient:   mocktikv.NewRPCClient(cluster, mvccStore, nil),
+	}
+	defer client.Close()
+	hasher := NewSipHashKeyHasher([16]byte{1})
+	client.SetKeyHasher(hasher)
+
+	require.Nil(t, client.Put([]byte("a"), []byte("1")))
+	require.Nil(t, client.BatchPut([][]byte{[]byte("b"), []byte("c")}, [][]byte{[]byte("2"), []byte("3")}))
+	val, err := client.Get([]byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), val)
+	vals, err := client.BatchGet([][]byte{[]byte("b"), []byte("c"), []byte("d")})
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("2"), []byte("3"), nil}, vals)
+
+	// The keys are stored with the hash prefix.
+	rawStore := mvccStore.(mocktikv.RawKV)
+	pairs := rawStore.RawScan(nil, nil, 10)
+	require.Len(t, pairs, 3)
+	for _, pair := range pairs {
+		require.Equal(t, pair.Key, hasher.HashKey(hasher.UnhashKey(pair.Key)))
+	}
+	require.Equal(t, []byte("1"), rawStore.RawGet(hasher.HashKey([]byte("a"))))
+
+	require.Nil(t, client.Delete([]byte("a")))
+	require.Nil(t, client.BatchDelete([][]byte{[]byte("b")}))
+	for key, expected := range map[string][]byte{"a": nil, "b": nil, "c": []byte("3")} {
+		val, err = client.Get([]byte(key))
+		require.Nil(t, err)
+		require.Equal(t, expected, val)
+	}
+
+	// The range operations run on every prefix and return the original keys.
+	for _, key := range []string{"d", "e", "f", "g"} {
+		require.Nil(t, client.Put([]byte(key), []byte(key)))
+	}
+	keys, _, err := client.Scan(nil, nil, 10)
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("c"), []byte("d"), []byte("e"), []byte("f"), []byte("g")}, keys)
+	keys, values, err := client.Scan([]byte("d"), []byte("f"), 10)
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("d"), []byte("e")}, keys)
+	require.Equal(t, [][]byte{[]byte("d"), []byte("e")}, values)
+	keys, _, err = client.Scan(nil, nil, 2)
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("c"), []byte("d")}, keys)
+	keys, _, err = client.ReverseScan([]byte("f"), []byte("d"), 10)
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("e"), []byte("d")}, keys)
+	keys, _, err = client.ReverseScan(nil, nil, 2)
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("g"), []byte("f")}, keys)
+
+	require.Nil(t, client.DeleteRange([]byte("d"), []byte("f")))
+	keys, _, err = client.Scan(nil, nil, 10)
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("c"), []byte("f"), []byte("g")}, keys)
+
+	client.SetKeyHasher(reverseKeyHasher{})
+	_, _, err = client.Scan(nil, nil, 10)
+	require.ErrorIs(t, err, ErrRangeWithKeyHasher)
+	require.ErrorIs(t, client.DeleteRange([]byte("a"), []byte("z")), ErrRangeWithKeyHasher)
+}
+
+// reverseKeyHasher doesn't keep the order of the keys in any range.
+type reverseKeyHasher struct{}
+
+func (reverseKeyHasher) HashKey(key []byte) []byte {
+	hashed := make([]byte, len(key))
+	for i, b := range key {
+		hashed[len(key)-1-i] = b
+	}
+	return hashed
+}
+
+func (h reverseKeyHasher) UnhashKey(hashed []byte) []byte {
+	return h.HashKey(hashed)
+}
+
+// BenchmarkSequentialInsertHotspot reports the largest share of the keys of a
+// sequential-insert workload falling into one of 16 equal key ranges, which
+// stand for the regions the keys are split into.
+func BenchmarkSequentialInsertHotspot(b *testing.B) {
+	const ranges = 16
+	for _, bc := range []struct {
+		name   string
+		hasher KeyHasher
+	}{
+		{"plain", nil},
+		{"siphash", NewSipHashKeyHasher([16]byte{1})},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var counts [ranges]int
+			key := make([]byte, 8)
+			for i := 0; i < b.N; i++ {
+				binary.BigEndian.PutUint64(key, uint64(i))
+				stored := key
+				if bc.hasher != nil {
+					stored = bc.hasher.HashKey(key)
+				}
+				counts[int(stored[0])*ranges/256]++
+			}
+			max := 0
+			for _, cnt := range counts {
+				if cnt > max {
+					max = cnt
+				}
+			}
+			b.ReportMetric(float64(max)/float64(b.N), "max-range-share")
+		})
+	}
+}
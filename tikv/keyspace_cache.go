@@ -0,0 +1,144 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// keyspaceRefreshTimeout is the timeout of loading a keyspace meta in the
+	// background.
+	keyspaceRefreshTimeout = 10 * time.Second
+	// keyspaceRefreshRetryInterval is the time a stale meta is served before
+	// retrying a failed background load.
+	keyspaceRefreshRetryInterval = time.Second
+)
+
+// KeyspaceMeta is the meta of a keyspace.
+type KeyspaceMeta struct {
+	ID   uint32
+	Name string
+	// APIVersion is the version of the key encoding used by the keyspace.
+	APIVersion uint32
+	// ReadOnly indicates that the keyspace rejects writes.
+	ReadOnly bool
+}
+
+// KeyspaceMetaLoader loads the meta of keyspaces, e.g. from PD.
+type KeyspaceMetaLoader interface {
+	LoadKeyspaceMeta(ctx context.Context, keyspaceID uint32) (KeyspaceMeta, error)
+}
+
+type keyspaceCacheEntry struct {
+	meta       KeyspaceMeta
+	expireAt   time.Time
+	refreshing bool
+}
+
+// KeyspaceMetadataCache caches the meta of keyspaces so that transactions
+// don't load it for each of them.
+//
+// A cached meta is served for the TTL. After it expires, or after it's
+// invalidated, the stale meta is still served while it's reloaded in the
+// background, so transactions are only blocked by the first load of a
+// keyspace. The cache doesn't watch the keyspaces, the owner should call
+// Invalidate when it's notified of a change of the meta.
+type KeyspaceMetadataCache struct {
+	loader KeyspaceMetaLoader
+	ttl    time.Duration
+	group  singleflight.Group
+
+	mu      sync.Mutex
+	entries map[uint32]*keyspaceCacheEntry
+}
+
+// NewKeyspaceMetadataCache creates a KeyspaceMetadataCache loading the meta by
+// the loader and serving it for the TTL.
+func NewKeyspaceMetadataCache(loader KeyspaceMetaLoader, ttl time.Duration) *KeyspaceMetadataCache {
+	return &KeyspaceMetadataCache{
+		loader:  loader,
+		ttl:     ttl,
+		entries: make(map[uint32]*keyspaceCacheEntry),
+	}
+}
+
+// Refresh returns the meta of the keyspace. It's loaded synchronously only if
+// the keyspace is not cached. A stale meta is returned as is and reloaded in
+// the background.
+func (c *KeyspaceMetadataCache) Refresh(ctx context.Context, keyspaceID uint32) (KeyspaceMeta, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[keyspaceID]
+	if ok {
+		meta := entry.meta
+		if !entry.refreshing && !time.Now().Before(entry.expireAt) {
+			entry.refreshing = true
+			go c.refreshInBackground(keyspaceID)
+		}
+		c.mu.Unlock()
+		return meta, nil
+	}
+	c.mu.Unlock()
+	return c.load(ctx, keyspaceID)
+}
+
+// Invalidate marks the cached meta of the keyspace stale, the next Refresh
+// reloads it.
+func (c *KeyspaceMetadataCache) Invalidate(keyspaceID uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[keyspaceID]; ok {
+		entry.expireAt = time.Time{}
+	}
+}
+
+func (c *KeyspaceMetadataCache) load(ctx context.Context, keyspaceID uint32) (KeyspaceMeta, error) {
+	v, err, _ := c.group.Do(strconv.FormatUint(uint64(keyspaceID), 10), func() (interface{}, error) {
+		meta, err := c.loader.LoadKeyspaceMeta(ctx, keyspaceID)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.entries[keyspaceID] = &keyspaceCacheEntry{meta: meta, expireAt: time.Now().Add(c.ttl)}
+		c.mu.Unlock()
+		return meta, nil
+	})
+	if err != nil {
+		return KeyspaceMeta{}, errors.Trace(err)
+	}
+	return v.(KeyspaceMeta), nil
+}
+
+func (c *KeyspaceMetadataCache) refreshInBackground(keyspaceID uint32) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyspaceRefreshTimeout)
+	defer cancel()
+	if _, err := c.load(ctx, keyspaceID); err != nil {
+		logutil.BgLogger().Warn("refresh keyspace meta failed, keep serving the stale one",
+			zap.Uint32("keyspaceID", keyspaceID), zap.Error(err))
+		c.mu.Lock()
+		if entry, ok := c.entries[keyspaceID]; ok {
+			entry.refreshing = false
+			entry.expireAt = time.Now().Add(keyspaceRefreshRetryInterval)
+		}
+		c.mu.Unlock()
+	}
+}
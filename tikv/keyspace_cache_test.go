@@ -0,0 +1,66 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type mockKeyspaceLoader struct {
+	loads    int32
+	readOnly int32
+	fail     int32
+}
+
+func (l *mockKeyspaceLoader) LoadKeyspaceMeta(ctx context.Context, keyspaceID uint32) (KeyspaceMeta, error) {
+	atomic.AddInt32(&l.loads, 1)
+	if atomic.LoadInt32(&l.fail) > 0 {
+		return KeyspaceMeta{}, errors.New("mock load failure")
+	}
+	return KeyspaceMeta{ID: keyspaceID, ReadOnly: atomic.LoadInt32(&l.readOnly) > 0}, nil
+}
+
+func TestKeyspaceMetadataCache(t *testing.T) {
+	loader := &mockKeyspaceLoader{fail: 1}
+	c := NewKeyspaceMetadataCache(loader, time.Hour)
+	ctx := context.Background()
+
+	_, err := c.Refresh(ctx, 1)
+	require.NotNil(t, err)
+	atomic.StoreInt32(&loader.fail, 0)
+	meta, err := c.Refresh(ctx, 1)
+	require.Nil(t, err)
+	require.Equal(t, KeyspaceMeta{ID: 1}, meta)
+	_, err = c.Refresh(ctx, 1)
+	require.Nil(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&loader.loads))
+
+	// The stale meta is served while it's reloaded in the background.
+	atomic.StoreInt32(&loader.readOnly, 1)
+	c.Invalidate(1)
+	meta, err = c.Refresh(ctx, 1)
+	require.Nil(t, err)
+	require.False(t, meta.ReadOnly)
+	require.Eventually(t, func() bool {
+		meta, err = c.Refresh(ctx, 1)
+		return err == nil && meta.ReadOnly
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(3), atomic.LoadInt32(&loader.loads))
+}
@@ -58,6 +58,7 @@ import (
 	"github.com/tikv/client-go/v2/metrics"
 	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/oracle/oracles"
+	"github.com/tikv/client-go/v2/scheduler"
 	"github.com/tikv/client-go/v2/tikvrpc"
 	"github.com/tikv/client-go/v2/util"
 	pd "github.com/tikv/pd/client"
@@ -102,6 +103,14 @@ type KVStore struct {
 	regionCache  *locate.RegionCache
 	lockResolver *LockResolver
 	txnLatches   *latch.LatchesScheduler
+	// intentCoalescer serializes the optimistic transactions writing the same
+	// keys, it's nil if disabled.
+	intentCoalescer *IntentCoalescer
+	// priorityScheduler schedules the commits by priority, it's nil if disabled.
+	priorityScheduler *scheduler.PriorityScheduler
+
+	// conflictGraph detects the deadlocks among the pessimistic transactions of the store.
+	conflictGraph *ConflictGraph
 
 	mock bool
 
@@ -117,6 +126,26 @@ type KVStore struct {
 
 	replicaReadSeed uint32 // this is used to load balance followers / learners when replica read is enabled
 
+	// writeStall paces the prewrite batches when TiKV is stalled, it's shared
+	// with the other stores of the cluster.
+	writeStall *client.WriteStallDetector
+	// flowControl paces the prewrite batches by the flow control advisory of
+	// TiKV, it's nil if disabled.
+	flowControl *FlowControlHandler
+	// prewriteModePredictor narrows down the commit protocols of the
+	// transactions, it's nil if disabled.
+	prewriteModePredictor PrewriteModePredictor
+	// secondaryBatcher commits the secondary batches in waves, it's nil if
+	// disabled.
+	secondaryBatcher *DynamicSecondaryBatcher
+	// adaptiveTimeout sets the timeouts of the retried requests of the
+	// transactions, it's nil if disabled.
+	adaptiveTimeout *AdaptiveTimeoutPolicy
+
+	// capabilities is the *ServerCapabilities restricting the features used
+	// by transactions, it's empty if the features are not restricted.
+	capabilities atomic.Value
+
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
@@ -161,8 +190,9 @@ func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Cl
 		return nil, errors.Trace(err)
 	}
 	ctx, cancel := context.WithCancel(context.Background())
+	clusterID := pdClient.GetClusterID(context.TODO())
 	store := &KVStore{
-		clusterID:       pdClient.GetClusterID(context.TODO()),
+		clusterID:       clusterID,
 		uuid:            uuid,
 		oracle:          o,
 		pdClient:        pdClient,
@@ -171,10 +201,12 @@ func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Cl
 		safePoint:       0,
 		spTime:          time.Now(),
 		replicaReadSeed: rand.Uint32(),
+		writeStall:      client.ClusterWriteStallDetector(clusterID),
+		conflictGraph:   NewConflictGraph(),
 		ctx:             ctx,
 		cancel:          cancel,
 	}
-	store.clientMu.client = client.NewReqCollapse(tikvclient)
+	store.clientMu.client = client.NewReqCollapse(client.NewWriteStallClient(tikvclient, store.writeStall))
 	store.lockResolver = newLockResolver(store)
 
 	store.wg.Add(2)
@@ -212,6 +244,12 @@ func NewTxnClient(pdAddrs []string) (*KVStore, error) {
 	if cfg.TxnLocalLatches.Enabled {
 		s.EnableTxnLocalLatches(cfg.TxnLocalLatches.Capacity)
 	}
+	capabilities, err := s.NegotiateCapabilities(context.TODO())
+	if err != nil {
+		logutil.BgLogger().Warn("failed to negotiate capabilities with TiKV", zap.Error(err))
+	} else {
+		s.SetCapabilities(capabilities)
+	}
 	return s, nil
 }
 
@@ -246,6 +284,102 @@ func (s *KVStore) EnableTxnLocalLatches(size uint) {
 	s.txnLatches = latch.NewScheduler(size)
 }
 
+// EnableIntentCoalescing makes the optimistic transactions writing the same
+// keys commit one by one, each waiting for at most maxWait. It should be called
+// before using the store to serve any requests.
+func (s *KVStore) EnableIntentCoalescing(maxWait time.Duration) {
+	s.intentCoalescer = NewIntentCoalescer(maxWait)
+}
+
+// EnablePriorityScheduling makes the prewrites of high priority dispatched
+// before the others when the writes are paced because TiKV is stalled. It
+// should be called before using the store to serve any requests.
+func (s *KVStore) EnablePriorityScheduling() {
+	s.priorityScheduler = scheduler.NewPriorityScheduler(s.writeStall)
+}
+
+// SchedulerStats returns the number of the prewrite batches waiting to be
+// dispatched by priority, it's empty if priority scheduling is disabled.
+func (s *KVStore) SchedulerStats() scheduler.SchedulerStats {
+	if s.priorityScheduler == nil {
+		return scheduler.SchedulerStats{}
+	}
+	return s.priorityScheduler.Stats()
+}
+
+// EnablePrewriteFlowControl makes the prewrite batches sleep when the flow
+// control advisory read by signal reports that TiKV is overloaded. It should be
+// called before using the store to serve any requests.
+func (s *KVStore) EnablePrewriteFlowControl(signal FlowControlSignal) {
+	s.flowControl = NewFlowControlHandler(signal)
+}
+
+// SetPrewriteModePredictor makes the transactions use 1PC or async commit only
+// if predictor predicts it's faster than the protocols after it. It should be
+// called before using the store to serve any requests.
+func (s *KVStore) SetPrewriteModePredictor(predictor PrewriteModePredictor) {
+	s.prewriteModePredictor = predictor
+}
+
+// SetAdaptiveTimeout makes the reads and the writes of the transactions set
+// the timeouts of their retried requests by policy, see WithAdaptiveTimeout.
+// It should be called before using the store to serve any requests.
+func (s *KVStore) SetAdaptiveTimeout(policy *AdaptiveTimeoutPolicy) {
+	s.adaptiveTimeout = policy
+}
+
+// senderOptions are the options of the senders of the transactions.
+func (s *KVStore) senderOptions() []SenderOption {
+	if s.adaptiveTimeout == nil {
+		return nil
+	}
+	return []SenderOption{WithAdaptiveTimeout(s.adaptiveTimeout)}
+}
+
+// EnableDynamicSecondaryCommit makes the transactions commit the secondary
+// batches by a DynamicSecondaryBatcher of concurrency workers, which sends the
+// slow batches again instead of waiting for them. It should be called before
+// using the store to serve any requests.
+func (s *KVStore) EnableDynamicSecondaryCommit(concurrency int) {
+	s.secondaryBatcher = NewDynamicSecondaryBatcher(concurrency)
+}
+
+// EnableStoreTopologyWatcher makes the store load the store list from PD every
+// interval, so the stores joining or leaving the cluster are known without
+// traffic to them. It should be called at most once.
+func (s *KVStore) EnableStoreTopologyWatcher(interval time.Duration) {
+	w := locate.NewStoreTopologyWatcher(s.regionCache, interval)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		w.Run(s.ctx)
+	}()
+}
+
+// EnableSplitConnPrewarm makes the store connect to the leaders of the new
+// regions once it learns a region is split, before any request is sent to
+// them.
+func (s *KVStore) EnableSplitConnPrewarm() {
+	s.regionCache.RegisterSplitObserver(locate.NewLeaderConnPrewarmer(s.regionCache, s.GetTiKVClient()))
+}
+
+// EnablePDLeaderMonitor makes the store watch the PD leader through the etcd
+// client of its SafePointKV. It fails if the store doesn't use an
+// EtcdSafePointKV. It should be called at most once.
+func (s *KVStore) EnablePDLeaderMonitor() (*PDLeaderMonitor, error) {
+	etcdKV, ok := s.kv.(*EtcdSafePointKV)
+	if !ok {
+		return nil, errors.New("pd leader monitor requires an etcd safe point kv")
+	}
+	m := NewPDLeaderMonitor(etcdKV.cli, s.pdClient)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		m.Run(s.ctx)
+	}()
+	return m, nil
+}
+
 // IsLatchEnabled is used by mockstore.TestConfig.
 func (s *KVStore) IsLatchEnabled() bool {
 	return s.txnLatches != nil
@@ -274,13 +408,13 @@ func (s *KVStore) runSafePointChecker() {
 }
 
 // Begin a global transaction.
-func (s *KVStore) Begin() (*KVTxn, error) {
-	return s.BeginWithOption(DefaultStartTSOption())
+func (s *KVStore) Begin(opts ...TxnOption) (*KVTxn, error) {
+	return s.BeginWithOption(DefaultStartTSOption(), opts...)
 }
 
 // BeginWithOption begins a transaction with the given StartTSOption
-func (s *KVStore) BeginWithOption(options StartTSOption) (*KVTxn, error) {
-	return newTiKVTxnWithOptions(s, options)
+func (s *KVStore) BeginWithOption(options StartTSOption, opts ...TxnOption) (*KVTxn, error) {
+	return newTiKVTxnWithOptions(s, options, opts...)
 }
 
 // GetSnapshot gets a snapshot that is able to read any data which data is <= ver.
@@ -378,7 +512,8 @@ func (s *KVStore) SupportDeleteRange() (supported bool) {
 
 // SendReq sends a request to locate.
 func (s *KVStore) SendReq(bo *Backoffer, req *tikvrpc.Request, regionID locate.RegionVerID, timeout time.Duration) (*tikvrpc.Response, error) {
-	sender := locate.NewRegionRequestSender(s.regionCache, s.GetTiKVClient())
+	sender := locate.NewRegionRequestSender(s.regionCache, s.GetTiKVClient(), s.senderOptions()...)
+	sender.SetLeaderWritePolicy(locate.LeaderWriteAlways)
 	return sender.SendReq(bo, req, regionID, timeout)
 }
 
@@ -503,7 +638,7 @@ func (s *KVStore) updateSafeTS(ctx context.Context) {
 			resp, err := tikvClient.SendRequest(ctx, storeAddr, tikvrpc.NewRequest(tikvrpc.CmdStoreSafeTS, &kvrpcpb.StoreSafeTSRequest{KeyRange: &kvrpcpb.KeyRange{
 				StartKey: []byte(""),
 				EndKey:   []byte(""),
-			}}), client.ReadTimeoutShort)
+			}}), client.GlobalTimeouts().ReadShort)
 			storeIDStr := strconv.Itoa(int(storeID))
 			if err != nil {
 				metrics.TiKVSafeTSUpdateCounter.WithLabelValues("fail", storeIDStr).Inc()
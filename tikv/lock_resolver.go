@@ -73,6 +73,8 @@ type LockResolver struct {
 		resolved       map[uint64]TxnStatus
 		recentResolved *list.List
 	}
+	// statusCache caches the statuses of uncommitted txns, nil if disabled.
+	statusCache  *txnStatusCache
 	testingKnobs struct {
 		meetLock func(locks []*Lock)
 	}
@@ -84,6 +86,9 @@ func newLockResolver(store *KVStore) *LockResolver {
 	}
 	r.mu.resolved = make(map[uint64]TxnStatus)
 	r.mu.recentResolved = list.New()
+	if capacity := config.GetGlobalConfig().TiKVClient.TxnStatusCacheCapacity; capacity > 0 {
+		r.statusCache = newTxnStatusCache(int(capacity))
+	}
 	return r
 }
 
@@ -592,6 +597,15 @@ func (lr *LockResolver) getTxnStatus(bo *Backoffer, txnID uint64, primary []byte
 
 	var status TxnStatus
 	resolvingPessimisticLock := lockInfo != nil && lockInfo.LockType == kvrpcpb.Op_PessimisticLock
+	// The cached status of an uncommitted txn is only used if the lock is not
+	// expired, an expired lock should be checked by TiKV to roll it back.
+	useStatusCache := lr.statusCache != nil && !forceSyncCommit && !resolvingPessimisticLock
+	if useStatusCache {
+		if s, ok := lr.statusCache.get(txnID, callerStartTS); ok &&
+			!lr.store.GetOracle().IsExpired(txnID, s.ttl, &oracle.Option{TxnScope: oracle.GlobalTxnScope}) {
+			return s, nil
+		}
+	}
 	req := tikvrpc.NewRequest(tikvrpc.CmdCheckTxnStatus, &kvrpcpb.CheckTxnStatusRequest{
 		PrimaryKey:               primary,
 		LockTs:                   txnID,
@@ -641,9 +655,15 @@ func (lr *LockResolver) getTxnStatus(bo *Backoffer, txnID uint64, primary []byte
 		if status.primaryLock != nil && status.primaryLock.UseAsyncCommit && !forceSyncCommit {
 			if !lr.store.GetOracle().IsExpired(txnID, cmdResp.LockTtl, &oracle.Option{TxnScope: oracle.GlobalTxnScope}) {
 				status.ttl = cmdResp.LockTtl
+				if useStatusCache {
+					lr.statusCache.put(txnID, callerStartTS, status)
+				}
 			}
 		} else if cmdResp.LockTtl != 0 {
 			status.ttl = cmdResp.LockTtl
+			if useStatusCache {
+				lr.statusCache.put(txnID, callerStartTS, status)
+			}
 		} else {
 			if cmdResp.CommitVersion == 0 {
 				metrics.LockResolverCountWithQueryTxnStatusRolledBack.Inc()
@@ -655,6 +675,9 @@ func (lr *LockResolver) getTxnStatus(bo *Backoffer, txnID uint64, primary []byte
 			if status.StatusCacheable() {
 				lr.saveResolved(txnID, status)
 			}
+			if lr.statusCache != nil {
+				lr.statusCache.remove(txnID)
+			}
 		}
 
 		return status, nil
@@ -0,0 +1,617 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	otlpDefaultEndpoint = "localhost:4317"
+	otlpExportMethod    = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+	// otlpQueueSize is the max number of the finished spans waiting to be
+	// exported, the spans finished when the queue is full are dropped.
+	otlpQueueSize = 2048
+	// otlpMaxBatchSize is the max number of the spans exported in a request.
+	otlpMaxBatchSize   = 512
+	otlpExportInterval = 5 * time.Second
+	otlpExportTimeout  = 10 * time.Second
+)
+
+// OTLPTraceExporter exports the spans of the commits to an OpenTelemetry
+// collector by OTLP over gRPC. The finished spans are queued and exported in
+// batches by a background goroutine, every otlpExportInterval or once a batch
+// is full, so finishing a span doesn't wait for the network.
+//
+// The exporter is configured by the standard OpenTelemetry environment
+// variables:
+//   - OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT is the
+//     address of the collector, "localhost:4317" by default. The http scheme
+//     or OTEL_EXPORTER_OTLP_INSECURE=true disables TLS.
+//   - OTEL_SERVICE_NAME is the service.name of the resource, "tikv-client" by
+//     default.
+//
+// The OpenTelemetry SDK isn't a dependency of the module, the spans are encoded
+// in the OTLP protobuf format directly.
+type OTLPTraceExporter struct {
+	conn        *grpc.ClientConn
+	serviceName string
+	tracer      *otlpTracer
+
+	queue   chan *otlpSpan
+	flushCh chan chan struct{}
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+	dropped uint64
+}
+
+// EnableOTLPTracing creates an OTLPTraceExporter configured from the
+// environment and registers its tracer by SetCommitTracer, so every commit is
+// traced. Shutdown must be called to flush the spans when the program exits.
+func EnableOTLPTracing() (*OTLPTraceExporter, error) {
+	e, err := NewOTLPTraceExporter()
+	if err != nil {
+		return nil, err
+	}
+	SetCommitTracer(e.Tracer())
+	return e, nil
+}
+
+// NewOTLPTraceExporter creates an OTLPTraceExporter configured from the
+// environment.
+func NewOTLPTraceExporter() (*OTLPTraceExporter, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = otlpDefaultEndpoint
+	}
+	insecure, _ := strconv.ParseBool(os.Getenv("OTEL_EXPORTER_OTLP_INSECURE"))
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid OTLP endpoint %q", endpoint)
+		}
+		insecure = insecure || u.Scheme == "http"
+		endpoint = u.Host
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "tikv-client"
+	}
+	return newOTLPTraceExporter(endpoint, insecure, serviceName)
+}
+
+func newOTLPTraceExporter(endpoint string, insecure bool, serviceName string) (*OTLPTraceExporter, error) {
+	opt := grpc.WithInsecure()
+	if !insecure {
+		opt = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+	}
+	conn, err := grpc.Dial(endpoint, opt)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	e := &OTLPTraceExporter{
+		conn:        conn,
+		serviceName: serviceName,
+		queue:       make(chan *otlpSpan, otlpQueueSize),
+		flushCh:     make(chan chan struct{}),
+		closeCh:     make(chan struct{}),
+	}
+	e.tracer = &otlpTracer{exporter: e, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	e.wg.Add(1)
+	go e.run()
+	return e, nil
+}
+
+// Tracer returns the OpenTracing tracer whose finished spans are exported.
+// Injecting and extracting span contexts are not supported.
+func (e *OTLPTraceExporter) Tracer() opentracing.Tracer {
+	return e.tracer
+}
+
+// Flush exports the queued spans and waits until they're exported.
+func (e *OTLPTraceExporter) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case e.flushCh <- done:
+	case <-e.closeCh:
+		return nil
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	}
+}
+
+// Shutdown exports the queued spans and closes the connection to the
+// collector. The spans finished after Shutdown are dropped.
+func (e *OTLPTraceExporter) Shutdown(ctx context.Context) error {
+	err := e.Flush(ctx)
+	e.once.Do(func() {
+		close(e.closeCh)
+		e.wg.Wait()
+		if err1 := e.conn.Close(); err == nil {
+			err = errors.Trace(err1)
+		}
+	})
+	return err
+}
+
+func (e *OTLPTraceExporter) enqueue(s *otlpSpan) {
+	select {
+	case <-e.closeCh:
+		return
+	default:
+	}
+	select {
+	case e.queue <- s:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+func (e *OTLPTraceExporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(otlpExportInterval)
+	defer ticker.Stop()
+	batch := make([]*otlpSpan, 0, otlpMaxBatchSize)
+	export := func() {
+		if len(batch) > 0 {
+			e.export(batch)
+			batch = batch[:0]
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case s := <-e.queue:
+				if batch = append(batch, s); len(batch) == otlpMaxBatchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) == otlpMaxBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-e.flushCh:
+			drain()
+			close(done)
+		case <-e.closeCh:
+			drain()
+			return
+		}
+	}
+}
+
+func (e *OTLPTraceExporter) export(spans []*otlpSpan) {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpExportTimeout)
+	defer cancel()
+	req := &otlpExportRequest{serviceName: e.serviceName, spans: spans}
+	if err := e.conn.Invoke(ctx, otlpExportMethod, req, &otlpExportResponse{}); err != nil {
+		logutil.BgLogger().Warn("failed to export spans by OTLP",
+			zap.Int("spans", len(spans)),
+			zap.Uint64("dropped", atomic.LoadUint64(&e.dropped)),
+			zap.Error(err))
+	}
+}
+
+type otlpTracer struct {
+	exporter *OTLPTraceExporter
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// randomID returns a random ID of n bytes, n is a multiple of 8.
+func (t *otlpTracer) randomID(n int) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := make([]byte, n)
+	for i := 0; i < n; i += 8 {
+		binary.BigEndian.PutUint64(id[i:], t.rand.Uint64())
+	}
+	return id
+}
+
+// StartSpan implements opentracing.Tracer.
+func (t *otlpTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var options opentracing.StartSpanOptions
+	for _, opt := range opts {
+		opt.Apply(&options)
+	}
+	s := &otlpSpan{tracer: t, name: operationName, start: options.StartTime}
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	for _, ref := range options.References {
+		if parent, ok := ref.ReferencedContext.(otlpSpanContext); ok {
+			s.ctx.traceID = parent.traceID
+			s.parentID = parent.spanID
+			break
+		}
+	}
+	if s.parentID == nil {
+		s.ctx.traceID = t.randomID(16)
+	}
+	s.ctx.spanID = t.randomID(8)
+	for k, v := range options.Tags {
+		s.SetTag(k, v)
+	}
+	return s
+}
+
+// Inject implements opentracing.Tracer.
+func (t *otlpTracer) Inject(sm opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	return opentracing.ErrUnsupportedFormat
+}
+
+// Extract implements opentracing.Tracer.
+func (t *otlpTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	return nil, opentracing.ErrUnsupportedFormat
+}
+
+type otlpSpanContext struct {
+	traceID []byte
+	spanID  []byte
+}
+
+// ForeachBaggageItem implements opentracing.SpanContext, baggage is not
+// supported.
+func (otlpSpanContext) ForeachBaggageItem(handler func(k, v string) bool) {}
+
+type otlpAttribute struct {
+	key   string
+	value interface{}
+}
+
+type otlpEvent struct {
+	time       time.Time
+	name       string
+	attributes []otlpAttribute
+}
+
+type otlpSpan struct {
+	tracer   *otlpTracer
+	ctx      otlpSpanContext
+	parentID []byte
+
+	mu         sync.Mutex
+	name       string
+	start, end time.Time
+	attributes []otlpAttribute
+	events     []otlpEvent
+	isError    bool
+}
+
+// Finish implements opentracing.Span.
+func (s *otlpSpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+// FinishWithOptions implements opentracing.Span.
+func (s *otlpSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.mu.Lock()
+	s.end = opts.FinishTime
+	if s.end.IsZero() {
+		s.end = time.Now()
+	}
+	s.mu.Unlock()
+	for _, r := range opts.LogRecords {
+		s.log(r.Timestamp, r.Fields)
+	}
+	s.tracer.exporter.enqueue(s)
+}
+
+// Context implements opentracing.Span.
+func (s *otlpSpan) Context() opentracing.SpanContext {
+	return s.ctx
+}
+
+// SetOperationName implements opentracing.Span.
+func (s *otlpSpan) SetOperationName(operationName string) opentracing.Span {
+	s.mu.Lock()
+	s.name = operationName
+	s.mu.Unlock()
+	return s
+}
+
+// SetTag implements opentracing.Span. The error tag sets the status of the span.
+func (s *otlpSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key == "error" {
+		if b, ok := value.(bool); ok {
+			s.isError = b
+			return s
+		}
+	}
+	s.attributes = append(s.attributes, otlpAttribute{key: key, value: value})
+	return s
+}
+
+// LogFields implements opentracing.Span.
+func (s *otlpSpan) LogFields(fields ...otlog.Field) {
+	s.log(time.Now(), fields)
+}
+
+// LogKV implements opentracing.Span.
+func (s *otlpSpan) LogKV(alternatingKeyValues ...interface{}) {
+	fields, err := otlog.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		fields = []otlog.Field{otlog.Error(err)}
+	}
+	s.log(time.Now(), fields)
+}
+
+func (s *otlpSpan) log(t time.Time, fields []otlog.Field) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	event := otlpEvent{time: t, name: "log"}
+	for _, f := range fields {
+		if f.Key() == "event" {
+			event.name = fmt.Sprint(f.Value())
+			continue
+		}
+		event.attributes = append(event.attributes, otlpAttribute{key: f.Key(), value: f.Value()})
+	}
+	s.mu.Lock()
+	s.events = append(s.events, event)
+	s.mu.Unlock()
+}
+
+// SetBaggageItem implements opentracing.Span, baggage is not supported.
+func (s *otlpSpan) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	return s
+}
+
+// BaggageItem implements opentracing.Span, baggage is not supported.
+func (s *otlpSpan) BaggageItem(restrictedKey string) string {
+	return ""
+}
+
+// Tracer implements opentracing.Span.
+func (s *otlpSpan) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+// LogEvent implements opentracing.Span.
+func (s *otlpSpan) LogEvent(event string) {
+	s.LogFields(otlog.String("event", event))
+}
+
+// LogEventWithPayload implements opentracing.Span.
+func (s *otlpSpan) LogEventWithPayload(event string, payload interface{}) {
+	s.LogFields(otlog.String("event", event), otlog.Object("payload", payload))
+}
+
+// Log implements opentracing.Span.
+func (s *otlpSpan) Log(data opentracing.LogData) {
+	s.log(data.Timestamp, data.ToLogRecord().Fields)
+}
+
+// otlpExportRequest is an ExportTraceServiceRequest of OTLP. It implements
+// proto.Marshaler, so the gRPC codec sends the encoded bytes as they are.
+type otlpExportRequest struct {
+	serviceName string
+	spans       []*otlpSpan
+}
+
+func (r *otlpExportRequest) Reset()         {}
+func (r *otlpExportRequest) String() string { return "ExportTraceServiceRequest" }
+func (r *otlpExportRequest) ProtoMessage()  {}
+
+// Field numbers of the OTLP trace protocol.
+const (
+	otlpRequestResourceSpans    = 1
+	otlpResourceSpansResource   = 1
+	otlpResourceSpansScopeSpans = 2
+	otlpResourceAttributes      = 1
+	otlpScopeSpansScope         = 1
+	otlpScopeSpansSpans         = 2
+	otlpScopeName               = 1
+	otlpSpanTraceID             = 1
+	otlpSpanSpanID              = 2
+	otlpSpanParentSpanID        = 4
+	otlpSpanName                = 5
+	otlpSpanKind                = 6
+	otlpSpanStartTime           = 7
+	otlpSpanEndTime             = 8
+	otlpSpanAttributes          = 9
+	otlpSpanEvents              = 11
+	otlpSpanStatus              = 15
+	otlpEventTime               = 1
+	otlpEventName               = 2
+	otlpEventAttributes         = 3
+	otlpStatusCode              = 3
+	otlpKeyValueKey             = 1
+	otlpKeyValueValue           = 2
+	otlpAnyValueString          = 1
+	otlpAnyValueBool            = 2
+	otlpAnyValueInt             = 3
+	otlpAnyValueDouble          = 4
+
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+)
+
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+// Marshal encodes the request in the protobuf format.
+func (r *otlpExportRequest) Marshal() ([]byte, error) {
+	resource := otlpEncodeMessage(func(b *proto.Buffer) {
+		otlpEncodeAttribute(b, otlpResourceAttributes, otlpAttribute{key: "service.name", value: r.serviceName})
+	})
+	scopeSpans := otlpEncodeMessage(func(b *proto.Buffer) {
+		otlpEncodeBytes(b, otlpScopeSpansScope, otlpEncodeMessage(func(b *proto.Buffer) {
+			otlpEncodeString(b, otlpScopeName, "github.com/tikv/client-go")
+		}))
+		for _, s := range r.spans {
+			otlpEncodeBytes(b, otlpScopeSpansSpans, s.marshal())
+		}
+	})
+	resourceSpans := otlpEncodeMessage(func(b *proto.Buffer) {
+		otlpEncodeBytes(b, otlpResourceSpansResource, resource)
+		otlpEncodeBytes(b, otlpResourceSpansScopeSpans, scopeSpans)
+	})
+	return otlpEncodeMessage(func(b *proto.Buffer) {
+		otlpEncodeBytes(b, otlpRequestResourceSpans, resourceSpans)
+	}), nil
+}
+
+func (s *otlpSpan) marshal() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return otlpEncodeMessage(func(b *proto.Buffer) {
+		otlpEncodeBytes(b, otlpSpanTraceID, s.ctx.traceID)
+		otlpEncodeBytes(b, otlpSpanSpanID, s.ctx.spanID)
+		if s.parentID != nil {
+			otlpEncodeBytes(b, otlpSpanParentSpanID, s.parentID)
+		}
+		otlpEncodeString(b, otlpSpanName, s.name)
+		otlpEncodeVarint(b, otlpSpanKind, otlpSpanKindInternal)
+		otlpEncodeFixed64(b, otlpSpanStartTime, uint64(s.start.UnixNano()))
+		otlpEncodeFixed64(b, otlpSpanEndTime, uint64(s.end.UnixNano()))
+		for _, a := range s.attributes {
+			otlpEncodeAttribute(b, otlpSpanAttributes, a)
+		}
+		for _, e := range s.events {
+			otlpEncodeBytes(b, otlpSpanEvents, otlpEncodeMessage(func(b *proto.Buffer) {
+				otlpEncodeFixed64(b, otlpEventTime, uint64(e.time.UnixNano()))
+				otlpEncodeString(b, otlpEventName, e.name)
+				for _, a := range e.attributes {
+					otlpEncodeAttribute(b, otlpEventAttributes, a)
+				}
+			}))
+		}
+		if s.isError {
+			otlpEncodeBytes(b, otlpSpanStatus, otlpEncodeMessage(func(b *proto.Buffer) {
+				otlpEncodeVarint(b, otlpStatusCode, otlpStatusCodeError)
+			}))
+		}
+	})
+}
+
+func otlpEncodeMessage(encode func(b *proto.Buffer)) []byte {
+	b := proto.NewBuffer(nil)
+	encode(b)
+	return b.Bytes()
+}
+
+func otlpEncodeTag(b *proto.Buffer, field int, wireType int) {
+	_ = b.EncodeVarint(uint64(field)<<3 | uint64(wireType))
+}
+
+func otlpEncodeBytes(b *proto.Buffer, field int, v []byte) {
+	otlpEncodeTag(b, field, protoWireBytes)
+	_ = b.EncodeRawBytes(v)
+}
+
+func otlpEncodeString(b *proto.Buffer, field int, v string) {
+	otlpEncodeTag(b, field, protoWireBytes)
+	_ = b.EncodeStringBytes(v)
+}
+
+func otlpEncodeVarint(b *proto.Buffer, field int, v uint64) {
+	otlpEncodeTag(b, field, protoWireVarint)
+	_ = b.EncodeVarint(v)
+}
+
+func otlpEncodeFixed64(b *proto.Buffer, field int, v uint64) {
+	otlpEncodeTag(b, field, protoWireFixed64)
+	_ = b.EncodeFixed64(v)
+}
+
+func otlpEncodeAttribute(b *proto.Buffer, field int, a otlpAttribute) {
+	value := otlpEncodeMessage(func(b *proto.Buffer) {
+		switch v := a.value.(type) {
+		case string:
+			otlpEncodeString(b, otlpAnyValueString, v)
+		case bool:
+			var x uint64
+			if v {
+				x = 1
+			}
+			otlpEncodeVarint(b, otlpAnyValueBool, x)
+		case int:
+			otlpEncodeVarint(b, otlpAnyValueInt, uint64(v))
+		case int32:
+			otlpEncodeVarint(b, otlpAnyValueInt, uint64(v))
+		case int64:
+			otlpEncodeVarint(b, otlpAnyValueInt, uint64(v))
+		case uint32:
+			otlpEncodeVarint(b, otlpAnyValueInt, uint64(v))
+		case uint64:
+			otlpEncodeVarint(b, otlpAnyValueInt, v)
+		case float64:
+			otlpEncodeFixed64(b, otlpAnyValueDouble, math.Float64bits(v))
+		default:
+			otlpEncodeString(b, otlpAnyValueString, fmt.Sprint(v))
+		}
+	})
+	otlpEncodeBytes(b, field, otlpEncodeMessage(func(b *proto.Buffer) {
+		otlpEncodeString(b, otlpKeyValueKey, a.key)
+		otlpEncodeBytes(b, otlpKeyValueValue, value)
+	}))
+}
+
+// otlpExportResponse is an ExportTraceServiceResponse of OTLP, its content is
+// ignored.
+type otlpExportResponse struct{}
+
+func (r *otlpExportResponse) Reset()                 {}
+func (r *otlpExportResponse) String() string         { return "ExportTraceServiceResponse" }
+func (r *otlpExportResponse) ProtoMessage()          {}
+func (r *otlpExportResponse) Unmarshal([]byte) error { return nil }
@@ -0,0 +1,161 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/util"
+	pd "github.com/tikv/pd/client"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"go.uber.org/zap"
+)
+
+const pdLeaderMonitorRetryInterval = time.Second
+
+// PDLeaderMonitor watches the leader key PD campaigns on in etcd and caches
+// the address of the PD leader. When the leader moves, it asks the PD client
+// to reload its members at once instead of waiting for a request to fail on
+// the old leader or for the periodic member check.
+type PDLeaderMonitor struct {
+	kv       clientv3.KV
+	watcher  clientv3.Watcher
+	pdClient pd.Client
+	leader   atomic.Value // string
+}
+
+// NewPDLeaderMonitor creates a PDLeaderMonitor that watches the PD leader
+// through the etcd client of PD.
+func NewPDLeaderMonitor(etcdCli *clientv3.Client, pdClient pd.Client) *PDLeaderMonitor {
+	return newPDLeaderMonitor(etcdCli.KV, etcdCli.Watcher, pdClient)
+}
+
+func newPDLeaderMonitor(kv clientv3.KV, watcher clientv3.Watcher, pdClient pd.Client) *PDLeaderMonitor {
+	m := &PDLeaderMonitor{kv: kv, watcher: watcher, pdClient: pdClient}
+	m.leader.Store("")
+	return m
+}
+
+// Leader returns the cached client URL of the PD leader. It's empty before
+// the leader is loaded or while PD is electing a new leader.
+func (m *PDLeaderMonitor) Leader() string {
+	return m.leader.Load().(string)
+}
+
+// Run watches the PD leader until ctx is done.
+func (m *PDLeaderMonitor) Run(ctx context.Context) {
+	key := fmt.Sprintf("/pd/%d/leader", m.pdClient.GetClusterID(ctx))
+	for {
+		if err := m.watch(ctx, key); err != nil {
+			logutil.BgLogger().Warn("watch pd leader failed", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pdLeaderMonitorRetryInterval):
+		}
+	}
+}
+
+// watch loads the leader and applies the changes of it until the watch is
+// broken.
+func (m *PDLeaderMonitor) watch(ctx context.Context, key string) error {
+	resp, err := m.kv.Get(ctx, key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(resp.Kvs) == 0 {
+		m.setLeader("")
+	} else if err := m.applyLeader(resp.Kvs[0].Value); err != nil {
+		return err
+	}
+	ch := m.watcher.Watch(ctx, key, clientv3.WithRev(resp.Header.GetRevision()+1))
+	for {
+		var wresp clientv3.WatchResponse
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return nil
+		case wresp, ok = <-ch:
+		}
+		if !ok {
+			return errors.New("pd leader watch channel is closed")
+		}
+		if err := wresp.Err(); err != nil {
+			return errors.Trace(err)
+		}
+		for _, ev := range wresp.Events {
+			switch ev.Type {
+			case mvccpb.PUT:
+				if err := m.applyLeader(ev.Kv.Value); err != nil {
+					return err
+				}
+			case mvccpb.DELETE:
+				m.setLeader("")
+			}
+		}
+	}
+}
+
+func (m *PDLeaderMonitor) applyLeader(value []byte) error {
+	var member pdpb.Member
+	if err := member.Unmarshal(value); err != nil {
+		return errors.Trace(err)
+	}
+	if len(member.GetClientUrls()) == 0 {
+		return errors.Errorf("pd leader %s has no client url", member.GetName())
+	}
+	m.setLeader(member.GetClientUrls()[0])
+	return nil
+}
+
+func (m *PDLeaderMonitor) setLeader(addr string) {
+	if m.Leader() == addr {
+		return
+	}
+	m.leader.Store(addr)
+	if addr == "" {
+		logutil.BgLogger().Info("pd leader is lost")
+		return
+	}
+	logutil.BgLogger().Info("pd leader changed", zap.String("leader", addr))
+	if m.pdClient.GetLeaderAddr() != addr {
+		scheduleCheckPDLeader(m.pdClient)
+	}
+}
+
+// scheduleCheckPDLeader asks the PD client to reload its members. Only the
+// concrete PD client has the method, so the wrappers are unwrapped first.
+func scheduleCheckPDLeader(c pd.Client) {
+	for {
+		switch w := c.(type) {
+		case interface{ ScheduleCheckLeader() }:
+			w.ScheduleCheckLeader()
+			return
+		case *CodecPDClient:
+			c = w.Client
+		case util.InterceptedPDClient:
+			c = w.Client
+		default:
+			return
+		}
+	}
+}
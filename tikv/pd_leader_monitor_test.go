@@ -0,0 +1,101 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util"
+	pd "github.com/tikv/pd/client"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/etcdserverpb"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+type leaderKV struct {
+	clientv3.KV
+	key   string
+	value []byte
+}
+
+func (kv *leaderKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: 10}}
+	if key == kv.key && kv.value != nil {
+		resp.Kvs = []*mvccpb.KeyValue{{Key: []byte(key), Value: kv.value}}
+	}
+	return resp, nil
+}
+
+type leaderWatcher struct {
+	clientv3.Watcher
+	ch chan clientv3.WatchResponse
+}
+
+func (w *leaderWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	return w.ch
+}
+
+type leaderPDClient struct {
+	pd.Client
+	leader  string
+	checked int32
+}
+
+func (c *leaderPDClient) GetClusterID(context.Context) uint64 { return 1 }
+
+func (c *leaderPDClient) GetLeaderAddr() string { return c.leader }
+
+func (c *leaderPDClient) ScheduleCheckLeader() { atomic.AddInt32(&c.checked, 1) }
+
+func marshalMember(t *testing.T, url string) []byte {
+	data, err := (&pdpb.Member{Name: url, ClientUrls: []string{url}}).Marshal()
+	require.Nil(t, err)
+	return data
+}
+
+func TestPDLeaderMonitor(t *testing.T) {
+	kv := &leaderKV{key: "/pd/1/leader", value: marshalMember(t, "http://pd1:2379")}
+	watcher := &leaderWatcher{ch: make(chan clientv3.WatchResponse)}
+	pdClient := &leaderPDClient{leader: "http://pd1:2379"}
+	m := newPDLeaderMonitor(kv, watcher, &CodecPDClient{Client: util.InterceptedPDClient{Client: pdClient}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool { return m.Leader() == "http://pd1:2379" }, time.Second, 10*time.Millisecond)
+	// The PD client knows the leader already.
+	require.Equal(t, int32(0), atomic.LoadInt32(&pdClient.checked))
+
+	// The leader resigns.
+	watcher.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte(kv.key)}}}}
+	require.Eventually(t, func() bool { return m.Leader() == "" }, time.Second, 10*time.Millisecond)
+
+	// A new leader is elected.
+	watcher.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte(kv.key), Value: marshalMember(t, "http://pd2:2379")}}}}
+	require.Eventually(t, func() bool { return m.Leader() == "http://pd2:2379" }, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&pdClient.checked))
+}
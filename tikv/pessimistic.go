@@ -122,8 +122,15 @@ func (action actionPessimisticLock) handleSingleBatch(c *twoPhaseCommitter, bo *
 		WaitTimeout:  action.LockWaitTime,
 		ReturnValues: action.ReturnValues,
 		MinCommitTs:  c.forUpdateTS + 1,
-	}, kvrpcpb.Context{Priority: c.priority, SyncLog: c.syncLog, ResourceGroupTag: action.LockCtx.ResourceGroupTag})
+	}, kvrpcpb.Context{Priority: c.priority, SyncLog: c.syncLogMode.syncLog(batch.isPrimary), ResourceGroupTag: action.LockCtx.ResourceGroupTag})
+	req.Metadata = c.txn.requestMetadata
 	lockWaitStartTime := action.WaitStartTime
+	var waitingFor []uint64
+	defer func() {
+		for _, txnID := range waitingFor {
+			c.store.conflictGraph.removeWait(c.startTS, txnID)
+		}
+	}()
 	for {
 		// if lockWaitTime set, refine the request `WaitTimeout` field based on timeout limit
 		if action.LockWaitTime > 0 {
@@ -161,7 +168,7 @@ func (action actionPessimisticLock) handleSingleBatch(c *twoPhaseCommitter, bo *
 					return errors.Trace(err)
 				}
 			}
-			same, err := batch.relocate(bo, c.store.regionCache)
+			same, err := c.relocateBatch(bo, &batch)
 			if err != nil {
 				return errors.Trace(err)
 			}
@@ -180,7 +187,12 @@ func (action actionPessimisticLock) handleSingleBatch(c *twoPhaseCommitter, bo *
 			if action.ReturnValues {
 				action.ValuesLock.Lock()
 				for i, mutation := range mutations {
-					action.Values[string(mutation.Key)] = kv.ReturnedValue{Value: lockResp.Values[i]}
+					value, err := c.txn.snapshot.decodeValue(lockResp.Values[i])
+					if err != nil {
+						action.ValuesLock.Unlock()
+						return errors.Trace(err)
+					}
+					action.Values[string(mutation.Key)] = kv.ReturnedValue{Value: value}
 				}
 				action.ValuesLock.Unlock()
 			}
@@ -220,7 +232,11 @@ func (action actionPessimisticLock) handleSingleBatch(c *twoPhaseCommitter, bo *
 		if msBeforeTxnExpired > 0 {
 			if action.LockWaitTime == LockNoWait {
 				return tikverr.ErrLockAcquireFailAndNoWaitSet
-			} else if action.LockWaitTime == LockAlwaysWait {
+			}
+			if cycle := c.waitForLocks(&waitingFor, locks); cycle != nil {
+				return errors.Trace(&tikverr.ErrDeadlockDetected{Cycle: cycle})
+			}
+			if action.LockWaitTime == LockAlwaysWait {
 				// do nothing but keep wait
 			} else {
 				// the lockWaitTime is set, we should return wait timeout if we are still blocked by a lock
@@ -247,12 +263,31 @@ func (action actionPessimisticLock) handleSingleBatch(c *twoPhaseCommitter, bo *
 	}
 }
 
+// waitForLocks records in the conflict graph that the transaction waits for
+// the locks, replacing the edges recorded in waitingFor by the previous wait.
+// It returns the cycle if the wait makes a deadlock.
+func (c *twoPhaseCommitter) waitForLocks(waitingFor *[]uint64, locks []*Lock) []uint64 {
+	g := c.store.conflictGraph
+	for _, txnID := range *waitingFor {
+		g.removeWait(c.startTS, txnID)
+	}
+	*waitingFor = (*waitingFor)[:0]
+	for _, l := range locks {
+		if l.TxnID != c.startTS {
+			g.waitFor(c.startTS, l.TxnID)
+			*waitingFor = append(*waitingFor, l.TxnID)
+		}
+	}
+	return g.findCycle(c.startTS)
+}
+
 func (actionPessimisticRollback) handleSingleBatch(c *twoPhaseCommitter, bo *Backoffer, batch batchMutations) error {
 	req := tikvrpc.NewRequest(tikvrpc.CmdPessimisticRollback, &kvrpcpb.PessimisticRollbackRequest{
 		StartVersion: c.startTS,
 		ForUpdateTs:  c.forUpdateTS,
 		Keys:         batch.mutations.GetKeys(),
 	})
+	req.Metadata = c.txn.requestMetadata
 	resp, err := c.store.SendReq(bo, req, batch.region, client.ReadTimeoutShort)
 	if err != nil {
 		return errors.Trace(err)
@@ -54,6 +54,16 @@ import (
 	"go.uber.org/zap"
 )
 
+// prewriteSentKey is the context key of the function called when a pipelined
+// prewrite batch is about to be sent.
+type prewriteSentKey struct{}
+
+func notifyPrewriteSent(bo *Backoffer) {
+	if f, ok := bo.GetCtx().Value(prewriteSentKey{}).(func()); ok {
+		f()
+	}
+}
+
 type actionPrewrite struct{ retry bool }
 
 var _ twoPhaseCommitAction = actionPrewrite{}
@@ -137,7 +147,44 @@ func (c *twoPhaseCommitter) buildPrewriteRequest(batch batchMutations, txnSize u
 		req.TryOnePc = true
 	}
 
-	return tikvrpc.NewRequest(tikvrpc.CmdPrewrite, req, kvrpcpb.Context{Priority: c.priority, SyncLog: c.syncLog, ResourceGroupTag: c.resourceGroupTag})
+	r := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, req, kvrpcpb.Context{Priority: c.priority, SyncLog: c.syncLogMode.syncLog(batch.isPrimary), ResourceGroupTag: c.resourceGroupTag})
+	r.Metadata = c.txn.requestMetadata
+	return r
+}
+
+// encodeValues compresses and encrypts the values of the Put and Insert
+// mutations of a prewrite request built by buildPrewriteRequest.
+func (c *twoPhaseCommitter) encodeValues(req *kvrpcpb.PrewriteRequest) error {
+	if c.txn.compressor == nil && c.txn.encryption == nil {
+		return nil
+	}
+	for _, m := range req.Mutations {
+		if m.Op != kvrpcpb.Op_Put && m.Op != kvrpcpb.Op_Insert {
+			continue
+		}
+		value, err := c.encodeValue(m.Value)
+		if err != nil {
+			return err
+		}
+		m.Value = value
+	}
+	return nil
+}
+
+// encodeValue compresses and encrypts a value written by the transaction.
+func (c *twoPhaseCommitter) encodeValue(value []byte) ([]byte, error) {
+	var err error
+	if c.txn.compressor != nil {
+		if value, err = compressValue(c.txn.compressor, value); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if c.txn.encryption != nil {
+		if value, err = encryptValue(c.txn.encryption, value); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return value, nil
 }
 
 func (action actionPrewrite) handleSingleBatch(c *twoPhaseCommitter, bo *Backoffer, batch batchMutations) (err error) {
@@ -146,6 +193,11 @@ func (action actionPrewrite) handleSingleBatch(c *twoPhaseCommitter, bo *Backoff
 	// regionErr, it's uncertain if the request will be splitted into multiple and sent to multiple
 	// regions. It invokes `prewriteMutations` recursively here, and the number of batches will be
 	// checked there.
+	defer func() {
+		if err != nil {
+			c.cancelPrewrite()
+		}
+	}()
 
 	if c.sessionID > 0 {
 		if batch.isPrimary {
@@ -176,11 +228,20 @@ func (action actionPrewrite) handleSingleBatch(c *twoPhaseCommitter, bo *Backoff
 		txnSize = math.MaxUint64
 	}
 
+	if batch.leaderHint != nil {
+		c.store.GetRegionCache().SwitchLeaderIfPresent(batch.region, batch.leaderHint)
+	}
+
 	tBegin := time.Now()
 	attempts := 0
 
 	req := c.buildPrewriteRequest(batch, txnSize)
-	sender := NewRegionRequestSender(c.store.regionCache, c.store.GetTiKVClient())
+	if err = c.encodeValues(req.Prewrite()); err != nil {
+		return errors.Trace(err)
+	}
+	atomic.AddInt64(&c.prewriteRPCBytes, int64(req.Prewrite().Size()))
+	sender := NewRegionRequestSender(c.store.regionCache, c.store.GetTiKVClient(), c.store.senderOptions()...)
+	sender.SetLeaderWritePolicy(LeaderWriteAlways)
 	defer func() {
 		if err != nil {
 			// If we fail to receive response for async commit prewrite, it will be undetermined whether this
@@ -199,6 +260,7 @@ func (action actionPrewrite) handleSingleBatch(c *twoPhaseCommitter, bo *Backoff
 			tBegin = time.Now()
 		}
 
+		notifyPrewriteSent(bo)
 		resp, err := sender.SendReq(bo, req, batch.region, client.ReadTimeoutShort)
 		// Unexpected error occurs, return it
 		if err != nil {
@@ -219,7 +281,7 @@ func (action actionPrewrite) handleSingleBatch(c *twoPhaseCommitter, bo *Backoff
 					return errors.Trace(err)
 				}
 			}
-			same, err := batch.relocate(bo, c.store.regionCache)
+			same, err := c.relocateBatch(bo, &batch)
 			if err != nil {
 				return errors.Trace(err)
 			}
@@ -234,6 +296,11 @@ func (action actionPrewrite) handleSingleBatch(c *twoPhaseCommitter, bo *Backoff
 			return errors.Trace(tikverr.ErrBodyMissing)
 		}
 		prewriteResp := resp.Resp.(*kvrpcpb.PrewriteResponse)
+		if c.store.flowControl != nil {
+			if err := c.store.flowControl.pace(bo.GetCtx(), prewriteResp); err != nil {
+				return errors.Trace(err)
+			}
+		}
 		keyErrs := prewriteResp.GetErrors()
 		if len(keyErrs) == 0 {
 			// Clear the RPC Error since the request is evaluated successfully.
@@ -281,6 +348,12 @@ func (action actionPrewrite) handleSingleBatch(c *twoPhaseCommitter, bo *Backoff
 					if c.testingKnobs.noFallBack {
 						return nil
 					}
+					if c.txn.asyncCommitStrict {
+						return errors.Trace(&tikverr.ErrAsyncCommitFailed{
+							StartTS: c.startTS,
+							Reason:  "the returned minCommitTS is zero",
+						})
+					}
 					logutil.Logger(bo.GetCtx()).Warn("async commit cannot proceed since the returned minCommitTS is zero, "+
 						"fallback to normal path", zap.Uint64("startTS", c.startTS))
 					c.setAsyncCommit(false)
@@ -334,6 +407,25 @@ func (c *twoPhaseCommitter) prewriteMutations(bo *Backoffer, mutations Committer
 		bo.SetCtx(opentracing.ContextWithSpan(bo.GetCtx(), span1))
 	}
 
+	// All the batches of the prewrite, including the ones retried after region
+	// errors, use contexts derived from the forked one, so the first failed
+	// batch can cancel the RPCs of the others.
+	bo, cancel := bo.Fork()
+	defer cancel()
+	c.prewriteCancel = cancel
+	defer func() { c.prewriteCancel = nil }()
+
 	// `doActionOnMutations` will unset `useOnePC` if the mutations is splitted into multiple batches.
 	return c.doActionOnMutations(bo, actionPrewrite{}, mutations)
 }
+
+// cancelPrewrite aborts the in-flight prewrite batches after one of them fails,
+// the transaction fails anyway. The contexts of their RPCs are canceled, so
+// gRPC cancels the calls on TiKV instead of abandoning the responses.
+func (c *twoPhaseCommitter) cancelPrewrite() {
+	if c.prewriteCancel == nil {
+		return
+	}
+	atomic.StoreUint32(&c.prewriteCancelled, 1)
+	c.prewriteCancel()
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"math/rand"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// PrimaryKeyStrategy selects the primary key of a transaction from its sorted
+// mutations. It's only used if the primary key is not decided before commit,
+// e.g. by the first pessimistic lock. Mutations with Op_CheckNotExists must not
+// be selected because they are not prewritten. Select returns nil if there is
+// no candidate.
+type PrimaryKeyStrategy interface {
+	Select(mutations CommitterMutations) []byte
+}
+
+// FirstKeyStrategy selects the first key as the primary key, which may be an
+// Op_Lock one. It's the default strategy.
+type FirstKeyStrategy struct{}
+
+// Select implements PrimaryKeyStrategy.
+func (FirstKeyStrategy) Select(mutations CommitterMutations) []byte {
+	return selectNthCandidate(mutations, 0, false)
+}
+
+// RandomKeyStrategy selects a random key as the primary key. It spreads the
+// primary keys of transactions that write sequential keys across regions.
+// Written keys are preferred to the Op_Lock ones, see hasWrites.
+type RandomKeyStrategy struct{}
+
+// Select implements PrimaryKeyStrategy.
+func (RandomKeyStrategy) Select(mutations CommitterMutations) []byte {
+	skipLock := hasWrites(mutations)
+	cnt := countCandidates(mutations, skipLock)
+	if cnt == 0 {
+		return nil
+	}
+	return selectNthCandidate(mutations, rand.Intn(cnt), skipLock)
+}
+
+// CentralKeyStrategy selects the key at the median index as the primary key.
+// Written keys are preferred to the Op_Lock ones, see hasWrites.
+type CentralKeyStrategy struct{}
+
+// Select implements PrimaryKeyStrategy.
+func (CentralKeyStrategy) Select(mutations CommitterMutations) []byte {
+	skipLock := hasWrites(mutations)
+	cnt := countCandidates(mutations, skipLock)
+	if cnt == 0 {
+		return nil
+	}
+	return selectNthCandidate(mutations, cnt/2, skipLock)
+}
+
+// hasWrites returns whether any of the mutations writes data. If so, the
+// strategies spreading the primary keys skip the Op_Lock mutations. The locked
+// keys are usually read by many transactions, e.g. a row locked by SELECT FOR
+// UPDATE, so picking them would gather the primary keys again.
+func hasWrites(mutations CommitterMutations) bool {
+	for i := 0; i < mutations.Len(); i++ {
+		if op := mutations.GetOp(i); op != kvrpcpb.Op_CheckNotExists && op != kvrpcpb.Op_Lock {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrimaryCandidate(mutations CommitterMutations, i int, skipLock bool) bool {
+	switch mutations.GetOp(i) {
+	case kvrpcpb.Op_CheckNotExists:
+		return false
+	case kvrpcpb.Op_Lock:
+		return !skipLock
+	}
+	return true
+}
+
+func countCandidates(mutations CommitterMutations, skipLock bool) int {
+	cnt := 0
+	for i := 0; i < mutations.Len(); i++ {
+		if isPrimaryCandidate(mutations, i, skipLock) {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func selectNthCandidate(mutations CommitterMutations, n int, skipLock bool) []byte {
+	for i := 0; i < mutations.Len(); i++ {
+		if !isPrimaryCandidate(mutations, i, skipLock) {
+			continue
+		}
+		if n == 0 {
+			return mutations.GetKey(i)
+		}
+		n--
+	}
+	return nil
+}
+
+// WithPrimaryKeyStrategy sets the strategy to select the primary key of the transaction.
+func WithPrimaryKeyStrategy(s PrimaryKeyStrategy) TxnOption {
+	return func(txn *KVTxn) {
+		txn.SetPrimaryKeyStrategy(s)
+	}
+}
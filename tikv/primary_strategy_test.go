@@ -0,0 +1,98 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrimaryKeyStrategySelect(t *testing.T) {
+	type mutation struct {
+		key string
+		op  kvrpcpb.Op
+	}
+	put := func(key string) mutation { return mutation{key, kvrpcpb.Op_Put} }
+	lock := func(key string) mutation { return mutation{key, kvrpcpb.Op_Lock} }
+	check := func(key string) mutation { return mutation{key, kvrpcpb.Op_CheckNotExists} }
+
+	for _, c := range []struct {
+		name      string
+		mutations []mutation
+		first     string
+		central   string
+		// random lists the keys RandomKeyStrategy may select.
+		random []string
+	}{
+		{"empty", nil, "", "", nil},
+		{"puts", []mutation{put("a"), put("b"), put("c"), put("d")}, "a", "c", []string{"a", "b", "c", "d"}},
+		{"check not exists", []mutation{check("a"), put("b"), check("c"), put("d"), put("e")}, "b", "d", []string{"b", "d", "e"}},
+		{"only check not exists", []mutation{check("a"), check("b")}, "", "", nil},
+		{"locks", []mutation{lock("a"), put("b"), lock("c"), lock("d"), put("e")}, "a", "e", []string{"b", "e"}},
+		{"only locks", []mutation{lock("a"), check("b"), lock("c"), lock("d")}, "a", "c", []string{"a", "c", "d"}},
+	} {
+		m := NewPlainMutations(len(c.mutations))
+		for _, mut := range c.mutations {
+			m.Push(mut.op, []byte(mut.key), nil, false)
+		}
+		require.Equal(t, c.first, string(FirstKeyStrategy{}.Select(&m)), c.name)
+		require.Equal(t, c.central, string(CentralKeyStrategy{}.Select(&m)), c.name)
+
+		selected := make(map[string]struct{})
+		for i := 0; i < 100; i++ {
+			key := RandomKeyStrategy{}.Select(&m)
+			if len(c.random) == 0 {
+				require.Nil(t, key, c.name)
+				continue
+			}
+			require.Contains(t, c.random, string(key), c.name)
+			selected[string(key)] = struct{}{}
+		}
+		// The keys are selected with equal probability, so all of them are
+		// selected in 100 tries.
+		require.Len(t, selected, len(c.random), c.name)
+	}
+}
+
+func TestPrimaryKeyStrategyCommit(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin(WithPrimaryKeyStrategy(CentralKeyStrategy{}))
+	require.Nil(t, err)
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		require.Nil(t, txn.Set([]byte(k), []byte(k)))
+	}
+	require.Nil(t, txn.Commit(ctx))
+	require.Equal(t, []byte("c"), txn.committer.primary())
+
+	// Every key is committed.
+	snapshot := store.GetSnapshot(txn.CommitTS())
+	m, err := snapshot.BatchGet(ctx, [][]byte{[]byte("a"), []byte("c"), []byte("e")})
+	require.Nil(t, err)
+	require.Len(t, m, 3)
+
+	// The default strategy selects the first key.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	for _, k := range []string{"b", "d"} {
+		require.Nil(t, txn.Set([]byte(k), []byte(k)))
+	}
+	require.Nil(t, txn.Commit(ctx))
+	require.Equal(t, []byte("b"), txn.committer.primary())
+}
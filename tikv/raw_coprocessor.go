@@ -0,0 +1,236 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/internal/client"
+	"github.com/tikv/client-go/v2/internal/locate"
+	"github.com/tikv/client-go/v2/internal/retry"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+// pluginError converts the error message of a coprocessor plugin request to an
+// error. RawCoprocessorResponse reports the errors as plain messages, so the
+// plugin not found error is recognized by the exact message TiKV sends for
+// name.
+func pluginError(name, msg string) error {
+	if msg == fmt.Sprintf("No registered coprocessor with name '%s'", name) {
+		return &tikverr.ErrPluginNotFound{Name: name}
+	}
+	return errors.New(msg)
+}
+
+// RawCoprocessor runs a coprocessor plugin of TiKV on raw key ranges.
+type RawCoprocessor struct {
+	client     *RawKVClient
+	name       string
+	versionReq string
+}
+
+// Coprocessor returns a RawCoprocessor which runs the coprocessor plugin
+// named name. versionReq is the SemVer constraint of the plugin version, such
+// as ">=1.0.0", the plugin is rejected by TiKV if it doesn't match.
+func (c *RawKVClient) Coprocessor(name, versionReq string) *RawCoprocessor {
+	return &RawCoprocessor{client: c, name: name, versionReq: versionReq}
+}
+
+// CoprocessorChunk is the result of the coprocessor plugin of a region. Data is
+// the response of the plugin for the parts of the ranges in the region, whose
+// format is defined by the plugin. Err is set in the last chunk if the
+// execution fails.
+type CoprocessorChunk struct {
+	RegionID uint64
+	// Ranges are the parts of the requested ranges in the region.
+	Ranges []kv.KeyRange
+	Data   []byte
+	Err    error
+}
+
+// Execute sends data to the coprocessor plugin of every region in ranges, and
+// returns the results of the regions in the order of keys. The format of data
+// and the results are defined by the plugin, so merging the results is left to
+// the caller.
+//
+// The ranges must be sorted and not overlapped. A range is split at the region
+// boundaries, and all ranges in a region are sent in one request.
+//
+// ErrPluginNotFound is returned if the plugin is not loaded by TiKV, so the
+// caller can process the data on the client side instead.
+func (c *RawCoprocessor) Execute(ctx context.Context, data []byte, ranges []kv.KeyRange) ([][]byte, error) {
+	ranges, err := c.client.hashKeyRanges(ranges)
+	if err != nil {
+		return nil, err
+	}
+	var results [][]byte
+	err = c.execute(ctx, data, ranges, func(chunk CoprocessorChunk) error {
+		results = append(results, chunk.Data)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return results, nil
+}
+
+// ExecuteStream is like Execute, but sends the result of each region to the
+// returned channel as soon as it's received, so the results of a large range
+// are not held in memory together. At most one chunk is buffered ahead of the
+// caller. The channel is closed after the last region or after a chunk with
+// Err. If the context is canceled before the last region, the last chunk has
+// the context's error, and it replaces the unreceived chunk so the caller may
+// stop receiving.
+//
+// TiKV has no streaming RPC for the coprocessor plugins, so a single region is
+// still returned in one response. The caller must drain the channel or cancel
+// the context.
+func (c *RawCoprocessor) ExecuteStream(ctx context.Context, data []byte, ranges []kv.KeyRange) (<-chan CoprocessorChunk, error) {
+	ranges, err := c.client.hashKeyRanges(ranges)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(ranges); i++ {
+		if len(ranges[i-1].EndKey) == 0 || bytes.Compare(ranges[i-1].EndKey, ranges[i].StartKey) > 0 {
+			return nil, errors.Errorf("ranges are not sorted or overlapped at %d", i)
+		}
+	}
+	ch := make(chan CoprocessorChunk, 1)
+	go func() {
+		defer close(ch)
+		err := c.execute(ctx, data, ranges, func(chunk CoprocessorChunk) error {
+			if err := ctx.Err(); err != nil {
+				return errors.Trace(err)
+			}
+			select {
+			case ch <- chunk:
+				return nil
+			case <-ctx.Done():
+				return errors.Trace(ctx.Err())
+			}
+		})
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			err = errors.Trace(ctx.Err())
+		}
+		select {
+		case ch <- CoprocessorChunk{Err: err}:
+		case <-ctx.Done():
+			// Only this goroutine sends, so the send never blocks once the
+			// buffered chunk is dropped.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- CoprocessorChunk{Err: err}
+		}
+	}()
+	return ch, nil
+}
+
+// MergeCoprocessorChunks receives the chunks from the channel returned by
+// ExecuteStream until it's closed, and returns their data in order.
+func MergeCoprocessorChunks(ch <-chan CoprocessorChunk) ([][]byte, error) {
+	var results [][]byte
+	for chunk := range ch {
+		if chunk.Err != nil {
+			return nil, chunk.Err
+		}
+		results = append(results, chunk.Data)
+	}
+	return results, nil
+}
+
+func (c *RawCoprocessor) execute(ctx context.Context, data []byte, ranges []kv.KeyRange, onChunk func(CoprocessorChunk) error) error {
+	bo := retry.NewBackofferWithVars(ctx, rawkvMaxBackoff, nil)
+	sender := locate.NewRegionRequestSender(c.client.regionCache, c.client.rpcClient)
+	i, start := 0, []byte(nil)
+	if len(ranges) > 0 {
+		start = ranges[0].StartKey
+	}
+	for i < len(ranges) {
+		loc, err := c.client.regionCache.LocateKey(bo, start)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		keyRanges, nextI, nextStart := rangesInRegion(loc, ranges, i, start)
+		req := tikvrpc.NewRequest(tikvrpc.CmdRawCoprocessor, &kvrpcpb.RawCoprocessorRequest{
+			CoprName:       c.name,
+			CoprVersionReq: c.versionReq,
+			Ranges:         keyRanges,
+			Data:           data,
+		})
+		resp, err := sender.SendReq(bo, req, loc.Region, client.ReadTimeoutMedium)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		regionErr, err := resp.GetRegionError()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if regionErr != nil {
+			err = bo.Backoff(retry.BoRegionMiss, errors.New(regionErr.String()))
+			if err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		}
+		if resp.Resp == nil {
+			return errors.Trace(tikverr.ErrBodyMissing)
+		}
+		cmdResp := resp.Resp.(*kvrpcpb.RawCoprocessorResponse)
+		if msg := cmdResp.GetError(); msg != "" {
+			return errors.Trace(pluginError(c.name, msg))
+		}
+		chunk := CoprocessorChunk{RegionID: loc.Region.GetID(), Data: cmdResp.GetData()}
+		for _, r := range keyRanges {
+			chunk.Ranges = append(chunk.Ranges, kv.KeyRange{StartKey: r.StartKey, EndKey: r.EndKey})
+		}
+		chunk.Ranges = c.client.unhashKeyRanges(chunk.Ranges)
+		if err = onChunk(chunk); err != nil {
+			return err
+		}
+		i, start = nextI, nextStart
+	}
+	return nil
+}
+
+// rangesInRegion returns the parts of ranges in the region of loc, starting
+// from start of ranges[i], and the position to continue from.
+func rangesInRegion(loc *locate.KeyLocation, ranges []kv.KeyRange, i int, start []byte) ([]*kvrpcpb.KeyRange, int, []byte) {
+	var keyRanges []*kvrpcpb.KeyRange
+	for ; i < len(ranges); i++ {
+		if len(loc.EndKey) > 0 && bytes.Compare(start, loc.EndKey) >= 0 {
+			return keyRanges, i, start
+		}
+		end := ranges[i].EndKey
+		if len(loc.EndKey) > 0 && (len(end) == 0 || bytes.Compare(end, loc.EndKey) > 0) {
+			keyRanges = append(keyRanges, &kvrpcpb.KeyRange{StartKey: start, EndKey: loc.EndKey})
+			return keyRanges, i, loc.EndKey
+		}
+		keyRanges = append(keyRanges, &kvrpcpb.KeyRange{StartKey: start, EndKey: end})
+		if i+1 < len(ranges) {
+			start = ranges[i+1].StartKey
+		}
+	}
+	return keyRanges, i, nil
+}
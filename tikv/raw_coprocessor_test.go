@@ -0,0 +1,161 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+// mockRawCoprocessorClient replies the ranges of the requests, and fails the
+// first request with RegionNotFound. The plugin named missing is not found.
+type mockRawCoprocessorClient struct {
+	Client
+	requests int
+}
+
+func (c *mockRawCoprocessorClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type != tikvrpc.CmdRawCoprocessor {
+		return c.Client.SendRequest(ctx, addr, req, timeout)
+	}
+	c.requests++
+	r := req.RawCoprocessor()
+	if c.requests == 1 {
+		return &tikvrpc.Response{Resp: &kvrpcpb.RawCoprocessorResponse{
+			RegionError: &errorpb.Error{RegionNotFound: &errorpb.RegionNotFound{RegionId: r.GetContext().GetRegionId()}},
+		}}, nil
+	}
+	if r.GetCoprName() == "missing" {
+		return &tikvrpc.Response{Resp: &kvrpcpb.RawCoprocessorResponse{
+			Error: fmt.Sprintf("No registered coprocessor with name '%s'", r.GetCoprName()),
+		}}, nil
+	}
+	data := r.GetCoprName()
+	for _, kr := range r.GetRanges() {
+		data += fmt.Sprintf(" [%s,%s)", kr.GetStartKey(), kr.GetEndKey())
+	}
+	return &tikvrpc.Response{Resp: &kvrpcpb.RawCoprocessorResponse{Data: []byte(data)}}, nil
+}
+
+func TestRawCoprocessor(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.SplitRaw(regionID, ids[0], []byte("m"), []uint64{ids[1]}, ids[1])
+
+	rpcClient := &mockRawCoprocessorClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	client := &RawKVClient{
+		regionCache: NewRegionCache(mocktikv.NewPDClient(cluster)),
+		rpcClient:   rpcClient,
+	}
+	defer client.Close()
+
+	results, err := client.Coprocessor("count", ">=1.0.0").Execute(context.Background(), nil, []kv.KeyRange{
+		{StartKey: []byte("a"), EndKey: []byte("c")},
+		{StartKey: []byte("d"), EndKey: []byte("p")},
+		{StartKey: []byte("x"), EndKey: []byte("z")},
+	})
+	require.Nil(t, err)
+	require.Equal(t, 3, rpcClient.requests)
+	require.Equal(t, [][]byte{
+		[]byte("count [a,c) [d,m)"),
+		[]byte("count [m,p) [x,z)"),
+	}, results)
+}
+
+func TestRawCoprocessorStream(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.SplitRaw(regionID, ids[0], []byte("m"), []uint64{ids[1]}, ids[1])
+
+	rpcClient := &mockRawCoprocessorClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	client := &RawKVClient{
+		regionCache: NewRegionCache(mocktikv.NewPDClient(cluster)),
+		rpcClient:   rpcClient,
+	}
+	defer client.Close()
+	copr := client.Coprocessor("count", ">=1.0.0")
+	ranges := []kv.KeyRange{
+		{StartKey: []byte("a"), EndKey: []byte("c")},
+		{StartKey: []byte("d"), EndKey: []byte("p")},
+	}
+
+	ch, err := copr.ExecuteStream(context.Background(), nil, ranges)
+	require.Nil(t, err)
+	chunk := <-ch
+	require.Nil(t, chunk.Err)
+	require.Equal(t, regionID, chunk.RegionID)
+	require.Equal(t, []kv.KeyRange{
+		{StartKey: []byte("a"), EndKey: []byte("c")},
+		{StartKey: []byte("d"), EndKey: []byte("m")},
+	}, chunk.Ranges)
+	results, err := MergeCoprocessorChunks(ch)
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("count [m,p)")}, results)
+
+	// The stream stops with the error of the context when it's canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err = copr.ExecuteStream(ctx, nil, ranges)
+	require.Nil(t, err)
+	cancel()
+	var last CoprocessorChunk
+	for chunk := range ch {
+		last = chunk
+	}
+	require.Equal(t, context.Canceled, errors.Cause(last.Err))
+
+	// The stream doesn't block when the caller stops receiving after canceling.
+	ctx, cancel = context.WithCancel(context.Background())
+	_, err = copr.ExecuteStream(ctx, nil, ranges)
+	require.Nil(t, err)
+	cancel()
+
+	_, err = copr.ExecuteStream(context.Background(), nil, []kv.KeyRange{ranges[1], ranges[0]})
+	require.NotNil(t, err)
+}
+
+func TestRawCoprocessorPluginNotFound(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+
+	rpcClient := &mockRawCoprocessorClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	client := &RawKVClient{
+		regionCache: NewRegionCache(mocktikv.NewPDClient(cluster)),
+		rpcClient:   rpcClient,
+	}
+	defer client.Close()
+
+	_, err := client.Coprocessor("missing", "").Execute(context.Background(), nil, []kv.KeyRange{{StartKey: []byte("n"), EndKey: []byte("p")}})
+	require.True(t, tikverr.Is(err, &tikverr.ErrPluginNotFound{}))
+	require.Equal(t, "missing", errors.Cause(err).(*tikverr.ErrPluginNotFound).Name)
+
+	// Other errors of the plugins are not taken as not found.
+	err = pluginError("count", "No registered coprocessor with name 'missing'")
+	require.False(t, tikverr.Is(err, &tikverr.ErrPluginNotFound{}))
+}
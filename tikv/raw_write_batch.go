@@ -0,0 +1,186 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/internal/client"
+	"github.com/tikv/client-go/v2/internal/retry"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+// BatchOp is a write of a batch written by RawKVClient.WriteBatch. It's one
+// of Put, Delete and PutIfAbsent.
+type BatchOp interface {
+	batchKey() []byte
+}
+
+// Put is a BatchOp that stores a key-value pair.
+type Put struct {
+	Key   []byte
+	Value []byte
+}
+
+func (op Put) batchKey() []byte { return op.Key }
+
+// Delete is a BatchOp that deletes a key.
+type Delete struct {
+	Key []byte
+}
+
+func (op Delete) batchKey() []byte { return op.Key }
+
+// PutIfAbsent is a BatchOp that stores a key-value pair if the key doesn't
+// exist.
+//
+// It's not supported yet, TiKV has no compare-and-swap RPC for raw keys.
+type PutIfAbsent struct {
+	Key   []byte
+	Value []byte
+}
+
+func (op PutIfAbsent) batchKey() []byte { return op.Key }
+
+// WriteBatch writes the ops to TiKV, the last op wins if there are multiple
+// ops on a key.
+//
+// The batch is written atomically by a single RawBatchPut or RawBatchDelete
+// request if all the keys are in one region and the ops are all puts or all
+// deletes. Otherwise the ops are written region by region without atomicity,
+// and ErrNonAtomicBatch is returned after all of them are written, so the
+// caller can tell that a reader may have seen a part of the batch. The raw
+// keys aren't visible to transactions, so the batch can't fall back to 1PC.
+func (c *RawKVClient) WriteBatch(ctx context.Context, ops []BatchOp) error {
+	start := time.Now()
+	defer func() { metrics.RawkvCmdHistogramWithBatchPut.Observe(time.Since(start).Seconds()) }()
+
+	last := make(map[string]int, len(ops))
+	for i, op := range ops {
+		switch op := op.(type) {
+		case Put:
+			if len(op.Value) == 0 {
+				return errors.New("empty value is not supported")
+			}
+		case Delete:
+		case PutIfAbsent:
+			return errors.New("PutIfAbsent is not supported")
+		default:
+			return errors.Errorf("unknown batch op %T", op)
+		}
+		last[string(op.batchKey())] = i
+	}
+	var putKeys, putValues, deleteKeys [][]byte
+	for i, op := range ops {
+		if last[string(op.batchKey())] != i {
+			continue
+		}
+		switch op := op.(type) {
+		case Put:
+			putKeys = append(putKeys, c.hashKey(op.Key))
+			putValues = append(putValues, op.Value)
+		case Delete:
+			deleteKeys = append(deleteKeys, c.hashKey(op.Key))
+		}
+	}
+
+	bo := retry.NewBackofferWithVars(ctx, rawkvMaxBackoff, nil)
+	if len(putKeys) == 0 || len(deleteKeys) == 0 {
+		var req *tikvrpc.Request
+		keys := putKeys
+		if len(putKeys) > 0 {
+			pairs := make([]*kvrpcpb.KvPair, 0, len(putKeys))
+			for i, key := range putKeys {
+				pairs = append(pairs, &kvrpcpb.KvPair{Key: key, Value: putValues[i]})
+			}
+			req = tikvrpc.NewRequest(tikvrpc.CmdRawBatchPut, &kvrpcpb.RawBatchPutRequest{Pairs: pairs})
+		} else if len(deleteKeys) > 0 {
+			keys = deleteKeys
+			req = tikvrpc.NewRequest(tikvrpc.CmdRawBatchDelete, &kvrpcpb.RawBatchDeleteRequest{Keys: deleteKeys})
+		} else {
+			return nil
+		}
+		written, err := c.writeBatchInRegion(bo, keys, req)
+		if err != nil || written {
+			return errors.Trace(err)
+		}
+	}
+
+	if len(putKeys) > 0 {
+		if err := c.sendBatchPut(bo, putKeys, putValues); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(deleteKeys) > 0 {
+		resp, err := c.sendBatchReq(bo, deleteKeys, tikvrpc.CmdRawBatchDelete)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if msg := resp.Resp.(*kvrpcpb.RawBatchDeleteResponse).GetError(); msg != "" {
+			return errors.New(msg)
+		}
+	}
+	return errors.Trace(tikverr.ErrNonAtomicBatch)
+}
+
+// writeBatchInRegion sends req if all the keys are in one region, it returns
+// false if they aren't.
+func (c *RawKVClient) writeBatchInRegion(bo *Backoffer, keys [][]byte, req *tikvrpc.Request) (bool, error) {
+	sender := c.newSender()
+	for {
+		loc, err := c.regionCache.LocateKey(bo, keys[0])
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		for _, key := range keys[1:] {
+			if !loc.Contains(key) {
+				return false, nil
+			}
+		}
+		resp, err := sender.SendReq(bo, req, loc.Region, client.ReadTimeoutShort)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		regionErr, err := resp.GetRegionError()
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if regionErr != nil {
+			err := bo.Backoff(retry.BoRegionMiss, errors.New(regionErr.String()))
+			if err != nil {
+				return false, errors.Trace(err)
+			}
+			continue
+		}
+		if resp.Resp == nil {
+			return false, errors.Trace(tikverr.ErrBodyMissing)
+		}
+		var msg string
+		switch r := resp.Resp.(type) {
+		case *kvrpcpb.RawBatchPutResponse:
+			msg = r.GetError()
+		case *kvrpcpb.RawBatchDeleteResponse:
+			msg = r.GetError()
+		}
+		if msg != "" {
+			return false, errors.New(msg)
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+)
+
+func TestRawWriteBatch(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.SplitRaw(regionID, ids[0], []byte("m"), []uint64{ids[1]}, ids[1])
+
+	client := &RawKVClient{
+		regionCache: NewRegionCache(mocktikv.NewPDClient(cluster)),
+		rpcClient:   mocktikv.NewRPCClient(cluster, mvccStore, nil),
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	mustGet := func(key string, expected string) {
+		val, err := client.Get([]byte(key))
+		require.Nil(t, err)
+		require.Equal(t, expected, string(val))
+	}
+
+	// All the keys are in one region.
+	err := client.WriteBatch(ctx, []BatchOp{
+		Put{Key: []byte("a"), Value: []byte("1")},
+		Put{Key: []byte("b"), Value: []byte("1")},
+		Put{Key: []byte("a"), Value: []byte("2")},
+	})
+	require.Nil(t, err)
+	mustGet("a", "2")
+	mustGet("b", "1")
+	require.Nil(t, client.WriteBatch(ctx, []BatchOp{Delete{Key: []byte("a")}}))
+	mustGet("a", "")
+
+	// The keys are in two regions.
+	err = client.WriteBatch(ctx, []BatchOp{
+		Put{Key: []byte("c"), Value: []byte("1")},
+		Put{Key: []byte("x"), Value: []byte("1")},
+	})
+	require.True(t, tikverr.Is(err, tikverr.ErrNonAtomicBatch))
+	mustGet("c", "1")
+	mustGet("x", "1")
+
+	// Puts and deletes are written by different requests.
+	err = client.WriteBatch(ctx, []BatchOp{
+		Put{Key: []byte("d"), Value: []byte("1")},
+		Delete{Key: []byte("b")},
+	})
+	require.True(t, tikverr.Is(err, tikverr.ErrNonAtomicBatch))
+	mustGet("d", "1")
+	mustGet("b", "")
+
+	require.NotNil(t, client.WriteBatch(ctx, []BatchOp{PutIfAbsent{Key: []byte("e"), Value: []byte("1")}}))
+	require.NotNil(t, client.WriteBatch(ctx, []BatchOp{Put{Key: []byte("e")}}))
+	require.Nil(t, client.WriteBatch(ctx, nil))
+}
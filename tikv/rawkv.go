@@ -35,16 +35,20 @@ package tikv
 import (
 	"bytes"
 	"context"
+	"sort"
 	"time"
 
 	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/errorpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/tikv/client-go/v2/config"
 	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/internal/client"
 	"github.com/tikv/client-go/v2/internal/kvrpc"
 	"github.com/tikv/client-go/v2/internal/locate"
 	"github.com/tikv/client-go/v2/internal/retry"
+	"github.com/tikv/client-go/v2/kv"
 	"github.com/tikv/client-go/v2/metrics"
 	"github.com/tikv/client-go/v2/tikvrpc"
 	pd "github.com/tikv/pd/client"
@@ -71,6 +75,8 @@ type RawKVClient struct {
 	regionCache *locate.RegionCache
 	pdClient    pd.Client
 	rpcClient   Client
+	// keyHasher hashes the keys before they are sent if it's not nil.
+	keyHasher KeyHasher
 }
 
 // NewRawKVClient creates a client with PD cluster addrs.
@@ -115,6 +121,7 @@ func (c *RawKVClient) Get(key []byte) ([]byte, error) {
 	start := time.Now()
 	defer func() { metrics.RawkvCmdHistogramWithGet.Observe(time.Since(start).Seconds()) }()
 
+	key = c.hashKey(key)
 	req := tikvrpc.NewRequest(tikvrpc.CmdRawGet, &kvrpcpb.RawGetRequest{Key: key})
 	resp, _, err := c.sendReq(key, req, false)
 	if err != nil {
@@ -142,6 +149,7 @@ func (c *RawKVClient) BatchGet(keys [][]byte) ([][]byte, error) {
 		metrics.RawkvCmdHistogramWithBatchGet.Observe(time.Since(start).Seconds())
 	}()
 
+	keys = c.hashKeys(keys)
 	bo := retry.NewBackofferWithVars(context.Background(), rawkvMaxBackoff, nil)
 	resp, err := c.sendBatchReq(bo, keys, tikvrpc.CmdRawBatchGet)
 	if err != nil {
@@ -175,6 +183,7 @@ func (c *RawKVClient) Put(key, value []byte) error {
 	if len(value) == 0 {
 		return errors.New("empty value is not supported")
 	}
+	key = c.hashKey(key)
 
 	req := tikvrpc.NewRequest(tikvrpc.CmdRawPut, &kvrpcpb.RawPutRequest{
 		Key:   key,
@@ -210,7 +219,7 @@ func (c *RawKVClient) BatchPut(keys, values [][]byte) error {
 		}
 	}
 	bo := retry.NewBackofferWithVars(context.Background(), rawkvMaxBackoff, nil)
-	err := c.sendBatchPut(bo, keys, values)
+	err := c.sendBatchPut(bo, c.hashKeys(keys), values)
 	return errors.Trace(err)
 }
 
@@ -219,6 +228,7 @@ func (c *RawKVClient) Delete(key []byte) error {
 	start := time.Now()
 	defer func() { metrics.RawkvCmdHistogramWithDelete.Observe(time.Since(start).Seconds()) }()
 
+	key = c.hashKey(key)
 	req := tikvrpc.NewRequest(tikvrpc.CmdRawDelete, &kvrpcpb.RawDeleteRequest{
 		Key: key,
 	})
@@ -243,6 +253,7 @@ func (c *RawKVClient) BatchDelete(keys [][]byte) error {
 		metrics.RawkvCmdHistogramWithBatchDelete.Observe(time.Since(start).Seconds())
 	}()
 
+	keys = c.hashKeys(keys)
 	bo := retry.NewBackofferWithVars(context.Background(), rawkvMaxBackoff, nil)
 	resp, err := c.sendBatchReq(bo, keys, tikvrpc.CmdRawBatchDelete)
 	if err != nil {
@@ -270,20 +281,41 @@ func (c *RawKVClient) DeleteRange(startKey []byte, endKey []byte) error {
 		metrics.TiKVRawkvCmdHistogram.WithLabelValues(label).Observe(time.Since(start).Seconds())
 	}()
 
+	var ranges []kv.KeyRange
+	ranges, err = c.hashedRanges(startKey, endKey)
+	if err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		if err = c.deleteRange(r.StartKey, r.EndKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RawKVClient) deleteRange(startKey []byte, endKey []byte) error {
 	// Process each affected region respectively
 	for !bytes.Equal(startKey, endKey) {
-		var resp *tikvrpc.Response
-		var actualEndKey []byte
-		resp, actualEndKey, err = c.sendDeleteRangeReq(startKey, endKey)
+		resp, actualEndKey, splitRanges, err := c.sendDeleteRangeReq(startKey, endKey)
 		if err != nil {
 			return errors.Trace(err)
 		}
-		if resp.Resp == nil {
-			return errors.Trace(tikverr.ErrBodyMissing)
+		// The region is split during the request. Delete the range in each of
+		// the new regions.
+		for _, r := range splitRanges {
+			if err = c.deleteRange(r.StartKey, r.EndKey); err != nil {
+				return err
+			}
 		}
-		cmdResp := resp.Resp.(*kvrpcpb.RawDeleteRangeResponse)
-		if cmdResp.GetError() != "" {
-			return errors.New(cmdResp.GetError())
+		if splitRanges == nil {
+			if resp.Resp == nil {
+				return errors.Trace(tikverr.ErrBodyMissing)
+			}
+			cmdResp := resp.Resp.(*kvrpcpb.RawDeleteRangeResponse)
+			if cmdResp.GetError() != "" {
+				return errors.New(cmdResp.GetError())
+			}
 		}
 		startKey = actualEndKey
 	}
@@ -303,7 +335,25 @@ func (c *RawKVClient) Scan(startKey, endKey []byte, limit int) (keys [][]byte, v
 	if limit > MaxRawKVScanLimit {
 		return nil, nil, errors.Trace(ErrMaxScanLimitExceeded)
 	}
+	if c.keyHasher == nil {
+		return c.scan(startKey, endKey, limit)
+	}
+	ranges, err := c.hashedRanges(startKey, endKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range ranges {
+		ks, vs, err := c.scan(r.StartKey, r.EndKey, limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys, values = append(keys, ks...), append(values, vs...)
+	}
+	keys, values = c.unhashPairs(keys, values, limit, false)
+	return keys, values, nil
+}
 
+func (c *RawKVClient) scan(startKey, endKey []byte, limit int) (keys [][]byte, values [][]byte, err error) {
 	for len(keys) < limit && (len(endKey) == 0 || bytes.Compare(startKey, endKey) < 0) {
 		req := tikvrpc.NewRequest(tikvrpc.CmdRawScan, &kvrpcpb.RawScanRequest{
 			StartKey: startKey,
@@ -336,7 +386,8 @@ func (c *RawKVClient) Scan(startKey, endKey []byte, limit int) (keys [][]byte, v
 // If you want to include the startKey or exclude the endKey, push a '\0' to the key. For example, to scan
 // (endKey, startKey], you can write:
 // `ReverseScan(push(startKey, '\0'), push(endKey, '\0'), limit)`.
-// It doesn't support Scanning from "", because locating the last Region is not yet implemented.
+// It doesn't support Scanning from "", because locating the last Region is not yet implemented,
+// unless a RangeKeyHasher is set.
 func (c *RawKVClient) ReverseScan(startKey, endKey []byte, limit int) (keys [][]byte, values [][]byte, err error) {
 	start := time.Now()
 	defer func() {
@@ -346,7 +397,27 @@ func (c *RawKVClient) ReverseScan(startKey, endKey []byte, limit int) (keys [][]
 	if limit > MaxRawKVScanLimit {
 		return nil, nil, errors.Trace(ErrMaxScanLimitExceeded)
 	}
+	if c.keyHasher == nil {
+		return c.reverseScan(startKey, endKey, limit)
+	}
+	// The stored range of every prefix is [p+endKey, p+startKey), where an
+	// empty startKey means the end of the prefix.
+	ranges, err := c.hashedRanges(endKey, startKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range ranges {
+		ks, vs, err := c.reverseScan(r.EndKey, r.StartKey, limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys, values = append(keys, ks...), append(values, vs...)
+	}
+	keys, values = c.unhashPairs(keys, values, limit, true)
+	return keys, values, nil
+}
 
+func (c *RawKVClient) reverseScan(startKey, endKey []byte, limit int) (keys [][]byte, values [][]byte, err error) {
 	for len(keys) < limit && bytes.Compare(startKey, endKey) > 0 {
 		req := tikvrpc.NewRequest(tikvrpc.CmdRawScan, &kvrpcpb.RawScanRequest{
 			StartKey: startKey,
@@ -374,9 +445,17 @@ func (c *RawKVClient) ReverseScan(startKey, endKey []byte, limit int) (keys [][]
 	return
 }
 
+// newSender creates a RegionRequestSender which always sends the raw writes to
+// the leader.
+func (c *RawKVClient) newSender() *locate.RegionRequestSender {
+	sender := locate.NewRegionRequestSender(c.regionCache, c.rpcClient)
+	sender.SetLeaderWritePolicy(locate.LeaderWriteAlways)
+	return sender
+}
+
 func (c *RawKVClient) sendReq(key []byte, req *tikvrpc.Request, reverse bool) (*tikvrpc.Response, *locate.KeyLocation, error) {
 	bo := retry.NewBackofferWithVars(context.Background(), rawkvMaxBackoff, nil)
-	sender := locate.NewRegionRequestSender(c.regionCache, c.rpcClient)
+	sender := c.newSender()
 	for {
 		var loc *locate.KeyLocation
 		var err error
@@ -467,7 +546,7 @@ func (c *RawKVClient) doBatchReq(bo *Backoffer, batch kvrpc.Batch, cmdType tikvr
 		})
 	}
 
-	sender := locate.NewRegionRequestSender(c.regionCache, c.rpcClient)
+	sender := c.newSender()
 	resp, err := sender.SendReq(bo, req, batch.RegionID, client.ReadTimeoutShort)
 
 	batchResp := kvrpc.BatchResult{}
@@ -512,15 +591,17 @@ func (c *RawKVClient) doBatchReq(bo *Backoffer, batch kvrpc.Batch, cmdType tikvr
 
 // sendDeleteRangeReq sends a raw delete range request and returns the response and the actual endKey.
 // If the given range spans over more than one regions, the actual endKey is the end of the first region.
+// If the region is split and the new regions cover [startKey, actualEndKey), the request is not retried,
+// and the range is returned split by the new regions instead of the response.
 // We can't use sendReq directly, because we need to know the end of the region before we send the request
 // TODO: Is there any better way to avoid duplicating code with func `sendReq` ?
-func (c *RawKVClient) sendDeleteRangeReq(startKey []byte, endKey []byte) (*tikvrpc.Response, []byte, error) {
+func (c *RawKVClient) sendDeleteRangeReq(startKey []byte, endKey []byte) (*tikvrpc.Response, []byte, []kv.KeyRange, error) {
 	bo := retry.NewBackofferWithVars(context.Background(), rawkvMaxBackoff, nil)
-	sender := locate.NewRegionRequestSender(c.regionCache, c.rpcClient)
+	sender := c.newSender()
 	for {
 		loc, err := c.regionCache.LocateKey(bo, startKey)
 		if err != nil {
-			return nil, nil, errors.Trace(err)
+			return nil, nil, nil, errors.Trace(err)
 		}
 
 		actualEndKey := endKey
@@ -535,23 +616,59 @@ func (c *RawKVClient) sendDeleteRangeReq(startKey []byte, endKey []byte) (*tikvr
 
 		resp, err := sender.SendReq(bo, req, loc.Region, client.ReadTimeoutShort)
 		if err != nil {
-			return nil, nil, errors.Trace(err)
+			return nil, nil, nil, errors.Trace(err)
 		}
 		regionErr, err := resp.GetRegionError()
 		if err != nil {
-			return nil, nil, errors.Trace(err)
+			return nil, nil, nil, errors.Trace(err)
 		}
 		if regionErr != nil {
+			if ranges := splitRangeByCurrentRegions(regionErr, startKey, actualEndKey); ranges != nil {
+				return nil, actualEndKey, ranges, nil
+			}
 			err := bo.Backoff(retry.BoRegionMiss, errors.New(regionErr.String()))
 			if err != nil {
-				return nil, nil, errors.Trace(err)
+				return nil, nil, nil, errors.Trace(err)
 			}
 			continue
 		}
-		return resp, actualEndKey, nil
+		return resp, actualEndKey, nil, nil
 	}
 }
 
+// splitRangeByCurrentRegions splits [startKey, endKey) by the current regions
+// in the EpochNotMatch error if the range is split into 2 or more of them and
+// they cover the range. Otherwise it returns nil.
+func splitRangeByCurrentRegions(regionErr *errorpb.Error, startKey, endKey []byte) []kv.KeyRange {
+	regions := append([]*metapb.Region(nil), regionErr.GetEpochNotMatch().GetCurrentRegions()...)
+	sort.Slice(regions, func(i, j int) bool {
+		return bytes.Compare(regions[i].GetStartKey(), regions[j].GetStartKey()) < 0
+	})
+	var ranges []kv.KeyRange
+	cur := startKey
+	for _, r := range regions {
+		if bytes.Compare(r.GetStartKey(), cur) > 0 {
+			break
+		}
+		if len(r.GetEndKey()) > 0 && bytes.Compare(r.GetEndKey(), cur) <= 0 {
+			continue
+		}
+		end := endKey
+		if len(r.GetEndKey()) > 0 && (len(endKey) == 0 || bytes.Compare(r.GetEndKey(), endKey) < 0) {
+			end = r.GetEndKey()
+		}
+		ranges = append(ranges, kv.KeyRange{StartKey: cur, EndKey: end})
+		if bytes.Equal(end, endKey) {
+			if len(ranges) < 2 {
+				return nil
+			}
+			return ranges
+		}
+		cur = end
+	}
+	return nil
+}
+
 func (c *RawKVClient) sendBatchPut(bo *Backoffer, keys, values [][]byte) error {
 	keyToValue := make(map[string][]byte, len(keys))
 	for i, key := range keys {
@@ -597,7 +714,7 @@ func (c *RawKVClient) doBatchPut(bo *Backoffer, batch kvrpc.Batch) error {
 
 	req := tikvrpc.NewRequest(tikvrpc.CmdRawBatchPut, &kvrpcpb.RawBatchPutRequest{Pairs: kvPair})
 
-	sender := locate.NewRegionRequestSender(c.regionCache, c.rpcClient)
+	sender := c.newSender()
 	resp, err := sender.SendReq(bo, req, batch.RegionID, client.ReadTimeoutShort)
 	if err != nil {
 		return errors.Trace(err)
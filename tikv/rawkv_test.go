@@ -36,11 +36,14 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/client-go/v2/internal/retry"
 	"github.com/tikv/client-go/v2/kv"
 	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
 )
 
 func TestRawKV(t *testing.T) {
@@ -195,3 +198,87 @@ func (s *testRawkvSuite) TestReplaceStore() {
 	err = client.Put(testKey, testValue)
 	s.Nil(err)
 }
+
+// splitOnDeleteRangeClient splits the region before the first raw delete range
+// request reaches it, and records the ranges of the requests.
+type splitOnDeleteRangeClient struct {
+	Client
+	split  func()
+	ranges []kv.KeyRange
+}
+
+func (c *splitOnDeleteRangeClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdRawDeleteRange {
+		r := req.RawDeleteRange()
+		c.ranges = append(c.ranges, kv.KeyRange{StartKey: r.StartKey, EndKey: r.EndKey})
+		if c.split != nil {
+			c.split()
+			c.split = nil
+		}
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func (s *testRawkvSuite) TestDeleteRangeRegionSplit() {
+	rpcClient := &splitOnDeleteRangeClient{Client: mocktikv.NewRPCClient(s.cluster, s.mvccStore, nil)}
+	client := &RawKVClient{
+		clusterID:   0,
+		regionCache: NewRegionCache(mocktikv.NewPDClient(s.cluster)),
+		rpcClient:   rpcClient,
+	}
+	defer client.Close()
+	keys := []string{"a", "b", "c", "d"}
+	for _, k := range keys {
+		s.Nil(client.Put([]byte(k), []byte(k)))
+	}
+
+	rpcClient.split = func() {
+		newRegionID, newPeerIDs := s.cluster.AllocID(), s.cluster.AllocIDs(2)
+		s.cluster.Split(s.region1, newRegionID, []byte("c"), newPeerIDs, newPeerIDs[0])
+	}
+	s.Nil(client.DeleteRange([]byte("a"), []byte("z")))
+	for _, k := range keys {
+		v, err := client.Get([]byte(k))
+		s.Nil(err)
+		s.Nil(v, k)
+	}
+	// The rejected request is followed by one for each new region.
+	ranges := rpcClient.ranges
+	s.Len(ranges, 3)
+	s.Equal(kv.KeyRange{StartKey: []byte("a"), EndKey: []byte("z")}, ranges[0])
+	s.Equal([]byte("a"), ranges[1].StartKey)
+	s.Equal(ranges[1].EndKey, ranges[2].StartKey)
+	s.Equal([]byte("z"), ranges[2].EndKey)
+}
+
+// addrRecordingClient records the addresses of the raw put requests.
+type addrRecordingClient struct {
+	Client
+	addrs []string
+}
+
+func (c *addrRecordingClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdRawPut {
+		c.addrs = append(c.addrs, addr)
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func (s *testRawkvSuite) TestRawWriteToLeader() {
+	rpcClient := &addrRecordingClient{Client: mocktikv.NewRPCClient(s.cluster, s.mvccStore, nil)}
+	client := &RawKVClient{
+		clusterID:   0,
+		regionCache: NewRegionCache(mocktikv.NewPDClient(s.cluster)),
+		rpcClient:   rpcClient,
+	}
+	defer client.Close()
+
+	// The raw writes go to the leader even if the request asks for a follower.
+	var seed uint32
+	for i := 0; i < 2; i++ {
+		req := tikvrpc.NewReplicaReadRequest(tikvrpc.CmdRawPut, &kvrpcpb.RawPutRequest{Key: []byte("a"), Value: []byte("v")}, kv.ReplicaReadFollower, &seed)
+		_, _, err := client.sendReq([]byte("a"), req, false)
+		s.Nil(err)
+	}
+	s.Equal([]string{s.storeAddr(s.store1), s.storeAddr(s.store1)}, rpcClient.addrs)
+}
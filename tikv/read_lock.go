@@ -0,0 +1,62 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+	tikv "github.com/tikv/client-go/v2/kv"
+)
+
+// ReadLock adds key to the read set of the optimistic transaction. The
+// transaction reads the version of key at its start ts, and aborts with
+// ErrReadWriteConflict at commit if key is written by another transaction after
+// that, so the write-after-read conflicts are detected like the write-write
+// ones. SerializableSnapshot read locks every key it reads.
+//
+// A read locked key not written by the transaction is prewritten with
+// Op_Lock, which writes nothing but is checked against the newer writes by
+// TiKV. Pessimistic transactions should use LockKeys instead.
+func (txn *KVTxn) ReadLock(ctx context.Context, key []byte) error {
+	if txn.IsPessimistic() {
+		return errors.New("ReadLock is not supported by pessimistic transactions")
+	}
+	txn.readSet.Add(key)
+	return nil
+}
+
+// lockReadSet marks the keys in the read set as locked in the mem buffer, so
+// they are prewritten. It's done at commit instead of in ReadLock, because
+// ReadLock may be called by an iterator of the mem buffer.
+func (txn *KVTxn) lockReadSet() {
+	memBuf := txn.us.GetMemBuffer()
+	for _, key := range txn.readSet.Keys() {
+		memBuf.UpdateFlags(key, tikv.SetKeyLocked)
+	}
+}
+
+// readWriteConflict returns ErrReadWriteConflict if err is a write conflict on
+// a key in the read set, otherwise err is returned.
+func (txn *KVTxn) readWriteConflict(err error) error {
+	conflict, ok := errors.Cause(err).(*tikverr.ErrWriteConflict)
+	if !ok {
+		return err
+	}
+	if !txn.readSet.Contains(conflict.GetKey()) {
+		return err
+	}
+	return errors.Trace(&tikverr.ErrReadWriteConflict{Conflict: conflict})
+}
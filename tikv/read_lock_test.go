@@ -0,0 +1,72 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+func TestReadLock(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+
+	set := func(key, value string) {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		require.Nil(t, txn.Set([]byte(key), []byte(value)))
+		require.Nil(t, txn.Commit(ctx))
+	}
+	set("balance", "100")
+
+	// The read key is not changed.
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.ReadLock(ctx, []byte("balance")))
+	require.Nil(t, txn.Set([]byte("withdraw"), []byte("10")))
+	require.Nil(t, txn.Commit(ctx))
+
+	// The read key is written after the txn starts.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	val, err := txn.Get(ctx, []byte("balance"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("100"), val)
+	require.Nil(t, txn.ReadLock(ctx, []byte("balance")))
+	require.Nil(t, txn.Set([]byte("withdraw"), []byte("100")))
+	set("balance", "50")
+	err = txn.Commit(ctx)
+	require.True(t, tikverr.Is(err, &tikverr.ErrReadWriteConflict{}))
+	require.True(t, tikverr.IsErrWriteConflict(err))
+
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	val, err = txn.Get(ctx, []byte("withdraw"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("10"), val)
+
+	// A write conflict on other keys is not a read-write conflict.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.ReadLock(ctx, []byte("balance")))
+	require.Nil(t, txn.Set([]byte("withdraw"), []byte("20")))
+	set("withdraw", "30")
+	err = txn.Commit(ctx)
+	require.True(t, tikverr.IsErrWriteConflict(err))
+	require.False(t, tikverr.Is(err, &tikverr.ErrReadWriteConflict{}))
+}
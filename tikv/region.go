@@ -80,6 +80,43 @@ type RegionRequestSender = locate.RegionRequestSender
 // StoreSelectorOption configures storeSelectorOp.
 type StoreSelectorOption = locate.StoreSelectorOption
 
+// SenderOption configures a RegionRequestSender.
+type SenderOption = locate.SenderOption
+
+// WithStoreType makes the sender route the coprocessor and MPP requests that
+// target TiKV to the stores of storeType, falling back to TiKV if the region
+// has no peer of the type. The other requests are always sent to TiKV.
+func WithStoreType(storeType tikvrpc.EndpointType) SenderOption {
+	return locate.WithStoreType(storeType)
+}
+
+// AdaptiveTimeoutPolicy sets the timeout of the retried requests by the P99
+// latency of the recent requests of the same type.
+type AdaptiveTimeoutPolicy = locate.AdaptiveTimeoutPolicy
+
+// NewAdaptiveTimeoutPolicy creates an AdaptiveTimeoutPolicy that bounds the
+// timeouts by [minTimeout, maxTimeout].
+func NewAdaptiveTimeoutPolicy(minTimeout, maxTimeout time.Duration) *AdaptiveTimeoutPolicy {
+	return locate.NewAdaptiveTimeoutPolicy(minTimeout, maxTimeout)
+}
+
+// WithAdaptiveTimeout makes the sender set the timeout of the retried requests
+// by policy.
+func WithAdaptiveTimeout(policy *AdaptiveTimeoutPolicy) SenderOption {
+	return locate.WithAdaptiveTimeout(policy)
+}
+
+// LeaderWritePolicy decides how RegionRequestSender routes write requests.
+type LeaderWritePolicy = locate.LeaderWritePolicy
+
+const (
+	// LeaderWriteByRequest routes write requests according to the replica read
+	// type and store selector options of the request.
+	LeaderWriteByRequest = locate.LeaderWriteByRequest
+	// LeaderWriteAlways always routes write requests to the current leader.
+	LeaderWriteAlways = locate.LeaderWriteAlways
+)
+
 // RegionRequestRuntimeStats records the runtime stats of send region requests.
 type RegionRequestRuntimeStats = locate.RegionRequestRuntimeStats
 
@@ -89,6 +126,16 @@ type RPCRuntimeStats = locate.RPCRuntimeStats
 // CodecPDClient wraps a PD Client to decode the encoded keys in region meta.
 type CodecPDClient = locate.CodecPDClient
 
+// StoreInfoCache caches the store metas loaded from PD.
+type StoreInfoCache = locate.StoreInfoCache
+
+// StoreInfoCacheStats is the statistics of a StoreInfoCache.
+type StoreInfoCacheStats = locate.StoreInfoCacheStats
+
+// RegionSplitObserver is notified when the RegionCache learns that a cached
+// region is split into two regions.
+type RegionSplitObserver = locate.RegionSplitObserver
+
 // RecordRegionRequestRuntimeStats records request runtime stats.
 func RecordRegionRequestRuntimeStats(stats map[tikvrpc.CmdType]*locate.RPCRuntimeStats, cmd tikvrpc.CmdType, d time.Duration) {
 	locate.RecordRegionRequestRuntimeStats(stats, cmd, d)
@@ -119,8 +166,8 @@ func GetStoreTypeByMeta(store *metapb.Store) tikvrpc.EndpointType {
 }
 
 // NewRegionRequestSender creates a new sender.
-func NewRegionRequestSender(regionCache *RegionCache, client client.Client) *RegionRequestSender {
-	return locate.NewRegionRequestSender(regionCache, client)
+func NewRegionRequestSender(regionCache *RegionCache, client client.Client, opts ...SenderOption) *RegionRequestSender {
+	return locate.NewRegionRequestSender(regionCache, client, opts...)
 }
 
 // LoadShuttingDown atomically loads ShuttingDown.
@@ -0,0 +1,136 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+// splittingClient is a test double splitting a region of the mock cluster at a
+// key right before the N-th request to the region is sent. The request then
+// fails with EpochNotMatch, which drives the committer through the real
+// relocate and retry paths. The region is split only once.
+type splittingClient struct {
+	Client
+	cluster  *mocktikv.Cluster
+	regionID uint64
+	splitKey []byte
+	n        int
+
+	mu       sync.Mutex
+	requests int
+	split    bool
+	// sent records the requests sent to the cluster.
+	sent []*tikvrpc.Request
+}
+
+func (c *splittingClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	c.mu.Lock()
+	if req.Context.GetRegionId() == c.regionID && !c.split {
+		c.requests++
+		if c.requests == c.n {
+			newPeerID := c.cluster.AllocID()
+			c.cluster.Split(c.regionID, c.cluster.AllocID(), c.splitKey, []uint64{newPeerID}, newPeerID)
+			c.split = true
+		}
+	}
+	c.sent = append(c.sent, req)
+	c.mu.Unlock()
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func (c *splittingClient) sentOfType(tp tikvrpc.CmdType) []*tikvrpc.Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var reqs []*tikvrpc.Request
+	for _, req := range c.sent {
+		if req.Type == tp {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+func newSplittingTestStore(t *testing.T, splitKey []byte, n int) (*KVStore, *splittingClient) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	client := &splittingClient{
+		Client:   mocktikv.NewRPCClient(cluster, mvccStore, nil),
+		cluster:  cluster,
+		regionID: regionID,
+		splitKey: splitKey,
+		n:        n,
+	}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	return store, client
+}
+
+func commitKeys(t *testing.T, store *KVStore, keys ...string) {
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for _, k := range keys {
+		require.Nil(t, txn.Set([]byte(k), []byte(k)))
+	}
+	require.Nil(t, txn.Commit(context.Background()))
+
+	snapshot, err := store.GetSnapshotWithOptions()
+	require.Nil(t, err)
+	for _, k := range keys {
+		val, err := snapshot.Get(context.Background(), []byte(k))
+		require.Nil(t, err)
+		require.Equal(t, []byte(k), val)
+	}
+}
+
+func TestPrewriteRetryAfterSplit(t *testing.T) {
+	store, client := newSplittingTestStore(t, []byte("k3"), 1)
+	defer store.Close()
+
+	commitKeys(t, store, "k1", "k2", "k3", "k4")
+	require.True(t, client.split)
+
+	// The prewrite of the stale region is retried by actionPrewrite{retry: true}
+	// in the two new regions, which doesn't know the size of the transaction.
+	prewrites := client.sentOfType(tikvrpc.CmdPrewrite)
+	require.Len(t, prewrites, 3)
+	require.NotEqual(t, uint64(math.MaxUint64), prewrites[0].Prewrite().TxnSize)
+	regions := make(map[uint64]struct{})
+	for _, req := range prewrites[1:] {
+		require.Equal(t, uint64(math.MaxUint64), req.Prewrite().TxnSize)
+		regions[req.Context.GetRegionId()] = struct{}{}
+	}
+	require.Len(t, regions, 2)
+}
+
+func TestCommitRetryAfterSplit(t *testing.T) {
+	// The first request to the region is the prewrite, the region is split
+	// before the commit.
+	store, client := newSplittingTestStore(t, []byte("k3"), 2)
+	defer store.Close()
+
+	commitKeys(t, store, "k1", "k2", "k3", "k4")
+	require.True(t, client.split)
+	require.Len(t, client.sentOfType(tikvrpc.CmdPrewrite), 1)
+	require.Len(t, client.sentOfType(tikvrpc.CmdCommit), 3)
+}
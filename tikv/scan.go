@@ -35,6 +35,7 @@ package tikv
 import (
 	"bytes"
 	"context"
+	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
@@ -50,6 +51,8 @@ import (
 
 // Scanner support tikv scan
 type Scanner struct {
+	// ctx is the context of the requests sent by the scanner.
+	ctx          context.Context
 	snapshot     *KVSnapshot
 	batchSize    int
 	cache        []*kvrpcpb.KvPair
@@ -61,16 +64,27 @@ type Scanner struct {
 	nextEndKey []byte
 	reverse    bool
 
-	valid bool
-	eof   bool
+	valid   bool
+	eof     bool
+	keyOnly bool
+
+	// stats collects the per region statistics if it's not nil.
+	stats *[]ScanStats
+	// profile collects the latency breakdown of the batches if it's not nil.
+	profile *[]ScanProfile
 }
 
 func newScanner(snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize int, reverse bool) (*Scanner, error) {
+	return newScannerWithStats(snapshot, startKey, endKey, batchSize, reverse, nil)
+}
+
+func newScannerWithStats(snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize int, reverse bool, stats *[]ScanStats) (*Scanner, error) {
 	// It must be > 1. Otherwise scanner won't skipFirst.
 	if batchSize <= 1 {
 		batchSize = defaultScanBatchSize
 	}
 	scanner := &Scanner{
+		ctx:          context.Background(),
 		snapshot:     snapshot,
 		batchSize:    batchSize,
 		valid:        true,
@@ -78,12 +92,56 @@ func newScanner(snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize
 		endKey:       endKey,
 		reverse:      reverse,
 		nextEndKey:   endKey,
+		keyOnly:      snapshot.keyOnly,
+		stats:        stats,
+	}
+	return scanner.open()
+}
+
+// newKeyOnlyScanner creates a scanner that doesn't fetch the values regardless
+// of the KeyOnly option of the snapshot.
+func newKeyOnlyScanner(ctx context.Context, snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize int) (*Scanner, error) {
+	if batchSize <= 1 {
+		batchSize = defaultScanBatchSize
+	}
+	scanner := &Scanner{
+		ctx:          ctx,
+		snapshot:     snapshot,
+		batchSize:    batchSize,
+		valid:        true,
+		nextStartKey: startKey,
+		endKey:       endKey,
+		nextEndKey:   endKey,
+		keyOnly:      true,
+	}
+	return scanner.open()
+}
+
+// newProfiledScanner creates a scanner that profiles the batches into profile.
+func newProfiledScanner(snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize int, profile *[]ScanProfile) (*Scanner, error) {
+	if batchSize <= 1 {
+		batchSize = defaultScanBatchSize
+	}
+	scanner := &Scanner{
+		ctx:          context.Background(),
+		snapshot:     snapshot,
+		batchSize:    batchSize,
+		valid:        true,
+		nextStartKey: startKey,
+		endKey:       endKey,
+		nextEndKey:   endKey,
+		keyOnly:      snapshot.keyOnly,
+		profile:      profile,
 	}
-	err := scanner.Next()
+	return scanner.open()
+}
+
+func (s *Scanner) open() (*Scanner, error) {
+	err := s.Next()
 	if tikverr.IsErrNotFound(err) {
-		return scanner, nil
+		return s, nil
 	}
-	return scanner, errors.Trace(err)
+	return s, errors.Trace(err)
 }
 
 // Valid return valid.
@@ -111,7 +169,7 @@ const scannerNextMaxBackoff = 600000 // 10 minutes
 
 // Next return next element.
 func (s *Scanner) Next() error {
-	bo := retry.NewBackofferWithVars(context.WithValue(context.Background(), retry.TxnStartKey, s.snapshot.version), scannerNextMaxBackoff, s.snapshot.vars)
+	bo := retry.NewBackofferWithVars(context.WithValue(s.ctx, retry.TxnStartKey, s.snapshot.version), scannerNextMaxBackoff, s.snapshot.vars)
 	if !s.valid {
 		return errors.New("scanner iterator is invalid")
 	}
@@ -150,7 +208,7 @@ func (s *Scanner) Next() error {
 
 			// The check here does not violate the KeyOnly semantic, because current's value
 			// is filled by resolveCurrentLock which fetches the value by snapshot.get, so an empty
-			// value stands for NotExist
+			// value stands for NotExist. It skips the keys deleted by the transaction of the lock.
 			if len(current.Value) == 0 {
 				continue
 			}
@@ -169,8 +227,11 @@ func (s *Scanner) startTS() uint64 {
 }
 
 func (s *Scanner) resolveCurrentLock(bo *Backoffer, current *kvrpcpb.KvPair) error {
-	ctx := context.Background()
-	val, err := s.snapshot.get(ctx, bo, current.Key)
+	val, err := s.snapshot.get(s.ctx, bo, current.Key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	val, err = s.snapshot.decodeValue(val)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -185,16 +246,20 @@ func (s *Scanner) getData(bo *Backoffer) error {
 		zap.String("nextEndKey", kv.StrKey(s.nextEndKey)),
 		zap.Bool("reverse", s.reverse),
 		zap.Uint64("txnStartTS", s.startTS()))
-	sender := locate.NewRegionRequestSender(s.snapshot.store.regionCache, s.snapshot.store.GetTiKVClient())
+	start := time.Now()
+	sender := locate.NewRegionRequestSender(s.snapshot.store.regionCache, s.snapshot.store.GetTiKVClient(), s.snapshot.store.senderOptions()...)
 	var reqEndKey, reqStartKey []byte
 	var loc *locate.KeyLocation
 	var err error
+	var step StepDuration
 	for {
+		lookupStart := time.Now()
 		if !s.reverse {
 			loc, err = s.snapshot.store.regionCache.LocateKey(bo, s.nextStartKey)
 		} else {
 			loc, err = s.snapshot.store.regionCache.LocateEndKey(bo, s.nextEndKey)
 		}
+		step.RegionLookup += time.Since(lookupStart)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -222,7 +287,7 @@ func (s *Scanner) getData(bo *Backoffer) error {
 			EndKey:     reqEndKey,
 			Limit:      uint32(s.batchSize),
 			Version:    s.startTS(),
-			KeyOnly:    s.snapshot.keyOnly,
+			KeyOnly:    s.keyOnly,
 			SampleStep: s.snapshot.sampleStep,
 		}
 		if s.reverse {
@@ -238,7 +303,9 @@ func (s *Scanner) getData(bo *Backoffer) error {
 			ResourceGroupTag: s.snapshot.resourceGroupTag,
 		})
 		s.snapshot.mu.RUnlock()
+		rpcStart := time.Now()
 		resp, err := sender.SendReq(bo, req, loc.Region, client.ReadTimeoutMedium)
+		step.RPCWait += time.Since(rpcStart)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -291,6 +358,7 @@ func (s *Scanner) getData(bo *Backoffer) error {
 		}
 
 		kvPairs := cmdScanResp.Pairs
+		decodeStart := time.Now()
 		// Check if kvPair contains error, it should be a Lock.
 		for _, pair := range kvPairs {
 			if keyErr := pair.GetError(); keyErr != nil && len(pair.Key) == 0 {
@@ -300,6 +368,25 @@ func (s *Scanner) getData(bo *Backoffer) error {
 				}
 				pair.Key = lock.Key
 			}
+			if pair.GetError() == nil && !s.keyOnly {
+				if pair.Value, err = s.snapshot.decodeValue(pair.Value); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+
+		step.Decode = time.Since(decodeStart)
+		if s.profile != nil {
+			step.Total = time.Since(start)
+			s.recordProfile(loc.Region.GetID(), step)
+		}
+
+		if s.stats != nil {
+			if !s.reverse {
+				s.recordStats(loc, s.nextStartKey, reqEndKey, kvPairs, time.Since(start))
+			} else {
+				s.recordStats(loc, reqStartKey, s.nextEndKey, kvPairs, time.Since(start))
+			}
 		}
 
 		s.cache, s.idx = kvPairs, 0
@@ -0,0 +1,105 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+type scanProfileCtxKeyType struct{}
+
+// ScanProfileKey is the context key of a *[]ScanProfile, which ScanProfiler
+// sets to the profiles of the scan after the scan completes.
+var ScanProfileKey = scanProfileCtxKeyType{}
+
+// StepDuration is the time spent on each step of a scan batch.
+type StepDuration struct {
+	// RegionLookup is the time spent on locating the region in the region
+	// cache, including loading it from PD.
+	RegionLookup time.Duration
+	// RPCWait is the time spent on waiting for the scan responses.
+	RPCWait time.Duration
+	// Decode is the time spent on decoding the values.
+	Decode time.Duration
+	// Total is the time spent on the batch, which also covers the backoffs
+	// and the locks resolved in the batch.
+	Total time.Duration
+}
+
+// ScanProfile is the latency breakdown of a scan batch of a region.
+type ScanProfile struct {
+	RegionID uint64
+	StepDuration
+}
+
+// ScanProfiler scans a snapshot and breaks down the latency of each batch by
+// the steps, which tells where the time of a slow scan goes.
+type ScanProfiler struct {
+	snapshot *KVSnapshot
+}
+
+// NewScanProfiler creates a ScanProfiler of the snapshot.
+func NewScanProfiler(snapshot *KVSnapshot) *ScanProfiler {
+	return &ScanProfiler{snapshot: snapshot}
+}
+
+// Scan returns at most limit pairs in [startKey, endKey). An empty endKey means
+// unbounded. If ctx has a *[]ScanProfile of ScanProfileKey, it's set to the
+// profiles of the batches once the scan completes.
+func (p *ScanProfiler) Scan(ctx context.Context, startKey, endKey []byte, limit int) ([]KVPair, error) {
+	if limit <= 0 {
+		return nil, errors.Errorf("invalid scan limit %d", limit)
+	}
+	batchSize := limit
+	if batchSize > p.snapshot.scanBatchSize {
+		batchSize = p.snapshot.scanBatchSize
+	}
+	var profiles []ScanProfile
+	defer func() {
+		if val := ctx.Value(ScanProfileKey); val != nil {
+			*val.(*[]ScanProfile) = profiles
+		}
+	}()
+	it, err := newProfiledScanner(p.snapshot, startKey, endKey, batchSize, &profiles)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer it.Close()
+	return collectPairs(ctx, it, limit)
+}
+
+func (s *Scanner) recordProfile(regionID uint64, step StepDuration) {
+	*s.profile = append(*s.profile, ScanProfile{RegionID: regionID, StepDuration: step})
+}
+
+// collectPairs returns at most limit pairs from it.
+func collectPairs(ctx context.Context, it *Scanner, limit int) ([]KVPair, error) {
+	var pairs []KVPair
+	for it.Valid() && len(pairs) < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		pairs = append(pairs, KVPair{Key: it.Key(), Value: it.Value()})
+		if len(pairs) == limit {
+			break
+		}
+		if err := it.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return pairs, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/internal/locate"
+)
+
+// ScanStats is the statistics of scanning a region.
+type ScanStats struct {
+	RegionID uint64
+	// StartKey and EndKey are the range scanned in the region.
+	StartKey []byte
+	EndKey   []byte
+	// BytesRead is the size of the keys and values returned by the region,
+	// including the ones beyond the limit of the scan.
+	BytesRead    uint64
+	RowsReturned uint64
+	// LatencyMs is the time spent on the scan requests to the region,
+	// including the retries.
+	LatencyMs uint64
+}
+
+// recordStats adds the statistics of a scan response of the range [start, end)
+// of the region. The consecutive responses of a region are merged, the first
+// one has the widest range.
+func (s *Scanner) recordStats(loc *locate.KeyLocation, start, end []byte, pairs []*kvrpcpb.KvPair, elapsed time.Duration) {
+	var bytesRead uint64
+	for _, pair := range pairs {
+		bytesRead += uint64(len(pair.Key) + len(pair.Value))
+	}
+	stats := *s.stats
+	if n := len(stats); n == 0 || stats[n-1].RegionID != loc.Region.GetID() {
+		stats = append(stats, ScanStats{
+			RegionID: loc.Region.GetID(),
+			StartKey: start,
+			EndKey:   end,
+		})
+	}
+	last := &stats[len(stats)-1]
+	last.BytesRead += bytesRead
+	last.RowsReturned += uint64(len(pairs))
+	last.LatencyMs += uint64(elapsed.Milliseconds())
+	*s.stats = stats
+}
+
+// ScanWithStats returns at most limit pairs in [startKey, endKey) with the
+// statistics of the regions scanned. An empty endKey means unbounded.
+func (s *KVSnapshot) ScanWithStats(ctx context.Context, startKey, endKey []byte, limit int) ([]KVPair, []ScanStats, error) {
+	if limit <= 0 {
+		return nil, nil, errors.Errorf("invalid scan limit %d", limit)
+	}
+	batchSize := limit
+	if batchSize > s.scanBatchSize {
+		batchSize = s.scanBatchSize
+	}
+	var stats []ScanStats
+	it, err := newScannerWithStats(s, startKey, endKey, batchSize, false, &stats)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer it.Close()
+
+	pairs, err := collectPairs(ctx, it, limit)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return pairs, stats, nil
+}
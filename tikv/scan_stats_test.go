@@ -0,0 +1,131 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+func TestScanWithStats(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("m"), []uint64{ids[1]}, ids[1])
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for _, k := range []string{"a", "b", "c", "x", "y"} {
+		require.Nil(t, txn.Set([]byte(k), []byte("v")))
+	}
+	require.Nil(t, txn.Commit(ctx))
+
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	snapshot := store.GetSnapshot(ts)
+	snapshot.SetScanBatchSize(2)
+	pairs, stats, err := snapshot.ScanWithStats(ctx, []byte("b"), []byte("z"), 3)
+	require.Nil(t, err)
+	require.Len(t, pairs, 3)
+	require.Equal(t, []byte("x"), pairs[2].Key)
+	require.Len(t, stats, 2)
+
+	require.Equal(t, regionID, stats[0].RegionID)
+	require.Equal(t, []byte("b"), stats[0].StartKey)
+	require.Equal(t, []byte("m"), stats[0].EndKey)
+	require.Equal(t, uint64(2), stats[0].RowsReturned)
+	require.Equal(t, uint64(4), stats[0].BytesRead)
+
+	require.Equal(t, ids[0], stats[1].RegionID)
+	require.Equal(t, []byte("m"), stats[1].StartKey)
+	require.Equal(t, []byte("z"), stats[1].EndKey)
+	require.Equal(t, uint64(2), stats[1].RowsReturned)
+
+	_, _, err = snapshot.ScanWithStats(ctx, nil, nil, 0)
+	require.NotNil(t, err)
+}
+
+// slowScanClient delays the scan responses, so the RPCs dominate the scans.
+type slowScanClient struct {
+	Client
+	delay time.Duration
+}
+
+func (c *slowScanClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdScan {
+		time.Sleep(c.delay)
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestScanProfiler(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("m"), []uint64{ids[1]}, ids[1])
+	client := &slowScanClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil), delay: 5 * time.Millisecond}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for _, k := range []string{"a", "b", "c", "x", "y"} {
+		require.Nil(t, txn.Set([]byte(k), []byte("v")))
+	}
+	require.Nil(t, txn.Commit(context.Background()))
+
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	snapshot := store.GetSnapshot(ts)
+	snapshot.SetScanBatchSize(2)
+	var profiles []ScanProfile
+	ctx := context.WithValue(context.Background(), ScanProfileKey, &profiles)
+	start := time.Now()
+	pairs, err := NewScanProfiler(snapshot).Scan(ctx, nil, nil, 10)
+	elapsed := time.Since(start)
+	require.Nil(t, err)
+	require.Len(t, pairs, 5)
+
+	// [a, b], [c], [x, y] and the empty batch after y.
+	require.Len(t, profiles, 4)
+	require.Equal(t, regionID, profiles[0].RegionID)
+	require.Equal(t, ids[0], profiles[3].RegionID)
+	withinTenPercent := func(sum, total time.Duration) {
+		require.InDelta(t, float64(total), float64(sum), float64(total)/10)
+	}
+	var total time.Duration
+	for _, p := range profiles {
+		require.GreaterOrEqual(t, int64(p.RPCWait), int64(client.delay))
+		withinTenPercent(p.RegionLookup+p.RPCWait+p.Decode, p.Total)
+		total += p.Total
+	}
+	withinTenPercent(total, elapsed)
+
+	_, err = NewScanProfiler(snapshot).Scan(context.Background(), nil, nil, 0)
+	require.NotNil(t, err)
+}
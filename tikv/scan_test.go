@@ -0,0 +1,72 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/oracle"
+)
+
+func TestScanSkipLockedDelete(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("b"), []byte("1")))
+	require.Nil(t, txn.Commit(context.Background()))
+
+	// Delete b, but only commit the primary key a, so b is still locked.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("a"), []byte("2")))
+	require.Nil(t, txn.Delete([]byte("b")))
+	committer, err := newTwoPhaseCommitterWithInit(txn, 0)
+	require.Nil(t, err)
+	bo := NewBackofferWithVars(context.Background(), 5000, nil)
+	require.Nil(t, committer.prewriteMutations(bo, committer.mutations))
+	committer.commitTS, err = store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	require.Equal(t, []byte("a"), committer.primary())
+	require.Nil(t, committer.commitMutations(bo, committer.mutations.Slice(0, 1)))
+
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	snapshot := store.GetSnapshot(ts)
+	for _, reverse := range []bool{false, true} {
+		var iter Iterator
+		if reverse {
+			iter, err = snapshot.IterReverse([]byte("z"))
+		} else {
+			iter, err = snapshot.Iter(nil, nil)
+		}
+		require.Nil(t, err)
+		var keys []string
+		for iter.Valid() {
+			keys = append(keys, string(iter.Key()))
+			require.Nil(t, iter.Next())
+		}
+		require.Equal(t, []string{"a"}, keys)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// secondaryCommitMaxWaves is the max number of waves a batch is sent in.
+	secondaryCommitMaxWaves = 3
+	// secondaryCommitLatencyWindow is the number of the recent latencies the
+	// deadline of a wave is computed from.
+	secondaryCommitLatencyWindow = 256
+	// secondaryCommitMinSamples is the number of latencies needed before the
+	// slow batches are sent again.
+	secondaryCommitMinSamples = 20
+)
+
+// DynamicSecondaryBatcher commits the secondary batches of the transactions in
+// waves. The first wave sends all batches. A batch in flight that hasn't
+// responded within P50 + 2σ of the recent commit latencies since a worker
+// started sending it is sent again in the next wave while
+// the first attempt keeps going, and the batch is done when either attempt
+// succeeds, so a slow region doesn't hold its secondary locks for long. Commit
+// is idempotent, so the extra attempts are safe. The latencies of the batches
+// are fed back to compute the deadlines of the later waves and transactions.
+type DynamicSecondaryBatcher struct {
+	concurrency int
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+// NewDynamicSecondaryBatcher creates a DynamicSecondaryBatcher that sends at
+// most concurrency commit requests of a transaction at a time.
+func NewDynamicSecondaryBatcher(concurrency int) *DynamicSecondaryBatcher {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &DynamicSecondaryBatcher{concurrency: concurrency}
+}
+
+// observe records the latency of a successful commit batch.
+func (b *DynamicSecondaryBatcher) observe(latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.latencies) < secondaryCommitLatencyWindow {
+		b.latencies = append(b.latencies, latency)
+		return
+	}
+	b.latencies[b.next] = latency
+	b.next = (b.next + 1) % secondaryCommitLatencyWindow
+}
+
+// deadline returns P50 + 2σ of the recent latencies, or 0 if not enough
+// latencies are observed.
+func (b *DynamicSecondaryBatcher) deadline() time.Duration {
+	b.mu.Lock()
+	latencies := append([]time.Duration(nil), b.latencies...)
+	b.mu.Unlock()
+	if len(latencies) < secondaryCommitMinSamples {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum float64
+	for _, l := range latencies {
+		sum += float64(l)
+	}
+	mean := sum / float64(len(latencies))
+	var variance float64
+	for _, l := range latencies {
+		variance += (float64(l) - mean) * (float64(l) - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(latencies)))
+	return latencies[len(latencies)/2] + time.Duration(2*stddev)
+}
+
+// secondaryCommitEvent is sent by a worker when it starts sending a batch,
+// when it skips a batch already done, and when the batch responds.
+type secondaryCommitEvent struct {
+	idx     int
+	started bool
+	skipped bool
+	at      time.Time
+	latency time.Duration
+	err     error
+}
+
+// secondaryCommitState is the state of a batch in commit.
+type secondaryCommitState struct {
+	// sentAt is when the latest attempt is taken by a worker.
+	sentAt time.Time
+	// waves is the number of the attempts taken by the workers.
+	waves    int
+	queued   int
+	inflight int
+	done     bool
+}
+
+// canResend returns whether the batch can be sent in the next wave. Only the
+// batches in flight are sent again, a batch waiting for a worker isn't slow.
+func (s *secondaryCommitState) canResend() bool {
+	return !s.done && s.inflight > 0 && s.queued == 0 && s.waves < secondaryCommitMaxWaves
+}
+
+// nextWait returns the time until the earliest batch that can be sent again
+// passes the deadline, or false if there is no such batch.
+func nextWait(states []secondaryCommitState, deadline time.Duration) (time.Duration, bool) {
+	var wait time.Duration
+	found := false
+	for i := range states {
+		s := &states[i]
+		if !s.canResend() {
+			continue
+		}
+		if d := deadline - time.Since(s.sentAt); !found || d < wait {
+			wait, found = d, true
+		}
+	}
+	if found && wait < 0 {
+		wait = 0
+	}
+	return wait, found
+}
+
+// commit commits the batches by a pool of workers and returns once every batch
+// succeeds or all its attempts fail. The attempts still running then are left
+// to finish in background.
+func (b *DynamicSecondaryBatcher) commit(c *twoPhaseCommitter, bo *Backoffer, batches []batchMutations) error {
+	if len(batches) == 0 {
+		return nil
+	}
+	// Every batch is sent at most secondaryCommitMaxWaves times, and a task
+	// causes at most two events, so neither the workers nor the senders of the
+	// tasks block.
+	tasks := make(chan int, len(batches)*secondaryCommitMaxWaves)
+	events := make(chan secondaryCommitEvent, 2*cap(tasks))
+	// finished marks the batches done, so the workers skip their queued tasks.
+	finished := make([]int32, len(batches))
+	// The workers are not capped by the number of batches, or the later waves
+	// of a single slow batch would wait for its first attempt.
+	workers := b.concurrency
+	if workers > cap(tasks) {
+		workers = cap(tasks)
+	}
+	c.storeWg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer c.storeWg.Done()
+			for idx := range tasks {
+				if atomic.LoadInt32(&finished[idx]) == 1 {
+					events <- secondaryCommitEvent{idx: idx, skipped: true}
+					continue
+				}
+				start := time.Now()
+				events <- secondaryCommitEvent{idx: idx, started: true, at: start}
+				err := actionCommit{}.handleSingleBatch(c, bo.Clone(), batches[idx])
+				if err == nil {
+					// Mark it before the event is handled, or the worker
+					// may take the queued task of the batch right away.
+					atomic.StoreInt32(&finished[idx], 1)
+				}
+				events <- secondaryCommitEvent{idx: idx, latency: time.Since(start), err: err}
+			}
+		}()
+	}
+	defer close(tasks)
+
+	states := make([]secondaryCommitState, len(batches))
+	send := func(idx int) {
+		states[idx].queued++
+		tasks <- idx
+	}
+	finish := func(idx int) {
+		states[idx].done = true
+		atomic.StoreInt32(&finished[idx], 1)
+	}
+	for i := range batches {
+		send(i)
+	}
+
+	var batchErrs []tikverr.BatchError
+	pending := len(batches)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for pending > 0 {
+		// Wake up when the earliest batch in flight passes the deadline.
+		var timeout <-chan time.Time
+		if deadline := b.deadline(); deadline > 0 {
+			if wait, ok := nextWait(states, deadline); ok {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(wait)
+				timeout = timer.C
+			}
+		}
+
+		select {
+		case e := <-events:
+			s := &states[e.idx]
+			if e.skipped {
+				s.queued--
+				continue
+			}
+			if e.started {
+				s.queued--
+				s.inflight++
+				s.waves++
+				s.sentAt = e.at
+				continue
+			}
+			s.inflight--
+			if e.err == nil {
+				// The late attempts are observed too, or the deadline would
+				// only reflect the fast ones.
+				b.observe(e.latency)
+			}
+			if s.done {
+				continue
+			}
+			if e.err == nil {
+				finish(e.idx)
+				pending--
+			} else if s.inflight == 0 && s.queued == 0 {
+				finish(e.idx)
+				pending--
+				batchErrs = append(batchErrs, tikverr.BatchError{RegionID: batches[e.idx].region.GetID(), Err: e.err})
+			}
+		case <-timeout:
+			deadline := b.deadline()
+			for i := range states {
+				s := &states[i]
+				if s.canResend() && time.Since(s.sentAt) >= deadline {
+					metrics.TiKVSecondaryCommitWaveRetryCounter.Inc()
+					logutil.BgLogger().Debug("send slow secondary commit batch in next wave",
+						zap.Uint64("txnStartTS", c.startTS), zap.Stringer("region", &batches[i].region),
+						zap.Int("wave", s.waves+1), zap.Duration("deadline", deadline))
+					send(i)
+				}
+			}
+		}
+	}
+	if len(batchErrs) > 0 {
+		return errors.Trace(&tikverr.MultiError{Errors: batchErrs})
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+func TestSecondaryCommitDeadline(t *testing.T) {
+	b := NewDynamicSecondaryBatcher(4)
+	for i := 0; i < secondaryCommitMinSamples-1; i++ {
+		b.observe(10 * time.Millisecond)
+	}
+	require.Equal(t, time.Duration(0), b.deadline())
+	b.observe(10 * time.Millisecond)
+	require.Equal(t, 10*time.Millisecond, b.deadline())
+
+	// P50 is 10ms, σ is 5ms.
+	for i := 0; i < secondaryCommitMinSamples; i++ {
+		b.observe(20 * time.Millisecond)
+	}
+	require.Equal(t, 30*time.Millisecond, b.deadline())
+
+	// Only the recent latencies are used.
+	for i := 0; i < secondaryCommitLatencyWindow; i++ {
+		b.observe(time.Millisecond)
+	}
+	require.Equal(t, time.Millisecond, b.deadline())
+}
+
+// slowCommitClient blocks the first commit request of the key until release is
+// closed.
+type slowCommitClient struct {
+	Client
+	key      []byte
+	release  chan struct{}
+	attempts int32
+	done     int32
+}
+
+func (c *slowCommitClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdCommit {
+		for _, key := range req.Commit().Keys {
+			if bytes.Equal(key, c.key) {
+				if atomic.AddInt32(&c.attempts, 1) == 1 {
+					<-c.release
+				}
+				defer atomic.AddInt32(&c.done, 1)
+			}
+		}
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestDynamicSecondaryCommit(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("m"), []uint64{ids[1]}, ids[1])
+	client := &slowCommitClient{
+		Client:  mocktikv.NewRPCClient(cluster, mvccStore, nil),
+		key:     []byte("x"),
+		release: make(chan struct{}),
+	}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	defer close(client.release)
+
+	store.EnableDynamicSecondaryCommit(4)
+	for i := 0; i < secondaryCommitMinSamples; i++ {
+		store.secondaryBatcher.observe(time.Millisecond)
+	}
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("a"), []byte("v")))
+	require.Nil(t, txn.Set([]byte("x"), []byte("v")))
+	require.Nil(t, txn.Commit(context.Background()))
+
+	// The secondary batch of x is sent in the later waves and succeeds while
+	// the first attempt is still blocked.
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&client.done) >= 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.GreaterOrEqual(t, atomic.LoadInt32(&client.attempts), int32(2))
+	require.LessOrEqual(t, atomic.LoadInt32(&client.attempts), int32(secondaryCommitMaxWaves))
+}
+
+// delayCommitClient delays every commit request and counts them.
+type delayCommitClient struct {
+	Client
+	delay   time.Duration
+	commits int32
+}
+
+func (c *delayCommitClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdCommit {
+		atomic.AddInt32(&c.commits, 1)
+		time.Sleep(c.delay)
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestDynamicSecondaryCommitQueuedBatches(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	var splitKeys [][]byte
+	for c := byte('b'); c <= 'j'; c++ {
+		splitKeys = append(splitKeys, []byte{c})
+	}
+	mocktikv.BootstrapWithMultiRegions(cluster, splitKeys...)
+	client := &delayCommitClient{
+		Client: mocktikv.NewRPCClient(cluster, mvccStore, nil),
+		delay:  20 * time.Millisecond,
+	}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	// The deadline is far below the latency of every batch, but the batches
+	// waiting for the only worker are not sent again, and the batch in flight
+	// is sent again only after the others.
+	store.EnableDynamicSecondaryCommit(1)
+	for i := 0; i < secondaryCommitMinSamples; i++ {
+		store.secondaryBatcher.observe(time.Millisecond)
+	}
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("a"), []byte("v")))
+	for _, key := range splitKeys {
+		require.Nil(t, txn.Set(key, []byte("v")))
+	}
+	require.Nil(t, txn.Commit(context.Background()))
+	// One for the primary batch and one for each secondary batch.
+	expected := int32(len(splitKeys) + 1)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&client.commits) >= expected
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(5 * client.delay)
+	require.Equal(t, expected, atomic.LoadInt32(&client.commits))
+}
@@ -0,0 +1,201 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pingcap/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+// ReadSet is the set of keys read by a transaction.
+type ReadSet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newReadSet() *ReadSet {
+	return &ReadSet{keys: make(map[string]struct{})}
+}
+
+// Add adds a key to the set.
+func (s *ReadSet) Add(key []byte) {
+	s.mu.Lock()
+	s.keys[string(key)] = struct{}{}
+	s.mu.Unlock()
+}
+
+// Contains returns whether the key is in the set.
+func (s *ReadSet) Contains(key []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.keys[string(key)]
+	return ok
+}
+
+// Keys returns the sorted keys in the set.
+func (s *ReadSet) Keys() [][]byte {
+	s.mu.Lock()
+	keys := make([][]byte, 0, len(s.keys))
+	for k := range s.keys {
+		keys = append(keys, []byte(k))
+	}
+	s.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+	return keys
+}
+
+// Len returns the number of keys in the set.
+func (s *ReadSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys)
+}
+
+func (s *ReadSet) clone() *ReadSet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clone := &ReadSet{keys: make(map[string]struct{}, len(s.keys))}
+	for k := range s.keys {
+		clone.keys[k] = struct{}{}
+	}
+	return clone
+}
+
+// SerializableSnapshot wraps an optimistic transaction to make it serializable.
+// It read locks the keys read through it by KVTxn.ReadLock, so at commit time
+// the keys are prewritten as Op_Lock along with the writes, and the commit
+// fails with ErrSerializableConflict if any of them was written after the
+// transaction's start ts.
+//
+// Only the keys that are read are checked, keys inserted into a scanned range
+// by other transactions are not detected.
+type SerializableSnapshot struct {
+	txn *KVTxn
+}
+
+// NewSerializableSnapshot creates a SerializableSnapshot on the transaction,
+// which must be optimistic because pessimistic locks check conflicts against
+// the for update ts instead of the start ts.
+func NewSerializableSnapshot(txn *KVTxn) (*SerializableSnapshot, error) {
+	if txn.IsPessimistic() {
+		return nil, errors.New("serializable snapshot requires an optimistic transaction")
+	}
+	return &SerializableSnapshot{txn: txn}, nil
+}
+
+// ReadSet returns the keys read by the transaction, including the ones read
+// locked by KVTxn.ReadLock directly.
+func (s *SerializableSnapshot) ReadSet() *ReadSet {
+	return s.txn.readSet
+}
+
+// Get gets the value of the key and adds it to the read set, even if it
+// doesn't exist.
+func (s *SerializableSnapshot) Get(ctx context.Context, k []byte) ([]byte, error) {
+	val, err := s.txn.Get(ctx, k)
+	if err == nil || tikverr.IsErrNotFound(err) {
+		if err := s.txn.ReadLock(ctx, k); err != nil {
+			return nil, err
+		}
+	}
+	return val, err
+}
+
+// BatchGet gets the values of the keys and adds them to the read set.
+func (s *SerializableSnapshot) BatchGet(ctx context.Context, keys [][]byte) (map[string][]byte, error) {
+	m, err := s.txn.BatchGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if err := s.txn.ReadLock(ctx, k); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Iter creates an Iterator which adds the keys it returns to the read set.
+func (s *SerializableSnapshot) Iter(k []byte, upperBound []byte) (Iterator, error) {
+	it, err := s.txn.Iter(k, upperBound)
+	if err != nil {
+		return nil, err
+	}
+	return newReadSetIter(it, s.txn)
+}
+
+// IterReverse creates a reversed Iterator which adds the keys it returns to
+// the read set.
+func (s *SerializableSnapshot) IterReverse(k []byte) (Iterator, error) {
+	it, err := s.txn.IterReverse(k)
+	if err != nil {
+		return nil, err
+	}
+	return newReadSetIter(it, s.txn)
+}
+
+// Set sets the value of the key.
+func (s *SerializableSnapshot) Set(k []byte, v []byte) error {
+	return s.txn.Set(k, v)
+}
+
+// Delete deletes the key.
+func (s *SerializableSnapshot) Delete(k []byte) error {
+	return s.txn.Delete(k)
+}
+
+// Rollback rolls back the transaction.
+func (s *SerializableSnapshot) Rollback() error {
+	return s.txn.Rollback()
+}
+
+// Commit commits the transaction after verifying that no key in the read set
+// was written after the start ts. If some were, the transaction is rolled back
+// and an ErrSerializableConflict is returned.
+func (s *SerializableSnapshot) Commit(ctx context.Context) error {
+	err := s.txn.Commit(ctx)
+	if e, ok := errors.Cause(err).(*tikverr.ErrReadWriteConflict); ok {
+		return errors.Trace(&tikverr.ErrSerializableConflict{StartTS: s.txn.StartTS(), Keys: [][]byte{e.Conflict.Key}, Conflict: e})
+	}
+	return err
+}
+
+type readSetIter struct {
+	Iterator
+	txn *KVTxn
+}
+
+func newReadSetIter(it Iterator, txn *KVTxn) (*readSetIter, error) {
+	if it.Valid() {
+		if err := txn.ReadLock(context.Background(), it.Key()); err != nil {
+			it.Close()
+			return nil, err
+		}
+	}
+	return &readSetIter{Iterator: it, txn: txn}, nil
+}
+
+func (it *readSetIter) Next() error {
+	if err := it.Iterator.Next(); err != nil {
+		return err
+	}
+	if it.Valid() {
+		return it.txn.ReadLock(context.Background(), it.Key())
+	}
+	return nil
+}
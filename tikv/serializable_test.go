@@ -0,0 +1,89 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+)
+
+func TestSerializableSnapshot(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	begin := func() *SerializableSnapshot {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		s, err := NewSerializableSnapshot(txn)
+		require.Nil(t, err)
+		return s
+	}
+	write := func(key string) {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		require.Nil(t, txn.Set([]byte(key), []byte(key)))
+		require.Nil(t, txn.Commit(ctx))
+	}
+
+	// The key read by s1 is written by another transaction.
+	s1 := begin()
+	_, err = s1.Get(ctx, []byte("a"))
+	require.True(t, tikverr.IsErrNotFound(err))
+	write("a")
+	require.Nil(t, s1.Set([]byte("b"), []byte("b")))
+	err = s1.Commit(ctx)
+	require.True(t, tikverr.Is(err, &tikverr.ErrSerializableConflict{}), "%v", err)
+	require.True(t, tikverr.Is(err, &tikverr.ErrReadWriteConflict{}), "%v", err)
+	conflict, ok := errors.Cause(err).(*tikverr.ErrSerializableConflict)
+	require.True(t, ok)
+	require.Equal(t, [][]byte{[]byte("a")}, conflict.Keys)
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	_, err = txn.Get(ctx, []byte("b"))
+	require.True(t, tikverr.IsErrNotFound(err))
+
+	// Writes to keys not read don't conflict.
+	s2 := begin()
+	it, err := s2.Iter([]byte("a"), nil)
+	require.Nil(t, err)
+	for it.Valid() {
+		require.Nil(t, it.Next())
+	}
+	it.Close()
+	require.Equal(t, [][]byte{[]byte("a")}, s2.ReadSet().Keys())
+	write("c")
+	require.Nil(t, s2.Set([]byte("b"), []byte("b")))
+	require.Nil(t, s2.Commit(ctx))
+
+	// The keys read locked on the transaction directly share the read set.
+	s3 := begin()
+	require.Nil(t, s3.txn.ReadLock(ctx, []byte("c")))
+	_, err = s3.Get(ctx, []byte("b"))
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("b"), []byte("c")}, s3.ReadSet().Keys())
+	write("c")
+	err = s3.Commit(ctx)
+	require.True(t, tikverr.Is(err, &tikverr.ErrSerializableConflict{}), "%v", err)
+}
@@ -130,6 +130,10 @@ type KVSnapshot struct {
 	sampleStep uint32
 	// resourceGroupTag is use to set the kv request resource group tag.
 	resourceGroupTag []byte
+	// encryption is used to decrypt the values read from TiKV.
+	encryption EncryptionProvider
+	// decompressor is used to decompress the values read from TiKV.
+	decompressor ValueDecompressor
 }
 
 // newTiKVSnapshot creates a snapshot of an TiKV store.
@@ -192,6 +196,9 @@ func (s *KVSnapshot) BatchGet(ctx context.Context, keys [][]byte) (map[string][]
 	s.mu.RUnlock()
 
 	if len(keys) == 0 {
+		if err := s.decodeValues(m); err != nil {
+			return nil, errors.Trace(err)
+		}
 		return m, nil
 	}
 
@@ -245,6 +252,9 @@ func (s *KVSnapshot) BatchGet(ctx context.Context, keys [][]byte) (map[string][]
 	}
 	s.mu.Unlock()
 
+	if err := s.decodeValues(m); err != nil {
+		return nil, errors.Trace(err)
+	}
 	return m, nil
 }
 
@@ -472,9 +482,130 @@ func (s *KVSnapshot) Get(ctx context.Context, k []byte) ([]byte, error) {
 	if len(val) == 0 {
 		return nil, tikverr.ErrNotExist
 	}
+	val, err = s.decodeValue(val)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return val, nil
+}
+
+// Exists checks whether the key k exists in the snapshot without fetching its
+// value. GetRequest can't omit the value, so it scans [k, k.Next()) with the
+// KeyOnly option, which returns the key alone. The cached values of the
+// snapshot are checked first.
+func (s *KVSnapshot) Exists(ctx context.Context, k []byte) (bool, error) {
+	s.mu.RLock()
+	if s.mu.cached != nil {
+		if value, ok := s.mu.cached[string(k)]; ok {
+			atomic.AddInt64(&s.mu.hitCnt, 1)
+			s.mu.RUnlock()
+			return len(value) > 0, nil
+		}
+	}
+	s.mu.RUnlock()
+
+	scanner, err := newKeyOnlyScanner(ctx, s, k, kv.NextKey(k), 2)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	defer scanner.Close()
+	return scanner.Valid() && bytes.Equal(scanner.Key(), k), nil
+}
+
+// fastGetTimeout is the timeout of the request sent by FastGet, it's short as
+// the request falls back to Get on timeout.
+const fastGetTimeout = 500 * time.Millisecond
+
+// FastGet gets the value for key k like Get, but if the region of the key is
+// cached, it sends the request to the cached leader directly through the
+// connection pool of the client, skipping the region request sender with its
+// replica selection and retries. Any error, region error or lock met on the
+// fast path falls back to Get, which handles it. The send failures and the
+// stale regions are reported to the region cache first.
+//
+// Replica reads, stale reads and reads matching store labels always use Get.
+func (s *KVSnapshot) FastGet(ctx context.Context, k []byte) ([]byte, error) {
+	start := time.Now()
+	val, ok := s.tryFastGet(ctx, k)
+	if !ok {
+		return s.Get(ctx, k)
+	}
+	defer func() {
+		metrics.TxnCmdHistogramWithGet.Observe(time.Since(start).Seconds())
+	}()
+	if err := s.store.CheckVisibility(s.version); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(val) == 0 {
+		return nil, tikverr.ErrNotExist
+	}
+	val, err := s.decodeValue(val)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	return val, nil
 }
 
+func (s *KVSnapshot) tryFastGet(ctx context.Context, k []byte) ([]byte, bool) {
+	s.mu.RLock()
+	if value, ok := s.mu.cached[string(k)]; ok {
+		atomic.AddInt64(&s.mu.hitCnt, 1)
+		s.mu.RUnlock()
+		return value, true
+	}
+	if s.mu.replicaRead != kv.ReplicaReadLeader || s.mu.isStaleness || len(s.mu.matchStoreLabels) > 0 {
+		s.mu.RUnlock()
+		return nil, false
+	}
+	req := tikvrpc.NewRequest(tikvrpc.CmdGet,
+		&kvrpcpb.GetRequest{
+			Key:     k,
+			Version: s.version,
+		}, kvrpcpb.Context{
+			Priority:         s.priority.ToPB(),
+			NotFillCache:     s.notFillCache,
+			TaskId:           s.mu.taskID,
+			ResourceGroupTag: s.resourceGroupTag,
+		})
+	req.TxnScope = s.mu.txnScope
+	s.mu.RUnlock()
+
+	loc := s.store.regionCache.TryLocateKey(k)
+	if loc == nil {
+		return nil, false
+	}
+	bo := retry.NewNoopBackoff(ctx)
+	rpcCtx, err := s.store.regionCache.GetTiKVRPCContext(bo, loc.Region, kv.ReplicaReadLeader, 0)
+	if err != nil || rpcCtx == nil || rpcCtx.ProxyStore != nil {
+		return nil, false
+	}
+	if err = tikvrpc.SetContext(req, rpcCtx.Meta, rpcCtx.Peer); err != nil {
+		return nil, false
+	}
+	resp, err := s.store.GetTiKVClient().SendRequest(ctx, rpcCtx.Addr, req, fastGetTimeout)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.store.regionCache.OnSendFail(bo, rpcCtx, false, err)
+		}
+		return nil, false
+	}
+	if resp.Resp == nil {
+		return nil, false
+	}
+	getResp, ok := resp.Resp.(*kvrpcpb.GetResponse)
+	if !ok || getResp.GetError() != nil {
+		return nil, false
+	}
+	if regionErr := getResp.GetRegionError(); regionErr != nil {
+		if regionErr.GetNotLeader() != nil || regionErr.GetEpochNotMatch() != nil ||
+			regionErr.GetRegionNotFound() != nil || regionErr.GetKeyNotInRegion() != nil {
+			s.store.regionCache.InvalidateCachedRegion(loc.Region)
+		}
+		return nil, false
+	}
+	return getResp.GetValue(), true
+}
+
 func (s *KVSnapshot) get(ctx context.Context, bo *Backoffer, k []byte) ([]byte, error) {
 	// Check the cached values first.
 	s.mu.RLock()
@@ -615,12 +746,17 @@ func (s *KVSnapshot) mergeExecDetail(detail *kvrpcpb.ExecDetailsV2) {
 }
 
 // Iter return a list of key-value pair after `k`.
+//
+// Deleted keys are never returned. It also applies to keys locked by a
+// transaction that deletes them: the lock is resolved and the key is skipped
+// if the delete turns out to be committed before the snapshot.
 func (s *KVSnapshot) Iter(k []byte, upperBound []byte) (Iterator, error) {
 	scanner, err := newScanner(s, k, upperBound, s.scanBatchSize, false)
 	return scanner, errors.Trace(err)
 }
 
 // IterReverse creates a reversed Iterator positioned on the first entry which key is less than k.
+// Deleted keys are skipped like Iter.
 func (s *KVSnapshot) IterReverse(k []byte) (Iterator, error) {
 	scanner, err := newScanner(s, nil, k, s.scanBatchSize, true)
 	return scanner, errors.Trace(err)
@@ -706,6 +842,52 @@ func (s *KVSnapshot) SetResourceGroupTag(tag []byte) {
 	s.resourceGroupTag = tag
 }
 
+// SetEncryption sets the provider to decrypt the values read from TiKV. The
+// values cached by the snapshot are kept encrypted.
+func (s *KVSnapshot) SetEncryption(ep EncryptionProvider) {
+	s.encryption = ep
+}
+
+// SetValueDecompressor sets the decompressor of the values read from TiKV.
+// The values cached by the snapshot are kept compressed.
+func (s *KVSnapshot) SetValueDecompressor(d ValueDecompressor) {
+	s.decompressor = d
+}
+
+// decodeValue decrypts and decompresses a value read from TiKV. Empty values
+// stand for not exist and are returned as is.
+func (s *KVSnapshot) decodeValue(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	var err error
+	if s.encryption != nil {
+		if value, err = decryptValue(s.encryption, value); err != nil {
+			return nil, err
+		}
+	}
+	if s.decompressor != nil {
+		if value, err = decompressValue(s.decompressor, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func (s *KVSnapshot) decodeValues(m map[string][]byte) error {
+	if s.encryption == nil && s.decompressor == nil {
+		return nil
+	}
+	for k, v := range m {
+		value, err := s.decodeValue(v)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		m[k] = value
+	}
+	return nil
+}
+
 // SnapCacheHitCount gets the snapshot cache hit count. Only for test.
 func (s *KVSnapshot) SnapCacheHitCount() int {
 	return int(atomic.LoadInt64(&s.mu.hitCnt))
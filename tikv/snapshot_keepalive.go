@@ -0,0 +1,94 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/metrics"
+	"go.uber.org/zap"
+)
+
+// snapshotKeepAliveProcessID tells the service GC safe points of the
+// processes on the same cluster apart, since the counter starts from 1 in
+// every process.
+var snapshotKeepAliveProcessID = uuid.New().String()
+
+var snapshotKeepAliveID uint64
+
+// SnapshotKeepAlive keeps the data read by snap from being garbage collected.
+// It registers the startTS of snap as a service GC safe point in PD and
+// refreshes it every interval with a TTL of 3 intervals, so the GC safe point
+// can't advance past the snapshot while it's kept alive, and it's released
+// automatically if the process exits. It's meant for long-running scans.
+//
+// The returned function stops the keepalive and removes the service GC safe
+// point. It must be called when the snapshot is no longer read.
+func SnapshotKeepAlive(ctx context.Context, snap *KVSnapshot, interval time.Duration) func() {
+	pdClient := snap.store.GetPDClient()
+	serviceID := fmt.Sprintf("snapshot_keepalive_%s_%s_%d", snap.store.UUID(), snapshotKeepAliveProcessID, atomic.AddUint64(&snapshotKeepAliveID, 1))
+	ttl := int64(3 * interval / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	update := func() {
+		minSafePoint, err := pdClient.UpdateServiceGCSafePoint(ctx, serviceID, ttl, snap.version)
+		if err != nil {
+			metrics.TiKVSnapshotKeepAliveCounter.WithLabelValues("err").Inc()
+			logutil.Logger(ctx).Warn("update service GC safe point for snapshot failed",
+				zap.String("serviceID", serviceID), zap.Uint64("startTS", snap.version), zap.Error(err))
+			return
+		}
+		metrics.TiKVSnapshotKeepAliveCounter.WithLabelValues("ok").Inc()
+		if minSafePoint > snap.version {
+			logutil.Logger(ctx).Warn("GC safe point has passed the snapshot",
+				zap.String("serviceID", serviceID), zap.Uint64("startTS", snap.version), zap.Uint64("minSafePoint", minSafePoint))
+		}
+	}
+	update()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				update()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+			if _, err := pdClient.UpdateServiceGCSafePoint(context.Background(), serviceID, 0, snap.version); err != nil {
+				logutil.BgLogger().Warn("remove service GC safe point for snapshot failed",
+					zap.String("serviceID", serviceID), zap.Error(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"time"
+
+	"github.com/pingcap/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/oracle"
+)
+
+// SnapshotOption configures a snapshot created by KVStore.GetSnapshotWithOptions.
+type SnapshotOption func(*snapshotOptions)
+
+type snapshotOptions struct {
+	txnScope  string
+	staleness time.Duration
+}
+
+// WithStaleness makes the snapshot a stale read at the current timestamp minus
+// the staleness. The reads can be served by any replica whose data is up to the
+// timestamp.
+func WithStaleness(d time.Duration) SnapshotOption {
+	return func(o *snapshotOptions) {
+		o.staleness = d
+	}
+}
+
+// WithSnapshotTxnScope sets the scope of the timestamp of the snapshot.
+func WithSnapshotTxnScope(txnScope string) SnapshotOption {
+	return func(o *snapshotOptions) {
+		o.txnScope = txnScope
+	}
+}
+
+// GetSnapshotWithOptions gets a snapshot at the current timestamp configured by
+// the options.
+//
+// For a stale read, the timestamp must be after the GC safe point, otherwise
+// ErrStalenessTooLarge is returned instead of reading at a timestamp later
+// than the requested one.
+func (s *KVStore) GetSnapshotWithOptions(opts ...SnapshotOption) (*KVSnapshot, error) {
+	options := snapshotOptions{txnScope: oracle.GlobalTxnScope}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	ts, err := s.CurrentTimestamp(options.txnScope)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if options.staleness <= 0 {
+		snapshot := s.GetSnapshot(ts)
+		snapshot.SetTxnScope(options.txnScope)
+		return snapshot, nil
+	}
+
+	readTS := oracle.GoTimeToTS(oracle.GetTimeFromTS(ts).Add(-options.staleness))
+	s.spMutex.RLock()
+	safePoint := s.safePoint
+	s.spMutex.RUnlock()
+	if readTS <= safePoint {
+		return nil, &tikverr.ErrStalenessTooLarge{Staleness: options.staleness, ReadTS: readTS, GCSafePoint: safePoint}
+	}
+	snapshot := s.GetSnapshot(readTS)
+	snapshot.SetTxnScope(options.txnScope)
+	snapshot.SetIsStatenessReadOnly(true)
+	return snapshot, nil
+}
@@ -0,0 +1,271 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+func newSnapshotTestStore(t testing.TB) *KVStore {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	return store
+}
+
+func TestFastGet(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	require.Nil(t, txn.Commit(ctx))
+
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	snapshot := store.GetSnapshot(ts)
+
+	// The region is not cached, the regular path is used.
+	store.regionCache.InvalidateCachedRegion(store.regionCache.TryLocateKey([]byte("a")).Region)
+	_, ok := snapshot.tryFastGet(ctx, []byte("a"))
+	require.False(t, ok)
+	val, err := snapshot.FastGet(ctx, []byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), val)
+
+	_, ok = snapshot.tryFastGet(ctx, []byte("a"))
+	require.True(t, ok)
+	val, err = snapshot.FastGet(ctx, []byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), val)
+	_, err = snapshot.FastGet(ctx, []byte("b"))
+	require.True(t, tikverr.IsErrNotFound(err))
+}
+
+func TestFastGetStaleRegion(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	store, err := NewTestTiKVStore(mocktikv.NewRPCClient(cluster, mvccStore, nil), mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	snapshot := store.GetSnapshot(ts)
+	_, err = snapshot.FastGet(ctx, []byte("a"))
+	require.True(t, tikverr.IsErrNotFound(err))
+	loc := store.regionCache.TryLocateKey([]byte("a"))
+	require.NotNil(t, loc)
+
+	// The epoch not match error invalidates the cached region.
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("b"), []uint64{ids[1]}, ids[1])
+	_, ok := snapshot.tryFastGet(ctx, []byte("a"))
+	require.False(t, ok)
+	require.Nil(t, store.regionCache.TryLocateKey([]byte("a")))
+	_, err = snapshot.FastGet(ctx, []byte("a"))
+	require.True(t, tikverr.IsErrNotFound(err))
+	require.NotEqual(t, loc.Region, store.regionCache.TryLocateKey([]byte("a")).Region)
+}
+
+func TestGetSnapshotWithStaleness(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	require.Nil(t, txn.Commit(ctx))
+
+	snapshot, err := store.GetSnapshotWithOptions()
+	require.Nil(t, err)
+	val, err := snapshot.Get(ctx, []byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), val)
+
+	snapshot, err = store.GetSnapshotWithOptions(WithStaleness(time.Hour))
+	require.Nil(t, err)
+	require.True(t, snapshot.mu.isStaleness)
+	require.Less(t, snapshot.version, txn.StartTS())
+	_, err = snapshot.Get(ctx, []byte("a"))
+	require.True(t, tikverr.IsErrNotFound(err))
+
+	store.UpdateSPCache(oracle.GoTimeToTS(time.Now().Add(-30*time.Minute)), time.Now())
+	_, err = store.GetSnapshotWithOptions(WithStaleness(time.Hour))
+	require.ErrorIs(t, err, &tikverr.ErrStalenessTooLarge{})
+	_, err = store.GetSnapshotWithOptions(WithStaleness(time.Minute))
+	require.Nil(t, err)
+}
+
+func benchmarkGet(b *testing.B, fast bool) {
+	store := newSnapshotTestStore(b)
+	defer store.Close()
+	ctx := context.Background()
+	txn, err := store.Begin()
+	require.Nil(b, err)
+	require.Nil(b, txn.Set([]byte("a"), []byte("1")))
+	require.Nil(b, txn.Commit(ctx))
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(b, err)
+	snapshot := store.GetSnapshot(ts)
+	_, err = snapshot.Get(ctx, []byte("a"))
+	require.Nil(b, err)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if fast {
+			_, err = snapshot.FastGet(ctx, []byte("a"))
+		} else {
+			_, err = snapshot.Get(ctx, []byte("a"))
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSnapshotGet(b *testing.B) {
+	benchmarkGet(b, false)
+}
+
+func BenchmarkSnapshotFastGet(b *testing.B) {
+	benchmarkGet(b, true)
+}
+
+func TestSnapshotKeepAlive(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+	pdClient := store.GetPDClient()
+
+	snapshot := store.GetSnapshot(100)
+	stop := SnapshotKeepAlive(ctx, snapshot, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	minSafePoint, err := pdClient.UpdateServiceGCSafePoint(ctx, "gc_worker", 10, 200)
+	require.Nil(t, err)
+	require.Equal(t, uint64(100), minSafePoint)
+
+	stop()
+	stop()
+	minSafePoint, err = pdClient.UpdateServiceGCSafePoint(ctx, "gc_worker", 10, 200)
+	require.Nil(t, err)
+	require.Equal(t, uint64(200), minSafePoint)
+
+	// The keepalive of another process on the same cluster, which counts from
+	// 1 as well, is not released by the stop.
+	other := fmt.Sprintf("snapshot_keepalive_%s_%d", store.UUID(), atomic.LoadUint64(&snapshotKeepAliveID)+1)
+	_, err = pdClient.UpdateServiceGCSafePoint(ctx, other, 10, 210)
+	require.Nil(t, err)
+	SnapshotKeepAlive(ctx, store.GetSnapshot(220), time.Second)()
+	minSafePoint, err = pdClient.UpdateServiceGCSafePoint(ctx, "gc_worker", 10, 300)
+	require.Nil(t, err)
+	require.Equal(t, uint64(210), minSafePoint)
+}
+
+// keyOnlyScanClient drops the values of the key only scans like TiKV, and
+// counts the requests by type.
+type keyOnlyScanClient struct {
+	Client
+	keyOnlyScans int
+	gets         int
+}
+
+func (c *keyOnlyScanClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	resp, err := c.Client.SendRequest(ctx, addr, req, timeout)
+	switch req.Type {
+	case tikvrpc.CmdGet:
+		c.gets++
+	case tikvrpc.CmdScan:
+		if req.Scan().KeyOnly && err == nil {
+			c.keyOnlyScans++
+			for _, pair := range resp.Resp.(*kvrpcpb.ScanResponse).Pairs {
+				pair.Value = nil
+			}
+		}
+	}
+	return resp, err
+}
+
+func TestSnapshotExists(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := &keyOnlyScanClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k1"), []byte("v1")))
+	require.Nil(t, txn.Set([]byte("k10"), []byte("v10")))
+	require.Nil(t, txn.Set([]byte("k2"), []byte("v2")))
+	require.Nil(t, txn.Commit(ctx))
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Delete([]byte("k2")))
+	require.Nil(t, txn.Commit(ctx))
+
+	snapshot := store.GetSnapshot(math.MaxUint64)
+	for key, exists := range map[string]bool{"k": false, "k1": true, "k10": true, "k2": false, "k3": false} {
+		ok, err := snapshot.Exists(ctx, []byte(key))
+		require.Nil(t, err)
+		require.Equal(t, exists, ok, key)
+	}
+	require.Equal(t, 5, client.keyOnlyScans)
+	require.Equal(t, 0, client.gets)
+
+	// The scan is sent with the context of Exists.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = snapshot.Exists(canceled, []byte("k3"))
+	require.Equal(t, context.Canceled, errors.Cause(err))
+}
+
+func TestSnapshotAdaptiveTimeout(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	policy := NewAdaptiveTimeoutPolicy(0, time.Second)
+	store.SetAdaptiveTimeout(policy)
+
+	// The latencies of the reads of the snapshot are fed to the policy.
+	snapshot := store.GetSnapshot(math.MaxUint64)
+	for i := 0; i < 100; i++ {
+		_, err := snapshot.Get(context.Background(), []byte(fmt.Sprintf("k%d", i)))
+		require.Equal(t, tikverr.ErrNotExist, err)
+	}
+	require.NotEqual(t, time.Hour, policy.Timeout(tikvrpc.CmdGet, time.Hour))
+}
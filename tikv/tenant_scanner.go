@@ -0,0 +1,94 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/client-go/v2/kv"
+)
+
+// KVPair is a key-value pair.
+type KVPair struct {
+	Key   []byte
+	Value []byte
+}
+
+// TenantScanner scans the keys of a tenant, which are the keys with the
+// tenant's prefix, in a snapshot.
+type TenantScanner struct {
+	snapshot *KVSnapshot
+	prefix   []byte
+	// end is the exclusive upper bound of the keys with prefix, nil means
+	// unbounded.
+	end []byte
+}
+
+// NewTenantScanner creates a TenantScanner for the tenant with prefix. The
+// prefix must not be empty.
+func NewTenantScanner(snapshot *KVSnapshot, prefix []byte) (*TenantScanner, error) {
+	if len(prefix) == 0 {
+		return nil, errors.New("tenant prefix is empty")
+	}
+	return &TenantScanner{
+		snapshot: snapshot,
+		prefix:   prefix,
+		end:      kv.PrefixNextKey(prefix),
+	}, nil
+}
+
+// ScanWithCursor returns at most limit pairs of the tenant after the cursor.
+// An empty cursor starts from the first key of the tenant. The returned cursor
+// is used to scan the next page, it's nil if there are no more keys.
+//
+// The cursor is the last returned key without the tenant prefix, the prefix is
+// added back when the cursor is used, so no cursor can position the scan out of
+// the tenant's keys.
+func (s *TenantScanner) ScanWithCursor(ctx context.Context, cursor []byte, limit int) (pairs []KVPair, nextCursor []byte, err error) {
+	if limit <= 0 {
+		return nil, nil, errors.Errorf("invalid scan limit %d", limit)
+	}
+	start := s.prefix
+	if len(cursor) > 0 {
+		start = make([]byte, 0, len(s.prefix)+len(cursor)+1)
+		start = append(start, s.prefix...)
+		start = append(start, cursor...)
+		start = append(start, 0)
+	}
+	batchSize := limit
+	if batchSize > s.snapshot.scanBatchSize {
+		batchSize = s.snapshot.scanBatchSize
+	}
+	it, err := newScanner(s.snapshot, start, s.end, batchSize, false)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer it.Close()
+
+	for it.Valid() && len(pairs) < limit {
+		if err = ctx.Err(); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		pairs = append(pairs, KVPair{Key: it.Key(), Value: it.Value()})
+		if err = it.Next(); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+	}
+	if !it.Valid() || len(pairs) == 0 {
+		return pairs, nil, nil
+	}
+	lastKey := pairs[len(pairs)-1].Key
+	return pairs, append([]byte{}, lastKey[len(s.prefix):]...), nil
+}
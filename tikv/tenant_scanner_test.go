@@ -0,0 +1,71 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/oracle"
+)
+
+func TestTenantScanner(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	for _, k := range []string{"t0/a", "t1", "t1/a", "t1/b", "t1/c", "t2/a"} {
+		require.Nil(t, txn.Set([]byte(k), []byte(k)))
+	}
+	require.Nil(t, txn.Commit(ctx))
+
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	scanner, err := NewTenantScanner(store.GetSnapshot(ts), []byte("t1"))
+	require.Nil(t, err)
+
+	var keys []string
+	var cursor []byte
+	for {
+		pairs, next, err := scanner.ScanWithCursor(ctx, cursor, 2)
+		require.Nil(t, err)
+		for _, p := range pairs {
+			keys = append(keys, string(p.Key))
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	require.Equal(t, []string{"t1", "t1/a", "t1/b", "t1/c"}, keys)
+
+	// A manipulated cursor can't move the scan out of the tenant.
+	pairs, next, err := scanner.ScanWithCursor(ctx, []byte("\xff\xff"), 10)
+	require.Nil(t, err)
+	require.Empty(t, pairs)
+	require.Nil(t, next)
+	pairs, _, err = scanner.ScanWithCursor(ctx, []byte("/b"), 10)
+	require.Nil(t, err)
+	require.Equal(t, []KVPair{{Key: []byte("t1/c"), Value: []byte("t1/c")}}, pairs)
+}
@@ -91,7 +91,7 @@ func (s StoreProbe) ClearTxnLatches() {
 // SendTxnHeartbeat renews a txn's ttl.
 func (s StoreProbe) SendTxnHeartbeat(ctx context.Context, key []byte, startTS uint64, ttl uint64) (uint64, error) {
 	bo := retry.NewBackofferWithVars(ctx, PrewriteMaxBackoff, nil)
-	newTTL, _, err := sendTxnHeartBeat(bo, s.KVStore, key, startTS, ttl)
+	newTTL, _, err := sendTxnHeartBeat(bo, s.KVStore, key, startTS, ttl, nil)
 	return newTTL, err
 }
 
@@ -571,6 +571,16 @@ func (c ConfigProbe) StorePreSplitSizeThreshold(v uint32) {
 	atomic.StoreUint32(&preSplitSizeThreshold, v)
 }
 
+// LoadRegionEstimateSampleStep returns the sample step used to estimate region count.
+func (c ConfigProbe) LoadRegionEstimateSampleStep() uint32 {
+	return atomic.LoadUint32(&regionEstimateSampleStep)
+}
+
+// StoreRegionEstimateSampleStep updates the sample step used to estimate region count.
+func (c ConfigProbe) StoreRegionEstimateSampleStep(v uint32) {
+	atomic.StoreUint32(&regionEstimateSampleStep, v)
+}
+
 // SetOracleUpdateInterval sets the interval of updating cached ts.
 func (c ConfigProbe) SetOracleUpdateInterval(v int) {
 	oracleUpdateInterval = v
@@ -0,0 +1,53 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+var commitTracer atomic.Value
+
+type tracerHolder struct {
+	tracer opentracing.Tracer
+}
+
+// SetCommitTracer makes KVTxn.Commit start a root span with tracer when the
+// context doesn't carry a span, so the spans of the committer, such as
+// prewriteMutations and commitMutations, are recorded for every transaction.
+// Pass nil to disable it.
+//
+// EnableOTLPTracing sets the tracer of an OTLPTraceExporter to export the spans
+// by OTLP.
+func SetCommitTracer(tracer opentracing.Tracer) {
+	commitTracer.Store(tracerHolder{tracer: tracer})
+}
+
+// startCommitSpan starts the span named operationName as a child of the span in
+// ctx, or as a root span of the commit tracer if ctx has no span. It returns
+// nil if neither exists.
+func startCommitSpan(ctx context.Context, operationName string) (opentracing.Span, context.Context) {
+	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
+		span1 := span.Tracer().StartSpan(operationName, opentracing.ChildOf(span.Context()))
+		return span1, opentracing.ContextWithSpan(ctx, span1)
+	}
+	if h, ok := commitTracer.Load().(tracerHolder); ok && h.tracer != nil {
+		span := h.tracer.StartSpan(operationName)
+		return span, opentracing.ContextWithSpan(ctx, span)
+	}
+	return nil, ctx
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestCommitTracer(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	tracer := mocktracer.New()
+	SetCommitTracer(tracer)
+	defer SetCommitTracer(nil)
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k"), []byte("v")))
+	require.Nil(t, txn.Commit(context.Background()))
+
+	spans := make(map[string]*mocktracer.MockSpan)
+	for _, span := range tracer.FinishedSpans() {
+		spans[span.OperationName] = span
+	}
+	root := spans["tikvTxn.Commit"]
+	require.NotNil(t, root)
+	require.Equal(t, 0, root.ParentID)
+	for _, name := range []string{"twoPhaseCommitter.prewriteMutations", "twoPhaseCommitter.commitMutations"} {
+		require.NotNil(t, spans[name], name)
+		require.Equal(t, root.SpanContext.TraceID, spans[name].SpanContext.TraceID)
+	}
+
+	SetCommitTracer(nil)
+	tracer.Reset()
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k"), []byte("v2")))
+	require.Nil(t, txn.Commit(context.Background()))
+	require.Len(t, tracer.FinishedSpans(), 0)
+}
+
+// rawMessage keeps the encoded message as it is.
+type rawMessage struct {
+	data []byte
+}
+
+func (m *rawMessage) Reset()                   {}
+func (m *rawMessage) String() string           { return "raw" }
+func (m *rawMessage) ProtoMessage()            {}
+func (m *rawMessage) Marshal() ([]byte, error) { return m.data, nil }
+func (m *rawMessage) Unmarshal(data []byte) error {
+	m.data = append([]byte(nil), data...)
+	return nil
+}
+
+func TestOTLPTraceExporter(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	var (
+		mu       sync.Mutex
+		methods  []string
+		requests [][]byte
+	)
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		var req rawMessage
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		method, _ := grpc.MethodFromServerStream(stream)
+		mu.Lock()
+		methods = append(methods, method)
+		requests = append(requests, req.data)
+		mu.Unlock()
+		return stream.SendMsg(&rawMessage{})
+	}))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://"+lis.Addr().String())
+	os.Setenv("OTEL_SERVICE_NAME", "otlp-test")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	defer os.Unsetenv("OTEL_SERVICE_NAME")
+	exporter, err := EnableOTLPTracing()
+	require.Nil(t, err)
+	defer SetCommitTracer(nil)
+
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k"), []byte("v")))
+	require.Nil(t, txn.Commit(context.Background()))
+	require.Nil(t, exporter.Shutdown(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"/opentelemetry.proto.collector.trace.v1.TraceService/Export"}, methods)
+	for _, s := range []string{"otlp-test", "tikvTxn.Commit", "twoPhaseCommitter.prewriteMutations", "twoPhaseCommitter.commitMutations"} {
+		require.True(t, bytes.Contains(requests[0], []byte(s)), s)
+	}
+
+	// The spans finished after shutdown are dropped.
+	exporter.Tracer().StartSpan("dropped").Finish()
+	require.Len(t, exporter.queue, 0)
+}
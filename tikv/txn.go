@@ -49,7 +49,9 @@ import (
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/internal/client"
 	"github.com/tikv/client-go/v2/internal/logutil"
 	"github.com/tikv/client-go/v2/internal/retry"
 	"github.com/tikv/client-go/v2/internal/unionstore"
@@ -96,6 +98,115 @@ func (to StartTSOption) SetTxnScope(txnScope string) StartTSOption {
 	return to
 }
 
+// SyncLogMode controls whether TiKV is asked to sync its raft log to disk
+// before responding to the write requests of a transaction.
+type SyncLogMode int
+
+const (
+	// SyncLogDefault leaves the decision to the raftstore configuration of TiKV.
+	SyncLogDefault SyncLogMode = iota
+	// SyncLogAlways asks TiKV to sync the log for every write request of the transaction.
+	SyncLogAlways
+	// SyncLogNever never asks TiKV to sync the log, even for the primary key.
+	SyncLogNever
+	// SyncLogAlwaysForPrimary only asks TiKV to sync the log for the batches containing
+	// the primary key. Since the transaction status is decided by the primary key, this
+	// gives a durable commit confirmation without paying the sync cost on secondaries.
+	SyncLogAlwaysForPrimary
+)
+
+// String implements fmt.Stringer interface.
+func (m SyncLogMode) String() string {
+	switch m {
+	case SyncLogDefault:
+		return "default"
+	case SyncLogAlways:
+		return "always"
+	case SyncLogNever:
+		return "never"
+	case SyncLogAlwaysForPrimary:
+		return "always-for-primary"
+	}
+	return fmt.Sprintf("SyncLogMode(%d)", int(m))
+}
+
+// syncLog returns the SyncLog flag of a write request, isPrimary indicates whether
+// the request contains the primary key.
+func (m SyncLogMode) syncLog(isPrimary bool) bool {
+	switch m {
+	case SyncLogAlways:
+		return true
+	case SyncLogAlwaysForPrimary:
+		return isPrimary
+	}
+	return false
+}
+
+// TxnOption configures a transaction when it begins.
+type TxnOption func(*KVTxn)
+
+// WithSyncLog sets the SyncLogMode of the transaction.
+func WithSyncLog(mode SyncLogMode) TxnOption {
+	return func(txn *KVTxn) {
+		txn.syncLogMode = mode
+	}
+}
+
+// WithAsyncCommitStrict makes the transaction fail with ErrAsyncCommitFailed
+// instead of falling back to 2PC when async commit cannot proceed. It's useful
+// when the latency of the extra round trip of 2PC is not acceptable.
+//
+// TiKV returns a zero MinCommitTS and the transaction falls back when the
+// min commit ts cannot be calculated, for example when the start ts is too old
+// compared to the max ts of the region, or when the region is being split or
+// merged.
+func WithAsyncCommitStrict() TxnOption {
+	return func(txn *KVTxn) {
+		txn.SetAsyncCommitStrict(true)
+	}
+}
+
+// WithRequestMetadata makes the committer send md as the gRPC metadata of its
+// requests, such as prewrite, commit, pessimistic lock, heartbeat and
+// rollback, so the gRPC interceptors of TiKV can see the metadata of the
+// caller, such as the audit or tracing context. md is copied.
+func WithRequestMetadata(md map[string]string) TxnOption {
+	return func(txn *KVTxn) {
+		txn.requestMetadata = make(map[string]string, len(md))
+		for k, v := range md {
+			txn.requestMetadata[k] = v
+		}
+	}
+}
+
+// WithPrewritePipelineDepth makes the transaction prewrite at most n region
+// batches at a time, and send the batches in order, each after the previous
+// one is sent. n is still limited by Config.CommitterConcurrency. 0 means the
+// batches are prewritten with the concurrency of Config.CommitterConcurrency
+// and no order.
+func WithPrewritePipelineDepth(n int) TxnOption {
+	return func(txn *KVTxn) {
+		txn.prewritePipelineDepth = n
+	}
+}
+
+// WithMaxCommitTSFromOracle makes the async commit or 1PC of the transaction
+// use a maxCommitTS of the current ts of the oracle plus margin, see
+// KVTxn.SetMaxCommitTSFromOracle.
+func WithMaxCommitTSFromOracle(margin time.Duration) TxnOption {
+	return func(txn *KVTxn) {
+		txn.SetMaxCommitTSFromOracle(margin)
+	}
+}
+
+// WithAutoIsolation makes the transaction commit as read-only if it doesn't
+// write any key, see KVTxn.SetAutoIsolation.
+func WithAutoIsolation() TxnOption {
+	return func(txn *KVTxn) {
+		txn.SetAutoIsolation(true)
+	}
+}
+
 // KVTxn contains methods to interact with a TiKV transaction.
 type KVTxn struct {
 	snapshot  *KVSnapshot
@@ -121,15 +232,44 @@ type KVTxn struct {
 
 	binlog             BinlogExecutor
 	schemaLeaseChecker SchemaLeaseChecker
-	syncLog            bool
+	syncLogMode        SyncLogMode
+	rollbackStrategy   RollbackStrategy
 	priority           Priority
 	isPessimistic      bool
 	enableAsyncCommit  bool
+	asyncCommitStrict  bool
 	enable1PC          bool
 	causalConsistency  bool
 	scope              string
 	kvFilter           KVFilter
 	resourceGroupTag   []byte
+	primaryKeyStrategy PrimaryKeyStrategy
+	encryption         EncryptionProvider
+	compressor         ValueCompressor
+	commitID           []byte
+	leaderHints        map[uint64]*metapb.Peer
+	autoIsolation      bool
+	eventListener      TxnEventListener
+	profile            TxnProfile
+	optimisticRetry    *optimisticRetry
+	// prewriteConfirm is called with the result of a 1PC commit that returned
+	// once the prewrite was sent.
+	prewriteConfirm func(commitTS uint64, err error)
+	// readSet is the keys read locked by ReadLock.
+	readSet *ReadSet
+	// branches is shared with the clones of the transaction.
+	branches *txnBranches
+	// requestMetadata is sent with the requests of the committer.
+	requestMetadata map[string]string
+	// wal journals the committer state, it's nil if the txn is not journaled.
+	wal *TxnWAL
+	// prewritePipelineDepth limits the prewrite batches in flight, 0 means no
+	// limit other than Config.CommitterConcurrency.
+	prewritePipelineDepth int
+	// maxCommitTSMargin is added to a ts of the oracle to get the maxCommitTS
+	// of async commit and 1PC, 0 means the maxCommitTS is decided by the
+	// safe window only.
+	maxCommitTSMargin time.Duration
 }
 
 // ExtractStartTS use `option` to get the proper startTS for a transaction.
@@ -141,7 +281,7 @@ func ExtractStartTS(store *KVStore, option StartTSOption) (uint64, error) {
 	return store.getTimestampWithRetry(bo, option.TxnScope)
 }
 
-func newTiKVTxnWithOptions(store *KVStore, options StartTSOption) (*KVTxn, error) {
+func newTiKVTxnWithOptions(store *KVStore, options StartTSOption, opts ...TxnOption) (*KVTxn, error) {
 	if options.TxnScope == "" {
 		options.TxnScope = oracle.GlobalTxnScope
 	}
@@ -159,6 +299,10 @@ func newTiKVTxnWithOptions(store *KVStore, options StartTSOption) (*KVTxn, error
 		valid:     true,
 		vars:      tikv.DefaultVars,
 		scope:     options.TxnScope,
+		readSet:   newReadSet(),
+	}
+	for _, opt := range opts {
+		opt(newTiKVTxn)
 	}
 	return newTiKVTxn, nil
 }
@@ -186,12 +330,14 @@ func (txn *KVTxn) GetVars() *tikv.Variables {
 func (txn *KVTxn) Get(ctx context.Context, k []byte) ([]byte, error) {
 	ret, err := txn.us.Get(ctx, k)
 	if tikverr.IsErrNotFound(err) {
+		txn.profile.addRead(1, len(k))
 		return nil, err
 	}
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
+	txn.profile.addRead(1, len(k)+len(ret))
 	return ret, nil
 }
 
@@ -199,7 +345,16 @@ func (txn *KVTxn) Get(ctx context.Context, k []byte) ([]byte, error) {
 // Do not use len(value) == 0 or value == nil to represent non-exist.
 // If a key doesn't exist, there shouldn't be any corresponding entry in the result map.
 func (txn *KVTxn) BatchGet(ctx context.Context, keys [][]byte) (map[string][]byte, error) {
-	return NewBufferBatchGetter(txn.GetMemBuffer(), txn.GetSnapshot()).BatchGet(ctx, keys)
+	m, err := NewBufferBatchGetter(txn.GetMemBuffer(), txn.GetSnapshot()).BatchGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	bytes := 0
+	for _, k := range keys {
+		bytes += len(k) + len(m[string(k)])
+	}
+	txn.profile.addRead(len(keys), bytes)
+	return m, nil
 }
 
 // Set sets the value for key k as v into kv store.
@@ -219,12 +374,20 @@ func (txn *KVTxn) String() string {
 // It yields only keys that < upperBound. If upperBound is nil, it means the upperBound is unbounded.
 // The Iterator must be Closed after use.
 func (txn *KVTxn) Iter(k []byte, upperBound []byte) (Iterator, error) {
-	return txn.us.Iter(k, upperBound)
+	it, err := txn.us.Iter(k, upperBound)
+	if err != nil {
+		return nil, err
+	}
+	return newProfiledIter(it, &txn.profile), nil
 }
 
 // IterReverse creates a reversed Iterator positioned on the first entry which key is less than k.
 func (txn *KVTxn) IterReverse(k []byte) (Iterator, error) {
-	return txn.us.IterReverse(k)
+	it, err := txn.us.IterReverse(k)
+	if err != nil {
+		return nil, err
+	}
+	return newProfiledIter(it, &txn.profile), nil
 }
 
 // Delete removes the entry for key k from kv store.
@@ -238,8 +401,44 @@ func (txn *KVTxn) SetSchemaLeaseChecker(checker SchemaLeaseChecker) {
 }
 
 // EnableForceSyncLog indicates tikv to always sync log for the transaction.
+// It is equivalent to SetSyncLogMode(SyncLogAlways).
 func (txn *KVTxn) EnableForceSyncLog() {
-	txn.syncLog = true
+	txn.syncLogMode = SyncLogAlways
+}
+
+// SetSyncLogMode sets the SyncLogMode of the transaction.
+func (txn *KVTxn) SetSyncLogMode(mode SyncLogMode) {
+	txn.syncLogMode = mode
+}
+
+// GetSyncLogMode returns the SyncLogMode of the transaction.
+func (txn *KVTxn) GetSyncLogMode() SyncLogMode {
+	return txn.syncLogMode
+}
+
+// SetPrimaryKeyStrategy sets the strategy to select the primary key if it's not
+// decided before commit. Nil means FirstKeyStrategy.
+func (txn *KVTxn) SetPrimaryKeyStrategy(s PrimaryKeyStrategy) {
+	txn.primaryKeyStrategy = s
+}
+
+// SetEncryption sets the provider to encrypt the written values and decrypt the
+// read values. Nil disables encryption.
+func (txn *KVTxn) SetEncryption(ep EncryptionProvider) {
+	txn.encryption = ep
+	txn.snapshot.SetEncryption(ep)
+}
+
+// SetValueCompression sets the compressor of the written values and the
+// decompressor of the read values. Nil disables compression.
+func (txn *KVTxn) SetValueCompression(c ValueCompressor, d ValueDecompressor) {
+	txn.compressor = c
+	txn.snapshot.SetValueDecompressor(d)
+}
+
+// SetCommitID sets the idempotency key of the transaction, see WithCommitID.
+func (txn *KVTxn) SetCommitID(id []byte) {
+	txn.commitID = id
 }
 
 // SetPessimistic indicates if the transaction should use pessimictic lock.
@@ -275,16 +474,95 @@ func (txn *KVTxn) SetCommitCallback(f func(string, error)) {
 	txn.commitCallback = f
 }
 
+// SetLeaderHint tells the transaction that the leader of the region is being
+// transferred to the peer, e.g. during a rolling upgrade, so the prewrite
+// requests to the region are sent to the peer directly. The hint is ignored if
+// the peer is not a peer of the cached region.
+//
+// It only switches the leader of the region in the region cache of the client
+// before the prewrite, so the other requests of the client to the region are
+// sent to the peer too. Nothing is sent to TiKV and the leader is not
+// transferred: if the peer is not the leader yet, TiKV returns NotLeader and
+// the request is retried on the actual leader.
+func (txn *KVTxn) SetLeaderHint(regionID uint64, leader *metapb.Peer) {
+	if txn.leaderHints == nil {
+		txn.leaderHints = make(map[uint64]*metapb.Peer)
+	}
+	txn.leaderHints[regionID] = leader
+}
+
+// WriteAmplificationStats returns the write amplification of the committed
+// transaction, it's zero if the transaction is not committed.
+func (txn *KVTxn) WriteAmplificationStats() WriteAmplificationStats {
+	if txn.committer == nil {
+		return WriteAmplificationStats{}
+	}
+	return txn.committer.writeAmplificationStats()
+}
+
+// SetAutoIsolation indicates if the transaction commits as read-only when it
+// doesn't write any key. The keys locked by LockKeys are not prewritten and
+// committed then, the pessimistic locks are rolled back instead, so the locks
+// only protect the keys until the transaction commits, and an optimistic
+// transaction doesn't check whether the locked keys are written by others.
+func (txn *KVTxn) SetAutoIsolation(b bool) {
+	txn.autoIsolation = b
+}
+
+// SetEventListener sets the listener notified of the events of the
+// transaction.
+func (txn *KVTxn) SetEventListener(l TxnEventListener) {
+	txn.eventListener = l
+}
+
+// Profile returns the reads and writes of the transaction so far. The writes
+// are counted when the transaction commits.
+func (txn *KVTxn) Profile() TxnProfile {
+	return txn.profile.load()
+}
+
 // SetEnableAsyncCommit indicates if the transaction will try to use async commit.
 func (txn *KVTxn) SetEnableAsyncCommit(b bool) {
 	txn.enableAsyncCommit = b
 }
 
+// SetMaxCommitTSFromOracle makes the async commit or 1PC of the transaction
+// use a maxCommitTS of the current ts of the oracle plus margin, fetched when
+// the commit starts, so the transaction can commit within margin from now
+// even if it has been running longer than the safe window. A margin of 0
+// restores the default.
+//
+// The safe window keeps the commit ts within the schema lease checked by the
+// SchemaLeaseChecker. With a margin the maxCommitTS may go past that window,
+// so the transaction may commit after a schema change it has not been checked
+// against. Only use it when the caller tolerates that or doesn't depend on the
+// schema lease.
+func (txn *KVTxn) SetMaxCommitTSFromOracle(margin time.Duration) {
+	txn.maxCommitTSMargin = margin
+}
+
+// SetAsyncCommitStrict indicates if the transaction fails instead of falling
+// back to 2PC when async commit cannot proceed.
+func (txn *KVTxn) SetAsyncCommitStrict(b bool) {
+	txn.asyncCommitStrict = b
+}
+
 // SetEnable1PC indicates if the transaction will try to use 1 phase commit.
 func (txn *KVTxn) SetEnable1PC(b bool) {
 	txn.enable1PC = b
 }
 
+// SetPrewriteAndConfirmAsync makes Commit return as soon as the prewrite of a
+// 1PC transaction is sent, without waiting for TiKV to apply it. The result is
+// confirmed in background and passed to confirm with the commitTS. If it
+// fails, the transaction is rolled back and confirm gets the error, so the
+// caller must be able to undo what it did after Commit returned. Commit waits
+// for the result as usual if the transaction doesn't use 1PC. It has no effect
+// if the local latches are enabled.
+func (txn *KVTxn) SetPrewriteAndConfirmAsync(confirm func(commitTS uint64, err error)) {
+	txn.prewriteConfirm = confirm
+}
+
 // SetCausalConsistency indicates if the transaction does not need to
 // guarantee linearizability. Default value is false which means
 // linearizability is guaranteed.
@@ -320,17 +598,35 @@ func (txn *KVTxn) GetScope() string {
 
 // Commit commits the transaction operations to KV store.
 func (txn *KVTxn) Commit(ctx context.Context) error {
-	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
-		span1 := span.Tracer().StartSpan("tikvTxn.Commit", opentracing.ChildOf(span.Context()))
-		defer span1.Finish()
-		ctx = opentracing.ContextWithSpan(ctx, span1)
+	var span opentracing.Span
+	if span, ctx = startCommitSpan(ctx, "tikvTxn.Commit"); span != nil {
+		defer span.Finish()
 	}
 	defer trace.StartRegion(ctx, "CommitTxn").End()
 
 	if !txn.valid {
 		return tikverr.ErrInvalidTxn
 	}
-	defer txn.close()
+	// The transaction is left valid to be rolled back.
+	if txn.branches != nil {
+		if err := txn.branches.claim(txn); err != nil {
+			return err
+		}
+	}
+	// finish collects the steps run after the commit in reverse order, like
+	// defers. If Commit returns before the commit finishes, they're detached
+	// and run by the background commit instead.
+	var (
+		finish   []func()
+		detached bool
+	)
+	defer func() { txn.valid = false }()
+	defer func() {
+		if !detached {
+			runFinish(finish)
+		}
+	}()
+	finish = append(finish, txn.emitTrace, txn.emitProfile)
 
 	if val, err := util.EvalFailpoint("mockCommitError"); err == nil && val.(bool) {
 		if _, err := util.EvalFailpoint("mockCommitErrorOpt"); err == nil {
@@ -359,19 +655,34 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 		}
 		txn.committer = committer
 	}
-	defer committer.ttlManager.close()
+	finish = append(finish, committer.ttlManager.close)
 
+	if txn.commitID != nil {
+		committed, err := txn.checkCommitID(ctx, committer)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if committed {
+			return nil
+		}
+	}
+
+	txn.lockReadSet()
 	initRegion := trace.StartRegion(ctx, "InitKeys")
 	err = committer.initKeysAndMutations()
 	initRegion.End()
 	if err != nil {
 		return errors.Trace(err)
 	}
+	txn.profile.setWrites(committer.mutations)
 	if committer.mutations.Len() == 0 {
 		return nil
 	}
+	if txn.autoIsolation && txn.readSet.Len() == 0 && isReadOnlyCommit(committer.mutations) {
+		return txn.commitReadOnly(committer)
+	}
 
-	defer func() {
+	finish = append(finish, func() {
 		detail := committer.getDetail()
 		detail.Mu.Lock()
 		metrics.TiKVTxnCommitBackoffSeconds.Observe(float64(detail.Mu.CommitBackoffTime) / float64(time.Second))
@@ -387,11 +698,28 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 				*commitDetail = detail
 			}
 		}
-	}()
+	})
 	// latches disabled
 	// pessimistic transaction should also bypass latch.
 	if txn.store.txnLatches == nil || txn.IsPessimistic() {
-		err = committer.execute(ctx)
+		if coalescer := txn.store.intentCoalescer; coalescer != nil && !txn.IsPessimistic() {
+			if keys := writtenKeys(committer.mutations); len(keys) > 0 {
+				start := time.Now()
+				release, waited, err := coalescer.acquire(ctx, txn.startTS, keys)
+				if waited {
+					metrics.TiKVIntentCoalesceWaitHistogram.Observe(time.Since(start).Seconds())
+				}
+				if err != nil {
+					return err
+				}
+				finish = append(finish, func() { release(txn.CommitTS()) })
+			}
+		}
+		if txn.prewriteConfirm != nil {
+			detached, err = txn.executeCommitConfirmAsync(committer, val == nil || sessionID > 0, finish)
+			return errors.Trace(err)
+		}
+		err = txn.executeCommit(ctx, committer)
 		if val == nil || sessionID > 0 {
 			txn.onCommitted(err)
 		}
@@ -412,7 +740,7 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 	if lock.IsStale() {
 		return &tikverr.ErrWriteConflictInLatch{StartTS: txn.startTS}
 	}
-	err = committer.execute(ctx)
+	err = txn.executeCommit(ctx, committer)
 	if val == nil || sessionID > 0 {
 		txn.onCommitted(err)
 	}
@@ -423,8 +751,106 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 	return errors.Trace(err)
 }
 
+// executeCommit runs the 2PC of committer. A write conflict on a read locked
+// key is returned as ErrReadWriteConflict.
+func (txn *KVTxn) executeCommit(ctx context.Context, committer *twoPhaseCommitter) error {
+	if txn.wal != nil {
+		if err := txn.wal.journalPrewrite(committer); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	err := committer.execute(ctx)
+	// The undetermined transactions are left to WALRecovery.
+	if txn.wal != nil && (err == nil || committer.getUndeterminedErr() == nil) {
+		txn.wal.remove(txn.startTS)
+	}
+	return txn.readWriteConflict(err)
+}
+
+// runFinish runs the finish steps of Commit in reverse order.
+func runFinish(finish []func()) {
+	for i := len(finish) - 1; i >= 0; i-- {
+		finish[i]()
+	}
+}
+
+// executeCommitConfirmAsync runs the commit in background and returns once the
+// 1PC prewrite is sent, or the commit finishes if it isn't 1PC. The commit is
+// run with the context of the store, since it may outlive the caller. If it
+// returns before the commit finishes, detached is true and the finish steps of
+// Commit are run in background after the commit.
+func (txn *KVTxn) executeCommitConfirmAsync(committer *twoPhaseCommitter, notify bool, finish []func()) (detached bool, err error) {
+	sent := make(chan struct{})
+	var once sync.Once
+	onSent := func() {
+		if committer.isOnePC() {
+			once.Do(func() { close(sent) })
+		}
+	}
+	ctx := context.WithValue(committer.storeCtx, prewriteSentKey{}, onSent)
+
+	var commitErr error
+	finished := make(chan struct{})
+	// confirmed is closed after async is set.
+	async := false
+	confirmed := make(chan struct{})
+	txn.store.wg.Add(1)
+	go func() {
+		defer txn.store.wg.Done()
+		commitErr = txn.executeCommit(ctx, committer)
+		if notify {
+			txn.onCommitted(commitErr)
+		}
+		close(finished)
+		<-confirmed
+		if async {
+			runFinish(finish)
+			if commitErr != nil {
+				logutil.BgLogger().Warn("1PC commit failed after returning",
+					zap.Uint64("txnStartTS", txn.startTS), zap.Error(commitErr))
+			}
+			_ = util.SafeCall(func() error {
+				txn.prewriteConfirm(committer.commitTS, commitErr)
+				return nil
+			}, nil)
+		}
+	}()
+
+	select {
+	case <-finished:
+		close(confirmed)
+		return false, commitErr
+	case <-sent:
+		async = true
+		close(confirmed)
+		metrics.TiKVOnePCConfirmAsyncCounter.Inc()
+		return true, nil
+	}
+}
+
+// commitReadOnly finishes the transaction which only locks keys without
+// prewriting and committing the locks.
+func (txn *KVTxn) commitReadOnly(committer *twoPhaseCommitter) error {
+	metrics.TiKVTxnAutoReadOnlyCounter.Inc()
+	if !txn.IsPessimistic() {
+		return nil
+	}
+	bo := retry.NewBackofferWithVars(context.Background(), cleanupMaxBackoff, txn.vars)
+	if err := committer.pessimisticRollbackMutations(bo, committer.mutations); err != nil {
+		// The locks are cleaned up by others after they expire.
+		logutil.BgLogger().Warn("rollback pessimistic locks of read-only txn failed",
+			zap.Uint64("txnStartTS", txn.startTS), zap.Error(err))
+	}
+	return nil
+}
+
 func (txn *KVTxn) close() {
 	txn.valid = false
+	txn.emitTrace()
+}
+
+func (txn *KVTxn) emitTrace() {
+	client.EmitTxnTrace(txn.store.GetTiKVClient(), txn.startTS)
 }
 
 // Rollback undoes the transaction operations to KV store.
@@ -505,7 +931,10 @@ func (txn *KVTxn) onCommitted(err error) {
 		}
 		infoStr, err2 := json.Marshal(info)
 		_ = err2
-		txn.commitCallback(string(infoStr), err)
+		_ = util.SafeCall(func() error {
+			txn.commitCallback(string(infoStr), err)
+			return nil
+		}, nil)
 	}
 }
 
@@ -737,6 +1166,11 @@ func (txn *KVTxn) StartTS() uint64 {
 	return txn.startTS
 }
 
+// CommitTS returns the commit ts of the transaction after it's committed.
+func (txn *KVTxn) CommitTS() uint64 {
+	return txn.commitTS
+}
+
 // Valid returns if the transaction is valid.
 // A transaction become invalid after commit or rollback.
 func (txn *KVTxn) Valid() bool {
@@ -0,0 +1,165 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/util"
+)
+
+// txnBranches is shared by a transaction and its clones. Only one of them can
+// commit.
+type txnBranches struct {
+	mu        sync.Mutex
+	committer *KVTxn
+}
+
+// claim makes txn the branch to commit, it fails if another branch has
+// started committing.
+func (b *txnBranches) claim(txn *KVTxn) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.committer != nil && b.committer != txn {
+		return errors.Trace(tikverr.ErrTxnBranchCommitted)
+	}
+	b.committer = txn
+	return nil
+}
+
+// Clone creates a speculative branch of the transaction. The branch has a deep
+// copy of the mutations and the snapshot cache, and its own commit state, so
+// the transaction and the branch can be written and committed independently.
+//
+// The branches share the start ts, so TiKV can't tell their locks apart and the
+// primary key of the one committed is the primary key of all of them. Hence
+// only one branch can commit: the first branch calling Commit commits the
+// start ts, and Commit of the other branches fails with ErrTxnBranchCommitted,
+// they must be rolled back with Rollback.
+//
+// Pessimistic transactions and the transactions being committed can't be
+// cloned.
+func (txn *KVTxn) Clone() (*KVTxn, error) {
+	if !txn.valid {
+		return nil, tikverr.ErrInvalidTxn
+	}
+	if txn.IsPessimistic() {
+		return nil, errors.New("pessimistic transactions can't be cloned")
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.committer != nil {
+		return nil, errors.New("committing transactions can't be cloned")
+	}
+
+	snapshot := txn.snapshot.clone()
+	us, err := txn.us.Clone(snapshot)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if txn.branches == nil {
+		txn.branches = &txnBranches{}
+	}
+	clone := &KVTxn{
+		snapshot:              snapshot,
+		us:                    us,
+		store:                 txn.store,
+		startTS:               txn.startTS,
+		startTime:             time.Now(),
+		setCnt:                txn.setCnt,
+		vars:                  txn.vars,
+		lockedCnt:             txn.lockedCnt,
+		valid:                 true,
+		schemaVer:             txn.schemaVer,
+		schemaAmender:         txn.schemaAmender,
+		commitCallback:        txn.commitCallback,
+		binlog:                txn.binlog,
+		schemaLeaseChecker:    txn.schemaLeaseChecker,
+		syncLogMode:           txn.syncLogMode,
+		rollbackStrategy:      txn.rollbackStrategy,
+		priority:              txn.priority,
+		isPessimistic:         txn.isPessimistic,
+		enableAsyncCommit:     txn.enableAsyncCommit,
+		asyncCommitStrict:     txn.asyncCommitStrict,
+		enable1PC:             txn.enable1PC,
+		causalConsistency:     txn.causalConsistency,
+		scope:                 txn.scope,
+		kvFilter:              txn.kvFilter,
+		resourceGroupTag:      txn.resourceGroupTag,
+		primaryKeyStrategy:    txn.primaryKeyStrategy,
+		encryption:            txn.encryption,
+		compressor:            txn.compressor,
+		commitID:              txn.commitID,
+		autoIsolation:         txn.autoIsolation,
+		eventListener:         txn.eventListener,
+		profile:               txn.profile,
+		optimisticRetry:       txn.optimisticRetry,
+		prewriteConfirm:       txn.prewriteConfirm,
+		branches:              txn.branches,
+		requestMetadata:       txn.requestMetadata,
+		wal:                   txn.wal,
+		prewritePipelineDepth: txn.prewritePipelineDepth,
+		maxCommitTSMargin:     txn.maxCommitTSMargin,
+	}
+	if txn.leaderHints != nil {
+		clone.leaderHints = make(map[uint64]*metapb.Peer, len(txn.leaderHints))
+		for id, peer := range txn.leaderHints {
+			clone.leaderHints[id] = peer
+		}
+	}
+	if txn.readSet != nil {
+		clone.readSet = txn.readSet.clone()
+	}
+	return clone, nil
+}
+
+// clone creates a snapshot of the same version with the same options and a
+// copy of the cache.
+func (s *KVSnapshot) clone() *KVSnapshot {
+	clone := &KVSnapshot{
+		store:            s.store,
+		version:          s.version,
+		isolationLevel:   s.isolationLevel,
+		priority:         s.priority,
+		notFillCache:     s.notFillCache,
+		keyOnly:          s.keyOnly,
+		vars:             s.vars,
+		replicaReadSeed:  s.replicaReadSeed,
+		resolvedLocks:    util.NewTSSet(5),
+		scanBatchSize:    s.scanBatchSize,
+		sampleStep:       s.sampleStep,
+		resourceGroupTag: s.resourceGroupTag,
+		encryption:       s.encryption,
+		decompressor:     s.decompressor,
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.mu.cached != nil {
+		clone.mu.cached = make(map[string][]byte, len(s.mu.cached))
+		for k, v := range s.mu.cached {
+			clone.mu.cached[k] = v
+		}
+	}
+	clone.mu.cachedSize = s.mu.cachedSize
+	clone.mu.replicaRead = s.mu.replicaRead
+	clone.mu.taskID = s.mu.taskID
+	clone.mu.isStaleness = s.mu.isStaleness
+	clone.mu.txnScope = s.mu.txnScope
+	clone.mu.matchStoreLabels = s.mu.matchStoreLabels
+	return clone
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/kv"
+)
+
+func TestTxnClone(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	require.Nil(t, txn.Delete([]byte("b")))
+	txn.GetMemBuffer().UpdateFlags([]byte("c"), kv.SetKeyLocked)
+
+	branch, err := txn.Clone()
+	require.Nil(t, err)
+	require.Equal(t, txn.StartTS(), branch.StartTS())
+	require.Equal(t, txn.Len(), branch.Len())
+	flags, err := branch.GetMemBuffer().GetFlags([]byte("c"))
+	require.Nil(t, err)
+	require.True(t, flags.HasLocked())
+	val, err := branch.GetMemBuffer().Get([]byte("b"))
+	require.Nil(t, err)
+	require.Empty(t, val)
+
+	// The branches are written independently.
+	require.Nil(t, txn.Set([]byte("d"), []byte("txn")))
+	require.Nil(t, branch.Set([]byte("d"), []byte("branch")))
+	require.Nil(t, branch.Set([]byte("a"), []byte("2")))
+	val, err = txn.Get(ctx, []byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), val)
+
+	// The first committed branch wins, the other one must be rolled back.
+	require.Nil(t, branch.Commit(ctx))
+	err = txn.Commit(ctx)
+	require.Equal(t, tikverr.ErrTxnBranchCommitted, errors.Cause(err))
+	require.True(t, txn.Valid())
+	require.Nil(t, txn.Rollback())
+
+	check, err := store.Begin()
+	require.Nil(t, err)
+	val, err = check.Get(ctx, []byte("d"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("branch"), val)
+	val, err = check.Get(ctx, []byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("2"), val)
+
+	// Pessimistic transactions can't be cloned.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	txn.SetPessimistic(true)
+	_, err = txn.Clone()
+	require.NotNil(t, err)
+	require.Nil(t, txn.Rollback())
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"sync/atomic"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/util"
+)
+
+// TxnProfile counts the reads and writes of a transaction. A read operation is
+// a key read by Get or BatchGet or a pair returned by an iterator. The writes
+// are counted when the transaction commits, a write operation is a key put,
+// inserted or deleted by the transaction, the keys only locked are not
+// counted. The bytes are the bytes of the keys and values.
+type TxnProfile struct {
+	ReadOps    uint64
+	WriteOps   uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+// WriteRatio returns the ratio of the write operations to all the operations,
+// or 0 if there are no operations.
+func (p TxnProfile) WriteRatio() float64 {
+	if p.ReadOps+p.WriteOps == 0 {
+		return 0
+	}
+	return float64(p.WriteOps) / float64(p.ReadOps+p.WriteOps)
+}
+
+// TxnEventListener is notified of the events of a transaction.
+type TxnEventListener interface {
+	// OnProfile is called with the profile of the transaction when it commits,
+	// whether the commit succeeds or not.
+	OnProfile(profile TxnProfile)
+}
+
+func (p *TxnProfile) addRead(ops, bytes int) {
+	atomic.AddUint64(&p.ReadOps, uint64(ops))
+	atomic.AddUint64(&p.ReadBytes, uint64(bytes))
+}
+
+func (p *TxnProfile) setWrites(m CommitterMutations) {
+	var ops, bytes uint64
+	for i := 0; i < m.Len(); i++ {
+		switch m.GetOp(i) {
+		case kvrpcpb.Op_Put, kvrpcpb.Op_Insert, kvrpcpb.Op_Del:
+			ops++
+			bytes += uint64(len(m.GetKey(i)) + len(m.GetValue(i)))
+		}
+	}
+	atomic.StoreUint64(&p.WriteOps, ops)
+	atomic.StoreUint64(&p.WriteBytes, bytes)
+}
+
+func (p *TxnProfile) load() TxnProfile {
+	return TxnProfile{
+		ReadOps:    atomic.LoadUint64(&p.ReadOps),
+		WriteOps:   atomic.LoadUint64(&p.WriteOps),
+		ReadBytes:  atomic.LoadUint64(&p.ReadBytes),
+		WriteBytes: atomic.LoadUint64(&p.WriteBytes),
+	}
+}
+
+// profiledIter counts the pairs returned by an iterator as reads.
+type profiledIter struct {
+	Iterator
+	profile *TxnProfile
+}
+
+func newProfiledIter(it Iterator, profile *TxnProfile) Iterator {
+	if it.Valid() {
+		profile.addRead(1, len(it.Key())+len(it.Value()))
+	}
+	return &profiledIter{Iterator: it, profile: profile}
+}
+
+func (it *profiledIter) Next() error {
+	if err := it.Iterator.Next(); err != nil {
+		return err
+	}
+	if it.Valid() {
+		it.profile.addRead(1, len(it.Key())+len(it.Value()))
+	}
+	return nil
+}
+
+func (txn *KVTxn) emitProfile() {
+	profile := txn.profile.load()
+	metrics.TiKVTxnWriteRatio.Observe(profile.WriteRatio())
+	if txn.eventListener != nil {
+		_ = util.SafeCall(func() error {
+			txn.eventListener.OnProfile(profile)
+			return nil
+		}, nil)
+	}
+}
+
+// isReadOnlyCommit returns whether the mutations only lock keys, which are
+// committed as read-only by auto isolation.
+func isReadOnlyCommit(m CommitterMutations) bool {
+	for i := 0; i < m.Len(); i++ {
+		if m.GetOp(i) != kvrpcpb.Op_Lock {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"go.uber.org/zap"
+)
+
+// BackoffStrategy decides how long to wait before retrying a transaction.
+type BackoffStrategy interface {
+	// Backoff returns the time to wait before the attempt-th retry, attempt
+	// starts from 1.
+	Backoff(attempt int) time.Duration
+}
+
+// ExponentialBackoff doubles the wait time from Base for every retry, up to
+// Max.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// Backoff implements BackoffStrategy.
+func (b ExponentialBackoff) Backoff(attempt int) time.Duration {
+	d := b.Base
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+type optimisticRetry struct {
+	maxAttempts int
+	backoff     BackoffStrategy
+}
+
+// WithOptimisticRetry makes RunInTxn retry the optimistic transaction for up to
+// maxAttempts attempts in total when its commit fails with a write conflict.
+// A nil backoff retries without waiting.
+func WithOptimisticRetry(maxAttempts int, backoff BackoffStrategy) TxnOption {
+	return func(txn *KVTxn) {
+		txn.optimisticRetry = &optimisticRetry{maxAttempts: maxAttempts, backoff: backoff}
+	}
+}
+
+// RunInTxn runs fn in a new transaction and commits it. If the transaction is
+// optimistic and created with WithOptimisticRetry, a commit failed by a write
+// conflict is retried in another new transaction, so fn must be safe to run
+// again. Every attempt gets a new start ts, so fn reads the data written by
+// the conflicting transactions.
+//
+// The transaction is rolled back if fn returns an error.
+func (s *KVStore) RunInTxn(ctx context.Context, fn func(txn *KVTxn) error, opts ...TxnOption) error {
+	for attempt := 1; ; attempt++ {
+		txn, err := s.Begin(opts...)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = fn(txn); err != nil {
+			if rollbackErr := txn.Rollback(); rollbackErr != nil {
+				logutil.Logger(ctx).Warn("rollback txn failed", zap.Uint64("txnStartTS", txn.StartTS()), zap.Error(rollbackErr))
+			}
+			return err
+		}
+		err = txn.Commit(ctx)
+		retry := txn.optimisticRetry
+		if err == nil || retry == nil || txn.IsPessimistic() || attempt >= retry.maxAttempts ||
+			!(tikverr.IsErrWriteConflict(err) || tikverr.Is(err, &tikverr.ErrWriteConflictInLatch{})) {
+			return err
+		}
+		logutil.Logger(ctx).Debug("retry txn on write conflict",
+			zap.Uint64("txnStartTS", txn.StartTS()), zap.Int("attempt", attempt), zap.Error(err))
+		if retry.backoff == nil {
+			continue
+		}
+		timer := time.NewTimer(retry.backoff.Backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Trace(ctx.Err())
+		}
+	}
+}
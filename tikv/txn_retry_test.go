@@ -0,0 +1,84 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+func TestRunInTxnOptimisticRetry(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+	key := []byte("counter")
+
+	// increase increments the counter, and makes a conflicting increment in
+	// the first conflicts attempts.
+	increase := func(conflicts int, attempts *int) func(txn *KVTxn) error {
+		return func(txn *KVTxn) error {
+			*attempts++
+			val, err := txn.Get(ctx, key)
+			if err != nil && !tikverr.IsErrNotFound(err) {
+				return err
+			}
+			n, _ := strconv.Atoi(string(val))
+			if *attempts <= conflicts {
+				other, err := store.Begin()
+				require.Nil(t, err)
+				require.Nil(t, other.Set(key, []byte(strconv.Itoa(n+100))))
+				require.Nil(t, other.Commit(ctx))
+			}
+			return txn.Set(key, []byte(strconv.Itoa(n+1)))
+		}
+	}
+	mustGet := func(expected string) {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		val, err := txn.Get(ctx, key)
+		require.Nil(t, err)
+		require.Equal(t, expected, string(val))
+	}
+
+	attempts := 0
+	err := store.RunInTxn(ctx, increase(1, &attempts))
+	require.True(t, tikverr.IsErrWriteConflict(err))
+	require.Equal(t, 1, attempts)
+	mustGet("100")
+
+	attempts = 0
+	err = store.RunInTxn(ctx, increase(2, &attempts), WithOptimisticRetry(3, ExponentialBackoff{Base: time.Millisecond, Max: 4 * time.Millisecond}))
+	require.Nil(t, err)
+	require.Equal(t, 3, attempts)
+	mustGet("301")
+
+	attempts = 0
+	err = store.RunInTxn(ctx, increase(2, &attempts), WithOptimisticRetry(2, nil))
+	require.True(t, tikverr.IsErrWriteConflict(err))
+	require.Equal(t, 2, attempts)
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Base: time.Millisecond, Max: 5 * time.Millisecond}
+	require.Equal(t, time.Millisecond, b.Backoff(1))
+	require.Equal(t, 2*time.Millisecond, b.Backoff(2))
+	require.Equal(t, 4*time.Millisecond, b.Backoff(3))
+	require.Equal(t, 5*time.Millisecond, b.Backoff(4))
+	require.Equal(t, 5*time.Millisecond, b.Backoff(100))
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// txnStatusCacheTTL is how long a cached status is served.
+const txnStatusCacheTTL = time.Second
+
+type txnStatusCacheEntry struct {
+	txnID uint64
+	// callerStartTS is the max caller start ts of the CheckTxnStatus requests
+	// returning the status. TiKV has pushed the min commit ts of the
+	// transaction past it, so the status can be used by callers with smaller
+	// start ts.
+	callerStartTS uint64
+	status        TxnStatus
+	expireAt      time.Time
+}
+
+// txnStatusCache is an LRU cache of the statuses of uncommitted transactions.
+// The statuses of committed or rolled back transactions never change and are
+// cached by LockResolver.saveResolved instead.
+type txnStatusCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[uint64]*list.Element
+	lru      *list.List
+}
+
+func newTxnStatusCache(capacity int) *txnStatusCache {
+	return &txnStatusCache{
+		capacity: capacity,
+		entries:  make(map[uint64]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *txnStatusCache) get(txnID, callerStartTS uint64) (TxnStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[txnID]
+	if !ok {
+		return TxnStatus{}, false
+	}
+	entry := elem.Value.(*txnStatusCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		c.lru.Remove(elem)
+		delete(c.entries, txnID)
+		return TxnStatus{}, false
+	}
+	if entry.callerStartTS < callerStartTS {
+		return TxnStatus{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.status, true
+}
+
+func (c *txnStatusCache) put(txnID, callerStartTS uint64, status TxnStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expireAt := time.Now().Add(txnStatusCacheTTL)
+	if elem, ok := c.entries[txnID]; ok {
+		entry := elem.Value.(*txnStatusCacheEntry)
+		if callerStartTS > entry.callerStartTS || time.Now().After(entry.expireAt) {
+			entry.callerStartTS = callerStartTS
+		}
+		entry.status = status
+		entry.expireAt = expireAt
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[txnID] = c.lru.PushFront(&txnStatusCacheEntry{
+		txnID:         txnID,
+		callerStartTS: callerStartTS,
+		status:        status,
+		expireAt:      expireAt,
+	})
+	if c.lru.Len() > c.capacity {
+		back := c.lru.Back()
+		c.lru.Remove(back)
+		delete(c.entries, back.Value.(*txnStatusCacheEntry).txnID)
+	}
+}
+
+func (c *txnStatusCache) remove(txnID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[txnID]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, txnID)
+	}
+}
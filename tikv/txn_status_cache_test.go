@@ -0,0 +1,55 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnStatusCache(t *testing.T) {
+	c := newTxnStatusCache(2)
+	c.put(1, 10, TxnStatus{ttl: 100})
+
+	// The status can't be used by a caller with larger start ts, TiKV may not
+	// have pushed the min commit ts past it.
+	s, ok := c.get(1, 10)
+	require.True(t, ok)
+	require.Equal(t, uint64(100), s.ttl)
+	_, ok = c.get(1, 5)
+	require.True(t, ok)
+	_, ok = c.get(1, 11)
+	require.False(t, ok)
+
+	// The least recently used entry is evicted.
+	c.put(2, 10, TxnStatus{ttl: 200})
+	c.get(1, 10)
+	c.put(3, 10, TxnStatus{ttl: 300})
+	_, ok = c.get(2, 10)
+	require.False(t, ok)
+	_, ok = c.get(1, 10)
+	require.True(t, ok)
+
+	c.remove(1)
+	_, ok = c.get(1, 10)
+	require.False(t, ok)
+
+	// Expired entries are not served.
+	c.entries[3].Value.(*txnStatusCacheEntry).expireAt = time.Now().Add(-time.Millisecond)
+	_, ok = c.get(3, 10)
+	require.False(t, ok)
+	require.Equal(t, 0, c.lru.Len())
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pingcap/errors"
+)
+
+// AtomicSwap swaps the values of keyA and keyB in the transaction. Both keys
+// are read at the start ts and written with the value of the other one, so the
+// swap fails with a write conflict at commit if either key is written by
+// another transaction after the start ts. It returns ErrNotExist if either key
+// doesn't exist.
+//
+// The keys are committed by the same 2PC like the other mutations of the
+// transaction, the mutations in one region are prewritten in one batch, so the
+// keys in one region take a single prewrite request. Pessimistic transactions
+// should lock the keys by LockKeys and swap them with Get and Set instead.
+func (txn *KVTxn) AtomicSwap(ctx context.Context, keyA, keyB []byte) error {
+	if txn.IsPessimistic() {
+		return errors.New("AtomicSwap is not supported by pessimistic transactions")
+	}
+	if bytes.Equal(keyA, keyB) {
+		_, err := txn.Get(ctx, keyA)
+		return errors.Trace(err)
+	}
+	valueA, err := txn.Get(ctx, keyA)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	valueB, err := txn.Get(ctx, keyB)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = txn.Set(keyA, valueB); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(txn.Set(keyB, valueA))
+}
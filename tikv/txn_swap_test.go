@@ -0,0 +1,82 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+)
+
+func TestAtomicSwap(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], []byte("m"), []uint64{ids[1]}, ids[1])
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	set := func(kvs ...string) {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		for i := 0; i < len(kvs); i += 2 {
+			require.Nil(t, txn.Set([]byte(kvs[i]), []byte(kvs[i+1])))
+		}
+		require.Nil(t, txn.Commit(ctx))
+	}
+	check := func(kvs ...string) {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		for i := 0; i < len(kvs); i += 2 {
+			val, err := txn.Get(ctx, []byte(kvs[i]))
+			require.Nil(t, err)
+			require.Equal(t, kvs[i+1], string(val))
+		}
+	}
+	set("a", "1", "b", "2", "x", "3")
+
+	// The keys in one region.
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.AtomicSwap(ctx, []byte("a"), []byte("b")))
+	require.Nil(t, txn.Commit(ctx))
+	check("a", "2", "b", "1")
+
+	// The keys in different regions.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.AtomicSwap(ctx, []byte("a"), []byte("x")))
+	require.Nil(t, txn.Commit(ctx))
+	check("a", "3", "x", "2")
+
+	// A key written after the start ts fails the swap.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.AtomicSwap(ctx, []byte("a"), []byte("x")))
+	set("x", "4")
+	require.True(t, tikverr.IsErrWriteConflict(txn.Commit(ctx)))
+	check("a", "3", "x", "4")
+
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.True(t, tikverr.IsErrNotFound(txn.AtomicSwap(ctx, []byte("a"), []byte("y"))))
+	require.Nil(t, txn.Rollback())
+}
@@ -0,0 +1,423 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/config"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/internal/retry"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/util"
+)
+
+func TestSyncLogMode(t *testing.T) {
+	cases := []struct {
+		mode      SyncLogMode
+		primary   bool
+		secondary bool
+	}{
+		{SyncLogDefault, false, false},
+		{SyncLogAlways, true, true},
+		{SyncLogNever, false, false},
+		{SyncLogAlwaysForPrimary, true, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.primary, c.mode.syncLog(true), c.mode.String())
+		assert.Equal(t, c.secondary, c.mode.syncLog(false), c.mode.String())
+	}
+
+	txn := &KVTxn{}
+	WithSyncLog(SyncLogAlwaysForPrimary)(txn)
+	assert.Equal(t, SyncLogAlwaysForPrimary, txn.GetSyncLogMode())
+	txn.EnableForceSyncLog()
+	assert.Equal(t, SyncLogAlways, txn.GetSyncLogMode())
+}
+
+func TestCommitID(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn1, err := store.Begin(WithCommitID([]byte("id")))
+	require.Nil(t, err)
+	require.Nil(t, txn1.Set([]byte("a"), []byte("1")))
+	require.Nil(t, txn1.Commit(ctx))
+	require.Greater(t, txn1.CommitTS(), txn1.StartTS())
+
+	// The resubmitted transaction is not committed again.
+	txn2, err := store.Begin(WithCommitID([]byte("id")))
+	require.Nil(t, err)
+	require.Nil(t, txn2.Set([]byte("b"), []byte("2")))
+	require.Nil(t, txn2.Commit(ctx))
+	require.Equal(t, txn1.CommitTS(), txn2.CommitTS())
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	_, err = txn.Get(ctx, []byte("b"))
+	require.True(t, tikverr.IsErrNotFound(err))
+}
+
+type panicKVFilter struct{}
+
+func (panicKVFilter) IsUnnecessaryKeyValue(key, value []byte, flags kv.KeyFlags) bool {
+	panic("filter panic")
+}
+
+func TestPanickingHooks(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	// A panicking filter fails the commit instead of crashing.
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	txn.SetKVFilter(panicKVFilter{})
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	require.NotNil(t, txn.Commit(ctx))
+
+	// A panicking commit callback doesn't affect the committed transaction.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	txn.SetCommitCallback(func(string, error) { panic("callback panic") })
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	require.Nil(t, txn.Commit(ctx))
+}
+
+type profileRecorder struct {
+	profiles []TxnProfile
+}
+
+func (r *profileRecorder) OnProfile(profile TxnProfile) {
+	r.profiles = append(r.profiles, profile)
+}
+
+func TestTxnProfileAndAutoIsolation(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	require.Nil(t, txn.Set([]byte("b"), []byte("2")))
+	require.Nil(t, txn.Commit(ctx))
+
+	r := &profileRecorder{}
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	txn.SetEventListener(r)
+	_, err = txn.Get(ctx, []byte("a"))
+	require.Nil(t, err)
+	_, err = txn.BatchGet(ctx, [][]byte{[]byte("a"), []byte("c")})
+	require.Nil(t, err)
+	it, err := txn.Iter([]byte("a"), nil)
+	require.Nil(t, err)
+	for it.Valid() {
+		require.Nil(t, it.Next())
+	}
+	it.Close()
+	require.Nil(t, txn.Delete([]byte("b")))
+	require.Nil(t, txn.Commit(ctx))
+	require.Equal(t, []TxnProfile{{ReadOps: 5, WriteOps: 1, ReadBytes: 9, WriteBytes: 1}}, r.profiles)
+
+	// The pessimistic lock of a read-only transaction is rolled back instead
+	// of being committed.
+	txn, err = store.Begin(WithAutoIsolation())
+	require.Nil(t, err)
+	txn.SetPessimistic(true)
+	lockCtx := &kv.LockCtx{ForUpdateTS: txn.StartTS(), WaitStartTime: time.Now()}
+	require.Nil(t, txn.LockKeys(ctx, lockCtx, []byte("a")))
+	require.Nil(t, txn.Commit(ctx))
+	require.Zero(t, txn.CommitTS())
+
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	txn.SetPessimistic(true)
+	lockCtx = &kv.LockCtx{ForUpdateTS: txn.StartTS(), WaitStartTime: time.Now(), LockWaitTime: LockNoWait}
+	require.Nil(t, txn.LockKeys(ctx, lockCtx, []byte("a")))
+	require.Nil(t, txn.Rollback())
+}
+
+// metadataRecordClient records the metadata of the requests.
+type metadataRecordClient struct {
+	Client
+	mu       sync.Mutex
+	metadata map[tikvrpc.CmdType]map[string]string
+}
+
+func (c *metadataRecordClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	c.mu.Lock()
+	c.metadata[req.Type] = req.Metadata
+	c.mu.Unlock()
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestRequestMetadata(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := &metadataRecordClient{
+		Client:   mocktikv.NewRPCClient(cluster, mvccStore, nil),
+		metadata: make(map[tikvrpc.CmdType]map[string]string),
+	}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	md := map[string]string{"x-caller": "alice"}
+	txn, err := store.Begin(WithRequestMetadata(md))
+	require.Nil(t, err)
+	// The metadata is copied.
+	md["x-caller"] = "bob"
+	expected := map[string]string{"x-caller": "alice"}
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	require.Nil(t, txn.Commit(context.Background()))
+	require.Equal(t, expected, client.metadata[tikvrpc.CmdPrewrite])
+	require.Equal(t, expected, client.metadata[tikvrpc.CmdCommit])
+
+	bo := retry.NewBackofferWithVars(context.Background(), 1000, nil)
+	// The lock is committed, so only the metadata of the request is checked.
+	sendTxnHeartBeat(bo, store, []byte("a"), txn.StartTS(), 1000, txn.requestMetadata)
+	require.Equal(t, expected, client.metadata[tikvrpc.CmdTxnHeartBeat])
+}
+
+// inflightPrewriteClient records the max number of prewrite requests in
+// flight and the order they are sent in.
+type inflightPrewriteClient struct {
+	Client
+	mu       sync.Mutex
+	inflight int
+	max      int
+	keys     []string
+}
+
+func (c *inflightPrewriteClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type != tikvrpc.CmdPrewrite {
+		return c.Client.SendRequest(ctx, addr, req, timeout)
+	}
+	c.mu.Lock()
+	c.inflight++
+	if c.inflight > c.max {
+		c.max = c.inflight
+	}
+	c.keys = append(c.keys, string(req.Prewrite().Mutations[0].Key))
+	c.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	defer func() {
+		c.mu.Lock()
+		c.inflight--
+		c.mu.Unlock()
+	}()
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestPrewritePipelineDepth(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	var splitKeys [][]byte
+	for _, k := range keys[1:] {
+		splitKeys = append(splitKeys, []byte(k))
+	}
+	mocktikv.BootstrapWithMultiRegions(cluster, splitKeys...)
+	client := &inflightPrewriteClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	for _, depth := range []int{1, 3} {
+		client.max, client.keys = 0, nil
+		txn, err := store.Begin(WithPrewritePipelineDepth(depth))
+		require.Nil(t, err)
+		for _, k := range keys {
+			require.Nil(t, txn.Set([]byte(k), []byte(k)))
+		}
+		require.Nil(t, txn.Commit(context.Background()))
+		require.Equal(t, depth, client.max)
+		// The primary batch comes first, then the others by region.
+		require.Equal(t, keys, client.keys)
+	}
+
+	// The depth doesn't exceed CommitterConcurrency.
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.CommitterConcurrency = 2
+	})()
+	client.max, client.keys = 0, nil
+	txn, err := store.Begin(WithPrewritePipelineDepth(3))
+	require.Nil(t, err)
+	for _, k := range keys {
+		require.Nil(t, txn.Set([]byte(k), []byte(k)))
+	}
+	require.Nil(t, txn.Commit(context.Background()))
+	require.Equal(t, 2, client.max)
+	require.Equal(t, keys, client.keys)
+}
+
+// blockingPrewriteClient holds the prewrite requests until release is closed.
+type blockingPrewriteClient struct {
+	Client
+	release chan struct{}
+}
+
+func (c *blockingPrewriteClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdPrewrite {
+		<-c.release
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestPrewriteAndConfirmAsync(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := &blockingPrewriteClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+	ctx := context.WithValue(context.Background(), util.SessionID, uint64(1))
+
+	type result struct {
+		commitTS uint64
+		err      error
+	}
+	begin := func() (*KVTxn, chan result) {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		txn.SetEnable1PC(true)
+		results := make(chan result, 1)
+		txn.SetPrewriteAndConfirmAsync(func(commitTS uint64, err error) {
+			results <- result{commitTS, err}
+		})
+		require.Nil(t, txn.Set([]byte("k"), []byte("v1")))
+		return txn, results
+	}
+
+	// Commit returns while the prewrite is held.
+	client.release = make(chan struct{})
+	txn, results := begin()
+	var detail *util.CommitDetails
+	require.Nil(t, txn.Commit(context.WithValue(ctx, util.CommitDetailCtxKey, &detail)))
+	// The commit detail is handed over after the commit finishes.
+	require.Nil(t, detail)
+	close(client.release)
+	r := <-results
+	require.Nil(t, r.err)
+	require.NotNil(t, detail)
+	require.Greater(t, r.commitTS, txn.StartTS())
+	snapshot := store.GetSnapshot(r.commitTS)
+	val, err := snapshot.Get(context.Background(), []byte("k"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v1"), val)
+
+	// The failed commit is reported to confirm.
+	client.release = make(chan struct{})
+	txn, results = begin()
+	conflict, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, conflict.Set([]byte("k"), []byte("v2")))
+	close(client.release)
+	require.Nil(t, conflict.Commit(context.Background()))
+	client.release = make(chan struct{})
+	require.Nil(t, txn.Commit(ctx))
+	close(client.release)
+	r = <-results
+	require.NotNil(t, r.err)
+
+	// Commit waits for the result if it's not 1PC.
+	txn, results = begin()
+	require.Nil(t, txn.Commit(context.Background()))
+	require.Len(t, results, 0)
+
+	// A panic in confirm doesn't crash the background commit.
+	client.release = make(chan struct{})
+	txn, _ = begin()
+	confirmed := make(chan struct{})
+	txn.SetPrewriteAndConfirmAsync(func(uint64, error) {
+		defer close(confirmed)
+		panic("confirm")
+	})
+	require.Nil(t, txn.Commit(ctx))
+	close(client.release)
+	<-confirmed
+}
+
+// addrRecordClient records the addresses of the prewrite requests.
+type addrRecordClient struct {
+	Client
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (c *addrRecordClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdPrewrite {
+		c.mu.Lock()
+		c.addrs = append(c.addrs, addr)
+		c.mu.Unlock()
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func TestLeaderHint(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	storeIDs, peerIDs, regionID, leaderPeer := mocktikv.BootstrapWithMultiStores(cluster, 3)
+	client := &addrRecordClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	var hint *metapb.Peer
+	var leaderAddr string
+	for i, id := range peerIDs {
+		if id == leaderPeer {
+			leaderAddr = cluster.GetStore(storeIDs[i]).GetAddress()
+		} else if hint == nil {
+			hint = &metapb.Peer{Id: id, StoreId: storeIDs[i]}
+		}
+	}
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	txn.SetLeaderHint(regionID, hint)
+	require.Nil(t, txn.Set([]byte("a"), []byte("1")))
+	require.Nil(t, txn.Commit(context.Background()))
+
+	// The prewrite is sent to the hinted peer first. It's not the leader, so
+	// the prewrite is retried on the leader after NotLeader.
+	require.Equal(t, []string{cluster.GetStore(hint.StoreId).GetAddress(), leaderAddr}, client.addrs)
+}
@@ -0,0 +1,335 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/goleveldb/leveldb"
+	"github.com/pingcap/goleveldb/leveldb/opt"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/internal/retry"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/util/codec"
+	"go.uber.org/zap"
+)
+
+// WAL phases of a transaction.
+const (
+	// walPhasePrewrite means the transaction may have locks but is not
+	// committed.
+	walPhasePrewrite byte = iota + 1
+	// walPhaseCommit means the commitTS is decided and the primary key may be
+	// committed.
+	walPhaseCommit
+)
+
+// TxnWAL journals the committer states of the transactions to a local leveldb
+// database before the committer sends any request, so the transactions that
+// are interrupted by a crash can be completed by WALRecovery after restart.
+// The journal entry of a transaction is deleted once it's committed or rolled
+// back. The entries are synced to disk before they are used, so they survive
+// a machine crash as well as a process crash.
+//
+// The values are journaled as the transaction prewrites them, compressed and
+// encrypted if the transaction is configured to, so the replay writes the
+// same values without the options of the transaction.
+//
+// The async commit and 1PC transactions are committed by their prewrites, so
+// the commitTS is never journaled for them and they are left in the prewrite
+// phase. See ReplayPending for how they are replayed.
+type TxnWAL struct {
+	db *leveldb.DB
+}
+
+// OpenTxnWAL opens the journal in the directory path, creating it if it
+// doesn't exist.
+func OpenTxnWAL(path string) (*TxnWAL, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &TxnWAL{db: db}, nil
+}
+
+// Close closes the journal.
+func (w *TxnWAL) Close() error {
+	return errors.Trace(w.db.Close())
+}
+
+// WithTxnWAL makes the transaction journal its committer state to wal when
+// it's committed.
+func WithTxnWAL(wal *TxnWAL) TxnOption {
+	return func(txn *KVTxn) {
+		txn.wal = wal
+	}
+}
+
+// walWriteOptions syncs the writes to the journal, or an entry may be lost by
+// a machine crash after the requests it guards are sent.
+var walWriteOptions = &opt.WriteOptions{Sync: true}
+
+func walKey(startTS uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, startTS)
+	return key
+}
+
+type walEntry struct {
+	phase    byte
+	commitTS uint64
+	state    []byte
+}
+
+func (e *walEntry) marshal() []byte {
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+len(e.state))
+	buf = append(buf, e.phase)
+	buf = codec.EncodeUvarint(buf, e.commitTS)
+	return append(buf, e.state...)
+}
+
+func unmarshalWALEntry(data []byte) (*walEntry, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty txn wal entry")
+	}
+	e := &walEntry{phase: data[0]}
+	rest, commitTS, err := codec.DecodeUvarint(data[1:])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	e.commitTS = commitTS
+	e.state = rest
+	return e, nil
+}
+
+func (w *TxnWAL) journalPrewrite(c *twoPhaseCommitter) error {
+	state := c.state()
+	m := &state.Mutations
+	for i := range m.values {
+		if op := m.ops[i]; op != kvrpcpb.Op_Put && op != kvrpcpb.Op_Insert {
+			continue
+		}
+		value, err := c.encodeValue(m.values[i])
+		if err != nil {
+			return err
+		}
+		m.values[i] = value
+	}
+	data, err := state.Marshal()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	e := &walEntry{phase: walPhasePrewrite, state: data}
+	return errors.Trace(w.db.Put(walKey(state.StartTS), e.marshal(), walWriteOptions))
+}
+
+func (w *TxnWAL) journalCommit(startTS, commitTS uint64) error {
+	key := walKey(startTS)
+	data, err := w.db.Get(key, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	e, err := unmarshalWALEntry(data)
+	if err != nil {
+		return err
+	}
+	e.phase, e.commitTS = walPhaseCommit, commitTS
+	return errors.Trace(w.db.Put(key, e.marshal(), walWriteOptions))
+}
+
+func (w *TxnWAL) remove(startTS uint64) {
+	if err := w.db.Delete(walKey(startTS), nil); err != nil {
+		logutil.BgLogger().Warn("remove txn wal entry failed", zap.Uint64("txnStartTS", startTS), zap.Error(err))
+	}
+}
+
+// WALOutcome is the outcome of a transaction replayed by WALRecovery.
+type WALOutcome int
+
+// The outcomes of the replayed transactions.
+const (
+	// WALPending means the transaction is not resolved yet, its entry is kept
+	// for the next replay.
+	WALPending WALOutcome = iota
+	// WALCommitted means the transaction is committed.
+	WALCommitted
+	// WALRolledBack means the transaction is rolled back.
+	WALRolledBack
+)
+
+// WALReplayResult is the result of replaying a transaction in the journal.
+type WALReplayResult struct {
+	StartTS uint64
+	// CommitTS is the commitTS of a committed transaction.
+	CommitTS uint64
+	Outcome  WALOutcome
+	// Err is the error that keeps the transaction pending, or the error of
+	// the commit that rolled it back.
+	Err error
+}
+
+// WALRecovery completes the transactions left in a TxnWAL by a crash.
+type WALRecovery struct {
+	store TxnClient
+	wal   *TxnWAL
+}
+
+// NewWALRecovery creates a WALRecovery that completes the transactions in wal
+// with store.
+func NewWALRecovery(store TxnClient, wal *TxnWAL) *WALRecovery {
+	return &WALRecovery{store: store, wal: wal}
+}
+
+// ReplayPending resolves the transactions in the journal by the status of
+// their primary keys, which is checked by CheckTxnStatus:
+//
+//   - If the primary key is committed, the other keys are committed with the
+//     same commitTS.
+//   - If the primary key is rolled back, the other keys are rolled back.
+//   - If the primary lock of an async commit transaction is expired, the
+//     transaction is resolved by its secondary locks, like the lock resolver
+//     does. It's pending if the lock is alive.
+//   - If the primary lock is alive, the transaction is committed with the
+//     journaled commitTS, or prewritten again and committed if the commitTS
+//     was not decided.
+//   - If the primary key is not prewritten, the transaction is prewritten and
+//     committed again.
+//
+// It returns the results of all replayed transactions, the entries of the
+// pending ones are kept for the next replay. The error is only returned if
+// the journal can't be read.
+func (r *WALRecovery) ReplayPending(ctx context.Context) ([]WALReplayResult, error) {
+	iter := r.wal.db.NewIterator(nil, nil)
+	type pendingTxn struct {
+		startTS uint64
+		entry   *walEntry
+	}
+	var pending []pendingTxn
+	for iter.Next() {
+		e, err := unmarshalWALEntry(append([]byte(nil), iter.Value()...))
+		if err != nil {
+			iter.Release()
+			return nil, err
+		}
+		pending = append(pending, pendingTxn{startTS: binary.BigEndian.Uint64(iter.Key()), entry: e})
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	results := make([]WALReplayResult, 0, len(pending))
+	for _, p := range pending {
+		res := WALReplayResult{StartTS: p.startTS}
+		res.Outcome, res.CommitTS, res.Err = r.replay(ctx, p.startTS, p.entry)
+		if res.Outcome == WALPending {
+			logutil.Logger(ctx).Warn("replay txn wal entry pending", zap.Uint64("txnStartTS", p.startTS), zap.Error(res.Err))
+		} else {
+			r.wal.remove(p.startTS)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (r *WALRecovery) replay(ctx context.Context, startTS uint64, e *walEntry) (WALOutcome, uint64, error) {
+	txn, err := r.store.BeginWithOption(DefaultStartTSOption().SetStartTS(startTS), WithTxnWAL(r.wal))
+	if err != nil {
+		return WALPending, 0, errors.Trace(err)
+	}
+	if err = txn.RestoreCommitterState(0, e.state); err != nil {
+		return WALPending, 0, errors.Trace(err)
+	}
+	committer := txn.committer
+	primary := committer.primary()
+	lr := txn.store.GetLockResolver()
+	bo := retry.NewBackofferWithVars(ctx, int(atomic.LoadUint64(&VeryLongMaxBackoff)), nil)
+	for {
+		currentTS, err := txn.store.GetOracle().GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+		if err != nil {
+			return WALPending, 0, errors.Trace(err)
+		}
+		// The callerStartTS is 0, or the min commit ts of the lock is pushed
+		// beyond the journaled commitTS.
+		status, err := lr.getTxnStatus(bo, startTS, primary, 0, currentTS, false, false, nil)
+		if _, ok := errors.Cause(err).(txnNotFoundErr); ok {
+			// Nobody can commit the transaction without its primary lock.
+			return r.commitFromPrewrite(ctx, txn)
+		}
+		if err != nil {
+			return WALPending, 0, errors.Trace(err)
+		}
+		asyncLock := status.primaryLock != nil && status.primaryLock.UseAsyncCommit
+		switch {
+		case status.IsCommitted():
+			return r.commitWithTS(bo, txn, status.CommitTS())
+		case status.ttl == 0 && asyncLock:
+			// The expired async commit lock is resolved by the secondaries,
+			// then the status is checked again.
+			if err = lr.resolveLockAsync(bo, NewLock(status.primaryLock), status); err != nil {
+				return WALPending, 0, errors.Trace(err)
+			}
+		case status.ttl == 0:
+			defer txn.close()
+			if err = committer.initKeysAndMutations(); err != nil {
+				return WALPending, 0, errors.Trace(err)
+			}
+			if err = committer.cleanupMutations(bo, committer.mutations); err != nil {
+				return WALPending, 0, errors.Trace(err)
+			}
+			return WALRolledBack, 0, nil
+		case e.phase == walPhaseCommit:
+			return r.commitWithTS(bo, txn, e.commitTS)
+		case asyncLock:
+			return WALPending, 0, errors.Errorf("async commit lock of txn %d is alive", startTS)
+		default:
+			// The lock is prewritten by 2PC, so is the replay.
+			txn.SetEnableAsyncCommit(false)
+			txn.SetEnable1PC(false)
+			return r.commitFromPrewrite(ctx, txn)
+		}
+	}
+}
+
+// commitWithTS commits the restored transaction with the decided commitTS.
+func (r *WALRecovery) commitWithTS(bo *Backoffer, txn *KVTxn, commitTS uint64) (WALOutcome, uint64, error) {
+	defer txn.close()
+	committer := txn.committer
+	if err := committer.initKeysAndMutations(); err != nil {
+		return WALPending, 0, errors.Trace(err)
+	}
+	committer.commitTS = commitTS
+	if err := committer.commitMutations(bo, committer.mutations); err != nil {
+		return WALPending, 0, errors.Trace(err)
+	}
+	txn.commitTS = commitTS
+	return WALCommitted, commitTS, nil
+}
+
+// commitFromPrewrite prewrites and commits the restored transaction again.
+func (r *WALRecovery) commitFromPrewrite(ctx context.Context, txn *KVTxn) (WALOutcome, uint64, error) {
+	if err := txn.Commit(ctx); err != nil {
+		if txn.committer.getUndeterminedErr() != nil {
+			return WALPending, 0, errors.Trace(err)
+		}
+		// The locks of the transaction are cleaned up by Commit or resolved
+		// by others after they expire.
+		return WALRolledBack, 0, errors.Trace(err)
+	}
+	return WALCommitted, txn.CommitTS(), nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/pingcap/goleveldb/leveldb"
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/oracle"
+)
+
+func TestTxnWAL(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	wal, err := OpenTxnWAL(t.TempDir())
+	require.Nil(t, err)
+	defer wal.Close()
+	ctx := context.Background()
+
+	get := func(ts uint64, key string) []byte {
+		val, err := store.GetSnapshot(ts).Get(ctx, []byte(key))
+		require.Nil(t, err, key)
+		return val
+	}
+
+	// The entry is removed after commit.
+	txn, err := store.Begin(WithTxnWAL(wal))
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k0"), []byte("v0")))
+	require.Nil(t, txn.Commit(ctx))
+	_, err = wal.db.Get(walKey(txn.StartTS()), nil)
+	require.Equal(t, leveldb.ErrNotFound, err)
+
+	bo := NewBackofferWithVars(ctx, 5000, nil)
+	journal := func(txn *KVTxn, kvs ...string) *twoPhaseCommitter {
+		for i := 0; i < len(kvs); i += 2 {
+			require.Nil(t, txn.Set([]byte(kvs[i]), []byte(kvs[i+1])))
+		}
+		_, err := txn.GetCommitterState(0)
+		require.Nil(t, err)
+		require.Nil(t, wal.journalPrewrite(txn.committer))
+		return txn.committer
+	}
+	primaryOnly := func(c *twoPhaseCommitter) CommitterMutations {
+		return c.mutations.Slice(0, 1)
+	}
+
+	// Crashed before sending any request.
+	txn1, err := store.Begin(WithTxnWAL(wal))
+	require.Nil(t, err)
+	journal(txn1, "k1", "v1")
+
+	// Crashed after the commitTS is decided.
+	txn2, err := store.Begin(WithTxnWAL(wal))
+	require.Nil(t, err)
+	committer := journal(txn2, "k2", "v2", "k3", "v3")
+	require.Nil(t, committer.prewriteMutations(bo, committer.mutations))
+	commitTS, err := store.GetOracle().GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	require.Nil(t, err)
+	require.Nil(t, wal.journalCommit(txn2.StartTS(), commitTS))
+
+	// Crashed after the primary key is committed, the commit is not journaled
+	// like an async commit.
+	txn3, err := store.Begin(WithTxnWAL(wal))
+	require.Nil(t, err)
+	committer = journal(txn3, "k4", "v4", "k5", "v5")
+	require.Nil(t, committer.prewriteMutations(bo, committer.mutations))
+	committer.commitTS, err = store.GetOracle().GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	require.Nil(t, err)
+	require.Nil(t, committer.commitMutations(bo, primaryOnly(committer)))
+	commitTS3 := committer.commitTS
+
+	// Crashed after the primary key is rolled back by others.
+	txn4, err := store.Begin(WithTxnWAL(wal))
+	require.Nil(t, err)
+	committer = journal(txn4, "k6", "v6", "k7", "v7")
+	require.Nil(t, committer.prewriteMutations(bo, committer.mutations))
+	require.Nil(t, committer.cleanupMutations(bo, primaryOnly(committer)))
+
+	// Crashed before sending any request with an encrypted value, which is
+	// written as encrypted by the replay.
+	txn5, err := store.Begin(WithTxnWAL(wal), WithEncryption(xorEncryption{keyID: 1}))
+	require.Nil(t, err)
+	journal(txn5, "k8", "v8")
+
+	results, err := NewWALRecovery(store, wal).ReplayPending(ctx)
+	require.Nil(t, err)
+	require.Len(t, results, 5)
+	for i, txn := range []*KVTxn{txn1, txn2, txn3, txn4, txn5} {
+		require.Equal(t, txn.StartTS(), results[i].StartTS)
+	}
+	require.Equal(t, WALCommitted, results[0].Outcome)
+	require.Equal(t, []byte("v1"), get(results[0].CommitTS, "k1"))
+	require.Equal(t, WALCommitted, results[1].Outcome)
+	require.Equal(t, commitTS, results[1].CommitTS)
+	require.Equal(t, []byte("v2"), get(commitTS, "k2"))
+	require.Equal(t, []byte("v3"), get(commitTS, "k3"))
+	require.Equal(t, WALCommitted, results[2].Outcome)
+	require.Equal(t, commitTS3, results[2].CommitTS)
+	require.Equal(t, []byte("v5"), get(commitTS3, "k5"))
+	require.Equal(t, WALRolledBack, results[3].Outcome)
+	for _, key := range []string{"k6", "k7"} {
+		_, err = store.GetSnapshot(math.MaxUint64).Get(ctx, []byte(key))
+		require.True(t, tikverr.IsErrNotFound(err))
+	}
+	require.Equal(t, WALCommitted, results[4].Outcome)
+	require.Equal(t, append([]byte{0, 0, 0, 1}, xorBytes([]byte("v8"), 1)...), get(results[4].CommitTS, "k8"))
+
+	// Nothing is left to replay.
+	results, err = NewWALRecovery(store, wal).ReplayPending(ctx)
+	require.Nil(t, err)
+	require.Len(t, results, 0)
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/pingcap/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+// versionKeySuffix is appended to a key to get the key of its version.
+var versionKeySuffix = []byte("\x00version")
+
+func versionKey(key []byte) []byte {
+	vk := make([]byte, 0, len(key)+len(versionKeySuffix))
+	vk = append(vk, key...)
+	return append(vk, versionKeySuffix...)
+}
+
+func getVersion(ctx context.Context, txn *KVTxn, key []byte) (uint64, error) {
+	val, err := txn.Get(ctx, versionKey(key))
+	if tikverr.IsErrNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if len(val) != 8 {
+		return 0, errors.Errorf("invalid version of key %q, len: %d", key, len(val))
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// GetWithVersion returns the value and the version of a key written by
+// PutIfVersionMatch. The version of a key that has never been written by
+// PutIfVersionMatch is 0.
+func (s *KVStore) GetWithVersion(ctx context.Context, key []byte) ([]byte, uint64, error) {
+	txn, err := s.Begin()
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	defer txn.Rollback()
+	version, err := getVersion(ctx, txn, key)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	val, err := txn.Get(ctx, key)
+	if err != nil {
+		return nil, version, errors.Trace(err)
+	}
+	return val, version, nil
+}
+
+// PutIfVersionMatch puts value to key and increases the version of the key if
+// the version is expectedVersion, otherwise it returns ErrVersionMismatch. The
+// version is stored in the key with the "\x00version" suffix, so the key
+// should not be written without PutIfVersionMatch.
+//
+// The read and the write are done in one optimistic transaction, a concurrent
+// update of the key fails the transaction with a write conflict, which is also
+// reported as ErrVersionMismatch with an unknown (0) actual version. 1PC is
+// enabled for the transaction, but like for any transaction it's only used if
+// ctx carries a non-zero util.SessionID, otherwise the transaction is committed
+// by 2PC.
+func (s *KVStore) PutIfVersionMatch(ctx context.Context, key, value []byte, expectedVersion uint64) error {
+	txn, err := s.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	version, err := getVersion(ctx, txn, key)
+	if err != nil {
+		txn.Rollback()
+		return errors.Trace(err)
+	}
+	if version != expectedVersion {
+		txn.Rollback()
+		return errors.Trace(&tikverr.ErrVersionMismatch{Key: key, Expected: expectedVersion, Actual: version})
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], version+1)
+	if err = txn.Set(key, value); err != nil {
+		txn.Rollback()
+		return errors.Trace(err)
+	}
+	if err = txn.Set(versionKey(key), buf[:]); err != nil {
+		txn.Rollback()
+		return errors.Trace(err)
+	}
+	txn.SetEnable1PC(true)
+	err = txn.Commit(ctx)
+	if tikverr.IsErrWriteConflict(err) {
+		return errors.Trace(&tikverr.ErrVersionMismatch{Key: key, Expected: expectedVersion})
+	}
+	return errors.Trace(err)
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/util"
+)
+
+func TestPutIfVersionMatch(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+	key := []byte("k")
+
+	val, version, err := store.GetWithVersion(ctx, key)
+	require.True(t, tikverr.IsErrNotFound(err))
+	require.Nil(t, val)
+	require.Equal(t, uint64(0), version)
+
+	require.Nil(t, store.PutIfVersionMatch(ctx, key, []byte("v1"), 0))
+	require.Nil(t, store.PutIfVersionMatch(ctx, key, []byte("v2"), 1))
+	val, version, err = store.GetWithVersion(ctx, key)
+	require.Nil(t, err)
+	require.Equal(t, []byte("v2"), val)
+	require.Equal(t, uint64(2), version)
+
+	// A stale version is rejected with the actual one.
+	err = store.PutIfVersionMatch(ctx, key, []byte("v3"), 1)
+	mismatch, ok := errors.Cause(err).(*tikverr.ErrVersionMismatch)
+	require.True(t, ok)
+	require.Equal(t, uint64(1), mismatch.Expected)
+	require.Equal(t, uint64(2), mismatch.Actual)
+	val, version, err = store.GetWithVersion(ctx, key)
+	require.Nil(t, err)
+	require.Equal(t, []byte("v2"), val)
+	require.Equal(t, uint64(2), version)
+}
+
+func TestPutIfVersionMatchConcurrent(t *testing.T) {
+	store := newSnapshotTestStore(t)
+	defer store.Close()
+	ctx := context.Background()
+	key := []byte("k")
+
+	// Only one of the writers expecting the same version succeeds.
+	const writers = 8
+	var (
+		wg        sync.WaitGroup
+		succeeded int32
+	)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := store.PutIfVersionMatch(ctx, key, []byte{byte(i)}, 0)
+			if err == nil {
+				atomic.AddInt32(&succeeded, 1)
+				return
+			}
+			require.True(t, tikverr.Is(err, &tikverr.ErrVersionMismatch{}), err)
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), succeeded)
+	_, version, err := store.GetWithVersion(ctx, key)
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), version)
+}
+
+// onePCClient counts the prewrite requests trying 1PC.
+type onePCClient struct {
+	Client
+	tries int32
+}
+
+func (c *onePCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdPrewrite && req.Prewrite().TryOnePc {
+		atomic.AddInt32(&c.tries, 1)
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func newOnePCTestStore(t *testing.T) (*KVStore, *onePCClient) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := &onePCClient{Client: mocktikv.NewRPCClient(cluster, mvccStore, nil)}
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	return store, client
+}
+
+func TestPutIfVersionMatchOnePC(t *testing.T) {
+	store, client := newOnePCTestStore(t)
+	defer store.Close()
+
+	// 1PC isn't tried without a session ID.
+	require.Nil(t, store.PutIfVersionMatch(context.Background(), []byte("k"), []byte("v1"), 0))
+	require.Equal(t, int32(0), atomic.LoadInt32(&client.tries))
+
+	ctx := context.WithValue(context.Background(), util.SessionID, uint64(1))
+	require.Nil(t, store.PutIfVersionMatch(ctx, []byte("k"), []byte("v2"), 1))
+	require.Equal(t, int32(1), atomic.LoadInt32(&client.tries))
+}
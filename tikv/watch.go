@@ -0,0 +1,174 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/oracle"
+	"go.uber.org/zap"
+)
+
+// WatchEventType is the type of a WatchEvent.
+type WatchEventType int
+
+const (
+	// WatchEventPut means the key is created or its value is changed.
+	WatchEventPut WatchEventType = iota
+	// WatchEventDelete means the key is deleted.
+	WatchEventDelete
+)
+
+// String implements fmt.Stringer interface.
+func (t WatchEventType) String() string {
+	if t == WatchEventDelete {
+		return "delete"
+	}
+	return "put"
+}
+
+// WatchEvent describes a change of the watched key.
+type WatchEvent struct {
+	Type  WatchEventType
+	Key   []byte
+	Value []byte
+	// CommitTS is the timestamp of the snapshot which first observes the change.
+	// The change was committed after the previous poll and no later than CommitTS.
+	CommitTS uint64
+}
+
+const (
+	defaultWatchPollInterval    = 100 * time.Millisecond
+	defaultWatchMaxPollInterval = 5 * time.Second
+)
+
+// WatchPoller watches keys by polling snapshots at increasing timestamps.
+// It is NOT a push notification: a change is observed with a latency up to
+// the current poll interval, and multiple changes between two polls are merged
+// into one event. Writes that do not change the value are not reported.
+type WatchPoller struct {
+	store *KVStore
+	// PollInterval is the interval between two polls after a change is observed.
+	PollInterval time.Duration
+	// MaxPollInterval is the upper bound of the interval. The interval is doubled
+	// each time a poll observes no change.
+	MaxPollInterval time.Duration
+}
+
+// NewWatchPoller creates a WatchPoller with default intervals.
+func NewWatchPoller(store *KVStore) *WatchPoller {
+	return &WatchPoller{
+		store:           store,
+		PollInterval:    defaultWatchPollInterval,
+		MaxPollInterval: defaultWatchMaxPollInterval,
+	}
+}
+
+// WatchKey polls the key for changes committed after afterTS. The returned
+// channel is closed when ctx is done or the store is closed.
+func (p *WatchPoller) WatchKey(ctx context.Context, key []byte, afterTS uint64) (<-chan WatchEvent, error) {
+	value, err := p.getValue(ctx, key, afterTS)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ch := make(chan WatchEvent)
+	p.store.wg.Add(1)
+	go func() {
+		defer p.store.wg.Done()
+		defer close(ch)
+		p.poll(ctx, key, value, ch)
+	}()
+	return ch, nil
+}
+
+func (p *WatchPoller) poll(ctx context.Context, key []byte, value []byte, ch chan<- WatchEvent) {
+	interval := p.PollInterval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.store.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		event, changed, err := p.check(ctx, key, value)
+		if err != nil {
+			logutil.Logger(ctx).Warn("watch key poll failed",
+				zap.String("key", kv.StrKey(key)),
+				zap.Error(err))
+		}
+		if !changed {
+			interval *= 2
+			if interval > p.MaxPollInterval {
+				interval = p.MaxPollInterval
+			}
+			continue
+		}
+		interval = p.PollInterval
+		value = event.Value
+		select {
+		case ch <- event:
+		case <-ctx.Done():
+			return
+		case <-p.store.ctx.Done():
+			return
+		}
+	}
+}
+
+// check reads the key at the latest timestamp and compares it with the
+// previously observed value, nil value means the key does not exist.
+func (p *WatchPoller) check(ctx context.Context, key []byte, prev []byte) (WatchEvent, bool, error) {
+	ts, err := p.store.oracle.GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	if err != nil {
+		return WatchEvent{}, false, errors.Trace(err)
+	}
+	value, err := p.getValue(ctx, key, ts)
+	if err != nil {
+		return WatchEvent{}, false, errors.Trace(err)
+	}
+	switch {
+	case value == nil && prev == nil:
+		return WatchEvent{}, false, nil
+	case value == nil:
+		return WatchEvent{Type: WatchEventDelete, Key: key, CommitTS: ts}, true, nil
+	case prev != nil && bytes.Equal(value, prev):
+		return WatchEvent{}, false, nil
+	}
+	return WatchEvent{Type: WatchEventPut, Key: key, Value: value, CommitTS: ts}, true, nil
+}
+
+func (p *WatchPoller) getValue(ctx context.Context, key []byte, ts uint64) ([]byte, error) {
+	value, err := p.store.GetSnapshot(ts).Get(ctx, key)
+	if tikverr.IsErrNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return value, nil
+}
+
+// WatchKey polls the key for changes committed after afterTS with a default
+// WatchPoller. See WatchPoller for the limitations.
+func (s *KVStore) WatchKey(ctx context.Context, key []byte, afterTS uint64) (<-chan WatchEvent, error) {
+	return NewWatchPoller(s).WatchKey(ctx, key, afterTS)
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/oracle"
+)
+
+func TestWatchKey(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	key := []byte("watched")
+	startTS, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	poller := NewWatchPoller(store)
+	poller.PollInterval = 10 * time.Millisecond
+	poller.MaxPollInterval = 20 * time.Millisecond
+	ch, err := poller.WatchKey(ctx, key, startTS)
+	require.Nil(t, err)
+
+	recv := func() WatchEvent {
+		select {
+		case e, ok := <-ch:
+			require.True(t, ok)
+			return e
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "watch event timeout")
+		}
+		return WatchEvent{}
+	}
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set(key, []byte("v1")))
+	require.Nil(t, txn.Commit(context.Background()))
+	e := recv()
+	require.Equal(t, WatchEventPut, e.Type)
+	require.Equal(t, []byte("v1"), e.Value)
+	require.GreaterOrEqual(t, e.CommitTS, txn.commitTS)
+
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Delete(key))
+	require.Nil(t, txn.Commit(context.Background()))
+	e = recv()
+	require.Equal(t, WatchEventDelete, e.Type)
+
+	cancel()
+	for range ch {
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// writeAmplificationWarnRatio is the write amplification ratio above which
+	// a warning is logged.
+	writeAmplificationWarnRatio = 3.0
+	// writeAmplificationWarnMinBytes is the min mutation bytes of a transaction
+	// to log the warning. The fixed fields of the requests dominate the small
+	// transactions, whose ratio says nothing about the key encoding.
+	writeAmplificationWarnMinBytes = 64 * 1024
+	// writeAmplificationWarnInterval is the min interval between the warnings.
+	writeAmplificationWarnInterval = time.Minute
+)
+
+// lastWriteAmplificationWarn is the unix nano time of the last warning.
+var lastWriteAmplificationWarn int64
+
+// WriteAmplificationStats compares the bytes of the keys and values of a
+// transaction with the bytes of the prewrite requests sent to write them.
+// The requests carry the keys and values plus the per-mutation and per-request
+// fields, and the requests resent after region errors are counted again.
+type WriteAmplificationStats struct {
+	MutationBytes int64
+	RPCBytes      int64
+}
+
+// Ratio returns RPCBytes / MutationBytes, or 0 if there are no mutations.
+func (s WriteAmplificationStats) Ratio() float64 {
+	if s.MutationBytes == 0 {
+		return 0
+	}
+	return float64(s.RPCBytes) / float64(s.MutationBytes)
+}
+
+func (c *twoPhaseCommitter) writeAmplificationStats() WriteAmplificationStats {
+	return WriteAmplificationStats{
+		MutationBytes: int64(c.txnSize),
+		RPCBytes:      atomic.LoadInt64(&c.prewriteRPCBytes),
+	}
+}
+
+func (c *twoPhaseCommitter) recordWriteAmplification(ctx context.Context) {
+	stats := c.writeAmplificationStats()
+	if stats.MutationBytes == 0 {
+		return
+	}
+	metrics.TiKVWriteAmplificationRatio.Observe(stats.Ratio())
+	if shouldWarnWriteAmplification(stats, time.Now()) {
+		logutil.Logger(ctx).Warn("high write amplification",
+			zap.Uint64("txnStartTS", c.startTS),
+			zap.Int64("mutationBytes", stats.MutationBytes),
+			zap.Int64("rpcBytes", stats.RPCBytes),
+			zap.Float64("ratio", stats.Ratio()),
+			zap.Int("keys", c.mutations.Len()))
+	}
+}
+
+// shouldWarnWriteAmplification returns true if the write amplification of a
+// large enough transaction is too high, and no warning is logged within
+// writeAmplificationWarnInterval.
+func shouldWarnWriteAmplification(stats WriteAmplificationStats, now time.Time) bool {
+	if stats.MutationBytes < writeAmplificationWarnMinBytes || stats.Ratio() <= writeAmplificationWarnRatio {
+		return false
+	}
+	last := atomic.LoadInt64(&lastWriteAmplificationWarn)
+	return now.UnixNano()-last >= int64(writeAmplificationWarnInterval) &&
+		atomic.CompareAndSwapInt64(&lastWriteAmplificationWarn, last, now.UnixNano())
+}
@@ -79,6 +79,7 @@ const (
 	CmdRawBatchDelete
 	CmdRawDeleteRange
 	CmdRawScan
+	CmdRawCoprocessor
 
 	CmdUnsafeDestroyRange
 
@@ -102,6 +103,7 @@ const (
 	CmdSplitRegion
 
 	CmdDebugGetRegionProperties CmdType = 2048 + iota
+	CmdDebugCompact
 
 	CmdEmpty CmdType = 3072 + iota
 )
@@ -150,6 +152,8 @@ func (t CmdType) String() string {
 		return "RawDeleteRange"
 	case CmdRawScan:
 		return "RawScan"
+	case CmdRawCoprocessor:
+		return "RawCoprocessor"
 	case CmdUnsafeDestroyRange:
 		return "UnsafeDestroyRange"
 	case CmdRegisterLockObserver:
@@ -184,6 +188,8 @@ func (t CmdType) String() string {
 		return "CheckSecondaryLocks"
 	case CmdDebugGetRegionProperties:
 		return "DebugGetRegionProperties"
+	case CmdDebugCompact:
+		return "DebugCompact"
 	case CmdTxnHeartBeat:
 		return "TxnHeartBeat"
 	case CmdStoreSafeTS:
@@ -208,6 +214,11 @@ type Request struct {
 	// If it's not empty, the store which receive the request will forward it to
 	// the forwarded host. It's useful when network partition occurs.
 	ForwardedHost string
+	// Metadata is sent as the gRPC metadata of the request, so it's visible
+	// to the gRPC interceptors of TiKV. The metadata of a batch commands
+	// stream is shared, so the batched requests with different metadata are
+	// sent by different streams.
+	Metadata map[string]string
 }
 
 // NewRequest returns new kv rpc request.
@@ -252,7 +263,7 @@ func (req *Request) EnableStaleRead() {
 // IsDebugReq check whether the req is debug req.
 func (req *Request) IsDebugReq() bool {
 	switch req.Type {
-	case CmdDebugGetRegionProperties:
+	case CmdDebugGetRegionProperties, CmdDebugCompact:
 		return true
 	}
 	return false
@@ -353,6 +364,11 @@ func (req *Request) RawScan() *kvrpcpb.RawScanRequest {
 	return req.Req.(*kvrpcpb.RawScanRequest)
 }
 
+// RawCoprocessor returns RawCoprocessorRequest in request.
+func (req *Request) RawCoprocessor() *kvrpcpb.RawCoprocessorRequest {
+	return req.Req.(*kvrpcpb.RawCoprocessorRequest)
+}
+
 // UnsafeDestroyRange returns UnsafeDestroyRangeRequest in request.
 func (req *Request) UnsafeDestroyRange() *kvrpcpb.UnsafeDestroyRangeRequest {
 	return req.Req.(*kvrpcpb.UnsafeDestroyRangeRequest)
@@ -433,6 +449,11 @@ func (req *Request) DebugGetRegionProperties() *debugpb.GetRegionPropertiesReque
 	return req.Req.(*debugpb.GetRegionPropertiesRequest)
 }
 
+// DebugCompact returns CompactRequest in request.
+func (req *Request) DebugCompact() *debugpb.CompactRequest {
+	return req.Req.(*debugpb.CompactRequest)
+}
+
 // Empty returns BatchCommandsEmptyRequest in request.
 func (req *Request) Empty() *tikvpb.BatchCommandsEmptyRequest {
 	return req.Req.(*tikvpb.BatchCommandsEmptyRequest)
@@ -504,6 +525,8 @@ func (req *Request) ToBatchCommandsRequest() *tikvpb.BatchCommandsRequest_Reques
 		return &tikvpb.BatchCommandsRequest_Request{Cmd: &tikvpb.BatchCommandsRequest_Request_RawDeleteRange{RawDeleteRange: req.RawDeleteRange()}}
 	case CmdRawScan:
 		return &tikvpb.BatchCommandsRequest_Request{Cmd: &tikvpb.BatchCommandsRequest_Request_RawScan{RawScan: req.RawScan()}}
+	case CmdRawCoprocessor:
+		return &tikvpb.BatchCommandsRequest_Request{Cmd: &tikvpb.BatchCommandsRequest_Request_RawCoprocessor{RawCoprocessor: req.RawCoprocessor()}}
 	case CmdCop:
 		return &tikvpb.BatchCommandsRequest_Request{Cmd: &tikvpb.BatchCommandsRequest_Request_Coprocessor{Coprocessor: req.Cop()}}
 	case CmdPessimisticLock:
@@ -571,6 +594,8 @@ func FromBatchCommandsResponse(res *tikvpb.BatchCommandsResponse_Response) (*Res
 		return &Response{Resp: res.RawDeleteRange}, nil
 	case *tikvpb.BatchCommandsResponse_Response_RawScan:
 		return &Response{Resp: res.RawScan}, nil
+	case *tikvpb.BatchCommandsResponse_Response_RawCoprocessor:
+		return &Response{Resp: res.RawCoprocessor}, nil
 	case *tikvpb.BatchCommandsResponse_Response_Coprocessor:
 		return &Response{Resp: res.Coprocessor}, nil
 	case *tikvpb.BatchCommandsResponse_Response_PessimisticLock:
@@ -667,6 +692,8 @@ func SetContext(req *Request, region *metapb.Region, peer *metapb.Peer) error {
 		req.RawDeleteRange().Context = ctx
 	case CmdRawScan:
 		req.RawScan().Context = ctx
+	case CmdRawCoprocessor:
+		req.RawCoprocessor().Context = ctx
 	case CmdUnsafeDestroyRange:
 		req.UnsafeDestroyRange().Context = ctx
 	case CmdRegisterLockObserver:
@@ -795,6 +822,10 @@ func GenRegionErrorResp(req *Request, e *errorpb.Error) (*Response, error) {
 		p = &kvrpcpb.RawScanResponse{
 			RegionError: e,
 		}
+	case CmdRawCoprocessor:
+		p = &kvrpcpb.RawCoprocessorResponse{
+			RegionError: e,
+		}
 	case CmdUnsafeDestroyRange:
 		p = &kvrpcpb.UnsafeDestroyRangeResponse{
 			RegionError: e,
@@ -909,6 +940,8 @@ func CallRPC(ctx context.Context, client tikvpb.TikvClient, req *Request) (*Resp
 		resp.Resp, err = client.RawDeleteRange(ctx, req.RawDeleteRange())
 	case CmdRawScan:
 		resp.Resp, err = client.RawScan(ctx, req.RawScan())
+	case CmdRawCoprocessor:
+		resp.Resp, err = client.RawCoprocessor(ctx, req.RawCoprocessor())
 	case CmdUnsafeDestroyRange:
 		resp.Resp, err = client.UnsafeDestroyRange(ctx, req.UnsafeDestroyRange())
 	case CmdRegisterLockObserver:
@@ -978,6 +1011,8 @@ func CallDebugRPC(ctx context.Context, client debugpb.DebugClient, req *Request)
 	switch req.Type {
 	case CmdDebugGetRegionProperties:
 		resp.Resp, err = client.GetRegionProperties(ctx, req.DebugGetRegionProperties())
+	case CmdDebugCompact:
+		resp.Resp, err = client.Compact(ctx, req.DebugCompact())
 	default:
 		return nil, errors.Errorf("invalid request type: %v", req.Type)
 	}
@@ -0,0 +1,149 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package txncontext propagates transactions through context.Context.
+package txncontext
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/tikv"
+	"go.uber.org/zap"
+)
+
+type txnKey struct{}
+
+type holderKey struct{}
+
+// txnHolder is installed by Middleware to collect the transactions attached to
+// the request context by WithTxn.
+type txnHolder struct {
+	mu   sync.Mutex
+	txns []*tikv.KVTxn
+}
+
+// WithTxn returns a copy of ctx carrying txn. If ctx is derived from the
+// context of a request served by Middleware, the Middleware also finishes txn
+// after the handler returns.
+func WithTxn(ctx context.Context, txn *tikv.KVTxn) context.Context {
+	if h, ok := ctx.Value(holderKey{}).(*txnHolder); ok {
+		h.mu.Lock()
+		h.txns = append(h.txns, txn)
+		h.mu.Unlock()
+	}
+	return context.WithValue(ctx, txnKey{}, txn)
+}
+
+// TxnFromContext returns the transaction carried by ctx.
+func TxnFromContext(ctx context.Context) (*tikv.KVTxn, bool) {
+	txn, ok := ctx.Value(txnKey{}).(*tikv.KVTxn)
+	return txn, ok && txn != nil
+}
+
+// bufferedResponse holds the response written by the handler, so it's sent
+// after the transactions are finished.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *bufferedResponse) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *bufferedResponse) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Middleware finishes the transactions attached to the request context by
+// WithTxn after next returns. They are committed if the response status is
+// 2xx, and rolled back if the status is not 2xx or next panics.
+//
+// The response of next is buffered and sent after the transactions are
+// finished. If a commit fails, the remaining transactions are rolled back and
+// the response is replaced by a 500 Internal Server Error, so a client never
+// sees a success for a transaction that isn't committed.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := &txnHolder{}
+		buf := &bufferedResponse{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), holderKey{}, h)
+		defer func() {
+			if p := recover(); p != nil {
+				h.finish(ctx, false)
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(buf, r.WithContext(ctx))
+		status := buf.statusCode()
+		if err := h.finish(ctx, status >= 200 && status < 300); err != nil {
+			header := w.Header()
+			for k := range header {
+				delete(header, k)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(status)
+		if _, err := w.Write(buf.body.Bytes()); err != nil {
+			logutil.Logger(ctx).Debug("write response in middleware failed", zap.Error(err))
+		}
+	})
+}
+
+// finish commits or rolls back the transactions. If a commit fails, the
+// transactions left are rolled back and the error is returned.
+func (h *txnHolder) finish(ctx context.Context, commit bool) error {
+	h.mu.Lock()
+	txns := h.txns
+	h.txns = nil
+	h.mu.Unlock()
+	var commitErr error
+	for _, txn := range txns {
+		if !txn.Valid() {
+			continue
+		}
+		if commit && commitErr == nil {
+			if err := txn.Commit(ctx); err != nil {
+				logutil.Logger(ctx).Warn("commit txn in middleware failed",
+					zap.Uint64("txnStartTS", txn.StartTS()),
+					zap.Error(err))
+				commitErr = err
+			}
+			continue
+		}
+		if err := txn.Rollback(); err != nil {
+			logutil.Logger(ctx).Warn("rollback txn in middleware failed",
+				zap.Uint64("txnStartTS", txn.StartTS()),
+				zap.Error(err))
+		}
+	}
+	return commitErr
+}
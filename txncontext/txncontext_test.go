@@ -0,0 +1,92 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txncontext
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/mockstore/mocktikv"
+	"github.com/tikv/client-go/v2/tikv"
+)
+
+func TestMiddleware(t *testing.T) {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+	cluster := mocktikv.NewCluster(mvccStore)
+	mocktikv.BootstrapWithSingleStore(cluster)
+	client := mocktikv.NewRPCClient(cluster, mvccStore, nil)
+	store, err := tikv.NewTestTiKVStore(client, mocktikv.NewPDClient(cluster), nil, nil, 0)
+	require.Nil(t, err)
+	defer store.Close()
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		ctx := WithTxn(r.Context(), txn)
+		got, ok := TxnFromContext(ctx)
+		require.True(t, ok)
+		require.Nil(t, got.Set([]byte(r.URL.Path), []byte("v")))
+		switch r.URL.Path {
+		case "/conflict":
+			other, err := store.Begin()
+			require.Nil(t, err)
+			require.Nil(t, other.Set([]byte(r.URL.Path), []byte("other")))
+			require.Nil(t, other.Commit(context.Background()))
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/panic":
+			panic("handler panic")
+		}
+		w.Write([]byte("done"))
+	}))
+
+	get := func(key string) error {
+		txn, err := store.Begin()
+		require.Nil(t, err)
+		_, err = txn.Get(context.Background(), []byte(key))
+		return err
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	require.Nil(t, get("/ok"))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "done", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fail", nil))
+	require.True(t, tikverr.IsErrNotFound(get("/fail")))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Equal(t, "done", w.Body.String())
+
+	// The commit fails before the response is sent, so the client gets an error
+	// instead of the response of the handler.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/conflict", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.NotContains(t, w.Body.String(), "done")
+
+	require.Panics(t, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+	require.True(t, tikverr.IsErrNotFound(get("/panic")))
+
+	_, ok := TxnFromContext(context.Background())
+	require.False(t, ok)
+}
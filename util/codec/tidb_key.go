@@ -0,0 +1,236 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"math"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+// The layout of the keys written by TiDB:
+//
+//	row key:   t{tableID}_r{rowID}
+//	index key: t{tableID}_i{indexID}{column values}[{handle}]
+//
+// The IDs are encoded by EncodeInt, the column values are encoded with a flag
+// byte followed by the value.
+var (
+	tablePrefix     = []byte{'t'}
+	rowPrefixSep    = []byte("_r")
+	indexPrefixSep  = []byte("_i")
+	tablePrefixLen  = len(tablePrefix) + 8
+	recordPrefixLen = tablePrefixLen + len(rowPrefixSep)
+)
+
+// The flags of the column values in TiDB index keys.
+const (
+	nilFlag          byte = 0
+	bytesFlag        byte = 1
+	compactBytesFlag byte = 2
+	intFlag          byte = 3
+	uintFlag         byte = 4
+	floatFlag        byte = 5
+	decimalFlag      byte = 6
+	durationFlag     byte = 7
+	varintFlag       byte = 8
+	uvarintFlag      byte = 9
+	jsonFlag         byte = 10
+	maxFlag          byte = 250
+)
+
+// TiDBKey is a key written by TiDB.
+type TiDBKey []byte
+
+// EncodeTiDBRowKey encodes the row key of a table with an integer handle.
+func EncodeTiDBRowKey(tableID, rowID int64) TiDBKey {
+	buf := make([]byte, 0, recordPrefixLen+8)
+	buf = append(buf, tablePrefix...)
+	buf = EncodeInt(buf, tableID)
+	buf = append(buf, rowPrefixSep...)
+	return EncodeInt(buf, rowID)
+}
+
+// TableID returns the ID of the table the key belongs to.
+func (k TiDBKey) TableID() (int64, error) {
+	if len(k) < tablePrefixLen || !bytes.HasPrefix(k, tablePrefix) {
+		return 0, errors.Errorf("invalid TiDB key %q", []byte(k))
+	}
+	_, tableID, err := DecodeInt(k[len(tablePrefix):])
+	return tableID, errors.Trace(err)
+}
+
+// IsRowKey returns whether the key is a row key.
+func (k TiDBKey) IsRowKey() bool {
+	return len(k) >= recordPrefixLen && bytes.HasPrefix(k, tablePrefix) &&
+		bytes.Equal(k[tablePrefixLen:recordPrefixLen], rowPrefixSep)
+}
+
+// IsIndexKey returns whether the key is an index key.
+func (k TiDBKey) IsIndexKey() bool {
+	return len(k) >= recordPrefixLen && bytes.HasPrefix(k, tablePrefix) &&
+		bytes.Equal(k[tablePrefixLen:recordPrefixLen], indexPrefixSep)
+}
+
+// RowID returns the integer handle of a row key. It fails for the row keys of
+// the tables clustered by a non-integer primary key, whose handles are encoded
+// column values.
+func (k TiDBKey) RowID() (int64, error) {
+	if !k.IsRowKey() || len(k) != recordPrefixLen+8 {
+		return 0, errors.Errorf("invalid TiDB row key %q", []byte(k))
+	}
+	_, rowID, err := DecodeInt(k[recordPrefixLen:])
+	return rowID, errors.Trace(err)
+}
+
+// IndexKey is a decoded TiDB index key.
+//
+// The values are nil for NULL, []byte for strings and binaries, int64 for
+// signed integers and enums, uint64 for unsigned integers, float64 for floats
+// and time.Duration for durations. Datetimes are encoded by TiDB as packed
+// uint64 and are returned as uint64.
+type IndexKey struct {
+	TableID int64
+	IndexID int64
+	Values  []interface{}
+	// Handle is the values after the index columns, which is the handle of the
+	// row for a non-unique index key.
+	Handle []interface{}
+}
+
+// IndexKeyDecoder decodes TiDB index keys. Decimal and JSON values are not
+// supported.
+type IndexKeyDecoder struct {
+	// NumColumns is the number of the columns of the index. The values after
+	// them are decoded as the handle. All the values are decoded as the
+	// columns if it's 0.
+	NumColumns int
+}
+
+// Decode decodes an index key.
+func (d IndexKeyDecoder) Decode(key []byte) (*IndexKey, error) {
+	k := TiDBKey(key)
+	if !k.IsIndexKey() {
+		return nil, errors.Errorf("invalid TiDB index key %q", key)
+	}
+	tableID, err := k.TableID()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	b, indexID, err := DecodeInt(key[recordPrefixLen:])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := &IndexKey{TableID: tableID, IndexID: indexID}
+	for len(b) > 0 {
+		var v interface{}
+		b, v, err = decodeIndexValue(b)
+		if err != nil {
+			return nil, errors.Annotatef(err, "decode index key %q", key)
+		}
+		if d.NumColumns > 0 && len(result.Values) == d.NumColumns {
+			result.Handle = append(result.Handle, v)
+		} else {
+			result.Values = append(result.Values, v)
+		}
+	}
+	return result, nil
+}
+
+func decodeIndexValue(b []byte) ([]byte, interface{}, error) {
+	flag := b[0]
+	b = b[1:]
+	switch flag {
+	case nilFlag:
+		return b, nil, nil
+	case bytesFlag:
+		b, v, err := DecodeBytes(b, nil)
+		return b, v, err
+	case compactBytesFlag:
+		b, n, err := DecodeVarint(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n < 0 || int64(len(b)) < n {
+			return nil, nil, errors.New("insufficient bytes to decode value")
+		}
+		return b[n:], b[:n], nil
+	case intFlag:
+		b, v, err := DecodeInt(b)
+		return b, v, err
+	case uintFlag:
+		b, v, err := DecodeUint(b)
+		return b, v, err
+	case floatFlag:
+		b, u, err := DecodeUint(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if u&signMask > 0 {
+			u &= ^signMask
+		} else {
+			u = ^u
+		}
+		return b, math.Float64frombits(u), nil
+	case durationFlag:
+		b, v, err := DecodeInt(b)
+		return b, time.Duration(v), err
+	case varintFlag:
+		b, v, err := DecodeVarint(b)
+		return b, v, err
+	case uvarintFlag:
+		b, v, err := DecodeUvarint(b)
+		return b, v, err
+	case decimalFlag, jsonFlag, maxFlag:
+		return nil, nil, errors.Errorf("unsupported value flag %d", flag)
+	}
+	return nil, nil, errors.Errorf("invalid value flag %d", flag)
+}
+
+// EncodeTiDBIndexKey encodes an index key with the values of the types
+// returned by IndexKeyDecoder.
+func EncodeTiDBIndexKey(tableID, indexID int64, values ...interface{}) (TiDBKey, error) {
+	buf := make([]byte, 0, recordPrefixLen+8+9*len(values))
+	buf = append(buf, tablePrefix...)
+	buf = EncodeInt(buf, tableID)
+	buf = append(buf, indexPrefixSep...)
+	buf = EncodeInt(buf, indexID)
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			buf = append(buf, nilFlag)
+		case []byte:
+			buf = EncodeBytes(append(buf, bytesFlag), v)
+		case int64:
+			buf = EncodeInt(append(buf, intFlag), v)
+		case uint64:
+			buf = EncodeUint(append(buf, uintFlag), v)
+		case float64:
+			u := math.Float64bits(v)
+			if v >= 0 {
+				u |= signMask
+			} else {
+				u = ^u
+			}
+			buf = EncodeUint(append(buf, floatFlag), u)
+		case time.Duration:
+			buf = EncodeInt(append(buf, durationFlag), int64(v))
+		default:
+			return nil, errors.Errorf("unsupported index value %v of type %T", v, v)
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTiDBRowKey(t *testing.T) {
+	// The row key of table 1, row 1 written by TiDB.
+	key, err := hex.DecodeString("7480000000000000015f728000000000000001")
+	require.Nil(t, err)
+	tableID, err := TiDBKey(key).TableID()
+	require.Nil(t, err)
+	require.Equal(t, int64(1), tableID)
+	rowID, err := TiDBKey(key).RowID()
+	require.Nil(t, err)
+	require.Equal(t, int64(1), rowID)
+	require.Equal(t, TiDBKey(key), EncodeTiDBRowKey(1, 1))
+
+	k := EncodeTiDBRowKey(-5, -100)
+	tableID, err = k.TableID()
+	require.Nil(t, err)
+	require.Equal(t, int64(-5), tableID)
+	rowID, err = k.RowID()
+	require.Nil(t, err)
+	require.Equal(t, int64(-100), rowID)
+
+	_, err = TiDBKey("x").TableID()
+	require.NotNil(t, err)
+	_, err = TiDBKey(key[:len(key)-1]).RowID()
+	require.NotNil(t, err)
+}
+
+func TestTiDBIndexKey(t *testing.T) {
+	// The key of a non-unique index on an int column of table 1, index 1, with
+	// value 1 and handle 2, and the one on a varchar column with value "abc".
+	key, err := hex.DecodeString("7480000000000000015f698000000000000001038000000000000001038000000000000002")
+	require.Nil(t, err)
+	idx, err := IndexKeyDecoder{NumColumns: 1}.Decode(key)
+	require.Nil(t, err)
+	require.Equal(t, &IndexKey{TableID: 1, IndexID: 1, Values: []interface{}{int64(1)}, Handle: []interface{}{int64(2)}}, idx)
+
+	key, err = hex.DecodeString("7480000000000000015f698000000000000002016162630000000000fa038000000000000002")
+	require.Nil(t, err)
+	idx, err = IndexKeyDecoder{}.Decode(key)
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{[]byte("abc"), int64(2)}, idx.Values)
+	require.False(t, TiDBKey(key).IsRowKey())
+
+	values := []interface{}{nil, []byte("x"), int64(-3), uint64(4), -1.5, 2.5, time.Second}
+	k, err := EncodeTiDBIndexKey(10, 20, values...)
+	require.Nil(t, err)
+	idx, err = IndexKeyDecoder{}.Decode(k)
+	require.Nil(t, err)
+	require.Equal(t, &IndexKey{TableID: 10, IndexID: 20, Values: values}, idx)
+
+	_, err = IndexKeyDecoder{}.Decode(append(k, decimalFlag))
+	require.NotNil(t, err)
+	_, err = IndexKeyDecoder{}.Decode(EncodeTiDBRowKey(1, 1))
+	require.NotNil(t, err)
+}
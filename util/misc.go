@@ -86,6 +86,21 @@ func WithRecovery(exec func(), recoverFn func(r interface{})) {
 	exec()
 }
 
+// SafeCall calls f, which is usually provided by the user, and recovers from
+// its panic. If f panics, the panic is logged with the stack trace and fallback
+// is returned.
+func SafeCall(f func() error, fallback error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logutil.BgLogger().Error("panic in the user-provided function",
+				zap.Reflect("r", r),
+				zap.Stack("stack trace"))
+			err = fallback
+		}
+	}()
+	return f()
+}
+
 type sessionIDCtxKey struct{}
 
 // SessionID is the context key type to mark a session.